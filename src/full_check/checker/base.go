@@ -9,13 +9,162 @@ import (
 
 type FullCheckParameter struct {
 	SourceHost   client.RedisHost
-	TargetHost   client.RedisHost
+	TargetHosts  []client.RedisHost // every source key is compared with all targets
 	ResultDBFile string
 	CompareCount int
 	Interval     int
 	BatchCount   int
 	Parallel     int
-	FilterTree   *common.Trie
+	Filter       *common.KeyFilter
+	KeyMapper    *common.KeyMapper // source key name -> target key name
+	DBMapping    map[int32]int32   // source db -> target db
+	// merge all source dbs into db 0 of the target with per-db key prefix, "{db}" is replaced by
+	// the source db, e.g., "db{db}:"
+	TargetDBPrefix string
+
+	// per data type and per key prefix compare mode, overrides the global one
+	TypeCompareMode   map[common.KeyTypeIndex]int
+	PrefixCompareMode []PrefixCompareMode
+
+	// only compare a sample of the keys and estimate the inconsistency rate
+	Sample SampleParameter
+	// only compare the keys of this shard, nil means all keys
+	Shard *common.Shard
+	// compare merkle trees first and only compare the keys in the differing buckets
+	Merkle MerkleParameter
+	// keep verifying the changed keys instead of comparing all keys once
+	Daemon DaemonParameter
+	// read the source as a replica instead of scanning it
+	Psync PsyncParameter
+	// write the source to a file instead of comparing it
+	Export ExportParameter
+	// read the source from a file written by the export
+	SourceFile SourceFileParameter
+	// verify the target against a fixture instead of a source
+	Fixture FixtureParameter
+	// connections shared by the clients of each host
+	Pool client.PoolOptions
+	// lower the qps limit when the source is overloaded
+	Adaptive AdaptiveParameter
+	// bytes per second read from each side, 0 means unlimited
+	Bandwidth BandwidthParameter
+	// requests per second and in flight of each side shared by all goroutines, 0 means unlimited
+	Throttle ThrottleParameter
+	// read the standalone source from a replica
+	SourceReplica SourceReplicaParameter
+	// wait for the target to catch up before each round
+	RoundWait RoundWaitParameter
+	// detect the slots of the cluster source moving during the scan
+	Topology TopologyParameter
+	// stop after a max duration and resume later
+	TimeBox TimeBoxParameter
+	// verify the hot keys first
+	HotKey HotKeyParameter
+	// re-read the conflict keys before they are recorded
+	Confirm ConfirmParameter
+}
+
+type SampleParameter struct {
+	Rate       float64 // fraction of keys, 0 means unused
+	Count      int64   // fixed number of keys per db, 0 means unused
+	Method     string  // "hash" or "randomkey"
+	Seed       string  // changes the subset picked by "hash"
+	Confidence float64 // confidence level of the reported interval, e.g., 0.95
+}
+
+func (p SampleParameter) Enabled() bool {
+	return p.Rate > 0 || p.Count > 0
+}
+
+type MerkleParameter struct {
+	Enable     bool
+	SubBuckets int    // sub-buckets per slot
+	Digest     string // common.DigestDump or common.DigestDebug
+	BaseFile   string // final result db of a previous run to compare the digests with
+}
+
+type DaemonParameter struct {
+	Enable        bool
+	Source        string // "notify", "file:PATH", "unix:PATH" or "tcp:ADDR"
+	SettleDelayMs int    // verify a changed key after it hasn't changed for this long
+	RecheckSecond int    // verify the live conflicts again at this interval, 0 means never
+}
+
+type PsyncParameter struct {
+	Enable bool
+	Follow bool // keep applying the command stream after the snapshot is loaded
+}
+
+type ExportParameter struct {
+	File   string // empty means unused
+	Digest bool   // only export the length and the digest of values
+}
+
+type SourceFileParameter struct {
+	File   string // empty means unused
+	Digest bool   // the file only holds the digests, read from its header
+}
+
+type FixtureParameter struct {
+	File      string // YAML or JSON, empty means unused
+	ExtraKeys bool   // report the keys on the target that aren't in the fixture
+}
+
+type AdaptiveParameter struct {
+	MaxLatencyMs int64   // p99 latency of the source commands, 0 means unused
+	MaxOps       int64   // instantaneous_ops_per_sec of any source node, 0 means unused
+	MaxCpu       float64 // cpu usage percent of any source node, 0 means unused
+	MinQps       int     // the qps limit isn't lowered below this
+}
+
+func (p AdaptiveParameter) Enabled() bool {
+	return p.MaxLatencyMs > 0 || p.MaxOps > 0 || p.MaxCpu > 0
+}
+
+type BandwidthParameter struct {
+	Source int64
+	Target int64 // shared by all targets
+}
+
+type ThrottleParameter struct {
+	SourceQps         int
+	TargetQps         int // shared by all targets
+	SourceConcurrency int
+	TargetConcurrency int
+}
+
+type SourceReplicaParameter struct {
+	Enable        bool
+	MaxLagSeconds int // replicas lagging behind longer aren't read
+}
+
+type RoundWaitParameter struct {
+	Source         string // "replica", "file:PATH" or an http(s) url, empty means unused
+	TimeoutSeconds int    // the round starts anyway after this
+}
+
+type TopologyParameter struct {
+	PollSeconds int // 0 means unused
+}
+
+type TimeBoxParameter struct {
+	MaxSeconds int  // 0 means unlimited
+	Resume     bool // continue from the checkpoint of the result db
+}
+
+func (p TimeBoxParameter) Enabled() bool {
+	return p.MaxSeconds > 0 || p.Resume
+}
+
+type HotKeyParameter struct {
+	Source string   // "freq", "idletime" or "file:PATH", empty means unused
+	Window int      // keys buffered and ordered by hotness
+	Keys   [][]byte // read from the file
+}
+
+type ConfirmParameter struct {
+	Enable  bool
+	DelayMs int // the target is read again after this long
 }
 
 type VerifierBase struct {
@@ -24,22 +173,63 @@ type VerifierBase struct {
 }
 
 func (p *VerifierBase) IncrKeyStat(oneKeyInfo *common.Key) {
-	p.Stat.ConflictKey[oneKeyInfo.Tp.Index][oneKeyInfo.ConflictType].Inc(1)
+	p.Stat.IncrKey(oneKeyInfo.Tp.Index, oneKeyInfo.ConflictType, oneKeyInfo.Target, 1)
 }
 
 func (p *VerifierBase) IncrFieldStat(oneKeyInfo *common.Key, conType common.ConflictType) {
-	p.Stat.ConflictField[oneKeyInfo.Tp.Index][conType].Inc(1)
+	p.Stat.IncrField(oneKeyInfo.Tp.Index, conType, oneKeyInfo.Target, 1)
 }
 
-func (p *VerifierBase) FetchTypeAndLen(keyInfo []*common.Key, sourceClient, targetClient *client.RedisClient) {
-	// fetch type
-	sourceKeyTypeStr, err := sourceClient.PipeTypeCommand(keyInfo)
+// FilterType marks the keys excluded by the data type rules as skipped and returns the rest.
+func (p *VerifierBase) FilterType(keyInfo []*common.Key) []*common.Key {
+	if p.Param.Filter == nil || !p.Param.Filter.HasTypeRule() {
+		return keyInfo
+	}
+
+	passKeyInfo := make([]*common.Key, 0, len(keyInfo))
+	for _, key := range keyInfo {
+		if p.Param.Filter.PassType(key.Tp) {
+			passKeyInfo = append(passKeyInfo, key)
+			continue
+		}
+		key.ConflictType = common.SkippedConflict
+		p.IncrKeyStat(key)
+	}
+	return passKeyInfo
+}
+
+/*
+ * FetchType fetches the type of the keys from the source. The keys compared for the first time
+ * whose type is known already, e.g., fetched by the DispatchVerifier to route them, are skipped.
+ */
+func (p *VerifierBase) FetchType(keyInfo []*common.Key, sourceClient *client.RedisClient) {
+	noTypeKeyInfo := make([]*common.Key, 0, len(keyInfo))
+	for _, key := range keyInfo {
+		if key.Tp == common.EndKeyType || key.ConflictType != common.EndConflict {
+			noTypeKeyInfo = append(noTypeKeyInfo, key)
+		}
+	}
+	if len(noTypeKeyInfo) == 0 {
+		return
+	}
+
+	sourceKeyTypeStr, err := sourceClient.PipeTypeCommand(noTypeKeyInfo)
 	if err != nil {
 		panic(common.Logger.Critical(err))
 	}
 	for i, t := range sourceKeyTypeStr {
-		keyInfo[i].Tp = common.NewKeyType(t)
-		// fmt.Printf("key:%v, type:%v cmd:%v\n", string(keyInfo[i].Key), t, keyInfo[i].Tp.FetchLenCommand)
+		noTypeKeyInfo[i].Tp = common.NewKeyType(t)
+		// fmt.Printf("key:%v, type:%v cmd:%v\n", string(noTypeKeyInfo[i].Key), t, noTypeKeyInfo[i].Tp.FetchLenCommand)
+	}
+}
+
+func (p *VerifierBase) FetchTypeAndLen(keyInfo []*common.Key, sourceClient, targetClient *client.RedisClient) {
+	p.FetchType(keyInfo, sourceClient)
+
+	// no need to fetch len of the skipped keys
+	keyInfo = p.FilterType(keyInfo)
+	if len(keyInfo) == 0 {
+		return
 	}
 
 	var wg sync.WaitGroup
@@ -102,4 +292,4 @@ type IVerifier interface {
 
 type ValueOutlineVerifier struct {
 	VerifierBase
-}
\ No newline at end of file
+}
@@ -0,0 +1,147 @@
+package checker
+
+import (
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+)
+
+/*
+ * ConfirmVerifier re-checks the conflicts found by the inner verifier before they are recorded,
+ * as a key written between the source read and the target read looks like a conflict while the
+ * source is live. The suspected keys are verified again at once, reading the source again, and
+ * the ones still differing are verified once more after the delay, comparing the source values
+ * just read with the target read again. Only the keys differing both times are conflicts, the
+ * rest are counted as equal and as false positives. The re-checks count into a scratch stat, the
+ * confirmed conflicts are reported with the keys given.
+ */
+type ConfirmVerifier struct {
+	VerifierBase
+	inner   IVerifier
+	recheck IVerifier // the same verifiers as the inner one counting into a scratch stat
+}
+
+func NewConfirmVerifier(stat *metric.Stat, param *FullCheckParameter, inner, recheck IVerifier) *ConfirmVerifier {
+	return &ConfirmVerifier{VerifierBase{stat, param}, inner, recheck}
+}
+
+func (p *ConfirmVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflictKey chan<- *common.Key, sourceClient *client.RedisClient, targetClient *client.RedisClient) {
+	// the verifiers fill the keys in, keep them as they come to verify them again
+	originals := make(map[*common.Key]*common.Key, len(keyInfo))
+	for _, key := range keyInfo {
+		originals[key] = copyKey(key)
+	}
+
+	// the keys as they come and the keys given, the confirmed state is copied into the ones given
+	suspects, given := make([]*common.Key, 0), make([]*common.Key, 0)
+	for _, key := range collectConflicts(p.inner, keyInfo, sourceClient, targetClient) {
+		original, ok := originals[key]
+		if !ok {
+			conflictKey <- key
+			continue
+		}
+		p.uncount(key)
+		suspects = append(suspects, original)
+		given = append(given, key)
+	}
+	if len(suspects) == 0 {
+		return
+	}
+
+	// the source replies of the first re-check are reused by the second one
+	outer := sourceClient.SwapCache(client.NewReplyCache())
+	defer sourceClient.SwapCache(outer)
+
+	suspects, given = stillDiffering(p.confirm(suspects, sourceClient, targetClient), given)
+	if len(suspects) == 0 {
+		return
+	}
+	time.Sleep(time.Duration(p.Param.Confirm.DelayMs) * time.Millisecond)
+	confirmed, given := stillDiffering(p.confirm(suspects, sourceClient, targetClient), given)
+	for i, key := range confirmed {
+		*given[i] = *key
+		p.IncrKeyStat(given[i])
+		for _, field := range given[i].Field {
+			p.IncrFieldStat(given[i], field.ConflictType)
+		}
+		conflictKey <- given[i]
+	}
+}
+
+// stillDiffering returns the keys re-checked that still differ and the keys given of them.
+func stillDiffering(rechecked, given []*common.Key) ([]*common.Key, []*common.Key) {
+	keys, ret := make([]*common.Key, 0, len(rechecked)), make([]*common.Key, 0, len(rechecked))
+	for i, key := range rechecked {
+		if key != nil {
+			keys = append(keys, key)
+			ret = append(ret, given[i])
+		}
+	}
+	return keys, ret
+}
+
+/*
+ * confirm verifies copies of the keys again with the re-check verifiers. It counts the keys found
+ * equal as false positives and returns the copies of the keys in order, nil for the ones equal. The
+ * keys passed in are left as they come.
+ */
+func (p *ConfirmVerifier) confirm(keyInfo []*common.Key, sourceClient, targetClient *client.RedisClient) []*common.Key {
+	copies := make([]*common.Key, len(keyInfo))
+	for i, key := range keyInfo {
+		copies[i] = copyKey(key)
+	}
+	conflicts := make(map[*common.Key]struct{})
+	for _, key := range collectConflicts(p.recheck, copies, sourceClient, targetClient) {
+		conflicts[key] = struct{}{}
+	}
+
+	for i, key := range copies {
+		if _, ok := conflicts[key]; ok {
+			continue
+		}
+		if key.Tp.Index < common.EndKeyTypeIndex {
+			p.Stat.IncrKey(key.Tp.Index, common.NoneConflict, key.Target, 1)
+		}
+		p.Stat.FalsePositive.Inc(1)
+		common.Logger.Debugf("conflict of key[%s] isn't confirmed, counted as equal", keyInfo[i].Key)
+		copies[i] = nil
+	}
+	return copies
+}
+
+// uncount takes the key and its conflict fields back from the stat counted by the inner verifier.
+func (p *ConfirmVerifier) uncount(key *common.Key) {
+	p.Stat.IncrKey(key.Tp.Index, key.ConflictType, key.Target, -1)
+	for _, field := range key.Field {
+		p.Stat.IncrField(key.Tp.Index, field.ConflictType, key.Target, -1)
+	}
+}
+
+// collectConflicts returns the conflict keys the verifier reports for the keys.
+func collectConflicts(verifier IVerifier, keyInfo []*common.Key, sourceClient,
+	targetClient *client.RedisClient) []*common.Key {
+	ch := make(chan *common.Key, len(keyInfo))
+	done := make(chan []*common.Key)
+	go func() {
+		conflicts := make([]*common.Key, 0)
+		for key := range ch {
+			conflicts = append(conflicts, key)
+		}
+		done <- conflicts
+	}()
+	verifier.VerifyOneGroupKeyInfo(keyInfo, ch, sourceClient, targetClient)
+	close(ch)
+	return <-done
+}
+
+// copyKey returns a copy of the key that the verifiers can fill in without changing the key.
+func copyKey(key *common.Key) *common.Key {
+	dup := *key
+	if key.Field != nil {
+		dup.Field = make([]common.Field, len(key.Field))
+		copy(dup.Field, key.Field)
+	}
+	return &dup
+}
@@ -0,0 +1,126 @@
+package checker
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	if common.Logger, err = common.InitLog("", "error,critical"); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestConfirmVerifier(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestConfirmVerifier case %d.\n", nr)
+
+		// conflictsFrom returns true from the nth time the verifier gets the key until the mth, 0 means forever
+		conflictsFrom := func(fake *fakeVerifier, n, m int) func(key *common.Key) bool {
+			return func(key *common.Key) bool {
+				seen := 0
+				for _, name := range fake.keys {
+					if name == string(key.Key) {
+						seen++
+					}
+				}
+				return seen >= n && (m == 0 || seen <= m)
+			}
+		}
+		extra := &common.Key{Key: []byte("extra"), Tp: common.StringKeyType, ConflictType: common.LackSourceConflict}
+
+		tests := []struct {
+			name          string
+			inner         func(fake *fakeVerifier)
+			recheck       func(fake *fakeVerifier)
+			conflicts     []string
+			rechecked     []string
+			valueConflict int64
+			noneConflict  int64
+			lackSource    int64
+			falsePositive int64
+		}{
+			{
+				name:          "confirmed on both passes",
+				inner:         func(fake *fakeVerifier) { fake.conflict = conflictsFrom(fake, 1, 0) },
+				recheck:       func(fake *fakeVerifier) { fake.conflict = conflictsFrom(fake, 1, 0) },
+				conflicts:     []string{"k"},
+				rechecked:     []string{"k", "k"},
+				valueConflict: 1,
+			},
+			{
+				name:          "cleared on the first pass",
+				inner:         func(fake *fakeVerifier) { fake.conflict = conflictsFrom(fake, 1, 0) },
+				recheck:       func(fake *fakeVerifier) {},
+				conflicts:     []string{},
+				rechecked:     []string{"k"},
+				noneConflict:  1,
+				falsePositive: 1,
+			},
+			{
+				name:          "cleared after the delay",
+				inner:         func(fake *fakeVerifier) { fake.conflict = conflictsFrom(fake, 1, 0) },
+				recheck:       func(fake *fakeVerifier) { fake.conflict = conflictsFrom(fake, 1, 1) },
+				conflicts:     []string{},
+				rechecked:     []string{"k", "k"},
+				noneConflict:  1,
+				falsePositive: 1,
+			},
+			{
+				name:         "keys not in the batch are passed on",
+				inner:        func(fake *fakeVerifier) { fake.extra = []*common.Key{extra} },
+				recheck:      func(fake *fakeVerifier) { fake.conflict = conflictsFrom(fake, 1, 0) },
+				conflicts:    []string{"extra"},
+				rechecked:    nil,
+				noneConflict: 1,
+				lackSource:   1,
+			},
+		}
+		for _, test := range tests {
+			var stat, scratch metric.Stat
+			param := &FullCheckParameter{Confirm: ConfirmParameter{Enable: true}}
+			inner := &fakeVerifier{VerifierBase: VerifierBase{&stat, param}}
+			recheck := &fakeVerifier{VerifierBase: VerifierBase{&scratch, param}}
+			test.inner(inner)
+			test.recheck(recheck)
+			verifier := NewConfirmVerifier(&stat, param, inner, recheck)
+
+			key := &common.Key{Key: []byte("k"), Tp: common.EndKeyType, ConflictType: common.EndConflict}
+			conflictKey := make(chan *common.Key, 10)
+			verifier.VerifyOneGroupKeyInfo([]*common.Key{key}, conflictKey, &client.RedisClient{},
+				&client.RedisClient{})
+			close(conflictKey)
+			conflicts := make([]string, 0)
+			for conflict := range conflictKey {
+				conflicts = append(conflicts, string(conflict.Key))
+				// the key given is reported, filled in
+				if string(conflict.Key) == "k" {
+					assert.True(t, conflict == key, "should be true: %s", test.name)
+					assert.Equal(t, common.ValueConflict, conflict.ConflictType, "should be equal: %s", test.name)
+				}
+			}
+
+			assert.Equal(t, test.conflicts, conflicts, "should be equal: %s", test.name)
+			assert.Equal(t, test.rechecked, recheck.keys, "should be equal: %s", test.name)
+			counters := stat.ConflictKey[common.StringKeyType.Index]
+			assert.Equal(t, test.valueConflict, counters[common.ValueConflict].Total(), "should be equal: %s",
+				test.name)
+			assert.Equal(t, test.noneConflict, counters[common.NoneConflict].Total(), "should be equal: %s",
+				test.name)
+			assert.Equal(t, test.lackSource, counters[common.LackSourceConflict].Total(), "should be equal: %s",
+				test.name)
+			assert.Equal(t, test.falsePositive, stat.FalsePositive.Total(), "should be equal: %s", test.name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"bytes"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+)
+
+/*
+ * DigestVerifier compares the digests computed by store.Value.ValueDigest instead of the values. It's
+ * used when the source is an export file that only holds the digests, so the differing fields of a
+ * conflicting key aren't known.
+ */
+type DigestVerifier struct {
+	VerifierBase
+}
+
+func NewDigestVerifier(stat *metric.Stat, param *FullCheckParameter) *DigestVerifier {
+	return &DigestVerifier{VerifierBase{stat, param}}
+}
+
+func (p *DigestVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflictKey chan<- *common.Key, sourceClient *client.RedisClient, targetClient *client.RedisClient) {
+	p.FetchTypeAndLen(keyInfo, sourceClient, targetClient)
+
+	// re-check ttl on the source side when key missing on the target side
+	p.RecheckTTL(keyInfo, sourceClient)
+
+	sameLen := make([]*common.Key, 0, len(keyInfo))
+	for _, key := range keyInfo {
+		if key.ConflictType == common.SkippedConflict || key.SourceAttr.ItemCount == common.TypeChanged {
+			continue
+		}
+		if key.Tp == common.NoneKeyType {
+			key.ConflictType = common.NoneConflict
+			p.IncrKeyStat(key)
+			continue
+		}
+
+		if key.TargetAttr.ItemCount == 0 && key.TargetAttr.ItemCount != key.SourceAttr.ItemCount {
+			key.ConflictType = common.LackTargetConflict
+		} else if key.TargetAttr.ItemCount == common.TypeChanged {
+			key.ConflictType = common.TypeConflict
+		} else if key.SourceAttr.ItemCount != key.TargetAttr.ItemCount {
+			key.ConflictType = common.ValueConflict
+		} else {
+			sameLen = append(sameLen, key)
+			continue
+		}
+		p.IncrKeyStat(key)
+		conflictKey <- key
+	}
+	if len(sameLen) == 0 {
+		return
+	}
+
+	sourceDigests, err := sourceClient.ValueDigests(sameLen)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	targetDigests, err := targetClient.ValueDigests(sameLen)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	for i, key := range sameLen {
+		switch {
+		case sourceDigests[i] == nil:
+			// expired or deleted on the source side meanwhile
+			key.ConflictType = common.NoneConflict
+		case targetDigests[i] == nil:
+			key.ConflictType = common.LackTargetConflict
+		case !bytes.Equal(sourceDigests[i], targetDigests[i]):
+			key.ConflictType = common.ValueConflict
+		default:
+			key.ConflictType = common.NoneConflict
+		}
+		p.IncrKeyStat(key)
+		if key.ConflictType != common.NoneConflict {
+			conflictKey <- key
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package checker
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+)
+
+type PrefixCompareMode struct {
+	Prefix []byte
+	Mode   int
+}
+
+/*
+ * DispatchVerifier chooses the compare mode for every key instead of using one mode for the whole
+ * run: the longest matched prefix rule wins, then the data type rule, then the default mode.
+ */
+type DispatchVerifier struct {
+	VerifierBase
+	defaultMode int
+	verifiers   map[int]IVerifier // compare mode -> verifier
+}
+
+func NewDispatchVerifier(stat *metric.Stat, param *FullCheckParameter, defaultMode int,
+	verifiers map[int]IVerifier) *DispatchVerifier {
+	return &DispatchVerifier{
+		VerifierBase: VerifierBase{stat, param},
+		defaultMode:  defaultMode,
+		verifiers:    verifiers,
+	}
+}
+
+func (p *DispatchVerifier) prefixMode(key []byte) (int, bool) {
+	// rules are sorted by prefix length in descending order
+	for _, rule := range p.Param.PrefixCompareMode {
+		if bytes.HasPrefix(key, rule.Prefix) {
+			return rule.Mode, true
+		}
+	}
+	return 0, false
+}
+
+func (p *DispatchVerifier) typeMode(tp *common.KeyType) int {
+	if mode, ok := p.Param.TypeCompareMode[tp.Index]; ok {
+		return mode
+	}
+	return p.defaultMode
+}
+
+func (p *DispatchVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflictKey chan<- *common.Key,
+	sourceClient *client.RedisClient, targetClient *client.RedisClient) {
+	group := make(map[int][]*common.Key)
+	noTypeKeyInfo := make([]*common.Key, 0, len(keyInfo))
+	for _, key := range keyInfo {
+		if mode, ok := p.prefixMode(key.Key); ok {
+			group[mode] = append(group[mode], key)
+		} else if key.Tp == common.EndKeyType && len(p.Param.TypeCompareMode) != 0 {
+			noTypeKeyInfo = append(noTypeKeyInfo, key)
+		} else {
+			mode := p.typeMode(key.Tp)
+			group[mode] = append(group[mode], key)
+		}
+	}
+
+	if len(noTypeKeyInfo) != 0 {
+		sourceKeyTypeStr, err := sourceClient.PipeTypeCommand(noTypeKeyInfo)
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		// the verifiers reuse the type instead of fetching it again
+		for i, t := range sourceKeyTypeStr {
+			noTypeKeyInfo[i].Tp = common.NewKeyType(t)
+			mode := p.typeMode(noTypeKeyInfo[i].Tp)
+			group[mode] = append(group[mode], noTypeKeyInfo[i])
+		}
+	}
+
+	for mode, keys := range group {
+		p.verifiers[mode].VerifyOneGroupKeyInfo(keys, conflictKey, sourceClient, targetClient)
+	}
+}
+
+// ParseTypeCompareMode parses the type rules, e.g., "string:1;hash:1;list:2".
+func ParseTypeCompareMode(input string) (map[common.KeyTypeIndex]int, error) {
+	ret := make(map[common.KeyTypeIndex]int)
+	if input == "" {
+		return ret, nil
+	}
+
+	for _, ele := range strings.Split(input, common.Splitter) {
+		items := strings.Split(ele, ":")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid type compare mode[%v], should be 'type:mode'", ele)
+		}
+		tp := common.NewKeyType(strings.ToLower(strings.TrimSpace(items[0])))
+		if tp == common.EndKeyType || tp == common.NoneKeyType {
+			return nil, fmt.Errorf("unknown data type[%v]", items[0])
+		}
+		mode, err := strconv.Atoi(strings.TrimSpace(items[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid compare mode[%v] of type[%v]", items[1], items[0])
+		}
+		ret[tp.Index] = mode
+	}
+	return ret, nil
+}
+
+// ParsePrefixCompareMode parses the prefix rules, e.g., "session:=3;big:=4".
+func ParsePrefixCompareMode(input string) ([]PrefixCompareMode, error) {
+	ret := make([]PrefixCompareMode, 0)
+	if input == "" {
+		return ret, nil
+	}
+
+	for _, ele := range strings.Split(input, common.Splitter) {
+		idx := strings.LastIndex(ele, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid prefix compare mode[%v], should be 'prefix=mode'", ele)
+		}
+		mode, err := strconv.Atoi(ele[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid compare mode[%v] of prefix[%v]", ele[idx+1:], ele[:idx])
+		}
+		ret = append(ret, PrefixCompareMode{Prefix: []byte(ele[:idx]), Mode: mode})
+	}
+
+	// the longest prefix wins
+	sort.SliceStable(ret, func(i, j int) bool {
+		return len(ret[i].Prefix) > len(ret[j].Prefix)
+	})
+	return ret, nil
+}
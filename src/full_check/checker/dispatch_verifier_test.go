@@ -0,0 +1,103 @@
+package checker
+
+import (
+	"fmt"
+	"testing"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+ * fakeVerifier records the keys it gets and reports the ones that conflict returns true for, then
+ * the extra keys as if found elsewhere.
+ */
+type fakeVerifier struct {
+	VerifierBase
+	keys     []string
+	conflict func(key *common.Key) bool
+	extra    []*common.Key
+}
+
+func (p *fakeVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflictKey chan<- *common.Key,
+	sourceClient *client.RedisClient, targetClient *client.RedisClient) {
+	for _, key := range keyInfo {
+		p.keys = append(p.keys, string(key.Key))
+		if key.Tp == common.EndKeyType {
+			key.Tp = common.StringKeyType
+		}
+		if p.conflict != nil && p.conflict(key) {
+			key.ConflictType = common.ValueConflict
+			p.IncrKeyStat(key)
+			conflictKey <- key
+		} else {
+			key.ConflictType = common.NoneConflict
+			p.IncrKeyStat(key)
+		}
+	}
+	for _, key := range p.extra {
+		p.IncrKeyStat(key)
+		conflictKey <- key
+	}
+}
+
+func TestDispatchVerifier(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestDispatchVerifier case %d.\n", nr)
+
+		prefix, err := ParsePrefixCompareMode("user:=3;user:vip:=4")
+		assert.Nil(t, err, "should be nil")
+		// the longest prefix first
+		assert.Equal(t, []byte("user:vip:"), prefix[0].Prefix, "should be equal")
+
+		types, err := ParseTypeCompareMode("hash:2;list:3")
+		assert.Nil(t, err, "should be nil")
+
+		var stat metric.Stat
+		param := &FullCheckParameter{TypeCompareMode: types, PrefixCompareMode: prefix}
+		verifiers := make(map[int]IVerifier)
+		fakes := make(map[int]*fakeVerifier)
+		for _, mode := range []int{1, 2, 3, 4} {
+			fakes[mode] = &fakeVerifier{VerifierBase: VerifierBase{&stat, param}}
+			verifiers[mode] = fakes[mode]
+		}
+		dispatcher := NewDispatchVerifier(&stat, param, 1, verifiers)
+
+		// the types are known, no type is fetched
+		keyInfo := []*common.Key{
+			{Key: []byte("user:vip:1"), Tp: common.HashKeyType, ConflictType: common.ValueConflict},
+			{Key: []byte("user:1"), Tp: common.HashKeyType, ConflictType: common.ValueConflict},
+			{Key: []byte("order:1"), Tp: common.HashKeyType, ConflictType: common.ValueConflict},
+			{Key: []byte("order:2"), Tp: common.ListKeyType, ConflictType: common.ValueConflict},
+			{Key: []byte("order:3"), Tp: common.StringKeyType, ConflictType: common.ValueConflict},
+		}
+		conflictKey := make(chan *common.Key, len(keyInfo))
+		dispatcher.VerifyOneGroupKeyInfo(keyInfo, conflictKey, nil, nil)
+
+		// prefix over type over the default mode
+		assert.Equal(t, []string{"order:3"}, fakes[1].keys, "should be equal")
+		assert.Equal(t, []string{"order:1"}, fakes[2].keys, "should be equal")
+		assert.Equal(t, []string{"user:1", "order:2"}, fakes[3].keys, "should be equal")
+		assert.Equal(t, []string{"user:vip:1"}, fakes[4].keys, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestDispatchVerifier case %d.\n", nr)
+
+		// the type fetched by the dispatcher in the first compare isn't fetched again
+		var base VerifierBase
+		keyInfo := []*common.Key{
+			{Key: []byte("a"), Tp: common.HashKeyType, ConflictType: common.EndConflict},
+			{Key: []byte("b"), Tp: common.NoneKeyType, ConflictType: common.EndConflict},
+		}
+		base.FetchType(keyInfo, nil)
+		assert.Equal(t, common.HashKeyType, keyInfo[0].Tp, "should be equal")
+		assert.Equal(t, common.NoneKeyType, keyInfo[1].Tp, "should be equal")
+	}
+}
@@ -0,0 +1,120 @@
+package checker
+
+import (
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+)
+
+// the ttl on the target is rounded by the time it's fetched
+const fixtureTTLSlackMs = 1000
+
+/*
+ * FixtureVerifier verifies the target against a fixture loaded as the source. Besides the values
+ * compared by the inner verifier, a key with a ttl in the fixture should expire on the target no
+ * later than the fixture says, otherwise it's reported as a value conflict. The keys found only on
+ * the target are reported as lack_source and verified again in the following rounds.
+ */
+type FixtureVerifier struct {
+	VerifierBase
+	inner IVerifier
+}
+
+func NewFixtureVerifier(stat *metric.Stat, param *FullCheckParameter, inner IVerifier) *FixtureVerifier {
+	return &FixtureVerifier{VerifierBase{stat, param}, inner}
+}
+
+func (p *FixtureVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflictKey chan<- *common.Key, sourceClient *client.RedisClient, targetClient *client.RedisClient) {
+	extraKeys := make([]*common.Key, 0)
+	fixtureKeys := make([]*common.Key, 0, len(keyInfo))
+	for _, key := range keyInfo {
+		if key.ConflictType == common.LackSourceConflict {
+			extraKeys = append(extraKeys, key)
+		} else {
+			fixtureKeys = append(fixtureKeys, key)
+		}
+	}
+
+	if len(extraKeys) != 0 {
+		p.verifyExtraKeys(extraKeys, conflictKey, targetClient)
+	}
+	if len(fixtureKeys) != 0 {
+		fixtureKeys = p.verifyTTL(fixtureKeys, conflictKey, sourceClient, targetClient)
+	}
+	if len(fixtureKeys) != 0 {
+		p.inner.VerifyOneGroupKeyInfo(fixtureKeys, conflictKey, sourceClient, targetClient)
+	}
+}
+
+// verifyExtraKeys reports the keys that are still on the target, the fixture doesn't change.
+func (p *FixtureVerifier) verifyExtraKeys(keyInfo []*common.Key, conflictKey chan<- *common.Key,
+	targetClient *client.RedisClient) {
+	types, err := targetClient.PipeTypeCommand(keyInfo)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	for i, key := range keyInfo {
+		if tp := common.NewKeyType(types[i]); tp != common.NoneKeyType {
+			key.Tp = tp
+			p.IncrKeyStat(key)
+			conflictKey <- key
+		} else {
+			key.ConflictType = common.NoneConflict
+			p.IncrKeyStat(key)
+		}
+	}
+}
+
+// verifyTTL reports the keys that expire later on the target than in the fixture and returns the rest.
+func (p *FixtureVerifier) verifyTTL(keyInfo []*common.Key, conflictKey chan<- *common.Key, sourceClient,
+	targetClient *client.RedisClient) []*common.Key {
+	sourceTTL, err := sourceClient.PipePTTLCommand(keyInfo)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	expiring := make([]*common.Key, 0)
+	expiringTTL := make([]int64, 0)
+	rest := make([]*common.Key, 0, len(keyInfo))
+	for i, key := range keyInfo {
+		if sourceTTL[i] > 0 {
+			expiring = append(expiring, key)
+			expiringTTL = append(expiringTTL, sourceTTL[i])
+		} else {
+			rest = append(rest, key)
+		}
+	}
+	if len(expiring) == 0 {
+		return rest
+	}
+
+	targetTTL, err := targetClient.PipePTTLCommand(expiring)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	conflicts := make([]*common.Key, 0)
+	for i, key := range expiring {
+		// -1 means no expiry, a missing key(-2) is reported by the inner verifier
+		if targetTTL[i] == -1 || targetTTL[i] > expiringTTL[i]+fixtureTTLSlackMs {
+			common.Logger.Debugf("ttl of key[%s] conflicts: fixture %dms, target %dms", key.Key, expiringTTL[i],
+				targetTTL[i])
+			conflicts = append(conflicts, key)
+		} else {
+			rest = append(rest, key)
+		}
+	}
+	if len(conflicts) == 0 {
+		return rest
+	}
+
+	types, err := sourceClient.PipeTypeCommand(conflicts)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	for i, key := range conflicts {
+		key.Tp = common.NewKeyType(types[i])
+		key.ConflictType = common.ValueConflict
+		p.IncrKeyStat(key)
+		conflictKey <- key
+	}
+	return rest
+}
@@ -30,7 +30,8 @@ func (p *FullValueVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflic
 	// 对于没有类型的Key, 取类型和长度
 	noTypeKeyInfo := make([]*common.Key, 0, len(keyInfo))
 	for i := 0; i < len(keyInfo); i++ {
-		if keyInfo[i].Tp == common.EndKeyType {
+		// the type may be fetched already by the dispatcher in the first compare
+		if keyInfo[i].Tp == common.EndKeyType || keyInfo[i].ConflictType == common.EndConflict {
 			noTypeKeyInfo = append(noTypeKeyInfo, keyInfo[i])
 		}
 	}
@@ -45,6 +46,11 @@ func (p *FullValueVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflic
 	fullCheckFetchAllKeyInfo := make([]*common.Key, 0, len(keyInfo))
 	retryNewVerifyKeyInfo := make([]*common.Key, 0, len(keyInfo))
 	for i := 0; i < len(keyInfo); i++ {
+		// excluded by the data type rules
+		if keyInfo[i].ConflictType == common.SkippedConflict {
+			continue
+		}
+
 		/************ 所有第一次比较的key，之前未比较的 key ***********/
 		if keyInfo[i].ConflictType == common.EndConflict { // 第二轮及以后比较的key，conflictType 肯定不是EndConflict
 			// 取type时，source redis上key已经被删除，认为是没有不一致
@@ -257,6 +263,7 @@ func (p *FullValueVerifier) CheckPartialValueHash(oneKeyInfo *common.Key, confli
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
+		args[0] = targetClient.KeyName(oneKeyInfo)
 		targetReply, err := targetClient.Do("hmget", args...)
 		if err != nil {
 			panic(common.Logger.Error(err))
@@ -288,7 +295,7 @@ func (p *FullValueVerifier) CheckPartialValueSet(oneKeyInfo *common.Key, conflic
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
-		tmpTargetValue, err := targetClient.PipeSismemberCommand(oneKeyInfo.Key, sendField)
+		tmpTargetValue, err := targetClient.PipeSismemberCommand(targetClient.KeyName(oneKeyInfo), sendField)
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
@@ -319,7 +326,7 @@ func (p *FullValueVerifier) CheckPartialValueSortedSet(oneKeyInfo *common.Key, c
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
-		tmpTargetValue, err := targetClient.PipeZscoreCommand(oneKeyInfo.Key, sendField)
+		tmpTargetValue, err := targetClient.PipeZscoreCommand(targetClient.KeyName(oneKeyInfo), sendField)
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
@@ -353,7 +360,7 @@ func (p *FullValueVerifier) CheckFullBigValue_List(oneKeyInfo *common.Key, confl
 		}
 		sourceValue := sourceReply.([]interface{})
 
-		targetReply, err := targetClient.Do("lrange", oneKeyInfo.Key, startIndex, startIndex+oneCmpCount-1)
+		targetReply, err := targetClient.Do("lrange", targetClient.KeyName(oneKeyInfo), startIndex, startIndex+oneCmpCount-1)
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
@@ -490,7 +497,7 @@ func (p *FullValueVerifier) CompareStream(oneKeyInfo *common.Key, conflictKey ch
 		panic(common.Logger.Error(err))
 	}
 
-	targetGroupsInfo, err := targetClient.Do("XINFO", "GROUPS", oneKeyInfo.Key)
+	targetGroupsInfo, err := targetClient.Do("XINFO", "GROUPS", targetClient.KeyName(oneKeyInfo))
 	if err != nil {
 		panic(common.Logger.Error(err))
 	}
@@ -539,7 +546,7 @@ func (p *FullValueVerifier) CompareStream(oneKeyInfo *common.Key, conflictKey ch
 		}
 
 		// 2. from target
-		targetXrange, err := targetClient.Do("XRANGE", oneKeyInfo.Key, startTs, "+", "COUNT", step)
+		targetXrange, err := targetClient.Do("XRANGE", targetClient.KeyName(oneKeyInfo), startTs, "+", "COUNT", step)
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
@@ -571,7 +578,7 @@ func (p *FullValueVerifier) CompareStream(oneKeyInfo *common.Key, conflictKey ch
 				panic(common.Logger.Error(err))
 			}
 
-			targetXpending, err := targetClient.Do("XPENDING", oneKeyInfo.Key, groupEle.name, startTs,
+			targetXpending, err := targetClient.Do("XPENDING", targetClient.KeyName(oneKeyInfo), groupEle.name, startTs,
 				"+", step)
 			if err != nil {
 				panic(common.Logger.Error(err))
@@ -20,12 +20,8 @@ func (p *KeyOutlineVerifier) FetchKeys(keyInfo []*common.Key, sourceClient *clie
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		sourceKeyTypeStr, err := sourceClient.PipeTypeCommand(keyInfo)
-		if err != nil {
-			panic(common.Logger.Critical(err))
-		}
-		for i, t := range sourceKeyTypeStr {
-			keyInfo[i].Tp = common.NewKeyType(t)
+		p.FetchType(keyInfo, sourceClient)
+		for i := range keyInfo {
 			/*
 			 * Bugfix: see https://github.com/alibaba/RedisFullCheck/issues/74.
 			 * It will skip the conflict key check because keyInfo[i].SourceAttr.ItemCount is zero here.
@@ -50,6 +46,8 @@ func (p *KeyOutlineVerifier) FetchKeys(keyInfo []*common.Key, sourceClient *clie
 	}()
 
 	wg.Wait()
+
+	p.FilterType(keyInfo)
 }
 
 func (p *KeyOutlineVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conflictKey chan<- *common.Key, sourceClient *client.RedisClient, targetClient *client.RedisClient) {
@@ -60,6 +58,11 @@ func (p *KeyOutlineVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, confli
 
 	// compare, filter
 	for i := 0; i < len(keyInfo); i++ {
+		// excluded by the data type rules
+		if keyInfo[i].ConflictType == common.SkippedConflict {
+			continue
+		}
+
 		// 在fetch type和之后的轮次扫描之间源端类型更改，不处理这种错误
 		if keyInfo[i].SourceAttr.ItemCount == common.TypeChanged {
 			continue
@@ -71,6 +74,9 @@ func (p *KeyOutlineVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, confli
 			keyInfo[i].ConflictType = common.LackTargetConflict
 			p.IncrKeyStat(keyInfo[i])
 			conflictKey <- keyInfo[i]
+		} else {
+			keyInfo[i].ConflictType = common.NoneConflict
+			p.IncrKeyStat(keyInfo[i])
 		}
 	} // end of for i := 0; i < len(keyInfo); i++
 }
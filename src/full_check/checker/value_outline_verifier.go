@@ -18,6 +18,11 @@ func (p *ValueOutlineVerifier) VerifyOneGroupKeyInfo(keyInfo []*common.Key, conf
 
 	// compare, filter
 	for i := 0; i < len(keyInfo); i++ {
+		// excluded by the data type rules
+		if keyInfo[i].ConflictType == common.SkippedConflict {
+			continue
+		}
+
 		// 取type时，source redis上key已经被删除，认为是没有不一致
 		if keyInfo[i].Tp == common.NoneKeyType {
 			keyInfo[i].ConflictType = common.NoneConflict
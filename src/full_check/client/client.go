@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/md5"
 	"fmt"
 	"io"
 	"net"
@@ -10,9 +11,9 @@ import (
 	"errors"
 
 	"full_check/common"
+	"full_check/store"
 
 	"github.com/gomodule/redigo/redis"
-	redigoCluster "github.com/najoast/redis-go-cluster"
 	"reflect"
 )
 
@@ -28,26 +29,59 @@ type RedisHost struct {
 	Authtype     string // "auth" or "adminauth"
 	DBType       int
 	DBFilterList map[int]struct{} // whitelist
+
+	// answers the commands instead of a live redis when set, e.g., the snapshot received by psync
+	Store *store.Store
+	// records the latency of each command or pipeline when set
+	Latency *common.LatencyWindow
+	// limits the bytes of the replies per second when set, shared by all clients of the side
+	Bandwidth *common.RateLimiter
+	// limits the requests per second and in flight when set, shared by all clients of the side
+	Throttle *common.Throttle
+	// connected instead when Addr can't be connected, e.g., the primary of the replica read
+	Fallback []string
 }
 
 func (p RedisHost) String() string {
 	return fmt.Sprintf("%s redis addr: %s", p.Role, p.Addr)
 }
 
+// Address returns the address list joined by semicolon, used to identify the host in results.
+func (p RedisHost) Address() string {
+	return strings.Join(p.Addr, AddressClusterSplitter)
+}
+
 func (p RedisHost) IsCluster() bool {
 	return p.DBType == common.TypeCluster
 }
 
+const (
+	RoleSource = "source"
+	RoleTarget = "target"
+)
+
 type RedisClient struct {
 	redisHost RedisHost
 	db        int32
 	conn      redis.Conn
+
+	// replies of the same command are reused when enabled, so that the source value is fetched
+	// only once when compared with several targets.
+	cache *ReplyCache
 }
 
 func (p RedisClient) String() string {
 	return p.redisHost.String()
 }
 
+// KeyName returns the name of the key on this side, the target name may be mapped from the source.
+func (p *RedisClient) KeyName(key *common.Key) []byte {
+	if p.redisHost.Role == RoleTarget && key.TargetKey != nil {
+		return key.TargetKey
+	}
+	return key.Key
+}
+
 func NewRedisClient(redisHost RedisHost, db int32) (RedisClient, error) {
 	rc := RedisClient{
 		redisHost: redisHost,
@@ -88,59 +122,113 @@ func (p *RedisClient) Connect() error {
 		return nil
 	}
 
-	var err error
-	if p.redisHost.IsCluster() == false {
-		// single db or proxy
-		if p.redisHost.TimeoutMs == 0 {
-			p.conn, err = redis.Dial("tcp", p.redisHost.Addr[0])
-		} else {
-			p.conn, err = redis.DialTimeout("tcp", p.redisHost.Addr[0], time.Millisecond*time.Duration(p.redisHost.TimeoutMs),
-				time.Millisecond*time.Duration(p.redisHost.TimeoutMs), time.Millisecond*time.Duration(p.redisHost.TimeoutMs))
-		}
-	} else {
-		// cluster
-		cluster, err := redigoCluster.NewCluster(
-			&redigoCluster.Options{
-				StartNodes:   p.redisHost.Addr,
-				ConnTimeout:  time.Duration(p.redisHost.TimeoutMs) * time.Millisecond,
-				ReadTimeout:  0,
-				WriteTimeout: 0,
-				KeepAlive:    16,
-				AliveTime:    60 * time.Second,
-				Password:     p.redisHost.Password,
-			})
-		if err == nil {
-			p.conn = common.NewClusterConn(cluster, 0)
-		}
+	if p.redisHost.Store != nil {
+		p.conn = store.NewConn(p.redisHost.Store, int(p.db))
+		return nil
+	}
+
+	// the connections are shared with the other clients of the host
+	conn, err := poolOf(p.redisHost).get(p.db)
+	if err != nil && len(p.redisHost.Fallback) != 0 {
+		common.Logger.Warnf("connect %v failed[%v], fall back to %v", p.redisHost, err, p.redisHost.Fallback)
+		fallback := p.redisHost
+		fallback.Addr, fallback.Fallback = p.redisHost.Fallback, nil
+		conn, err = poolOf(fallback).get(p.db)
 	}
 	if err != nil {
 		return err
 	}
+	p.conn = conn
+	return nil
+}
 
-	if len(p.redisHost.Password) != 0 {
-		var args []interface{}
-		for _, arg := range strings.Split(p.redisHost.Password, ":") {
-			args = append(args, arg)
-		}
-		if _, err := p.conn.Do(p.redisHost.Authtype, args...); err != nil {
-			return err
-		}
+// CacheMaxBytes bounds the replies kept by a reply cache, the commands beyond are sent again.
+const CacheMaxBytes = 16 * 1024 * 1024
+
+/*
+ * ReplyCache keeps the replies of the commands reading the compared values. The pages of the scan
+ * commands reading big keys aren't kept, nor the replies once CacheMaxBytes are kept.
+ */
+type ReplyCache struct {
+	replies map[string]interface{}
+	bytes   int64
+}
+
+func NewReplyCache() *ReplyCache {
+	return &ReplyCache{replies: make(map[string]interface{})}
+}
+
+func (c *ReplyCache) get(key string) (interface{}, bool) {
+	reply, ok := c.replies[key]
+	return reply, ok
+}
+
+func (c *ReplyCache) put(commandName, key string, reply interface{}) {
+	if strings.HasSuffix(strings.ToLower(commandName), "scan") {
+		return
+	}
+	size := replySize(reply) + int64(len(key))
+	if c.bytes+size > CacheMaxBytes {
+		return
 	}
+	c.replies[key] = reply
+	c.bytes += size
+}
 
-	if p.redisHost.DBType != common.TypeCluster {
-		_, err = p.conn.Do("select", p.db)
-		if err != nil {
-			return err
+// EnableCache starts reusing the replies of the same command until DisableCache is called.
+func (p *RedisClient) EnableCache() {
+	p.cache = NewReplyCache()
+}
+
+func (p *RedisClient) DisableCache() {
+	p.cache = nil
+}
+
+// SwapCache replaces the cache with the given one, nil disables it, and returns the one in use.
+func (p *RedisClient) SwapCache(cache *ReplyCache) *ReplyCache {
+	old := p.cache
+	p.cache = cache
+	return old
+}
+
+func cacheKey(commandName string, args []interface{}) string {
+	var buf strings.Builder
+	buf.WriteString(commandName)
+	for _, arg := range args {
+		buf.WriteByte(' ')
+		switch v := arg.(type) {
+		case []byte:
+			buf.WriteString(strconv.Quote(string(v)))
+		case string:
+			buf.WriteString(strconv.Quote(v))
+		default:
+			fmt.Fprint(&buf, v)
 		}
 	}
+	return buf.String()
+}
 
-	if p.conn == nil {
-		return fmt.Errorf("connect host[%v] failed: unknown", p.redisHost.Addr)
+func (p *RedisClient) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if p.cache != nil {
+		key := cacheKey(commandName, args)
+		if result, ok := p.cache.get(key); ok {
+			return result, nil
+		}
+		result, err := p.do(commandName, args...)
+		if err == nil {
+			p.cache.put(commandName, key, result)
+		}
+		return result, err
 	}
-	return nil
+	return p.do(commandName, args...)
 }
 
-func (p *RedisClient) Do(commandName string, args ...interface{}) (interface{}, error) {
+func (p *RedisClient) do(commandName string, args ...interface{}) (interface{}, error) {
+	if throttle := p.redisHost.Throttle; throttle != nil {
+		throttle.Acquire()
+		defer throttle.Release()
+	}
+
 	var err error
 	var result interface{}
 	for tryCount := 0; tryCount < common.MaxRetryCount; tryCount++ {
@@ -154,13 +242,16 @@ func (p *RedisClient) Do(commandName string, args ...interface{}) (interface{},
 			}
 		}
 
+		start := time.Now()
 		result, err = p.conn.Do(commandName, args...)
+		p.observeLatency(start)
 		if err != nil {
 			if p.CheckHandleNetError(err) {
 				continue
 			}
 			return nil, err
 		}
+		p.consumeBandwidth(result)
 		break
 	} // end for {}
 	return result, err
@@ -192,6 +283,45 @@ func (p *RedisClient) PipeRawCommand(commands []combine, specialErrorPrefix stri
 		common.Logger.Warnf("input commands length is 0")
 		return nil, emptyError
 	}
+	if p.cache == nil {
+		return p.pipeRawCommand(commands, specialErrorPrefix)
+	}
+
+	// only send the commands that aren't cached
+	result := make([]interface{}, len(commands))
+	keys := make([]string, len(commands))
+	missIndex := make([]int, 0, len(commands))
+	missCommands := make([]combine, 0, len(commands))
+	for i, ele := range commands {
+		keys[i] = cacheKey(ele.command, ele.params)
+		if reply, ok := p.cache.get(keys[i]); ok {
+			result[i] = reply
+		} else {
+			missIndex = append(missIndex, i)
+			missCommands = append(missCommands, ele)
+		}
+	}
+	if len(missCommands) == 0 {
+		return result, nil
+	}
+
+	ret, err := p.pipeRawCommand(missCommands, specialErrorPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, reply := range ret {
+		result[missIndex[i]] = reply
+		p.cache.put(commands[missIndex[i]].command, keys[missIndex[i]], reply)
+	}
+	return result, nil
+}
+
+func (p *RedisClient) pipeRawCommand(commands []combine, specialErrorPrefix string) ([]interface{}, error) {
+	// the pipeline counts as one request
+	if throttle := p.redisHost.Throttle; throttle != nil {
+		throttle.Acquire()
+		defer throttle.Release()
+	}
 
 	result := make([]interface{}, len(commands))
 	var err error
@@ -208,6 +338,7 @@ begin:
 			}
 		}
 
+		start := time.Now()
 		for _, ele := range commands {
 			err = p.conn.Send(ele.command, ele.params...)
 			if err != nil {
@@ -245,17 +376,53 @@ begin:
 			}
 			result[i] = reply
 		}
+		p.observeLatency(start)
+		p.consumeBandwidth(result)
 		break
 	} // end for {}
 	return result, nil
 }
 
+func (p *RedisClient) observeLatency(start time.Time) {
+	if p.redisHost.Latency != nil {
+		p.redisHost.Latency.Observe(time.Since(start))
+	}
+}
+
+// consumeBandwidth charges the size of the reply and waits when the side is over its byte rate.
+func (p *RedisClient) consumeBandwidth(reply interface{}) {
+	if p.redisHost.Bandwidth != nil {
+		p.redisHost.Bandwidth.Consume(replySize(reply))
+	}
+}
+
+// replySize approximates the bytes of the reply on the wire, the protocol overhead is ignored.
+func replySize(reply interface{}) int64 {
+	switch v := reply.(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	case []interface{}:
+		var size int64
+		for _, ele := range v {
+			size += replySize(ele)
+		}
+		return size
+	case redis.Error:
+		return int64(len(v))
+	default:
+		// integers and nil
+		return 8
+	}
+}
+
 func (p *RedisClient) PipeTypeCommand(keyInfo []*common.Key) ([]string, error) {
 	commands := make([]combine, len(keyInfo))
 	for i, key := range keyInfo {
 		commands[i] = combine{
 			command: "type",
-			params:  []interface{}{key.Key},
+			params:  []interface{}{p.KeyName(key)},
 		}
 	}
 
@@ -285,7 +452,7 @@ func (p *RedisClient) PipeExistsCommand(keyInfo []*common.Key) ([]int64, error)
 	for i, key := range keyInfo {
 		commands[i] = combine{
 			command: "exists",
-			params:  []interface{}{key.Key},
+			params:  []interface{}{p.KeyName(key)},
 		}
 	}
 
@@ -309,12 +476,139 @@ func (p *RedisClient) PipeExistsCommand(keyInfo []*common.Key) ([]int64, error)
 	return result, nil
 }
 
+/*
+ * PipeObjectCommand runs "object freq" or "object idletime" on the keys, -1 means the key is gone.
+ * An error is returned if the source doesn't track it, e.g., "freq" without an LFU maxmemory-policy.
+ */
+func (p *RedisClient) PipeObjectCommand(keyInfo []*common.Key, subcommand string) ([]int64, error) {
+	commands := make([]combine, len(keyInfo))
+	for i, key := range keyInfo {
+		commands[i] = combine{
+			command: "object",
+			params:  []interface{}{subcommand, p.KeyName(key)},
+		}
+	}
+
+	result := make([]int64, len(keyInfo))
+	ret, err := p.PipeRawCommand(commands, "")
+	if err != nil {
+		if err == emptyError {
+			return result, nil
+		}
+		return nil, err
+	}
+	for i, ele := range ret {
+		switch v := ele.(type) {
+		case int64:
+			result[i] = v
+		case nil:
+			result[i] = -1
+		default:
+			return nil, fmt.Errorf("object %s of key[%s] failed: %v", subcommand, keyInfo[i].Key, ele)
+		}
+	}
+	return result, nil
+}
+
+// PipeRandomKeyCommand runs "randomkey" count times, nil elements mean the db is empty.
+func (p *RedisClient) PipeRandomKeyCommand(count int) ([][]byte, error) {
+	commands := make([]combine, count)
+	for i := range commands {
+		commands[i] = combine{
+			command: "randomkey",
+			params:  []interface{}{},
+		}
+	}
+
+	result := make([][]byte, count)
+	if ret, err := p.PipeRawCommand(commands, ""); err != nil {
+		if err != emptyError {
+			return nil, err
+		}
+	} else {
+		for i, ele := range ret {
+			if ele == nil {
+				continue
+			}
+			if v, ok := ele.([]byte); ok {
+				result[i] = v
+			} else {
+				err := fmt.Errorf("run PipeRawCommand with commands[%s] return element[%v] isn't type bytes[%v]",
+					printCombinList(commands), ele, reflect.TypeOf(ele))
+				common.Logger.Error(err)
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+/*
+ * PipeDigestCommand fetches a digest of each value, nil means the key doesn't exist. "debug" uses
+ * "debug digest-value" computed by redis, "dump" hashes the serialized value without its trailing
+ * rdb version and checksum.
+ */
+func (p *RedisClient) PipeDigestCommand(keyInfo []*common.Key, method string) ([][]byte, error) {
+	commands := make([]combine, len(keyInfo))
+	for i, key := range keyInfo {
+		if method == common.DigestDebug {
+			commands[i] = combine{
+				command: "debug",
+				params:  []interface{}{[]byte("digest-value"), p.KeyName(key)},
+			}
+		} else {
+			commands[i] = combine{
+				command: "dump",
+				params:  []interface{}{p.KeyName(key)},
+			}
+		}
+	}
+
+	result := make([][]byte, len(keyInfo))
+	ret, err := p.PipeRawCommand(commands, "")
+	if err != nil {
+		if err != emptyError {
+			return nil, err
+		}
+		return result, nil
+	}
+	for i, ele := range ret {
+		if method == common.DigestDebug {
+			digests, ok := ele.([]interface{})
+			if ok && len(digests) == 1 {
+				if digest, ok := digests[0].([]byte); ok {
+					// all zero for the keys that don't exist
+					if strings.Trim(string(digest), "0") != "" {
+						result[i] = digest
+					}
+					continue
+				}
+			}
+		} else {
+			if ele == nil {
+				continue
+			}
+			if payload, ok := ele.([]byte); ok && len(payload) >= common.DumpTrailerLen {
+				digest := md5.Sum(payload[:len(payload)-common.DumpTrailerLen])
+				result[i] = digest[:]
+				continue
+			}
+		}
+
+		err := fmt.Errorf("run PipeRawCommand with commands[%s] return invalid digest[%v] with type[%v]",
+			printCombinList(commands), ele, reflect.TypeOf(ele))
+		common.Logger.Error(err)
+		return nil, err
+	}
+	return result, nil
+}
+
 func (p *RedisClient) PipeLenCommand(keyInfo []*common.Key) ([]int64, error) {
 	commands := make([]combine, len(keyInfo))
 	for i, key := range keyInfo {
 		commands[i] = combine{
 			command: key.Tp.FetchLenCommand,
-			params:  []interface{}{key.Key},
+			params:  []interface{}{p.KeyName(key)},
 		}
 	}
 
@@ -343,7 +637,7 @@ func (p *RedisClient) PipeTTLCommand(keyInfo []*common.Key) ([]bool, error) {
 	for i, key := range keyInfo {
 		commands[i] = combine{
 			command: "ttl",
-			params:  []interface{}{key.Key},
+			params:  []interface{}{p.KeyName(key)},
 		}
 	}
 
@@ -374,32 +668,32 @@ func (p *RedisClient) PipeValueCommand(keyInfo []*common.Key) ([]interface{}, er
 		case common.StringKeyType:
 			commands[i] = combine{
 				command: "get",
-				params:  []interface{}{key.Key},
+				params:  []interface{}{p.KeyName(key)},
 			}
 		case common.HashKeyType:
 			commands[i] = combine{
 				command: "hgetall",
-				params:  []interface{}{key.Key},
+				params:  []interface{}{p.KeyName(key)},
 			}
 		case common.ListKeyType:
 			commands[i] = combine{
 				command: "lrange",
-				params:  []interface{}{key.Key, "0", "-1"},
+				params:  []interface{}{p.KeyName(key), "0", "-1"},
 			}
 		case common.SetKeyType:
 			commands[i] = combine{
 				command: "smembers",
-				params:  []interface{}{key.Key},
+				params:  []interface{}{p.KeyName(key)},
 			}
 		case common.ZsetKeyType:
 			commands[i] = combine{
 				command: "zrange",
-				params:  []interface{}{key.Key, "0", "-1", "WITHSCORES"},
+				params:  []interface{}{p.KeyName(key), "0", "-1", "WITHSCORES"},
 			}
 		default:
 			commands[i] = combine{
 				command: "get",
-				params:  []interface{}{key.Key},
+				params:  []interface{}{p.KeyName(key)},
 			}
 		}
 	}
@@ -458,20 +752,20 @@ func (p *RedisClient) FetchValueUseScan_Hash_Set_SortedSet(oneKeyInfo *common.Ke
 	cursor := 0
 	value := make(map[string][]byte)
 	for {
-		reply, err := p.Do(scanCmd, oneKeyInfo.Key, cursor, "count", onceScanCount)
+		reply, err := p.Do(scanCmd, p.KeyName(oneKeyInfo), cursor, "count", onceScanCount)
 		if err != nil {
 			return nil, err
 		}
 
 		replyList, ok := reply.([]interface{})
 		if ok == false || len(replyList) != 2 {
-			return nil, fmt.Errorf("%s %s %d count %d failed, result: %+v", scanCmd, string(oneKeyInfo.Key),
+			return nil, fmt.Errorf("%s %s %d count %d failed, result: %+v", scanCmd, string(p.KeyName(oneKeyInfo)),
 				cursor, onceScanCount, reply)
 		}
 
 		cursorBytes, ok := replyList[0].([]byte)
 		if ok == false {
-			return nil, fmt.Errorf("%s %s %d count %d failed, result: %+v", scanCmd, string(oneKeyInfo.Key),
+			return nil, fmt.Errorf("%s %s %d count %d failed, result: %+v", scanCmd, string(p.KeyName(oneKeyInfo)),
 				cursor, onceScanCount, reply)
 		}
 
@@ -482,7 +776,7 @@ func (p *RedisClient) FetchValueUseScan_Hash_Set_SortedSet(oneKeyInfo *common.Ke
 
 		keylist, ok := replyList[1].([]interface{})
 		if ok == false {
-			panic(common.Logger.Criticalf("%s %s failed, result: %+v", scanCmd, string(oneKeyInfo.Key), reply))
+			panic(common.Logger.Criticalf("%s %s failed, result: %+v", scanCmd, string(p.KeyName(oneKeyInfo)), reply))
 		}
 		switch oneKeyInfo.Tp {
 		case common.HashKeyType:
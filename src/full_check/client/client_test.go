@@ -0,0 +1,43 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyCache(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestReplyCache case %d.\n", nr)
+
+		// the compared values are kept, the scan pages of big keys aren't
+		cache := NewReplyCache()
+		cache.put("get", cacheKey("get", []interface{}{[]byte("a")}), []byte("1"))
+		cache.put("hscan", cacheKey("hscan", []interface{}{[]byte("h"), 0}), []interface{}{[]byte("0")})
+		reply, ok := cache.get(cacheKey("get", []interface{}{[]byte("a")}))
+		assert.True(t, ok, "should be true")
+		assert.Equal(t, []byte("1"), reply, "should be equal")
+		_, ok = cache.get(cacheKey("hscan", []interface{}{[]byte("h"), 0}))
+		assert.False(t, ok, "should be false")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestReplyCache case %d.\n", nr)
+
+		// nothing is kept beyond CacheMaxBytes
+		cache := NewReplyCache()
+		big := bytes.Repeat([]byte("x"), CacheMaxBytes/2)
+		cache.put("get", "get a", big)
+		cache.put("get", "get b", big)
+		_, ok := cache.get("get a")
+		assert.True(t, ok, "should be true")
+		_, ok = cache.get("get b")
+		assert.False(t, ok, "should be false")
+		assert.True(t, cache.bytes <= CacheMaxBytes, "should be true")
+	}
+}
@@ -1,8 +1,10 @@
 package client
 
 import (
-	"strconv"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
 	"full_check/common"
 
@@ -88,3 +90,128 @@ func (p *RedisClient) FetchBaseInfo(isCluster bool) (map[int32]int64, []string,
 
 	return logicalDBMap, physicalDBList, nil
 }
+
+// FetchVersion returns the redis_version field of "info server".
+func (p *RedisClient) FetchVersion() (string, error) {
+	info, err := redis.Bytes(p.Do("info", "server"))
+	if err != nil {
+		return "", fmt.Errorf("get server info failed[%v]", err)
+	}
+	return common.ParseInfo(info)["redis_version"], nil
+}
+
+// FetchLoad returns the instantaneous_ops_per_sec of "info stats" and the cpu seconds used so far.
+func (p *RedisClient) FetchLoad() (int64, float64, error) {
+	stats, err := redis.Bytes(p.Do("info", "stats"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("get stats info failed[%v]", err)
+	}
+	cpu, err := redis.Bytes(p.Do("info", "cpu"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("get cpu info failed[%v]", err)
+	}
+
+	ops, err := strconv.ParseInt(common.ParseInfo(stats)["instantaneous_ops_per_sec"], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid instantaneous_ops_per_sec: %v", err)
+	}
+	cpuInfo := common.ParseInfo(cpu)
+	sys, err1 := strconv.ParseFloat(cpuInfo["used_cpu_sys"], 64)
+	user, err2 := strconv.ParseFloat(cpuInfo["used_cpu_user"], 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid used_cpu_sys[%v] or used_cpu_user[%v]", cpuInfo["used_cpu_sys"],
+			cpuInfo["used_cpu_user"])
+	}
+	return ops, sys + user, nil
+}
+
+// ReplicaState is one replica listed by "info replication" of its master.
+type ReplicaState struct {
+	Addr   string
+	State  string // "online" once the sync finished
+	Offset int64  // replication offset acknowledged by the replica
+	Lag    int64  // seconds since the last ack
+}
+
+/*
+ * FetchReplicas returns the role and master_repl_offset of "info replication" and the replicas
+ * listed there like "slave0:ip=127.0.0.1,port=6380,state=online,offset=1234,lag=0".
+ */
+func (p *RedisClient) FetchReplicas() (string, int64, []ReplicaState, error) {
+	content, err := redis.Bytes(p.Do("info", "replication"))
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("get replication info failed[%v]", err)
+	}
+	role, offset, replicas := parseReplicas(content)
+	return role, offset, replicas, nil
+}
+
+// parseReplicas parses "info replication", each replica is "slaveN:ip=...,port=...,state=...,offset=...,lag=...".
+func parseReplicas(content []byte) (string, int64, []ReplicaState) {
+	info := common.ParseInfo(content)
+	offset, _ := strconv.ParseInt(info["master_repl_offset"], 10, 64)
+
+	count, _ := strconv.Atoi(info["connected_slaves"])
+	replicas := make([]ReplicaState, 0, count)
+	for i := 0; i < count; i++ {
+		fields := make(map[string]string)
+		for _, item := range strings.Split(info[fmt.Sprintf("slave%d", i)], ",") {
+			if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+				fields[kv[0]] = kv[1]
+			}
+		}
+		if fields["ip"] == "" || fields["port"] == "" {
+			continue
+		}
+		replica := ReplicaState{
+			Addr:  net.JoinHostPort(fields["ip"], fields["port"]),
+			State: fields["state"],
+		}
+		replica.Offset, _ = strconv.ParseInt(fields["offset"], 10, 64)
+		replica.Lag, _ = strconv.ParseInt(fields["lag"], 10, 64)
+		replicas = append(replicas, replica)
+	}
+	return info["role"], offset, replicas
+}
+
+// FetchReplicationOffset returns the role and the offset of "info replication": master_repl_offset of
+// a master, or slave_repl_offset, the offset applied so far, of a replica.
+func (p *RedisClient) FetchReplicationOffset() (string, int64, error) {
+	content, err := redis.Bytes(p.Do("info", "replication"))
+	if err != nil {
+		return "", 0, fmt.Errorf("get replication info failed[%v]", err)
+	}
+	info := common.ParseInfo(content)
+	field := "master_repl_offset"
+	if info["role"] == "slave" {
+		field = "slave_repl_offset"
+	}
+	offset, err := strconv.ParseInt(info[field], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid %v[%v]", field, info[field])
+	}
+	return info["role"], offset, nil
+}
+
+// FetchClusterSlots returns the cluster_current_epoch of "cluster info" and the slot ranges of "cluster slots".
+func (p *RedisClient) FetchClusterSlots() (int64, []common.SlotRange, error) {
+	content, err := redis.Bytes(p.Do("cluster", "info"))
+	if err != nil {
+		return 0, nil, fmt.Errorf("get cluster info failed[%v]", err)
+	}
+	info := common.ParseInfo(content)
+	epoch, err := strconv.ParseInt(info["cluster_current_epoch"], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cluster_current_epoch[%v]", info["cluster_current_epoch"])
+	}
+
+	reply, err := p.Do("cluster", "slots")
+	if err != nil {
+		return 0, nil, fmt.Errorf("get cluster slots failed[%v]", err)
+	}
+	ranges, err := common.ParseClusterSlots(reply, p.redisHost.Addr[0])
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse cluster slots failed[%v]", err)
+	}
+	return epoch, ranges, nil
+}
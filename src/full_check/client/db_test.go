@@ -0,0 +1,65 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReplicas(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestParseReplicas case %d.\n", nr)
+
+		content := strings.Join([]string{
+			"# Replication",
+			"role:master",
+			"connected_slaves:2",
+			"slave0:ip=10.0.0.1,port=6379,state=online,offset=1000,lag=0",
+			"slave1:ip=::1,port=6380,state=wait_bgsave,offset=0,lag=12",
+			"master_replid:8c6f4e1b",
+			"master_repl_offset:1024",
+			"",
+		}, "\r\n")
+		role, offset, replicas := parseReplicas([]byte(content))
+		assert.Equal(t, "master", role, "should be equal")
+		assert.Equal(t, int64(1024), offset, "should be equal")
+		assert.Equal(t, []ReplicaState{
+			{Addr: "10.0.0.1:6379", State: "online", Offset: 1000, Lag: 0},
+			{Addr: "[::1]:6380", State: "wait_bgsave", Offset: 0, Lag: 12},
+		}, replicas, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestParseReplicas case %d.\n", nr)
+
+		// replicas without an address are skipped, missing numbers are 0
+		content := strings.Join([]string{
+			"role:master",
+			"connected_slaves:3",
+			"slave0:ip=10.0.0.1,state=online,offset=1,lag=0",
+			"slave1:ip=10.0.0.2,port=6379,state=online",
+			"slave2:garbage",
+		}, "\r\n")
+		role, offset, replicas := parseReplicas([]byte(content))
+		assert.Equal(t, "master", role, "should be equal")
+		assert.Equal(t, int64(0), offset, "should be equal")
+		assert.Equal(t, []ReplicaState{{Addr: "10.0.0.2:6379", State: "online"}}, replicas, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestParseReplicas case %d.\n", nr)
+
+		// a replica has no replicas of its own
+		content := "role:slave\r\nmaster_host:10.0.0.1\r\nconnected_slaves:0\r\nmaster_repl_offset:7\r\n"
+		role, offset, replicas := parseReplicas([]byte(content))
+		assert.Equal(t, "slave", role, "should be equal")
+		assert.Equal(t, int64(7), offset, "should be equal")
+		assert.Equal(t, 0, len(replicas), "should be equal")
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// KeyspaceEvents returns the "notify-keyspace-events" config of the redis.
+func (p *RedisClient) KeyspaceEvents() (string, error) {
+	reply, err := redis.Strings(p.Do("config", "get", "notify-keyspace-events"))
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("config get notify-keyspace-events returns invalid result[%v]", reply)
+	}
+	return reply[1], nil
+}
+
+// KeyspaceEventsEnabled returns true if the config publishes keyspace notifications of the writes.
+func KeyspaceEventsEnabled(events string) bool {
+	return strings.Contains(events, "K") && strings.ContainsAny(events, "A$lshzxegtd")
+}
+
+/*
+ * PSubscribe subscribes the channels matching the pattern and calls handle for every message. It
+ * only returns when the connection fails, and the client can't run other commands afterwards.
+ */
+func (p *RedisClient) PSubscribe(pattern string, handle func(channel string, data []byte)) error {
+	if err := p.Connect(); err != nil {
+		return err
+	}
+	defer p.Close()
+
+	psc := redis.PubSubConn{Conn: p.conn}
+	if err := psc.PSubscribe(pattern); err != nil {
+		return err
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			handle(v.Channel, v.Data)
+		case error:
+			return v
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"full_check/common"
+	"full_check/metric"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// give up waiting for a connection when the pool is still exhausted after this long
+	PoolWaitTimeout = time.Minute
+	// idle connections kept per host when not given
+	PoolMaxIdle = 8
+)
+
+type PoolOptions struct {
+	MaxActive   int           // connections per host, 0 means unlimited
+	MaxIdle     int           // idle connections kept per host
+	IdleTimeout time.Duration // close the connections idle for this long, 0 means never
+	HealthCheck time.Duration // ping the connections idle for this long before reusing them, 0 means never
+}
+
+/*
+ * The connections to each host are shared by all clients, so that the clients created per goroutine,
+ * per db and per round reuse them instead of connecting again. A pool is kept per host, role and
+ * credentials, the db is selected whenever a connection is taken from the pool.
+ */
+var (
+	poolOptions = PoolOptions{MaxIdle: PoolMaxIdle, HealthCheck: 30 * time.Second}
+	poolsLock   sync.Mutex
+	pools       = make(map[poolKey]*hostPool)
+)
+
+type hostPool struct {
+	redis.Pool
+	host RedisHost
+
+	dials     int64
+	unhealthy int64
+}
+
+/*
+ * NewPoolOptions returns the options of pools of at most size connections per host, 0 means
+ * unlimited. The idle connections kept are as many as the pool holds, or the given number of
+ * goroutines sharing it when it's unlimited, so that a released connection is reused.
+ */
+func NewPoolOptions(size, goroutines int, idleTimeout, healthCheck time.Duration) PoolOptions {
+	options := PoolOptions{
+		MaxActive:   size,
+		MaxIdle:     goroutines,
+		IdleTimeout: idleTimeout,
+		HealthCheck: healthCheck,
+	}
+	if size > 0 {
+		options.MaxIdle = size
+	}
+	if options.MaxIdle < 1 {
+		options.MaxIdle = PoolMaxIdle
+	}
+	return options
+}
+
+// SetPoolOptions changes the options of the pools created afterwards.
+func SetPoolOptions(options PoolOptions) {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+	poolOptions = options
+}
+
+// poolKey identifies the connections that can be shared: everything the dial depends on.
+type poolKey struct {
+	role      string
+	dbType    int
+	address   string
+	password  string
+	authType  string
+	timeoutMs uint64
+}
+
+func newPoolKey(host RedisHost) poolKey {
+	return poolKey{
+		role:      host.Role,
+		dbType:    host.DBType,
+		address:   host.Address(),
+		password:  host.Password,
+		authType:  host.Authtype,
+		timeoutMs: host.TimeoutMs,
+	}
+}
+
+func poolOf(host RedisHost) *hostPool {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	key := newPoolKey(host)
+	if pool, ok := pools[key]; ok {
+		return pool
+	}
+	pool := &hostPool{host: host}
+	pool.MaxActive = poolOptions.MaxActive
+	pool.MaxIdle = poolOptions.MaxIdle
+	pool.IdleTimeout = poolOptions.IdleTimeout
+	pool.Wait = true
+	pool.Dial = pool.dial
+	if healthCheck := poolOptions.HealthCheck; healthCheck > 0 {
+		pool.TestOnBorrow = func(conn redis.Conn, t time.Time) error {
+			if time.Since(t) < healthCheck {
+				return nil
+			}
+			_, err := conn.Do("ping")
+			if err != nil {
+				atomic.AddInt64(&pool.unhealthy, 1)
+				common.Logger.Warnf("drop unhealthy connection of %v: %v", pool.host, err)
+			}
+			return err
+		}
+	}
+	pools[key] = pool
+	return pool
+}
+
+func (p *hostPool) dial() (redis.Conn, error) {
+	atomic.AddInt64(&p.dials, 1)
+	timeout := time.Millisecond * time.Duration(p.host.TimeoutMs)
+	if p.host.IsCluster() {
+		// cluster, authenticated by the connection of each node
+		return common.NewClusterConn(p.host.Addr, p.host.Password, p.host.Authtype, timeout)
+	}
+
+	// single db or proxy
+	var conn redis.Conn
+	var err error
+	if timeout == 0 {
+		conn, err = redis.Dial("tcp", p.host.Addr[0])
+	} else {
+		conn, err = redis.DialTimeout("tcp", p.host.Addr[0], timeout, timeout, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(p.host.Password) != 0 {
+		var args []interface{}
+		for _, arg := range strings.Split(p.host.Password, ":") {
+			args = append(args, arg)
+		}
+		if _, err := conn.Do(p.host.Authtype, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// get takes a connection from the pool and selects the db.
+func (p *hostPool) get(db int32) (redis.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PoolWaitTimeout)
+	defer cancel()
+	conn, err := p.GetContext(ctx)
+	if err == context.DeadlineExceeded {
+		return nil, fmt.Errorf("no connection of %v is released in %v, the pool size %d is too small",
+			p.host, PoolWaitTimeout, p.MaxActive)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !p.host.IsCluster() {
+		if _, err := conn.Do("select", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (p *hostPool) stat() *metric.PoolStat {
+	stats := p.Stats()
+	return &metric.PoolStat{
+		Host:      fmt.Sprintf("%s %s", p.host.Role, p.host.Address()),
+		Active:    stats.ActiveCount,
+		Idle:      stats.IdleCount,
+		WaitCount: stats.WaitCount,
+		WaitMs:    stats.WaitDuration.Milliseconds(),
+		Dials:     atomic.LoadInt64(&p.dials),
+		Unhealthy: atomic.LoadInt64(&p.unhealthy),
+	}
+}
+
+// PoolStats returns the statistics of all pools ordered by host.
+func PoolStats() []*metric.PoolStat {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	ret := make([]*metric.PoolStat, 0, len(pools))
+	for _, pool := range pools {
+		ret = append(ret, pool.stat())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Host < ret[j].Host
+	})
+	return ret
+}
+
+// ClosePools closes the idle connections of all pools, the pools are created again when needed.
+func ClosePools() {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	for key, pool := range pools {
+		pool.Close()
+		delete(pools, key)
+	}
+}
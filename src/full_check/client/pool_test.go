@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"full_check/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveFakeRedis answers ping with PONG and any other command with OK until the listener is closed.
+func serveFakeRedis(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				args, err := readCommand(reader)
+				if err != nil {
+					return
+				}
+				reply := "+OK\r\n"
+				if strings.ToLower(args[0]) == "ping" {
+					reply = "+PONG\r\n"
+				}
+				if _, err := conn.Write([]byte(reply)); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	readLine := func(prefix byte) (int, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		if len(line) < 3 || line[0] != prefix {
+			return 0, fmt.Errorf("unexpected line %q", line)
+		}
+		return strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+	}
+	count, err := readLine('*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		length, err := readLine('$')
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:length])
+	}
+	return args, nil
+}
+
+func TestPoolOf(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestPoolOf case %d.\n", nr)
+
+		defer ClosePools()
+		host := RedisHost{
+			Addr:     []string{"127.0.0.1:6379"},
+			Password: "a",
+			Role:     RoleSource,
+			Authtype: "auth",
+			DBType:   common.TypeDB,
+		}
+		pool := poolOf(host)
+		// the db filter and the limits don't change the connections
+		same := host
+		same.DBFilterList = map[int]struct{}{1: {}}
+		same.Bandwidth = common.NewRateLimiter(1024)
+		assert.True(t, pool == poolOf(same), "should be true")
+
+		for _, change := range []func(host *RedisHost){
+			func(host *RedisHost) { host.Role = RoleTarget },
+			func(host *RedisHost) { host.DBType = common.TypeAliyunProxy },
+			func(host *RedisHost) { host.Addr = []string{"127.0.0.1:6380"} },
+			func(host *RedisHost) { host.Password = "b" },
+			func(host *RedisHost) { host.Authtype = "adminauth" },
+			func(host *RedisHost) { host.TimeoutMs = 1000 },
+		} {
+			other := host
+			change(&other)
+			assert.True(t, pool != poolOf(other), "should be true: %+v", other)
+		}
+		assert.Equal(t, 7, len(PoolStats()), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestPoolOf case %d.\n", nr)
+
+		// unlimited pools keep the idle connections of the goroutines, limited ones as many as they hold
+		assert.Equal(t, 4, NewPoolOptions(0, 4, 0, 0).MaxIdle, "should be equal")
+		assert.Equal(t, PoolMaxIdle, NewPoolOptions(0, 0, 0, 0).MaxIdle, "should be equal")
+		options := NewPoolOptions(10, 4, time.Minute, time.Second)
+		assert.Equal(t, PoolOptions{MaxActive: 10, MaxIdle: 10, IdleTimeout: time.Minute, HealthCheck: time.Second},
+			options, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestPoolOf case %d.\n", nr)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err, "should be nil")
+		defer listener.Close()
+		go serveFakeRedis(listener)
+
+		defer SetPoolOptions(poolOptions)
+		defer ClosePools()
+		ClosePools()
+		SetPoolOptions(NewPoolOptions(0, 2, 0, 0))
+		host := RedisHost{Addr: []string{listener.Addr().String()}, Role: RoleSource, DBType: common.TypeDB}
+
+		// a released connection is taken again by the next client, of another db as well
+		for db := int32(0); db < 3; db++ {
+			c, err := NewRedisClient(host, db)
+			assert.Nil(t, err, "should be nil")
+			c.Close()
+		}
+		stats := PoolStats()
+		assert.Equal(t, 1, len(stats), "should be equal")
+		assert.Equal(t, int64(1), stats[0].Dials, "should be equal")
+		assert.Equal(t, 1, stats[0].Idle, "should be equal")
+	}
+}
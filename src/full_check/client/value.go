@@ -0,0 +1,315 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"full_check/common"
+	"full_check/store"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// PipePTTLCommand fetches the remaining time to live in milliseconds, -1 means no expiry and -2 means
+// the key doesn't exist.
+func (p *RedisClient) PipePTTLCommand(keyInfo []*common.Key) ([]int64, error) {
+	commands := make([]combine, len(keyInfo))
+	for i, key := range keyInfo {
+		commands[i] = combine{
+			command: "pttl",
+			params:  []interface{}{p.KeyName(key)},
+		}
+	}
+
+	result := make([]int64, len(keyInfo))
+	if ret, err := p.PipeRawCommand(commands, ""); err != nil {
+		if err != emptyError {
+			return nil, err
+		}
+	} else {
+		for i, ele := range ret {
+			if v, ok := ele.(int64); ok {
+				result[i] = v
+			} else {
+				err := fmt.Errorf("run PipeRawCommand with commands[%s] return element[%v] isn't type int64[%v]",
+					printCombinList(commands), ele, reflect.TypeOf(ele))
+				common.Logger.Error(err)
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+/*
+ * FetchValues fetches the whole value of each key whose type is already known, nil means the key
+ * doesn't exist any more or its type changed. The expiry isn't fetched.
+ */
+func (p *RedisClient) FetchValues(keyInfo []*common.Key) ([]*store.Value, error) {
+	result := make([]*store.Value, len(keyInfo))
+	others := make([]*common.Key, 0, len(keyInfo))
+	othersIndex := make([]int, 0, len(keyInfo))
+	for i, key := range keyInfo {
+		if key.Tp == common.StreamKeyType {
+			value, err := p.fetchStream(key)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = value
+		} else if key.Tp != common.NoneKeyType && key.Tp != common.EndKeyType {
+			others = append(others, key)
+			othersIndex = append(othersIndex, i)
+		}
+	}
+	if len(others) == 0 {
+		return result, nil
+	}
+
+	ret, err := p.PipeValueCommand(others)
+	if err != nil {
+		return nil, err
+	}
+	for i, reply := range ret {
+		if reply == nil || reply == common.TypeChanged {
+			continue
+		}
+		value, err := newValue(others[i].Tp, reply)
+		if err != nil {
+			return nil, fmt.Errorf("fetch value of key[%s] failed: %v", others[i].Key, err)
+		}
+		result[othersIndex[i]] = value
+	}
+	return result, nil
+}
+
+func newValue(tp *common.KeyType, reply interface{}) (*store.Value, error) {
+	var value *store.Value
+	switch tp {
+	case common.StringKeyType:
+		str, err := redis.Bytes(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		return store.NewString(str), nil
+	case common.ListKeyType:
+		list, err := redis.ByteSlices(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		value = store.NewList()
+		value.List = list
+	case common.SetKeyType:
+		members, err := redis.ByteSlices(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		value = store.NewSet()
+		for _, member := range members {
+			value.Set[string(member)] = struct{}{}
+		}
+	case common.HashKeyType:
+		items, err := redis.ByteSlices(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		value = store.NewHash()
+		for i := 0; i+1 < len(items); i += 2 {
+			value.Hash[string(items[i])] = items[i+1]
+		}
+	case common.ZsetKeyType:
+		items, err := redis.ByteSlices(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+		value = store.NewZset()
+		for i := 0; i+1 < len(items); i += 2 {
+			score, err := strconv.ParseFloat(string(items[i+1]), 64)
+			if err != nil {
+				return nil, err
+			}
+			value.Zset[string(items[i])] = score
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type[%v]", tp)
+	}
+	// the key was deleted after its type was fetched
+	if value.Len() == 0 {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// infoMap converts the field-value array replied by "xinfo" to a map.
+func infoMap(reply interface{}) (map[string]interface{}, error) {
+	items, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{}, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		name, err := redis.String(items[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		ret[name] = items[i+1]
+	}
+	return ret, nil
+}
+
+func parseStreamID(reply interface{}) (store.StreamID, error) {
+	id, err := redis.String(reply, nil)
+	if err != nil {
+		return store.StreamID{}, err
+	}
+	return store.ParseStreamID(id, false)
+}
+
+/*
+ * fetchStream fetches the entries, the consumer groups and their pending entries of the stream, nil
+ * means the key doesn't exist any more. The fields added by 7.0 are kept unknown on older redis.
+ */
+func (p *RedisClient) fetchStream(key *common.Key) (*store.Value, error) {
+	name := p.KeyName(key)
+	reply, err := p.Do("xinfo", "stream", name)
+	if err != nil {
+		if _, ok := err.(redis.Error); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	info, err := infoMap(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	value := store.NewStream()
+	stream := value.Stream
+	if stream.LastID, err = parseStreamID(info["last-generated-id"]); err != nil {
+		return nil, err
+	}
+	if added, ok := info["entries-added"]; ok {
+		stream.EntriesAdded, _ = redis.Int64(added, nil)
+	}
+	if deleted, ok := info["max-deleted-entry-id"]; ok {
+		stream.MaxDeletedID, _ = parseStreamID(deleted)
+	}
+
+	entries, err := redis.Values(p.Do("xrange", name, "-", "+"))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range entries {
+		entry, err := redis.Values(item, nil)
+		if err != nil || len(entry) != 2 {
+			return nil, fmt.Errorf("invalid xrange entry[%v]", item)
+		}
+		id, err := parseStreamID(entry[0])
+		if err != nil {
+			return nil, err
+		}
+		fields, err := redis.ByteSlices(entry[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		stream.Entries = append(stream.Entries, &store.StreamEntry{ID: id, Fields: fields})
+	}
+
+	groups, err := redis.Values(p.Do("xinfo", "groups", name))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range groups {
+		info, err := infoMap(item)
+		if err != nil {
+			return nil, err
+		}
+		group := &store.StreamGroup{EntriesRead: -1}
+		if group.Name, err = redis.String(info["name"], nil); err != nil {
+			return nil, err
+		}
+		if group.LastID, err = parseStreamID(info["last-delivered-id"]); err != nil {
+			return nil, err
+		}
+		if read, ok := info["entries-read"]; ok && read != nil {
+			group.EntriesRead, _ = redis.Int64(read, nil)
+		}
+		pendingCount, _ := redis.Int64(info["pending"], nil)
+		if err := p.fetchStreamGroup(name, group, pendingCount); err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return value, nil
+}
+
+func (p *RedisClient) fetchStreamGroup(name []byte, group *store.StreamGroup, pendingCount int64) error {
+	consumers, err := redis.Values(p.Do("xinfo", "consumers", name, group.Name))
+	if err != nil {
+		return err
+	}
+	for _, item := range consumers {
+		info, err := infoMap(item)
+		if err != nil {
+			return err
+		}
+		consumer, err := redis.String(info["name"], nil)
+		if err != nil {
+			return err
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	if pendingCount == 0 {
+		return nil
+	}
+
+	pending, err := redis.Values(p.Do("xpending", name, group.Name, "-", "+", pendingCount))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, item := range pending {
+		fields, err := redis.Values(item, nil)
+		if err != nil || len(fields) != 4 {
+			return fmt.Errorf("invalid xpending entry[%v]", item)
+		}
+		id, err := parseStreamID(fields[0])
+		if err != nil {
+			return err
+		}
+		consumer, _ := redis.String(fields[1], nil)
+		idle, _ := redis.Int64(fields[2], nil)
+		count, _ := redis.Int64(fields[3], nil)
+		group.Pending = append(group.Pending, &store.StreamPending{
+			ID:            id,
+			Consumer:      consumer,
+			DeliveryTime:  now - idle,
+			DeliveryCount: count,
+		})
+	}
+	return nil
+}
+
+// ValueDigests returns the digest of each value computed by store.Value.ValueDigest, nil means the key
+// doesn't exist.
+func (p *RedisClient) ValueDigests(keyInfo []*common.Key) ([][]byte, error) {
+	result := make([][]byte, len(keyInfo))
+	if p.redisHost.Store != nil {
+		for i, key := range keyInfo {
+			result[i] = p.redisHost.Store.ValueDigest(int(p.db), string(p.KeyName(key)))
+		}
+		return result, nil
+	}
+
+	values, err := p.FetchValues(keyInfo)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if value != nil {
+			result[i] = value.ValueDigest()
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencies kept between two samples, the older ones are overwritten
+	latencyWindowSize = 4096
+
+	// the limit is multiplied by this when the source is overloaded
+	AdaptiveBackoffFactor = 0.5
+	// and raised by this fraction of the max limit per interval once it recovers
+	AdaptiveRampUpStep = 0.1
+)
+
+// LatencyWindow keeps the latest latencies of the commands to compute their percentiles.
+type LatencyWindow struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func NewLatencyWindow() *LatencyWindow {
+	return &LatencyWindow{samples: make([]time.Duration, 0, latencyWindowSize)}
+}
+
+func (w *LatencyWindow) Observe(latency time.Duration) {
+	w.lock.Lock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+		w.next = (w.next + 1) % latencyWindowSize
+	}
+	w.lock.Unlock()
+}
+
+// Percentile returns the given percentile(e.g., 0.99) of the latencies since the last call and clears them.
+// The second return is false when nothing was observed.
+func (w *LatencyWindow) Percentile(percentile float64) (time.Duration, bool) {
+	w.lock.Lock()
+	samples := w.samples
+	w.samples = make([]time.Duration, 0, latencyWindowSize)
+	w.next = 0
+	w.lock.Unlock()
+
+	if len(samples) == 0 {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	idx := int(float64(len(samples))*percentile+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+	return samples[idx], true
+}
+
+/*
+ * AdaptiveLimiter adjusts a qps limit between min and max: it backs off multiplicatively whenever
+ * the source is found overloaded and ramps up additively afterwards.
+ */
+type AdaptiveLimiter struct {
+	min   int64
+	max   int64
+	limit int64
+}
+
+func NewAdaptiveLimiter(min, max int) *AdaptiveLimiter {
+	return &AdaptiveLimiter{min: int64(min), max: int64(max), limit: int64(max)}
+}
+
+func (l *AdaptiveLimiter) Limit() int {
+	return int(atomic.LoadInt64(&l.limit))
+}
+
+// Adjust changes the limit by whether the source is overloaded, it returns the old and the new limit.
+func (l *AdaptiveLimiter) Adjust(overloaded bool) (int, int) {
+	old := atomic.LoadInt64(&l.limit)
+	limit := old
+	if overloaded {
+		limit = int64(float64(old) * AdaptiveBackoffFactor)
+		if limit < l.min {
+			limit = l.min
+		}
+	} else if old < l.max {
+		step := int64(float64(l.max) * AdaptiveRampUpStep)
+		if step < 1 {
+			step = 1
+		}
+		if limit = old + step; limit > l.max {
+			limit = l.max
+		}
+	}
+	atomic.StoreInt64(&l.limit, limit)
+	return int(old), int(limit)
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestAdaptiveLimiter case %d.\n", nr)
+
+		limiter := NewAdaptiveLimiter(10, 100)
+		assert.Equal(t, 100, limiter.Limit(), "should be equal")
+
+		// back off down to the min
+		for _, expect := range []int{50, 25, 12, 10, 10} {
+			_, limit := limiter.Adjust(true)
+			assert.Equal(t, expect, limit, "should be equal")
+		}
+		// ramp up to the max
+		for _, expect := range []int{20, 30, 40, 50, 60, 70, 80, 90, 100, 100} {
+			_, limit := limiter.Adjust(false)
+			assert.Equal(t, expect, limit, "should be equal")
+		}
+	}
+
+	{
+		nr++
+		fmt.Printf("TestAdaptiveLimiter case %d.\n", nr)
+
+		window := NewLatencyWindow()
+		_, ok := window.Percentile(0.99)
+		assert.False(t, ok, "should be false")
+
+		for i := 1; i <= 100; i++ {
+			window.Observe(time.Duration(i) * time.Millisecond)
+		}
+		p99, ok := window.Percentile(0.99)
+		assert.True(t, ok, "should be true")
+		assert.Equal(t, 99*time.Millisecond, p99, "should be equal")
+
+		// cleared by the last call
+		_, ok = window.Percentile(0.99)
+		assert.False(t, ok, "should be false")
+	}
+}
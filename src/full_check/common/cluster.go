@@ -1,101 +1,471 @@
 package common
 
 import (
-	redigoCluster "github.com/najoast/redis-go-cluster"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	redigo "github.com/gomodule/redigo/redis"
 )
 
-const(
-	RecvChanSize = 4096
+const (
+	// redirections followed for one command before its error is returned
+	MaxRedirections = 5
 )
 
-/* implement redigo.Conn(https://github.com/garyburd/redigo)
- * Embed redis-go-cluster(https://github.com/chasex/redis-go-cluster)
- * The reason I create this struct is that redis-go-cluster isn't fulfill redigo.Conn
- * interface. So I implement "Err", "Send", "Flush" and "Receive" interfaces.
+// MOVED redirections answered to all cluster connections, a hint that the slots changed
+var movedRedirections int64
+
+func MovedRedirections() int64 {
+	return atomic.LoadInt64(&movedRedirections)
+}
+
+/*
+ * ClusterConn implements redigo.Conn(https://github.com/gomodule/redigo) on top of a redis cluster.
+ * It keeps a local map of the node serving each slot, which is loaded by "cluster slots" and
+ * refreshed when a node answers MOVED. The commands sent before a flush are grouped by node into
+ * one pipeline per node, the pipelines run in parallel and the replies are returned in the order
+ * the commands were sent. MOVED and ASK redirections are followed per command.
+ *
+ * A slot is served by its master. If the master isn't among the given nodes but one of its
+ * replicas is, e.g., all the given nodes are replicas, the replica serves the slot in "readonly"
+ * mode.
  */
 type ClusterConn struct {
-	client   *redigoCluster.Cluster
-	recvChan chan reply
-	batcher  *redigoCluster.Batch
+	startNodes []string
+	password   string
+	authType   string
+	timeout    time.Duration
+
+	slots    [ClusterSlots]string   // address of the node serving each slot
+	readonly map[string]bool        // the nodes that are replicas
+	nodes    map[string]redigo.Conn // connections to the nodes
+
+	pending []clusterCommand
+	replies []interface{}
+	err     error // the last failure of the connections, the replies got lost
 }
 
-type reply struct {
-	answer interface{}
-	err    error
+type clusterCommand struct {
+	name string
+	args []interface{}
 }
 
-func NewClusterConn(clusterClient *redigoCluster.Cluster, recvChanSize int) redigo.Conn {
-	if recvChanSize == 0 {
-		recvChanSize = RecvChanSize
-	}
+// clusterRoute is the command sent to a node in a pipeline, asking is set when redirected by ASK.
+type clusterRoute struct {
+	index  int
+	addr   string
+	asking bool
+}
 
-	return &ClusterConn{
-		client:   clusterClient,
-		recvChan: make(chan reply, recvChanSize),
+func NewClusterConn(startNodes []string, password, authType string, timeout time.Duration) (redigo.Conn, error) {
+	cc := &ClusterConn{
+		startNodes: startNodes,
+		password:   password,
+		authType:   authType,
+		timeout:    timeout,
+		readonly:   make(map[string]bool),
+		nodes:      make(map[string]redigo.Conn),
+	}
+	if err := cc.refreshSlots(); err != nil {
+		cc.Close()
+		return nil, err
 	}
+	return cc, nil
 }
 
 func (cc *ClusterConn) Close() error {
-	cc.client.Close()
+	for addr, conn := range cc.nodes {
+		conn.Close()
+		delete(cc.nodes, addr)
+	}
 	return nil
 }
 
 func (cc *ClusterConn) Err() error {
-	return nil
+	return cc.err
 }
 
-func (cc *ClusterConn) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
-	return cc.client.Do(commandName, args...)
+func (cc *ClusterConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	// flush the pending commands and drop their replies, used by the pool when the conn is released
+	if commandName == "" {
+		err := cc.Flush()
+		cc.replies = nil
+		return nil, err
+	}
+
+	replies, err := cc.run([]clusterCommand{{name: commandName, args: args}})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(redigo.Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
 }
 
-// just add into batcher
+// just add into the pending commands
 func (cc *ClusterConn) Send(commandName string, args ...interface{}) error {
-	if cc.batcher == nil {
-		cc.batcher = cc.client.NewBatch()
-	}
-	return cc.batcher.Put(commandName, args...)
+	cc.pending = append(cc.pending, clusterCommand{name: commandName, args: args})
+	return nil
 }
 
-// send batcher and put the return into recvChan
+// run the pending commands and keep the replies for "Receive"
 func (cc *ClusterConn) Flush() error {
-	ret, err := cc.client.RunBatch(cc.batcher)
-	defer func() {
-		cc.batcher = nil // reset batcher
-	}()
+	commands := cc.pending
+	cc.pending = nil
+	if len(commands) == 0 {
+		return nil
+	}
 
+	replies, err := cc.run(commands)
 	if err != nil {
-		cc.recvChan <- reply{
-			answer: nil,
-			err:    err,
+		return err
+	}
+	cc.replies = append(cc.replies, replies...)
+	return nil
+}
+
+// return the replies in the order the commands were sent
+func (cc *ClusterConn) Receive() (interface{}, error) {
+	if len(cc.replies) == 0 {
+		return nil, fmt.Errorf("no pending reply")
+	}
+	reply := cc.replies[0]
+	cc.replies = cc.replies[1:]
+	if err, ok := reply.(redigo.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// run runs the commands on the nodes serving their slots and follows the redirections.
+func (cc *ClusterConn) run(commands []clusterCommand) ([]interface{}, error) {
+	replies := make([]interface{}, len(commands))
+	routes := make([]clusterRoute, len(commands))
+	for i, command := range commands {
+		routes[i] = clusterRoute{index: i, addr: cc.nodeOf(command)}
+	}
+
+	for redirections := 0; len(routes) != 0; redirections++ {
+		if err := cc.runPipelines(commands, routes, replies); err != nil {
+			cc.err = err
+			return nil, err
+		}
+		if redirections == MaxRedirections {
+			break
 		}
 
+		redirected := make([]clusterRoute, 0)
+		moved := false
+		for _, route := range routes {
+			err, ok := replies[route.index].(redigo.Error)
+			if !ok {
+				continue
+			}
+			kind, slot, addr, ok := parseRedirection(err.Error())
+			if !ok {
+				continue
+			}
+			if kind == "MOVED" {
+				atomic.AddInt64(&movedRedirections, 1)
+				cc.slots[slot] = addr
+				delete(cc.readonly, addr)
+				moved = true
+			}
+			redirected = append(redirected, clusterRoute{index: route.index, addr: addr, asking: kind == "ASK"})
+		}
+		if moved {
+			// the other slots of the node probably moved as well
+			if err := cc.refreshSlots(); err != nil {
+				Logger.Warnf("refresh cluster slots after MOVED failed: %v", err)
+			}
+		}
+		routes = redirected
+	}
+	return replies, nil
+}
+
+// runPipelines sends the commands of each node in one pipeline, all nodes in parallel.
+func (cc *ClusterConn) runPipelines(commands []clusterCommand, routes []clusterRoute, replies []interface{}) error {
+	groups := make(map[string][]clusterRoute)
+	for _, route := range routes {
+		groups[route.addr] = append(groups[route.addr], route)
+	}
+	// connect first, the connections aren't shared by the pipelines
+	for addr := range groups {
+		if _, err := cc.node(addr); err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groups))
+	for addr, group := range groups {
+		wg.Add(1)
+		go func(conn redigo.Conn, group []clusterRoute) {
+			defer wg.Done()
+			if err := runPipeline(conn, commands, group, replies); err != nil {
+				errs <- err
+			}
+		}(cc.nodes[addr], group)
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		// the replies of the failed pipelines are unknown, reconnect all
+		cc.Close()
+		return err
+	}
+	return nil
+}
+
+func runPipeline(conn redigo.Conn, commands []clusterCommand, group []clusterRoute, replies []interface{}) error {
+	for _, route := range group {
+		if route.asking {
+			if err := conn.Send("asking"); err != nil {
+				return err
+			}
+		}
+		if err := conn.Send(commands[route.index].name, commands[route.index].args...); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
 		return err
 	}
 
-	// for redis-go-cluster driver, "Receive" function returns all the replies once flushed.
-	// However, this action is different with redigo driver that "Receive" only returns 1
-	// reply each time.
+	for _, route := range group {
+		if route.asking {
+			if _, err := conn.Receive(); err != nil {
+				if _, ok := err.(redigo.Error); !ok {
+					return err
+				}
+			}
+		}
+		reply, err := conn.Receive()
+		if err != nil {
+			if _, ok := err.(redigo.Error); !ok {
+				return err
+			}
+			reply = err
+		}
+		replies[route.index] = reply
+	}
+	return nil
+}
+
+// node returns the connection to the node, a new one is created if needed.
+func (cc *ClusterConn) node(addr string) (redigo.Conn, error) {
+	if conn, ok := cc.nodes[addr]; ok {
+		return conn, nil
+	}
 
-	retLength := len(ret)
-	availableSize := cap(cc.recvChan) - len(cc.recvChan)
-	if availableSize < retLength {
-		Logger.Warnf("available channel size[%v] less than current returned batch size[%v]", availableSize, retLength)
+	var conn redigo.Conn
+	var err error
+	if cc.timeout == 0 {
+		conn, err = redigo.Dial("tcp", addr)
+	} else {
+		conn, err = redigo.DialTimeout("tcp", addr, cc.timeout, cc.timeout, cc.timeout)
+	}
+	if err != nil {
+		return nil, err
 	}
-	// Logger.Debugf("cluster flush batch with size[%v], return replies size[%v]", cc.batcher.GetBatchSize(), retLength)
 
-	for _, ele := range ret {
-		cc.recvChan <- reply{
-			answer: ele,
-			err:    err,
+	if len(cc.password) != 0 {
+		var args []interface{}
+		for _, arg := range strings.Split(cc.password, ":") {
+			args = append(args, arg)
+		}
+		if _, err := conn.Do(cc.authType, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if cc.readonly[addr] {
+		if _, err := conn.Do("readonly"); err != nil {
+			conn.Close()
+			return nil, err
 		}
 	}
+	cc.nodes[addr] = conn
+	return conn, nil
+}
+
+// nodeOf returns the address of the node the command should be sent to.
+func (cc *ClusterConn) nodeOf(command clusterCommand) string {
+	// commands without a key, e.g., "ping", are answered by any node
+	var addr string
+	if key, ok := commandKey(command.name, command.args); ok {
+		addr = cc.slots[KeySlot(key)]
+	} else {
+		addr = cc.slots[0]
+	}
+	// the slot isn't served, the node replies the error
+	if addr == "" {
+		addr = cc.startNodes[0]
+	}
+	return addr
+}
 
-	return err
+// commandKey returns the key of the command, false if the command has no key.
+func commandKey(name string, args []interface{}) ([]byte, bool) {
+	position := 0
+	switch strings.ToLower(name) {
+	case "ping", "info", "auth", "adminauth", "select", "cluster", "scan", "iscan", "dbsize", "randomkey", "time",
+		"config", "readonly", "asking", "command", "hello":
+		return nil, false
+	case "xinfo", "xgroup", "object", "memory", "debug":
+		// "xinfo groups key", "debug digest-value key"...
+		position = 1
+	}
+	if position >= len(args) {
+		return nil, false
+	}
+
+	switch v := args[position].(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	default:
+		return []byte(fmt.Sprint(v)), true
+	}
 }
 
-// read recvChan
-func (cc *ClusterConn) Receive() (reply interface{}, err error) {
-	ret := <- cc.recvChan
-	return ret.answer, ret.err
-}
\ No newline at end of file
+// parseRedirection parses "MOVED 3999 127.0.0.1:6381" or "ASK 3999 127.0.0.1:6381".
+func parseRedirection(message string) (string, int, string, bool) {
+	items := strings.Split(message, " ")
+	if len(items) != 3 || items[0] != "MOVED" && items[0] != "ASK" {
+		return "", 0, "", false
+	}
+	slot, err := strconv.Atoi(items[1])
+	if err != nil || slot < 0 || slot >= ClusterSlots {
+		return "", 0, "", false
+	}
+	return items[0], slot, items[2], true
+}
+
+// refreshSlots loads the slot map by "cluster slots" from the first node that answers.
+func (cc *ClusterConn) refreshSlots() error {
+	var lastErr error
+	candidates := append([]string{}, cc.startNodes...)
+	for addr := range cc.nodes {
+		candidates = append(candidates, addr)
+	}
+	for _, addr := range candidates {
+		conn, err := cc.node(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := conn.Do("cluster", "slots")
+		if err != nil {
+			if _, ok := err.(redigo.Error); !ok {
+				conn.Close()
+				delete(cc.nodes, addr)
+			}
+			lastErr = err
+			continue
+		}
+		ranges, err := ParseClusterSlots(reply, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cc.setSlots(ranges)
+		return nil
+	}
+	return fmt.Errorf("fetch cluster slots from %v failed: %v", cc.startNodes, lastErr)
+}
+
+func (cc *ClusterConn) setSlots(ranges []SlotRange) {
+	given := make(map[string]bool, len(cc.startNodes))
+	for _, addr := range cc.startNodes {
+		given[addr] = true
+	}
+
+	cc.readonly = make(map[string]bool)
+	for _, r := range ranges {
+		addr := r.Nodes[0]
+		if !given[addr] {
+			for _, replica := range r.Nodes[1:] {
+				if given[replica] {
+					addr = replica
+					cc.readonly[addr] = true
+					break
+				}
+			}
+		}
+		for slot := r.First; slot <= r.Last; slot++ {
+			cc.slots[slot] = addr
+		}
+	}
+	Logger.Debugf("cluster slots of %v refreshed: %d ranges", cc.startNodes, len(ranges))
+}
+
+type SlotRange struct {
+	First int
+	Last  int
+	Nodes []string // master first, then the replicas
+}
+
+/*
+ * ParseClusterSlots parses the reply of "cluster slots":
+ * 1) 1) (integer) 0
+ *    2) (integer) 5460
+ *    3) 1) "127.0.0.1"
+ *       2) (integer) 30001
+ *       3) "09dbe9720cda62f7865eabc5fd8857c5d2678366"
+ *    4) 1) "127.0.0.1"
+ *       2) (integer) 30004
+ *       3) "821d8ca00d7ccf931ed3ffc7e3db0599d2271abf"
+ * An empty ip means the node that answered.
+ */
+func ParseClusterSlots(reply interface{}, answered string) ([]SlotRange, error) {
+	items, err := redigo.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(answered)
+
+	ranges := make([]SlotRange, 0, len(items))
+	for _, item := range items {
+		fields, err := redigo.Values(item, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("invalid cluster slots entry[%v]", item)
+		}
+		first, err1 := redigo.Int(fields[0], nil)
+		last, err2 := redigo.Int(fields[1], nil)
+		if err1 != nil || err2 != nil || first < 0 || first > last || last >= ClusterSlots {
+			return nil, fmt.Errorf("invalid cluster slots entry[%v]", item)
+		}
+
+		r := SlotRange{First: first, Last: last}
+		for _, field := range fields[2:] {
+			node, err := redigo.Values(field, nil)
+			if err != nil || len(node) < 2 {
+				return nil, fmt.Errorf("invalid cluster slots node[%v]", field)
+			}
+			ip, err1 := redigo.String(node[0], nil)
+			port, err2 := redigo.Int(node[1], nil)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid cluster slots node[%v]", field)
+			}
+			if ip == "" {
+				ip = host
+			}
+			r.Nodes = append(r.Nodes, net.JoinHostPort(ip, strconv.Itoa(port)))
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no slot is served")
+	}
+	return ranges, nil
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClusterSlots(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestParseClusterSlots case %d.\n", nr)
+
+		reply := []interface{}{
+			[]interface{}{int64(0), int64(5460),
+				[]interface{}{[]byte("127.0.0.1"), int64(30001), []byte("09dbe9720cda")},
+				[]interface{}{[]byte("127.0.0.1"), int64(30004), []byte("821d8ca00d7c")}},
+			[]interface{}{int64(5461), int64(16383),
+				[]interface{}{[]byte(""), int64(30002), []byte("c9d93d9f2c0c")}},
+		}
+		ranges, err := ParseClusterSlots(reply, "10.0.0.1:30002")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, []SlotRange{
+			{First: 0, Last: 5460, Nodes: []string{"127.0.0.1:30001", "127.0.0.1:30004"}},
+			{First: 5461, Last: 16383, Nodes: []string{"10.0.0.1:30002"}},
+		}, ranges, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestParseClusterSlots case %d.\n", nr)
+
+		// invalid replies
+		inputs := []interface{}{
+			[]interface{}{},
+			[]interface{}{[]interface{}{int64(0), int64(16384), []interface{}{[]byte("127.0.0.1"), int64(30001)}}},
+			[]interface{}{[]interface{}{int64(10), int64(0), []interface{}{[]byte("127.0.0.1"), int64(30001)}}},
+			[]interface{}{[]interface{}{int64(0), int64(16383)}},
+		}
+		for _, input := range inputs {
+			_, err := ParseClusterSlots(input, "127.0.0.1:30001")
+			assert.NotNil(t, err, "should be not nil: %v", input)
+		}
+	}
+}
+
+func TestClusterRouting(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestClusterRouting case %d.\n", nr)
+
+		key, ok := commandKey("type", []interface{}{[]byte("foo")})
+		assert.True(t, ok, "should be true")
+		assert.Equal(t, []byte("foo"), key, "should be equal")
+
+		key, ok = commandKey("xinfo", []interface{}{"groups", "stream"})
+		assert.True(t, ok, "should be true")
+		assert.Equal(t, []byte("stream"), key, "should be equal")
+
+		_, ok = commandKey("SCAN", []interface{}{0, "count", 100})
+		assert.False(t, ok, "should be false")
+		_, ok = commandKey("ping", nil)
+		assert.False(t, ok, "should be false")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestClusterRouting case %d.\n", nr)
+
+		kind, slot, addr, ok := parseRedirection("MOVED 3999 127.0.0.1:6381")
+		assert.True(t, ok, "should be true")
+		assert.Equal(t, "MOVED", kind, "should be equal")
+		assert.Equal(t, 3999, slot, "should be equal")
+		assert.Equal(t, "127.0.0.1:6381", addr, "should be equal")
+
+		kind, _, _, ok = parseRedirection("ASK 1 127.0.0.1:6381")
+		assert.True(t, ok, "should be true")
+		assert.Equal(t, "ASK", kind, "should be equal")
+
+		for _, input := range []string{"WRONGTYPE Operation", "MOVED x 127.0.0.1:6381", "ASK 16384 127.0.0.1:6381"} {
+			_, _, _, ok = parseRedirection(input)
+			assert.False(t, ok, "should be false: %v", input)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	FilterGlobPrefix  = "glob:"
+	FilterRegexPrefix = "regex:"
+)
+
+type filterRule struct {
+	glob  []byte         // set if the rule is a glob pattern
+	regex *regexp.Regexp // set if the rule is a regular expression
+}
+
+func (r filterRule) match(key []byte) bool {
+	if r.regex != nil {
+		return r.regex.Match(key)
+	}
+	return GlobMatch(r.glob, key)
+}
+
+func (r filterRule) String() string {
+	if r.regex != nil {
+		return FilterRegexPrefix + r.regex.String()
+	}
+	return FilterGlobPrefix + string(r.glob)
+}
+
+/*
+ * KeyFilter decides which keys take part in the comparison. A key passes when it matches at least
+ * one include rule (or no include rule is given) and doesn't match any exclude rule.
+ * Include rules come from the legacy filter list(exact or trailing '*' prefix match, kept in a
+ * trie) and from glob/regex rules.
+ */
+type KeyFilter struct {
+	prefixTree  *Trie
+	prefixList  [][]byte // legacy filter list converted to globs, only used for push down
+	includeList []filterRule
+	excludeList []filterRule
+
+	// data type rules, only known after "type" is fetched unless pushed down to "scan ... type"
+	includeTypes map[KeyTypeIndex]struct{}
+	excludeTypes map[KeyTypeIndex]struct{}
+}
+
+func NewKeyFilter() *KeyFilter {
+	return &KeyFilter{}
+}
+
+func parseFilterRule(rule string) (filterRule, error) {
+	switch {
+	case strings.HasPrefix(rule, FilterRegexPrefix):
+		re, err := regexp.Compile(rule[len(FilterRegexPrefix):])
+		if err != nil {
+			return filterRule{}, fmt.Errorf("invalid regex rule[%v]: %v", rule, err)
+		}
+		return filterRule{regex: re}, nil
+	case strings.HasPrefix(rule, FilterGlobPrefix):
+		rule = rule[len(FilterGlobPrefix):]
+	}
+	if rule == "" {
+		return filterRule{}, fmt.Errorf("empty filter rule")
+	}
+	return filterRule{glob: []byte(rule)}, nil
+}
+
+// AddPrefix adds one element of the legacy filter list, e.g., "abc" or "abc*".
+func (f *KeyFilter) AddPrefix(prefix string) {
+	if f.prefixTree == nil {
+		f.prefixTree = NewTrie()
+	}
+	f.prefixTree.Insert([]byte(prefix))
+
+	// the trie stops at the first '*', do the same when converting to glob
+	if idx := strings.IndexByte(prefix, Star); idx >= 0 {
+		f.prefixList = append(f.prefixList, append(GlobEscape([]byte(prefix[:idx])), Star))
+	} else {
+		f.prefixList = append(f.prefixList, GlobEscape([]byte(prefix)))
+	}
+}
+
+// AddInclude adds an include rule: "glob:pattern", "regex:expression" or a bare glob pattern.
+func (f *KeyFilter) AddInclude(rule string) error {
+	r, err := parseFilterRule(rule)
+	if err != nil {
+		return err
+	}
+	f.includeList = append(f.includeList, r)
+	return nil
+}
+
+// AddExclude adds an exclude rule, same format as AddInclude.
+func (f *KeyFilter) AddExclude(rule string) error {
+	r, err := parseFilterRule(rule)
+	if err != nil {
+		return err
+	}
+	f.excludeList = append(f.excludeList, r)
+	return nil
+}
+
+func parseFilterType(name string) (KeyTypeIndex, error) {
+	tp := NewKeyType(strings.ToLower(strings.TrimSpace(name)))
+	if tp == EndKeyType || tp == NoneKeyType {
+		return EndKeyTypeIndex, fmt.Errorf("unknown data type[%v]", name)
+	}
+	return tp.Index, nil
+}
+
+// AddIncludeType only compares keys of the given data type, e.g., "hash".
+func (f *KeyFilter) AddIncludeType(name string) error {
+	index, err := parseFilterType(name)
+	if err != nil {
+		return err
+	}
+	if f.includeTypes == nil {
+		f.includeTypes = make(map[KeyTypeIndex]struct{})
+	}
+	f.includeTypes[index] = struct{}{}
+	return nil
+}
+
+// AddExcludeType skips keys of the given data type.
+func (f *KeyFilter) AddExcludeType(name string) error {
+	index, err := parseFilterType(name)
+	if err != nil {
+		return err
+	}
+	if f.excludeTypes == nil {
+		f.excludeTypes = make(map[KeyTypeIndex]struct{})
+	}
+	f.excludeTypes[index] = struct{}{}
+	return nil
+}
+
+func (f *KeyFilter) Empty() bool {
+	return f.prefixTree == nil && len(f.includeList) == 0 && len(f.excludeList) == 0 &&
+		!f.HasTypeRule()
+}
+
+func (f *KeyFilter) HasTypeRule() bool {
+	return len(f.includeTypes) != 0 || len(f.excludeTypes) != 0
+}
+
+/*
+ * PassType returns true if keys of the given type should be compared. Keys whose type is unknown
+ * or already deleted on the source always pass, the verifiers handle them as before.
+ */
+func (f *KeyFilter) PassType(tp *KeyType) bool {
+	if tp == NoneKeyType || tp == EndKeyType {
+		return true
+	}
+	if len(f.includeTypes) != 0 {
+		if _, ok := f.includeTypes[tp.Index]; !ok {
+			return false
+		}
+	}
+	_, ok := f.excludeTypes[tp.Index]
+	return !ok
+}
+
+/*
+ * ScanType returns the type that can be pushed down to "scan ... type"(redis >= 6.0), or "" if
+ * the type rules can't be expressed by a single type.
+ */
+func (f *KeyFilter) ScanType() string {
+	if len(f.includeTypes) != 1 {
+		return ""
+	}
+	for index := range f.includeTypes {
+		if _, ok := f.excludeTypes[index]; ok {
+			return ""
+		}
+		return index.String()
+	}
+	return ""
+}
+
+// Pass returns true if the key should be compared.
+func (f *KeyFilter) Pass(key []byte) bool {
+	if f.prefixTree != nil || len(f.includeList) != 0 {
+		hit := f.prefixTree != nil && f.prefixTree.Search(key)
+		for i := 0; !hit && i < len(f.includeList); i++ {
+			hit = f.includeList[i].match(key)
+		}
+		if !hit {
+			return false
+		}
+	}
+
+	for _, r := range f.excludeList {
+		if r.match(key) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * MatchPattern returns the glob that can be pushed down to "scan ... match", or nil if the rules
+ * can't be expressed by a single glob. Only a single include glob qualifies: scan accepts just one
+ * pattern and redis has no way to negate it, so the exclude rules are still applied client-side.
+ */
+func (f *KeyFilter) MatchPattern() []byte {
+	if len(f.prefixList)+len(f.includeList) != 1 {
+		return nil
+	}
+	if len(f.prefixList) == 1 {
+		return f.prefixList[0]
+	}
+	return f.includeList[0].glob
+}
+
+func (f *KeyFilter) String() string {
+	rules := make([]string, 0, len(f.prefixList)+len(f.includeList)+len(f.excludeList))
+	for _, prefix := range f.prefixList {
+		rules = append(rules, "include "+FilterGlobPrefix+string(prefix))
+	}
+	for _, r := range f.includeList {
+		rules = append(rules, "include "+r.String())
+	}
+	for _, r := range f.excludeList {
+		rules = append(rules, "exclude "+r.String())
+	}
+	for index := KeyTypeIndex(0); index < EndKeyTypeIndex; index++ {
+		if _, ok := f.includeTypes[index]; ok {
+			rules = append(rules, "include type:"+index.String())
+		}
+		if _, ok := f.excludeTypes[index]; ok {
+			rules = append(rules, "exclude type:"+index.String())
+		}
+	}
+	return strings.Join(rules, ", ")
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestGlobMatch case %d.\n", nr)
+
+		assert.Equal(t, true, GlobMatch([]byte("*"), []byte("")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("*"), []byte("abc")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("user:*"), []byte("user:1")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("user:*"), []byte("user:")), "should be equal")
+		assert.Equal(t, false, GlobMatch([]byte("user:*"), []byte("users")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("a/*/c"), []byte("a/b/d/c")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("h?llo"), []byte("hello")), "should be equal")
+		assert.Equal(t, false, GlobMatch([]byte("h?llo"), []byte("hllo")), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestGlobMatch case %d.\n", nr)
+
+		assert.Equal(t, true, GlobMatch([]byte("h[ae]llo"), []byte("hallo")), "should be equal")
+		assert.Equal(t, false, GlobMatch([]byte("h[ae]llo"), []byte("hillo")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("h[^e]llo"), []byte("hallo")), "should be equal")
+		assert.Equal(t, false, GlobMatch([]byte("h[^e]llo"), []byte("hello")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("h[a-c]llo"), []byte("hbllo")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("h[c-a]llo"), []byte("hbllo")), "should be equal")
+		assert.Equal(t, false, GlobMatch([]byte("h[a-c]llo"), []byte("hdllo")), "should be equal")
+		assert.Equal(t, true, GlobMatch([]byte("a\\*b"), []byte("a*b")), "should be equal")
+		assert.Equal(t, false, GlobMatch([]byte("a\\*b"), []byte("axb")), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestGlobMatch case %d.\n", nr)
+
+		special := []byte("a*b?c[d]e\\f")
+		assert.Equal(t, true, GlobMatch(GlobEscape(special), special), "should be equal")
+		assert.Equal(t, false, GlobMatch(GlobEscape(special), []byte("aXb?c[d]e\\f")), "should be equal")
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestKeyFilter case %d.\n", nr)
+
+		filter := NewKeyFilter()
+		assert.Equal(t, true, filter.Empty(), "should be equal")
+		assert.Equal(t, true, filter.Pass([]byte("abc")), "should be equal")
+		assert.Nil(t, filter.MatchPattern(), "should be nil")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeyFilter case %d.\n", nr)
+
+		// legacy filter list
+		filter := NewKeyFilter()
+		filter.AddPrefix("abc*")
+		assert.Equal(t, true, filter.Pass([]byte("abc1")), "should be equal")
+		assert.Equal(t, false, filter.Pass([]byte("ab")), "should be equal")
+		assert.Equal(t, "abc*", string(filter.MatchPattern()), "should be equal")
+
+		filter.AddPrefix("e?f")
+		assert.Equal(t, true, filter.Pass([]byte("e?f")), "should be equal")
+		assert.Equal(t, false, filter.Pass([]byte("exf")), "should be equal")
+		assert.Nil(t, filter.MatchPattern(), "should be nil")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeyFilter case %d.\n", nr)
+
+		filter := NewKeyFilter()
+		assert.Nil(t, filter.AddInclude("glob:user:*"), "should be nil")
+		assert.Nil(t, filter.AddExclude("regex:^user:[0-9]+:tmp$"), "should be nil")
+		assert.Nil(t, filter.AddExclude("*:cache"), "should be nil")
+		assert.Equal(t, true, filter.Pass([]byte("user:1")), "should be equal")
+		assert.Equal(t, false, filter.Pass([]byte("user:1:tmp")), "should be equal")
+		assert.Equal(t, false, filter.Pass([]byte("user:1:cache")), "should be equal")
+		assert.Equal(t, false, filter.Pass([]byte("order:1")), "should be equal")
+		assert.Equal(t, "user:*", string(filter.MatchPattern()), "should be equal")
+
+		assert.Nil(t, filter.AddInclude("regex:^order:"), "should be nil")
+		assert.Equal(t, true, filter.Pass([]byte("order:1")), "should be equal")
+		assert.Nil(t, filter.MatchPattern(), "should be nil")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeyFilter case %d.\n", nr)
+
+		// only exclude rules
+		filter := NewKeyFilter()
+		assert.NotNil(t, filter.AddExclude("regex:("), "should not be nil")
+		assert.NotNil(t, filter.AddInclude(""), "should not be nil")
+		assert.Nil(t, filter.AddExclude("tmp:*"), "should be nil")
+		assert.Equal(t, true, filter.Pass([]byte("abc")), "should be equal")
+		assert.Equal(t, false, filter.Pass([]byte("tmp:1")), "should be equal")
+		assert.Nil(t, filter.MatchPattern(), "should be nil")
+	}
+}
+
+func TestKeyFilterType(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestKeyFilterType case %d.\n", nr)
+
+		filter := NewKeyFilter()
+		assert.Nil(t, filter.AddIncludeType("hash"), "should be nil")
+		assert.NotNil(t, filter.AddIncludeType("none"), "should not be nil")
+		assert.NotNil(t, filter.AddExcludeType("abc"), "should not be nil")
+		assert.Equal(t, true, filter.HasTypeRule(), "should be equal")
+		assert.Equal(t, true, filter.Pass([]byte("abc")), "should be equal")
+		assert.Equal(t, true, filter.PassType(HashKeyType), "should be equal")
+		assert.Equal(t, false, filter.PassType(StringKeyType), "should be equal")
+		assert.Equal(t, true, filter.PassType(NoneKeyType), "should be equal")
+		assert.Equal(t, true, filter.PassType(EndKeyType), "should be equal")
+		assert.Equal(t, "hash", filter.ScanType(), "should be equal")
+
+		assert.Nil(t, filter.AddIncludeType("list"), "should be nil")
+		assert.Equal(t, true, filter.PassType(ListKeyType), "should be equal")
+		assert.Equal(t, "", filter.ScanType(), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeyFilterType case %d.\n", nr)
+
+		filter := NewKeyFilter()
+		assert.Nil(t, filter.AddExcludeType("stream"), "should be nil")
+		assert.Equal(t, true, filter.PassType(HashKeyType), "should be equal")
+		assert.Equal(t, false, filter.PassType(StreamKeyType), "should be equal")
+		assert.Equal(t, "", filter.ScanType(), "should be equal")
+	}
+}
@@ -0,0 +1,100 @@
+package common
+
+/*
+ * GlobMatch reports whether str matches the glob-style pattern using the same rules as redis
+ * stringmatchlen(): '*', '?', '[...]', '[^...]', ranges like '[a-z]' and '\' escaping. We can't use
+ * path.Match here because it treats '/' as a separator while redis doesn't, and the pattern has to
+ * behave exactly like the one pushed down to "scan ... match".
+ */
+func GlobMatch(pattern, str []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true // match everything left
+			}
+			for i := 0; i <= len(str); i++ {
+				if GlobMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for {
+				if len(pattern) == 0 {
+					break
+				}
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					pattern = pattern[2:]
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+				} else if pattern[0] == str[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// unterminated class, redis treats the end of pattern as ']'
+				return (match != not) && len(str) == 1
+			}
+			if match == not {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+// GlobEscape escapes all glob special characters so that the result only matches input itself.
+func GlobEscape(input []byte) []byte {
+	ret := make([]byte, 0, len(input)+4)
+	for _, c := range input {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			ret = append(ret, '\\')
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
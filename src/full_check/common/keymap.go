@@ -0,0 +1,118 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	KeyMapPrefix      = "prefix:"
+	KeyMapRegex       = "regex:"
+	KeyMapAddPrefix   = "addprefix:"
+	KeyMapStripPrefix = "stripprefix:"
+
+	KeyMapArrow = "=>"
+)
+
+type keyMapRule struct {
+	rule  string
+	from  []byte // prefix:, stripprefix:
+	to    []byte // prefix:, addprefix:, regex:
+	regex *regexp.Regexp
+}
+
+// apply returns the mapped key and true if the rule matches the key.
+func (r *keyMapRule) apply(key []byte) ([]byte, bool) {
+	switch {
+	case r.regex != nil:
+		if !r.regex.Match(key) {
+			return nil, false
+		}
+		return r.regex.ReplaceAll(key, r.to), true
+	case r.from != nil:
+		if !bytes.HasPrefix(key, r.from) {
+			return nil, false
+		}
+		ret := make([]byte, 0, len(r.to)+len(key)-len(r.from))
+		ret = append(ret, r.to...)
+		return append(ret, key[len(r.from):]...), true
+	default:
+		ret := make([]byte, 0, len(r.to)+len(key))
+		ret = append(ret, r.to...)
+		return append(ret, key...), true
+	}
+}
+
+/*
+ * KeyMapper maps the source key name to the target key name when the sync tool renames keys.
+ * Rules are tried in order and the first matched one wins, keys that don't match any rule keep
+ * their names. Supported rules:
+ *     prefix:OLD=>NEW          rewrite prefix OLD to NEW, e.g., "prefix:user:=>tenantA:user:"
+ *     regex:EXPR=>REPLACEMENT  replace matches of EXPR, $1 or ${name} refer to capture groups
+ *     addprefix:PREFIX         add PREFIX to every key
+ *     stripprefix:PREFIX       remove PREFIX if the key has it
+ */
+type KeyMapper struct {
+	rules []*keyMapRule
+}
+
+func NewKeyMapper() *KeyMapper {
+	return &KeyMapper{}
+}
+
+func (m *KeyMapper) AddRule(rule string) error {
+	r := &keyMapRule{rule: rule}
+	switch {
+	case strings.HasPrefix(rule, KeyMapPrefix):
+		items := strings.SplitN(rule[len(KeyMapPrefix):], KeyMapArrow, 2)
+		if len(items) != 2 || items[0] == "" {
+			return fmt.Errorf("invalid key map rule[%v], should be 'prefix:OLD=>NEW'", rule)
+		}
+		r.from, r.to = []byte(items[0]), []byte(items[1])
+	case strings.HasPrefix(rule, KeyMapRegex):
+		items := strings.SplitN(rule[len(KeyMapRegex):], KeyMapArrow, 2)
+		if len(items) != 2 || items[0] == "" {
+			return fmt.Errorf("invalid key map rule[%v], should be 'regex:EXPR=>REPLACEMENT'", rule)
+		}
+		re, err := regexp.Compile(items[0])
+		if err != nil {
+			return fmt.Errorf("invalid key map rule[%v]: %v", rule, err)
+		}
+		r.regex, r.to = re, []byte(items[1])
+	case strings.HasPrefix(rule, KeyMapAddPrefix):
+		if len(rule) == len(KeyMapAddPrefix) {
+			return fmt.Errorf("invalid key map rule[%v], prefix is empty", rule)
+		}
+		r.to = []byte(rule[len(KeyMapAddPrefix):])
+	case strings.HasPrefix(rule, KeyMapStripPrefix):
+		if len(rule) == len(KeyMapStripPrefix) {
+			return fmt.Errorf("invalid key map rule[%v], prefix is empty", rule)
+		}
+		r.from, r.to = []byte(rule[len(KeyMapStripPrefix):]), []byte{}
+	default:
+		return fmt.Errorf("unknown key map rule[%v], should start with %v, %v, %v or %v", rule,
+			KeyMapPrefix, KeyMapRegex, KeyMapAddPrefix, KeyMapStripPrefix)
+	}
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+// Map returns the target key name of the given source key.
+func (m *KeyMapper) Map(key []byte) []byte {
+	for _, r := range m.rules {
+		if ret, ok := r.apply(key); ok {
+			return ret
+		}
+	}
+	return key
+}
+
+func (m *KeyMapper) String() string {
+	rules := make([]string, 0, len(m.rules))
+	for _, r := range m.rules {
+		rules = append(rules, r.rule)
+	}
+	return strings.Join(rules, ", ")
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyMapper(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestKeyMapper case %d.\n", nr)
+
+		mapper := NewKeyMapper()
+		assert.Nil(t, mapper.AddRule("prefix:user:=>tenantA:user:"), "should be nil")
+		assert.Equal(t, "tenantA:user:1", string(mapper.Map([]byte("user:1"))), "should be equal")
+		assert.Equal(t, "order:1", string(mapper.Map([]byte("order:1"))), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeyMapper case %d.\n", nr)
+
+		mapper := NewKeyMapper()
+		assert.Nil(t, mapper.AddRule("regex:^order:([0-9]+):(.*)$=>o:$2:${1}"), "should be nil")
+		assert.Nil(t, mapper.AddRule("stripprefix:old:"), "should be nil")
+		assert.Nil(t, mapper.AddRule("addprefix:new:"), "should be nil")
+		assert.Equal(t, "o:detail:12", string(mapper.Map([]byte("order:12:detail"))), "should be equal")
+		assert.Equal(t, "abc", string(mapper.Map([]byte("old:abc"))), "should be equal")
+		assert.Equal(t, "new:abc", string(mapper.Map([]byte("abc"))), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeyMapper case %d.\n", nr)
+
+		mapper := NewKeyMapper()
+		assert.NotNil(t, mapper.AddRule("user:=>tenantA:user:"), "should not be nil")
+		assert.NotNil(t, mapper.AddRule("prefix:user:"), "should not be nil")
+		assert.NotNil(t, mapper.AddRule("regex:(=>a"), "should not be nil")
+		assert.NotNil(t, mapper.AddRule("addprefix:"), "should not be nil")
+		assert.Equal(t, "user:1", string(mapper.Map([]byte("user:1"))), "should be equal")
+	}
+}
@@ -124,7 +124,9 @@ type Attribute struct {
 
 type Key struct {
 	Key          []byte
+	TargetKey    []byte // key name on the target side, nil means the same as Key
 	Db           int32
+	Target       int // index of the target the key is compared with
 	Tp           *KeyType
 	ConflictType ConflictType
 	SourceAttr   Attribute
@@ -141,6 +143,7 @@ const (
 	LackSourceConflict
 	LackTargetConflict
 	NoneConflict
+	SkippedConflict // filtered out by the data type rules, not a conflict
 	EndConflict
 )
 
@@ -156,6 +159,8 @@ func (p ConflictType) String() string {
 		return "lack_target"
 	case NoneConflict:
 		return "equal"
+	case SkippedConflict:
+		return "skipped"
 	default:
 		return "unknown_conflict"
 	}
@@ -173,6 +178,8 @@ func NewConflictType(a string) ConflictType {
 		return LackTargetConflict
 	case "equal":
 		return NoneConflict
+	case "skipped":
+		return SkippedConflict
 	default:
 		return EndConflict
 	}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	// levels of the merkle tree: the root, one node per cluster slot and the sub-buckets of each slot
+	MerkleRootLevel = 0
+	MerkleSlotLevel = 1
+	MerkleLeafLevel = 2
+
+	// how the value digests are computed
+	DigestDump  = "dump"
+	DigestDebug = "debug"
+
+	// "dump" payload ends with 2 bytes rdb version and 8 bytes crc64
+	DumpTrailerLen = 10
+)
+
+type MerkleNode struct {
+	Digest [md5.Size]byte
+	Keys   int64
+}
+
+func (n *MerkleNode) add(digest []byte, keys int64) {
+	for i := range n.Digest {
+		n.Digest[i] ^= digest[i]
+	}
+	n.Keys += keys
+}
+
+/*
+ * MerkleTree summarizes the keys and value digests of one side. Keys are grouped by cluster slot
+ * and then by sub-bucket, node digests are the xor of their children so that the tree doesn't
+ * depend on the order keys are scanned in. Empty nodes aren't kept.
+ */
+type MerkleTree struct {
+	subBuckets uint32
+	lock       sync.Mutex
+	leaves     map[uint32]*MerkleNode
+}
+
+func NewMerkleTree(subBuckets int) *MerkleTree {
+	return &MerkleTree{
+		subBuckets: uint32(subBuckets),
+		leaves:     make(map[uint32]*MerkleNode),
+	}
+}
+
+// Bucket returns the leaf the key belongs to: slot * sub-buckets + sub-bucket.
+func (t *MerkleTree) Bucket(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return uint32(KeySlot(key))*t.subBuckets + h.Sum32()%t.subBuckets
+}
+
+// Add adds one key with its value digest, a nil digest means the key doesn't exist.
+func (t *MerkleTree) Add(key, digest []byte) {
+	if digest == nil {
+		return
+	}
+
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(key)))
+	h := md5.New()
+	h.Write(length[:])
+	h.Write(key)
+	h.Write(digest)
+	leaf := h.Sum(nil)
+
+	bucket := t.Bucket(key)
+	t.lock.Lock()
+	node, ok := t.leaves[bucket]
+	if !ok {
+		node = new(MerkleNode)
+		t.leaves[bucket] = node
+	}
+	node.add(leaf, 1)
+	t.lock.Unlock()
+}
+
+// Level returns the non-empty nodes of the given level keyed by their index in the level.
+func (t *MerkleTree) Level(level int) map[uint32]*MerkleNode {
+	if level == MerkleLeafLevel {
+		return t.leaves
+	}
+
+	ret := make(map[uint32]*MerkleNode)
+	for bucket, leaf := range t.leaves {
+		index := uint32(0)
+		if level == MerkleSlotLevel {
+			index = bucket / t.subBuckets
+		}
+		node, ok := ret[index]
+		if !ok {
+			node = new(MerkleNode)
+			ret[index] = node
+		}
+		node.add(leaf.Digest[:], leaf.Keys)
+	}
+	return ret
+}
+
+// DiffLevel returns the indexes of the nodes that differ between the two trees at the given level.
+func DiffLevel(a, b map[uint32]*MerkleNode) []uint32 {
+	ret := make([]uint32, 0)
+	for index, node := range a {
+		if other, ok := b[index]; !ok || other.Digest != node.Digest {
+			ret = append(ret, index)
+		}
+	}
+	for index := range b {
+		if _, ok := a[index]; !ok {
+			ret = append(ret, index)
+		}
+	}
+	return ret
+}
+
+/*
+ * Diff walks both trees from the root and returns the leaves that differ. Only the sub-buckets of
+ * the differing slots are compared.
+ */
+func (t *MerkleTree) Diff(other *MerkleTree) map[uint32]struct{} {
+	ret := make(map[uint32]struct{})
+	if len(DiffLevel(t.Level(MerkleRootLevel), other.Level(MerkleRootLevel))) == 0 {
+		return ret
+	}
+
+	for _, slot := range DiffLevel(t.Level(MerkleSlotLevel), other.Level(MerkleSlotLevel)) {
+		for bucket := slot * t.subBuckets; bucket < (slot+1)*t.subBuckets; bucket++ {
+			node, ok := t.leaves[bucket]
+			otherNode, otherOk := other.leaves[bucket]
+			if ok != otherOk || ok && node.Digest != otherNode.Digest {
+				ret[bucket] = struct{}{}
+			}
+		}
+	}
+	return ret
+}
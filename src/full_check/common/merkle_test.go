@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleTree(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestMerkleTree case %d.\n", nr)
+
+		// the order keys are added in doesn't matter
+		a, b := NewMerkleTree(4), NewMerkleTree(4)
+		for i := 0; i < 100; i++ {
+			a.Add([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+			b.Add([]byte(fmt.Sprintf("key%d", 99-i)), []byte("value"))
+		}
+		b.Add([]byte("missing"), nil)
+		assert.Equal(t, 0, len(a.Diff(b)), "should be equal")
+		assert.Equal(t, int64(100), a.Level(MerkleRootLevel)[0].Keys, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestMerkleTree case %d.\n", nr)
+
+		a, b := NewMerkleTree(4), NewMerkleTree(4)
+		for i := 0; i < 100; i++ {
+			a.Add([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+			if i != 7 {
+				b.Add([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+			}
+		}
+		b.Add([]byte("key200"), []byte("value"))
+		a.Add([]byte("key300"), []byte("v1"))
+		b.Add([]byte("key300"), []byte("v2"))
+
+		diff := a.Diff(b)
+		for _, key := range []string{"key7", "key200", "key300"} {
+			_, ok := diff[a.Bucket([]byte(key))]
+			assert.True(t, ok, "should be true")
+		}
+		assert.True(t, len(diff) <= 3, "should be true")
+		assert.Equal(t, len(diff), len(DiffLevel(a.Level(MerkleLeafLevel), b.Level(MerkleLeafLevel))), "should be equal")
+	}
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"strconv"
 )
@@ -50,4 +51,52 @@ func FilterDBList(dbs string) map[int]struct{} {
 		ret[val] = struct{}{}
 	}
 	return ret
-}
\ No newline at end of file
+}
+
+// ParseDBMapping parses the source db to target db mapping, e.g., "0:3,1:4" or "0:3;1:4".
+func ParseDBMapping(input string) (map[int32]int32, error) {
+	ret := make(map[int32]int32)
+	if input == "" {
+		return ret, nil
+	}
+
+	for _, ele := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ';' }) {
+		if ele = strings.TrimSpace(ele); ele == "" {
+			continue
+		}
+		items := strings.Split(ele, ":")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid db mapping[%v], should be 'source:target'", ele)
+		}
+		source, err := strconv.Atoi(strings.TrimSpace(items[0]))
+		if err != nil || source < 0 {
+			return nil, fmt.Errorf("invalid source db[%v]", items[0])
+		}
+		target, err := strconv.Atoi(strings.TrimSpace(items[1]))
+		if err != nil || target < 0 {
+			return nil, fmt.Errorf("invalid target db[%v]", items[1])
+		}
+		if _, ok := ret[int32(source)]; ok {
+			return nil, fmt.Errorf("source db[%v] is mapped more than once", source)
+		}
+		ret[int32(source)] = int32(target)
+	}
+	return ret, nil
+}
+
+// VersionAtLeast returns true if the redis version string(e.g., "6.2.7") is at least major.minor.
+func VersionAtLeast(version string, major, minor int) bool {
+	items := strings.Split(version, ".")
+	if len(items) < 2 {
+		return false
+	}
+	versionMajor, err := strconv.Atoi(items[0])
+	if err != nil {
+		return false
+	}
+	versionMinor, err := strconv.Atoi(items[1])
+	if err != nil {
+		return false
+	}
+	return versionMajor > major || versionMajor == major && versionMinor >= minor
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDBMapping(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestParseDBMapping case %d.\n", nr)
+
+		mapping, err := ParseDBMapping("")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, 0, len(mapping), "should be equal")
+
+		mapping, err = ParseDBMapping("0:3,1:4")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, map[int32]int32{0: 3, 1: 4}, mapping, "should be equal")
+
+		mapping, err = ParseDBMapping("0:3;1:4;")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, map[int32]int32{0: 3, 1: 4}, mapping, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestParseDBMapping case %d.\n", nr)
+
+		// whitespace around the pairs and the dbs
+		mapping, err := ParseDBMapping(" 0 : 3 , 1:4 ; ")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, map[int32]int32{0: 3, 1: 4}, mapping, "should be equal")
+
+		// several sources to one target are allowed
+		mapping, err = ParseDBMapping("0:2,1:2")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, map[int32]int32{0: 2, 1: 2}, mapping, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestParseDBMapping case %d.\n", nr)
+
+		for _, input := range []string{
+			"0", "0:", ":3", "0:3:4", "a:3", "0:b", "0-3", // malformed
+			"-1:3", "0:-3", // negative
+			"0:3,0:4", "1:1;1:2", // duplicate source
+		} {
+			_, err := ParseDBMapping(input)
+			assert.NotNil(t, err, "should be not nil: %v", input)
+		}
+	}
+}
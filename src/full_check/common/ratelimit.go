@@ -0,0 +1,186 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * RateLimiter limits the units, e.g., bytes or requests, consumed per second by all goroutines
+ * sharing it. A consumer sleeps until the budget covers what it consumed, so it can either charge
+ * before a request or after reading a reply. Up to a second of budget is saved while idle.
+ */
+type RateLimiter struct {
+	lock      sync.Mutex
+	rate      float64 // units per second
+	available float64
+	last      time.Time
+
+	total  int64 // consumed so far
+	waitNs int64 // slept so far
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: float64(rate), available: float64(rate), last: time.Now()}
+}
+
+// Consume charges n units and sleeps when they're over the budget.
+func (l *RateLimiter) Consume(n int64) {
+	atomic.AddInt64(&l.total, n)
+
+	l.lock.Lock()
+	now := time.Now()
+	l.available += now.Sub(l.last).Seconds() * l.rate
+	if l.available > l.rate {
+		l.available = l.rate
+	}
+	l.last = now
+	l.available -= float64(n)
+	var wait time.Duration
+	if l.available < 0 {
+		wait = time.Duration(-l.available / l.rate * float64(time.Second))
+	}
+	l.lock.Unlock()
+
+	if wait > 0 {
+		atomic.AddInt64(&l.waitNs, int64(wait))
+		time.Sleep(wait)
+	}
+}
+
+// SetRate changes the rate, the budget saved is kept within a second of the new rate.
+func (l *RateLimiter) SetRate(rate int64) {
+	l.lock.Lock()
+	l.rate = float64(rate)
+	if l.available > l.rate {
+		l.available = l.rate
+	}
+	l.lock.Unlock()
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int64(l.rate)
+}
+
+func (l *RateLimiter) Total() int64 {
+	return atomic.LoadInt64(&l.total)
+}
+
+func (l *RateLimiter) Waited() time.Duration {
+	return time.Duration(atomic.LoadInt64(&l.waitNs))
+}
+
+/*
+ * Throttle limits the requests per second and the requests in flight of one side, it's shared by
+ * all clients of the side. Either limit is optional.
+ */
+type Throttle struct {
+	qps   *RateLimiter
+	slots chan struct{}
+
+	waitNs int64 // waited for a slot so far
+}
+
+// NewThrottle returns nil when neither limit is given, 0 means unlimited.
+func NewThrottle(qps, concurrency int) *Throttle {
+	if qps <= 0 && concurrency <= 0 {
+		return nil
+	}
+	t := new(Throttle)
+	if qps > 0 {
+		t.qps = NewRateLimiter(int64(qps))
+	}
+	if concurrency > 0 {
+		t.slots = make(chan struct{}, concurrency)
+	}
+	return t
+}
+
+// Acquire waits for a slot and the budget of one request, Release should be called after it.
+func (t *Throttle) Acquire() {
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		default:
+			start := time.Now()
+			t.slots <- struct{}{}
+			atomic.AddInt64(&t.waitNs, int64(time.Since(start)))
+		}
+	}
+	if t.qps != nil {
+		t.qps.Consume(1)
+	}
+}
+
+func (t *Throttle) Release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// SetQps changes the qps limit, it's ignored when the qps isn't limited.
+func (t *Throttle) SetQps(qps int) {
+	if t.qps != nil {
+		t.qps.SetRate(int64(qps))
+	}
+}
+
+// Qps returns the qps limit, 0 means unlimited.
+func (t *Throttle) Qps() int {
+	if t.qps == nil {
+		return 0
+	}
+	return int(t.qps.Rate())
+}
+
+func (t *Throttle) Concurrency() int {
+	return cap(t.slots)
+}
+
+// Requests returns the requests so far, only counted when the qps is limited.
+func (t *Throttle) Requests() int64 {
+	if t.qps == nil {
+		return 0
+	}
+	return t.qps.Total()
+}
+
+// Waited returns the time waited for both the slots and the qps.
+func (t *Throttle) Waited() time.Duration {
+	waited := time.Duration(atomic.LoadInt64(&t.waitNs))
+	if t.qps != nil {
+		waited += t.qps.Waited()
+	}
+	return waited
+}
+
+// ParseBytes parses a byte size like "512", "64K", "10M" or "1G", the units are powers of 1024.
+func ParseBytes(size string) (int64, error) {
+	input := strings.ToUpper(strings.TrimSpace(size))
+	input = strings.TrimSuffix(input, "B")
+	unit := int64(1)
+	if len(input) != 0 {
+		switch input[len(input)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			input = input[:len(input)-1]
+		}
+	}
+	n, err := strconv.ParseInt(input, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size[%v]", size)
+	}
+	return n * unit, nil
+}
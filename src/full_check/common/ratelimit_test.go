@@ -0,0 +1,88 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestRateLimiter case %d.\n", nr)
+
+		for input, expect := range map[string]int64{"0": 0, "512": 512, "64K": 64 << 10, "10mb": 10 << 20, "1G": 1 << 30} {
+			n, err := ParseBytes(input)
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, expect, n, "should be equal: %v", input)
+		}
+		for _, input := range []string{"", "M", "-1", "1.5M", "1T"} {
+			_, err := ParseBytes(input)
+			assert.NotNil(t, err, "should be not nil: %v", input)
+		}
+	}
+
+	{
+		nr++
+		fmt.Printf("TestRateLimiter case %d.\n", nr)
+
+		// a second of budget is available at first, the rest waits
+		limiter := NewRateLimiter(10000)
+		start := time.Now()
+		limiter.Consume(10000)
+		assert.True(t, time.Since(start) < 50*time.Millisecond, "should be true")
+		limiter.Consume(2000)
+		assert.True(t, time.Since(start) >= 190*time.Millisecond, "should be true")
+		assert.Equal(t, int64(12000), limiter.Total(), "should be equal")
+		assert.True(t, limiter.Waited() > 0, "should be true")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestThrottle case %d.\n", nr)
+
+		assert.Nil(t, NewThrottle(0, 0), "should be nil")
+
+		// one request in flight at most
+		throttle := NewThrottle(0, 1)
+		throttle.Acquire()
+		released := make(chan struct{})
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(released)
+			throttle.Release()
+		}()
+		throttle.Acquire()
+		select {
+		case <-released:
+		default:
+			t.Error("acquired before released")
+		}
+		throttle.Release()
+		assert.True(t, throttle.Waited() > 0, "should be true")
+		assert.Equal(t, 1, throttle.Concurrency(), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestThrottle case %d.\n", nr)
+
+		throttle := NewThrottle(10, 0)
+		start := time.Now()
+		for i := 0; i < 12; i++ {
+			throttle.Acquire()
+			throttle.Release()
+		}
+		assert.True(t, time.Since(start) >= 190*time.Millisecond, "should be true")
+		assert.Equal(t, int64(12), throttle.Requests(), "should be equal")
+
+		throttle.SetQps(20)
+		assert.Equal(t, 20, throttle.Qps(), "should be equal")
+	}
+}
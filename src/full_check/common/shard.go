@@ -0,0 +1,100 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const (
+	ShardBySlot = "slot"
+	ShardByHash = "hash"
+
+	ClusterSlots = 16384
+)
+
+/*
+ * Shard selects the part of the keyspace handled by one of several full_check processes. Keys are
+ * split either by contiguous cluster slot ranges or by the hash of the key name modulo the shard
+ * count. Shards are numbered from 0, so "1/4" is the second of four shards.
+ */
+type Shard struct {
+	Index int
+	Count int
+	By    string
+}
+
+// ParseShard parses "i/N", e.g., "0/4".
+func ParseShard(input, by string) (*Shard, error) {
+	items := strings.Split(input, "/")
+	if len(items) != 2 {
+		return nil, fmt.Errorf("invalid shard[%v], should be 'i/N'", input)
+	}
+	index, err := strconv.Atoi(items[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard[%v]: %v", input, err)
+	}
+	count, err := strconv.Atoi(items[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard[%v]: %v", input, err)
+	}
+	if count < 1 || index < 0 || index >= count {
+		return nil, fmt.Errorf("invalid shard[%v], expect 0<=i<N", input)
+	}
+	if by != ShardBySlot && by != ShardByHash {
+		return nil, fmt.Errorf("invalid shard method[%v], expect %v/%v", by, ShardBySlot, ShardByHash)
+	}
+	if by == ShardBySlot && count > ClusterSlots {
+		return nil, fmt.Errorf("invalid shard[%v], at most %d shards by slot", input, ClusterSlots)
+	}
+	return &Shard{Index: index, Count: count, By: by}, nil
+}
+
+// Pass returns true if the key belongs to this shard.
+func (s *Shard) Pass(key []byte) bool {
+	if s.By == ShardBySlot {
+		return int(KeySlot(key))*s.Count/ClusterSlots == s.Index
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// SlotRange returns the first and the last slot of this shard, only meaningful when split by slot.
+func (s *Shard) SlotRange() (int, int) {
+	first := (s.Index*ClusterSlots + s.Count - 1) / s.Count
+	last := ((s.Index+1)*ClusterSlots+s.Count-1)/s.Count - 1
+	return first, last
+}
+
+func (s *Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// KeySlot returns the redis cluster slot of the key, hash tags like "{user1000}.following" are honored.
+func KeySlot(key []byte) uint16 {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return crc16(key) % ClusterSlots
+}
+
+// crc16 is the CRC16-CCITT(XMODEM) used by redis cluster.
+func crc16(buf []byte) uint16 {
+	var crc uint16
+	for _, b := range buf {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
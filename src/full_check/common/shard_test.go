@@ -0,0 +1,79 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySlot(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestKeySlot case %d.\n", nr)
+
+		assert.Equal(t, uint16(12182), KeySlot([]byte("foo")), "should be equal")
+		assert.Equal(t, uint16(0x31C3%ClusterSlots), KeySlot([]byte("123456789")), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestKeySlot case %d.\n", nr)
+
+		// hash tag
+		assert.Equal(t, KeySlot([]byte("user1000")), KeySlot([]byte("{user1000}.following")), "should be equal")
+		assert.Equal(t, KeySlot([]byte("{}.following")), KeySlot([]byte("{}.following")), "should be equal")
+		assert.NotEqual(t, KeySlot([]byte("")), KeySlot([]byte("{}.following")), "should be not equal")
+	}
+}
+
+func TestShard(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestShard case %d.\n", nr)
+
+		for _, input := range []string{"", "1", "a/2", "2/2", "-1/2", "0/0"} {
+			_, err := ParseShard(input, ShardBySlot)
+			assert.NotNil(t, err, "should be not nil")
+		}
+		_, err := ParseShard("0/2", "range")
+		assert.NotNil(t, err, "should be not nil")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestShard case %d.\n", nr)
+
+		// every key belongs to exactly one shard
+		for _, by := range []string{ShardBySlot, ShardByHash} {
+			shards := make([]*Shard, 3)
+			for i := range shards {
+				shards[i], _ = ParseShard(fmt.Sprintf("%d/3", i), by)
+			}
+			for k := 0; k < 1000; k++ {
+				key := []byte(fmt.Sprintf("key:%d", k))
+				hit := 0
+				for _, shard := range shards {
+					if shard.Pass(key) {
+						hit++
+					}
+				}
+				assert.Equal(t, 1, hit, "should be equal")
+			}
+		}
+	}
+
+	{
+		nr++
+		fmt.Printf("TestShard case %d.\n", nr)
+
+		shard, err := ParseShard("1/3", ShardBySlot)
+		assert.Nil(t, err, "should be nil")
+		first, last := shard.SlotRange()
+		assert.Equal(t, 5462, first, "should be equal")
+		assert.Equal(t, 10922, last, "should be equal")
+		assert.True(t, shard.Pass([]byte("foo")) == (12182 >= first && 12182 <= last), "should be equal")
+	}
+}
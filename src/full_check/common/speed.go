@@ -5,14 +5,22 @@ import "time"
 type Qos struct {
 	Bucket chan struct{}
 
-	limit int // qps
+	limit func() int // qps
 	close bool
 }
 
 func StartQoS(limit int) *Qos {
+	return StartDynamicQoS(func() int {
+		return limit
+	})
+}
+
+// StartDynamicQoS starts a qos whose limit is read every second, so that it can be adjusted meanwhile.
+func StartDynamicQoS(limit func() int) *Qos {
 	q := new(Qos)
 	q.limit = limit
-	q.Bucket = make(chan struct{}, limit)
+	// the bucket holds up to a second of tokens of the initial limit
+	q.Bucket = make(chan struct{}, limit())
 
 	go q.timer()
 	return q
@@ -23,7 +31,8 @@ func (q *Qos) timer() {
 		if q.close {
 			return
 		}
-		for i := 0; i < q.limit; i++ {
+		limit := q.limit()
+		for i := 0; i < limit; i++ {
 			select {
 			case q.Bucket <- struct{}{}:
 			default:
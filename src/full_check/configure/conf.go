@@ -1,32 +1,87 @@
 package conf
 
 var Opts struct {
-	SourceAddr         string `short:"s" long:"source" value-name:"SOURCE"  description:"Set host:port of source redis. If db type is cluster, split by semicolon(;'), e.g., 10.1.1.1:1000;10.2.2.2:2000;10.3.3.3:3000. We also support auto-detection, so \"master@10.1.1.1:1000\" or \"slave@10.1.1.1:1000\" means choose master or slave. Only need to give a role in the master or slave."`
-	SourcePassword     string `short:"p" long:"sourcepassword" value-name:"Password" description:"Set source redis password (format: password or username:password)"`
-	SourceAuthType     string `long:"sourceauthtype" value-name:"AUTH-TYPE" default:"auth" description:"useless for opensource redis, valid value:auth/adminauth" `
-	SourceDBType       int    `long:"sourcedbtype" default:"0" description:"0: db, 1: cluster 2: aliyun proxy, 3: tencent proxy"`
-	SourceDBFilterList string `long:"sourcedbfilterlist" default:"-1" description:"db white list that need to be compared, -1 means fetch all, \"0;5;15\" means fetch db 0, 5, and 15"`
-	TargetAddr         string `short:"t" long:"target" value-name:"TARGET"  description:"Set host:port of target redis. If db type is cluster, split by semicolon(;'), e.g., 10.1.1.1:1000;10.2.2.2:2000;10.3.3.3:3000. We also support auto-detection, so \"master@10.1.1.1:1000\" or \"slave@10.1.1.1:1000\" means choose master or slave. Only need to give a role in the master or slave."`
-	TargetPassword     string `short:"a" long:"targetpassword" value-name:"Password" description:"Set target redis password (format: password or username:password)"`
-	TargetAuthType     string `long:"targetauthtype" value-name:"AUTH-TYPE" default:"auth" description:"useless for opensource redis, valid value:auth/adminauth" `
-	TargetDBType       int    `long:"targetdbtype" default:"0" description:"0: db, 1: cluster 2: aliyun proxy 3: tencent proxy"`
-	TargetDBFilterList string `long:"targetdbfilterlist" default:"-1" description:"db white list that need to be compared, -1 means fetch all, \"0;5;15\" means fetch db 0, 5, and 15"`
-	ResultDBFile       string `short:"d" long:"db" value-name:"Sqlite3-DB-FILE" default:"result.db" description:"sqlite3 db file for store result. If exist, it will be removed and a new file is created."`
-	ResultFile         string `long:"result" value-name:"FILE" description:"store all diff result into the file, format is 'db\tdiff-type\tkey\tfield'"`
-	CompareTimes       string `long:"comparetimes" value-name:"COUNT" default:"3" description:"Total compare count, at least 1. In the first round, all keys will be compared. The subsequent rounds of the comparison will be done on the previous results."`
-	CompareMode        int    `short:"m" long:"comparemode" default:"2" description:"compare mode, 1: compare full value, 2: only compare value length, 3: only compare keys outline, 4: compare full value, but only compare value length when meets big key"`
-	Id                 string `long:"id" default:"unknown" description:"used in metric, run id, useless for open source"`
-	JobId              string `long:"jobid" default:"unknown" description:"used in metric, job id, useless for open source"`
-	TaskId             string `long:"taskid" default:"unknown" description:"used in metric, task id, useless for open source"`
-	Qps                int    `short:"q" long:"qps" default:"15000" description:"max batch qps limit: e.g., if qps is 10, full-check fetches 10 * $batch keys every second"`
-	Interval           int    `long:"interval" value-name:"Second" default:"5" description:"The time interval for each round of comparison(Second)"`
-	BatchCount         string `long:"batchcount" value-name:"COUNT" default:"256" description:"the count of key/field per batch compare, valid value [1, 10000]"`
-	Parallel           int    `long:"parallel" value-name:"COUNT" default:"5" description:"concurrent goroutine number for comparison, valid value [1, 100]"`
-	LogFile            string `long:"log" value-name:"FILE" description:"log file, if not specified, log is put to console"`
-	LogLevel           string `long:"loglevel" value-name:"LEVEL" description:"log level: 'debug', 'info', 'warn', 'error', default is 'info'"`
-	MetricPrint        bool   `long:"metric" value-name:"BOOL" description:"print metric in log"`
-	BigKeyThreshold    int64  `long:"bigkeythreshold" value-name:"COUNT" default:"16384"`
-	FilterList         string `short:"f" long:"filterlist" value-name:"FILTER" default:"" description:"if the filter list isn't empty, all elements in list will be synced. The input should be split by '|'. The end of the string is followed by a * to indicate a prefix match, otherwise it is a full match. e.g.: 'abc*|efg|m*' matches 'abc', 'abc1', 'efg', 'm', 'mxyz', but 'efgh', 'p' aren't'"`
-	SystemProfile      uint   `long:"systemprofile" value-name:"SYSTEM-PROFILE" default:"20445" description:"port that used to print golang inner head and stack message"`
-	Version            bool   `short:"v" long:"version"`
+	SourceAddr         string   `short:"s" long:"source" value-name:"SOURCE"  description:"Set host:port of source redis. If db type is cluster, split by semicolon(;'), e.g., 10.1.1.1:1000;10.2.2.2:2000;10.3.3.3:3000. We also support auto-detection, so \"master@10.1.1.1:1000\" or \"slave@10.1.1.1:1000\" means choose master or slave. Only need to give a role in the master or slave."`
+	SourcePassword     string   `short:"p" long:"sourcepassword" value-name:"Password" description:"Set source redis password (format: password or username:password)"`
+	SourceAuthType     string   `long:"sourceauthtype" value-name:"AUTH-TYPE" default:"auth" description:"useless for opensource redis, valid value:auth/adminauth" `
+	SourceDBType       int      `long:"sourcedbtype" default:"0" description:"0: db, 1: cluster 2: aliyun proxy, 3: tencent proxy"`
+	SourceDBFilterList string   `long:"sourcedbfilterlist" default:"-1" description:"db white list that need to be compared, -1 means fetch all, \"0;5;15\" means fetch db 0, 5, and 15"`
+	TargetAddr         []string `short:"t" long:"target" value-name:"TARGET"  description:"Set host:port of target redis. If db type is cluster, split by semicolon(;'), e.g., 10.1.1.1:1000;10.2.2.2:2000;10.3.3.3:3000. We also support auto-detection, so \"master@10.1.1.1:1000\" or \"slave@10.1.1.1:1000\" means choose master or slave. Only need to give a role in the master or slave. Can be given multiple times to compare one source with several targets in a single pass, the source value is only fetched once."`
+	TargetPassword     []string `short:"a" long:"targetpassword" value-name:"Password" description:"Set target redis password (format: password or username:password), give once for all targets or once per target"`
+	TargetAuthType     []string `long:"targetauthtype" value-name:"AUTH-TYPE" default:"auth" description:"useless for opensource redis, valid value:auth/adminauth, give once for all targets or once per target" `
+	TargetDBType       []int    `long:"targetdbtype" default:"0" description:"0: db, 1: cluster 2: aliyun proxy 3: tencent proxy, give once for all targets or once per target"`
+	TargetDBFilterList string   `long:"targetdbfilterlist" default:"-1" description:"db white list that need to be compared, -1 means fetch all, \"0;5;15\" means fetch db 0, 5, and 15"`
+	DBMapping          string   `long:"dbmapping" value-name:"MAPPING" description:"map the source db to the target db, e.g., \"0:3,1:4\" compares source db 0 with target db 3 and source db 1 with target db 4. Unmapped dbs are compared with the same db"`
+	TargetDBPrefix     string   `long:"targetdbprefix" value-name:"PREFIX" description:"the source dbs are merged into db 0 of the target(e.g., a cluster) with a per-db key prefix, {db} is replaced by the source db, e.g., \"db{db}:\" means key 'k' in source db 1 is 'db1:k' on the target"`
+	ResultDBFile       string   `short:"d" long:"db" value-name:"Sqlite3-DB-FILE" default:"result.db" description:"sqlite3 db file for store result. If exist, it will be removed and a new file is created."`
+	ResultFile         string   `long:"result" value-name:"FILE" description:"store all diff result into the file, format is 'db\tdiff-type\tkey\tfield', followed by '\ttarget-key' when the key names are mapped by --keymap or --targetdbprefix"`
+	CompareTimes       string   `long:"comparetimes" value-name:"COUNT" default:"3" description:"Total compare count, at least 1. In the first round, all keys will be compared. The subsequent rounds of the comparison will be done on the previous results."`
+	CompareMode        int      `short:"m" long:"comparemode" default:"2" description:"compare mode, 1: compare full value, 2: only compare value length, 3: only compare keys outline, 4: compare full value, but only compare value length when meets big key"`
+	CompareModeType    string   `long:"comparemodetype" value-name:"RULES" description:"compare mode per data type split by semicolon(;), overrides --comparemode, e.g., \"string:1;hash:1;list:2;stream:2\""`
+	CompareModePrefix  string   `long:"comparemodeprefix" value-name:"RULES" description:"compare mode per key prefix split by semicolon(;), overrides --comparemode and --comparemodetype, the longest prefix wins, e.g., \"session:=3;big:=4\""`
+	SampleRate         float64  `long:"samplerate" value-name:"RATE" description:"only compare the given fraction of keys in (0, 1], e.g., 0.01, and report the estimated inconsistency rate per data type"`
+	SampleCount        int64    `long:"samplecount" value-name:"COUNT" description:"only compare the given number of keys per db, with the 'hash' method the keys of the lowest hashes are compared once the scan ends, can't be used together with --samplerate"`
+	SampleMethod       string   `long:"samplemethod" value-name:"METHOD" default:"hash" description:"how keys are sampled, 'hash': pick keys from the scan output by the hash of the key name, repeated runs with the same --sampleseed pick the same keys; 'randomkey': pick keys by 'randomkey', only for source db type 0 and 1"`
+	SampleSeed         string   `long:"sampleseed" value-name:"SEED" description:"seed of the 'hash' sample method, change it to pick another subset"`
+	SampleConfidence   float64  `long:"sampleconfidence" value-name:"LEVEL" default:"0.95" description:"confidence level of the reported inconsistency rate interval"`
+	Shard              string   `long:"shard" value-name:"i/N" description:"only compare the i-th of N shards of the keyspace(i starts from 0), e.g., \"0/4\", so that N processes can run on different machines. Combine their final result dbs by \"redis-full-check --db=OUTPUT merge result.db.N ...\""`
+	ShardBy            string   `long:"shardby" value-name:"METHOD" default:"slot" description:"how keys are split into shards, 'slot': by contiguous cluster slot ranges, a cluster source only reads the nodes and the slots of the shard; 'hash': by the hash of the key name modulo N, every shard scans all keys"`
+	Merkle             bool     `long:"merkle" description:"for nearly identical source and target: build hash trees over the keys and value digests of both sides, grouped per slot and then per sub-bucket, and only compare the keys of the differing buckets. Only for db type 0 and 1, can't be used with --keymap, --targetdbprefix or sampling"`
+	MerkleBuckets      int      `long:"merklebuckets" value-name:"COUNT" default:"16" description:"sub-buckets per slot of the hash tree, valid value [1, 1024]"`
+	MerkleDigest       string   `long:"merkledigest" value-name:"METHOD" default:"debug" description:"how value digests are computed, 'debug': 'debug digest-value' computed by redis, often disabled on cloud redis; 'dump': hash of 'dump' output, serializes every value and sends it over the network, and equal values with a different encoding on both sides(e.g., listpack vs hashtable, or another redis version) are reported as different buckets, which are then compared by key"`
+	MerkleBase         string   `long:"merklebase" value-name:"FILE" description:"final result db of a previous merkle run with the same --merklebuckets, report how many digests changed on each side since then"`
+	Daemon             bool     `long:"daemon" description:"keep verifying the keys changed on the source until stopped instead of comparing all keys once. A conflict is verified again until found --comparetimes times in a row and then kept in the live_conflict table of the final result db until the key becomes consistent. The table is kept when the daemon starts again with the same --db and its keys are verified again at once"`
+	DaemonSource       string   `long:"daemonsource" value-name:"SOURCE" default:"notify" description:"where the changed keys come from, 'notify': keyspace notifications of the source(notify-keyspace-events should contain 'K'), only for source db type 0 and 1; 'file:PATH': lines appended to the file; 'unix:PATH' or 'tcp:ADDR': lines sent to the socket. Each line is 'key' for db 0 or 'db<TAB>key'"`
+	SettleDelay        int      `long:"settledelay" value-name:"Millisecond" default:"1000" description:"verify a changed key after it hasn't changed for this long, so that the sync tool can catch up"`
+	LiveRecheck        int      `long:"liverecheck" value-name:"Second" default:"60" description:"verify the keys of the live_conflict table again at this interval, so that the conflicts fixed on the target without another change on the source are removed, 0 means never"`
+	Psync              bool     `long:"psync" description:"read the source as a replica instead of scanning it: the rdb snapshot received by 'psync' is kept in memory and compared with the target, so the source only pays for a replica sync. Every node is synced for source db type 1. Only for source db type 0 and 1, can't be used with --merkle or --daemon"`
+	PsyncFollow        bool     `long:"psyncfollow" description:"keep applying the command stream after the snapshot, so that the later rounds see the changes of the source. Keys changed by commands that can't be applied(e.g., stream commands) aren't compared any more. A flush of a node of source db type 1 only removes the keys of the slots it serves"`
+	SourceReplica      bool     `long:"sourcereplica" description:"scan and read the source from the online replica with the lowest lag found by 'info replication' of the source, the source itself is read when no replica is available or the replica fails. The replica is picked again before each round and its lag is recorded in the SOURCE_REPLICA table of the final result db. Only for source db type 0, a proxy doesn't report the replicas behind it, can't be used with --daemon or --psync"`
+	MaxReplicaLag      int      `long:"maxreplicalag" value-name:"Second" default:"10" description:"replicas lagging behind longer than this aren't read by --sourcereplica"`
+	Export             string   `long:"export" value-name:"FILE" description:"write the keys of the source with their types, TTLs and values to the gzip compressed file instead of comparing them with a target. The keys are scanned like a comparison, so the filter and --shard apply"`
+	ExportDigest       bool     `long:"exportdigest" description:"only export the length and a digest of each value to save space, keys that differ are then reported without the differing fields"`
+	SourceFile         string   `long:"sourcefile" value-name:"FILE" description:"read the source from a file written by --export instead of a live redis, -s, --source isn't needed. Keys expired since the export aren't compared"`
+	Fixture            string   `long:"fixture" value-name:"FILE" description:"verify the target against the keys, types, values and optional TTLs described in the YAML or JSON file instead of a source, -s, --source isn't needed. e.g., 'keys: [{key: k1, type: hash, value: {f: v}, ttl: 60}, {key: k2, db: 1, type: set, value: [a, b]}]', zset values are member: score mappings and stream values are lists of {id: ID, fields: [f, v]}. A key with a TTL in the fixture that expires later or never on the target is reported as a value conflict"`
+	FixtureExtraKeys   bool     `long:"fixtureextrakeys" description:"also report the keys on the target that aren't in the fixture as lack_source, can't be used with --keymap or --targetdbprefix"`
+	Id                 string   `long:"id" default:"unknown" description:"used in metric, run id, useless for open source"`
+	JobId              string   `long:"jobid" default:"unknown" description:"used in metric, job id, useless for open source"`
+	TaskId             string   `long:"taskid" default:"unknown" description:"used in metric, task id, useless for open source"`
+	Qps                int      `short:"q" long:"qps" default:"15000" description:"max batch qps limit: e.g., if qps is 10, full-check fetches 10 * $batch keys every second in each of the --parallel goroutines, see --sourceqps and --targetqps for global limits"`
+	SourceQps          int      `long:"sourceqps" value-name:"COUNT" default:"0" description:"max requests per second sent to the source by all goroutines together, a pipeline counts once. Unlike --qps it isn't multiplied by --parallel. 0 means unlimited"`
+	TargetQps          int      `long:"targetqps" value-name:"COUNT" default:"0" description:"max requests per second sent to all targets together, same as --sourceqps"`
+	SourceConcurrency  int      `long:"sourceconcurrency" value-name:"COUNT" default:"0" description:"max requests in flight to the source by all goroutines together, 0 means unlimited"`
+	TargetConcurrency  int      `long:"targetconcurrency" value-name:"COUNT" default:"0" description:"max requests in flight to all targets together, 0 means unlimited"`
+	AdaptiveLatency    int64    `long:"adaptivelatency" value-name:"Millisecond" default:"0" description:"halve the qps limit(--sourceqps when given, otherwise --qps) whenever the p99 latency of the source commands exceeds this, and raise it by 10% of the given limit every 5 seconds while it doesn't. 0 means unused"`
+	AdaptiveOps        int64    `long:"adaptiveops" value-name:"COUNT" default:"0" description:"also halve the qps limit whenever instantaneous_ops_per_sec of any source node exceeds this, 0 means unused"`
+	AdaptiveCpu        float64  `long:"adaptivecpu" value-name:"PERCENT" default:"0" description:"also halve the qps limit whenever the cpu usage of any source node exceeds this percent(100 is a full core), 0 means unused"`
+	AdaptiveMinQps     int      `long:"adaptiveminqps" value-name:"COUNT" default:"1" description:"the adaptive qps limit isn't lowered below this"`
+	SourceByteRate     string   `long:"sourcebyterate" value-name:"BYTES" default:"0" description:"max bytes per second read from the source by all goroutines, e.g., 512K, 10M or 1G. The goroutines fetching large values are throttled to stay within it. 0 means unlimited"`
+	TargetByteRate     string   `long:"targetbyterate" value-name:"BYTES" default:"0" description:"max bytes per second read from all targets, same format as --sourcebyterate"`
+	Interval           int      `long:"interval" value-name:"Second" default:"5" description:"The time interval for each round of comparison(Second)"`
+	RoundWait          string   `long:"roundwait" value-name:"SOURCE" description:"before each round, wait until the target reflects the source at the moment the round starts. 'replica': the target is a replica of the source, wait until every target applied the master_repl_offset of the source, only for db type 0 on both sides as a proxy doesn't report the offset; an http(s) url or 'file:PATH': the sync tool reports its lag in milliseconds there, as a number or a json object with the 'lag' field, wait until the lag is shorter than the time since the round started"`
+	RoundWaitTimeout   int      `long:"roundwaittimeout" value-name:"Second" default:"600" description:"start the round anyway when the target hasn't caught up with the source in this long"`
+	MaxDuration        int      `long:"maxduration" value-name:"Second" default:"0" description:"stop scanning and verifying once the run takes this long, everything verified so far is committed and the cursor of each source node, or of the conflict keys of the last round, is recorded in the CHECKPOINT table of the final result db. The --result file is written once the last round finishes. 0 means unlimited"`
+	Resume             bool     `long:"resume" description:"continue the run stopped by --maxduration from its checkpoint instead of starting over, the result db and the other options should be the same"`
+	HotKeys            string   `long:"hotkeys" value-name:"SOURCE" description:"verify the hot keys first. 'freq': order the keys by 'object freq' of the source, which needs an LFU maxmemory-policy; 'idletime': order the keys by 'object idletime' of the source, the least idle first; 'file:PATH': the keys listed in the file, one per line, are verified before the scan in the first round and first among the conflict keys in the following ones. Works well with --maxduration"`
+	HotKeyWindow       int      `long:"hotkeywindow" value-name:"COUNT" default:"100000" description:"keys buffered and ordered by hotness before being verified, the larger the better the order and the more memory used"`
+	Confirm            bool     `long:"confirm" description:"re-check each conflict key before it's recorded to drop the ones written between the source read and the target read: the key is verified again at once, reading the source again, and once more after --confirmdelay, reading only the target again. Only the keys differing every time are conflicts, the others are counted as equal and as false positives in the stat"`
+	ConfirmDelay       int      `long:"confirmdelay" value-name:"Millisecond" default:"200" description:"read the target again this long after the source with --confirm, so that the sync tool can catch up"`
+	TopologyPoll       int      `long:"topologypoll" value-name:"Second" default:"0" description:"poll 'cluster slots' of the cluster source this often during the scan, and at once when a node answers MOVED. The slots that moved or whose node failed are re-scanned from their new owners after the scan, and the changes are recorded in the TOPOLOGY table of the final result db. Each poll asks a source node for 'cluster slots', 0 means off"`
+	BatchCount         string   `long:"batchcount" value-name:"COUNT" default:"256" description:"the count of key/field per batch compare, valid value [1, 10000]"`
+	Parallel           int      `long:"parallel" value-name:"COUNT" default:"5" description:"concurrent goroutine number for comparison, valid value [1, 100]"`
+	PoolSize           int      `long:"poolsize" value-name:"COUNT" default:"0" description:"max connections to each host shared by all goroutines, dbs and rounds, 0 means unlimited. Should be greater than --parallel, the scan needs one more"`
+	PoolIdleTimeout    int      `long:"poolidletimeout" value-name:"Second" default:"300" description:"close the pooled connections idle for this long, 0 means never"`
+	PoolHealthCheck    int      `long:"poolhealthcheck" value-name:"Second" default:"30" description:"ping the pooled connections idle for this long before reusing them, 0 means never"`
+	LogFile            string   `long:"log" value-name:"FILE" description:"log file, if not specified, log is put to console"`
+	LogLevel           string   `long:"loglevel" value-name:"LEVEL" description:"log level: 'debug', 'info', 'warn', 'error', default is 'info'"`
+	MetricPrint        bool     `long:"metric" value-name:"BOOL" description:"print metric in log"`
+	BigKeyThreshold    int64    `long:"bigkeythreshold" value-name:"COUNT" default:"16384"`
+	FilterList         string   `short:"f" long:"filterlist" value-name:"FILTER" default:"" description:"if the filter list isn't empty, all elements in list will be synced. The input should be split by '|'. The end of the string is followed by a * to indicate a prefix match, otherwise it is a full match. e.g.: 'abc*|efg|m*' matches 'abc', 'abc1', 'efg', 'm', 'mxyz', but 'efgh', 'p' aren't'"`
+	Include            []string `long:"include" value-name:"RULE" description:"include rule, can be given multiple times. 'glob:pattern' or a bare pattern is a redis glob, 'regex:expression' is a regular expression. A key is compared if it matches any include rule(or no include rule is given) and doesn't match any exclude rule. A single glob include rule is pushed down to 'scan ... match'."`
+	Exclude            []string `long:"exclude" value-name:"RULE" description:"exclude rule, can be given multiple times, same format as --include"`
+	IncludeType        string   `long:"includetype" value-name:"TYPES" description:"only compare keys of the given data types split by semicolon(;), e.g., \"hash;string\". A single type is pushed down to 'scan ... type' on redis >= 6.0, then the keys of other types are never read and their skipped counts are estimated from a page scanned without the type"`
+	ExcludeType        string   `long:"excludetype" value-name:"TYPES" description:"skip keys of the given data types split by semicolon(;), e.g., \"stream\". Skipped keys are counted per type in the stat, unless a single --includetype is pushed down to scan"`
+	KeyMap             []string `long:"keymap" value-name:"RULE" description:"map the source key name to the target key name, can be given multiple times and the first matched rule wins: 'prefix:OLD=>NEW', 'regex:EXPR=>REPLACEMENT'($1 refers to the capture group), 'addprefix:PREFIX' or 'stripprefix:PREFIX'. e.g., 'prefix:user:=>tenantA:user:'"`
+	SystemProfile      uint     `long:"systemprofile" value-name:"SYSTEM-PROFILE" default:"20445" description:"port that used to print golang inner head and stack message"`
+	Version            bool     `short:"v" long:"version"`
 }
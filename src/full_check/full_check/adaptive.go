@@ -0,0 +1,164 @@
+package full_check
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/metric"
+
+	"github.com/jinzhu/copier"
+)
+
+const (
+	// the source load is sampled and the qps limit adjusted once per interval
+	adaptiveInterval = 5 * time.Second
+)
+
+// adaptiveState adjusts the qps limit by the load of the source.
+type adaptiveState struct {
+	limiter *common.AdaptiveLimiter
+	latency *common.LatencyWindow
+	nodes   []*adaptiveNode
+	// the global source qps is adjusted instead of the qps of each verifier
+	global bool
+
+	lock        sync.Mutex
+	stat        metric.AdaptiveStat
+	adjustments int64
+}
+
+// adaptiveNode is a source node whose INFO is sampled.
+type adaptiveNode struct {
+	client    client.RedisClient
+	cpu       float64 // cpu seconds at the last sample
+	sampledAt time.Time
+}
+
+/*
+ * startAdaptive starts adjusting the qps limit when any threshold is given. The p99 latency of
+ * the source commands is measured by the clients of the source, the ops and the cpu usage are
+ * sampled by INFO from every source node. The global source qps is adjusted when it's limited,
+ * otherwise the qps of each verifier.
+ */
+func (p *FullCheck) startAdaptive() {
+	if !p.Adaptive.Enabled() {
+		return
+	}
+
+	maxQps := conf.Opts.Qps
+	global := p.SourceHost.Throttle != nil && p.SourceHost.Throttle.Qps() > 0
+	if global {
+		maxQps = p.SourceHost.Throttle.Qps()
+	}
+	p.adaptive = &adaptiveState{
+		limiter: common.NewAdaptiveLimiter(p.Adaptive.MinQps, maxQps),
+		latency: common.NewLatencyWindow(),
+		global:  global,
+	}
+	p.SourceHost.Latency = p.adaptive.latency
+	if p.Adaptive.MaxOps > 0 || p.Adaptive.MaxCpu > 0 {
+		for _, addr := range p.SourceHost.Addr {
+			var singleHost client.RedisHost
+			copier.Copy(&singleHost, &p.SourceHost)
+			singleHost.Addr = []string{addr}
+			singleHost.DBType = common.TypeDB
+			singleHost.Latency = nil
+			nodeClient, err := client.NewRedisClient(singleHost, 0)
+			if err != nil {
+				panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+					singleHost, 0, err))
+			}
+			p.adaptive.nodes = append(p.adaptive.nodes, &adaptiveNode{client: nodeClient})
+		}
+	}
+	common.Logger.Infof("adaptive qps limit enabled: p99 latency[%dms] ops[%d] cpu[%v%%], qps limit %d~%d, "+
+		"global source qps: %v", p.Adaptive.MaxLatencyMs, p.Adaptive.MaxOps, p.Adaptive.MaxCpu, p.Adaptive.MinQps,
+		maxQps, global)
+
+	go func() {
+		for range time.NewTicker(adaptiveInterval).C {
+			p.adjustAdaptive()
+		}
+	}()
+}
+
+// qpsLimit returns the current qps limit of each verifier.
+func (p *FullCheck) qpsLimit() int {
+	if p.adaptive != nil && !p.adaptive.global {
+		return p.adaptive.limiter.Limit()
+	}
+	return conf.Opts.Qps
+}
+
+func (p *FullCheck) adjustAdaptive() {
+	stat := metric.AdaptiveStat{}
+	reasons := make([]string, 0)
+
+	if p99, ok := p.adaptive.latency.Percentile(0.99); ok {
+		stat.P99Ms = p99.Milliseconds()
+		if p.Adaptive.MaxLatencyMs > 0 && stat.P99Ms > p.Adaptive.MaxLatencyMs {
+			reasons = append(reasons, fmt.Sprintf("p99 latency %dms > %dms", stat.P99Ms, p.Adaptive.MaxLatencyMs))
+		}
+	}
+
+	// the busiest node counts
+	for _, node := range p.adaptive.nodes {
+		ops, cpu, err := node.client.FetchLoad()
+		if err != nil {
+			common.Logger.Warnf("sample load of %v failed: %v", node.client, err)
+			continue
+		}
+		now := time.Now()
+		if ops > stat.Ops {
+			stat.Ops = ops
+		}
+		if !node.sampledAt.IsZero() {
+			if usage := (cpu - node.cpu) / now.Sub(node.sampledAt).Seconds() * 100; usage > stat.Cpu {
+				stat.Cpu = usage
+			}
+		}
+		node.cpu, node.sampledAt = cpu, now
+	}
+	if p.Adaptive.MaxOps > 0 && stat.Ops > p.Adaptive.MaxOps {
+		reasons = append(reasons, fmt.Sprintf("ops %d > %d", stat.Ops, p.Adaptive.MaxOps))
+	}
+	if p.Adaptive.MaxCpu > 0 && stat.Cpu > p.Adaptive.MaxCpu {
+		reasons = append(reasons, fmt.Sprintf("cpu %.1f%% > %v%%", stat.Cpu, p.Adaptive.MaxCpu))
+	}
+
+	old, limit := p.adaptive.limiter.Adjust(len(reasons) != 0)
+	if p.adaptive.global {
+		p.SourceHost.Throttle.SetQps(limit)
+	}
+	p.adaptive.lock.Lock()
+	defer p.adaptive.lock.Unlock()
+	if old != limit {
+		p.adaptive.adjustments++
+		if len(reasons) != 0 {
+			common.Logger.Warnf("source overloaded(%s), back off qps limit %d -> %d", strings.Join(reasons, ", "),
+				old, limit)
+		} else {
+			common.Logger.Infof("source recovered, ramp up qps limit %d -> %d", old, limit)
+		}
+	}
+	stat.Limit = limit
+	stat.Adjustments = p.adaptive.adjustments
+	p.adaptive.stat = stat
+}
+
+// adaptiveStat returns the latest sample, nil when the adaptive limit isn't enabled.
+func (p *FullCheck) adaptiveStat() *metric.AdaptiveStat {
+	if p.adaptive == nil {
+		return nil
+	}
+	p.adaptive.lock.Lock()
+	defer p.adaptive.lock.Unlock()
+	stat := p.adaptive.stat
+	stat.Limit = p.adaptive.limiter.Limit()
+	return &stat
+}
@@ -0,0 +1,486 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"full_check/common"
+	"full_check/configure"
+)
+
+const CheckpointTable = "CHECKPOINT"
+
+func createCheckpointTable(db *sql.DB) error {
+	checkpointSql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s(
+	Round  INTEGER NOT NULL,
+	Db     INTEGER NOT NULL,
+	Node   TEXT NOT NULL,
+	Slots  TEXT NOT NULL,
+	Cursor INTEGER NOT NULL,
+	Done   INTEGER NOT NULL,
+	Keys   INTEGER NOT NULL,
+	Time   INTEGER NOT NULL
+	);`, CheckpointTable)
+	if _, err := db.Exec(checkpointSql); err != nil {
+		return fmt.Errorf("exec sql %s failed: %v", checkpointSql, err)
+	}
+	return nil
+}
+
+/*
+ * checkpoint tracks how far each node of the current db is verified. A node is the scan of one
+ * source node in the first round, or the conflict keys of the last round read by id in the
+ * following ones. A batch of keys is sent with the cursor to continue from after it, and the
+ * cursor of a node moves on once all the batches sent before are verified. The keys are tracked
+ * one by one as the verifiers may get them in other batches, e.g., reordered by hotness. The
+ * batches verified after the first batch that isn't are verified again when the run is resumed.
+ * The slots re-scanned after the topology of a cluster source changed are nodes as well, named
+ * "rescan <n> <owner>" and recorded with their slots, so that they are re-scanned when resumed.
+ */
+type checkpoint struct {
+	lock    sync.Mutex
+	nodes   map[string]*checkpointNode
+	keys    map[*common.Key]*checkpointBatch // the batch sent with each key
+	rescans int                              // numbers the next re-scan
+}
+
+type checkpointNode struct {
+	cursor  int64 // continue from here
+	done    bool  // all keys verified
+	keys    int64 // keys verified
+	slots   []int // the slots of a re-scan, nil for a source node
+	pending []*checkpointBatch
+}
+
+const rescanNodePrefix = "rescan "
+
+type checkpointBatch struct {
+	node       *checkpointNode
+	batch      []*common.Key
+	cursor     int64
+	last       bool
+	keys       int
+	unverified int
+}
+
+func newCheckpoint() *checkpoint {
+	return &checkpoint{
+		nodes: make(map[string]*checkpointNode),
+		keys:  make(map[*common.Key]*checkpointBatch),
+	}
+}
+
+// node returns the node, created with the cursor and the state to resume from.
+func (c *checkpoint) node(name string, cursor int64, done bool, keys int64) *checkpointNode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	node, ok := c.nodes[name]
+	if !ok {
+		node = &checkpointNode{cursor: cursor, done: done, keys: keys}
+		c.nodes[name] = node
+	}
+	return node
+}
+
+// rescanNode returns a new node re-scanning the slots from the owner.
+func (c *checkpoint) rescanNode(owner string, slots []int) *checkpointNode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	node := &checkpointNode{slots: slots}
+	c.nodes[fmt.Sprintf("%s%d %s", rescanNodePrefix, c.rescans, owner)] = node
+	c.rescans++
+	return node
+}
+
+// sent registers the batch of the node, the node continues from the cursor once it's verified.
+func (c *checkpoint) sent(node *checkpointNode, batch []*common.Key, cursor int64, last bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := &checkpointBatch{node: node, batch: batch, cursor: cursor, last: last, keys: len(batch), unverified: len(batch)}
+	node.pending = append(node.pending, entry)
+	for _, key := range batch {
+		c.keys[key] = entry
+	}
+	node.advance()
+}
+
+// verified marks the keys verified, the keys that aren't sent by a node are ignored.
+func (c *checkpoint) verified(batch []*common.Key) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range batch {
+		entry, ok := c.keys[key]
+		if !ok {
+			continue
+		}
+		delete(c.keys, key)
+		if entry.unverified--; entry.unverified == 0 {
+			entry.node.advance()
+		}
+	}
+}
+
+// finished returns true if all keys of all nodes are verified.
+func (c *checkpoint) finished() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, node := range c.nodes {
+		if !node.done {
+			return false
+		}
+	}
+	return len(c.nodes) != 0
+}
+
+// unverifiedKeys returns the names of the keys sent after the cursors, they are verified again when resumed.
+func (c *checkpoint) unverifiedKeys() [][]byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret := make([][]byte, 0)
+	for _, node := range c.nodes {
+		for _, entry := range node.pending {
+			for _, key := range entry.batch {
+				ret = append(ret, key.Key)
+			}
+		}
+	}
+	return ret
+}
+
+// advance moves the cursor over the leading verified batches, the lock should be held.
+func (n *checkpointNode) advance() {
+	for len(n.pending) != 0 && n.pending[0].unverified == 0 {
+		n.cursor, n.done = n.pending[0].cursor, n.pending[0].last
+		n.keys += int64(n.pending[0].keys)
+		n.pending = n.pending[1:]
+	}
+}
+
+// timeUp returns true if the run should stop because the max duration passed.
+func (p *FullCheck) timeUp() bool {
+	return p.TimeBox.MaxSeconds > 0 && !time.Now().Before(p.deadline)
+}
+
+// sleep waits for the duration, or until the time is up. It returns false if the time is up.
+func (p *FullCheck) sleep(duration time.Duration) bool {
+	if p.TimeBox.MaxSeconds > 0 {
+		if left := time.Until(p.deadline); left < duration {
+			time.Sleep(left)
+			return false
+		}
+	}
+	time.Sleep(duration)
+	return true
+}
+
+// checkpointNode returns the node of the current db, nil when the run isn't time-boxed.
+func (p *FullCheck) checkpointNode(name string) *checkpointNode {
+	if p.checkpoint == nil {
+		return nil
+	}
+	state := p.resumed[checkpointKey{p.times, p.currentDB, name}]
+	return p.checkpoint.node(name, state.cursor, state.done, state.keys)
+}
+
+type checkpointKey struct {
+	round int
+	db    int32
+	node  string
+}
+
+type checkpointState struct {
+	cursor int64
+	done   bool
+	keys   int64
+	slots  string
+}
+
+/*
+ * loadCheckpoint reads the checkpoint of the run to resume. It returns the round to continue,
+ * 0 when the run finished.
+ */
+func (p *FullCheck) loadCheckpoint() int {
+	rows, err := p.db[p.CompareCount].Query(fmt.Sprintf("select Round, Db, Node, Slots, Cursor, Done, Keys from %s",
+		CheckpointTable))
+	if err != nil {
+		panic(common.Logger.Errorf("read checkpoint from %v failed: %v", p.ResultDBFile, err))
+	}
+	defer rows.Close()
+
+	p.resumed = make(map[checkpointKey]checkpointState)
+	round := 1
+	for rows.Next() {
+		var key checkpointKey
+		var state checkpointState
+		if err := rows.Scan(&key.round, &key.db, &key.node, &state.slots, &state.cursor, &state.done,
+			&state.keys); err != nil {
+			panic(common.Logger.Errorf("read checkpoint from %v failed: %v", p.ResultDBFile, err))
+		}
+		p.resumed[key] = state
+		if key.round > round {
+			round = key.round
+		}
+	}
+	if err := rows.Err(); err != nil {
+		panic(common.Logger.Errorf("read checkpoint from %v failed: %v", p.ResultDBFile, err))
+	}
+
+	// the round is finished once all dbs are, the dbs of the later rounds are the same
+	for db := range p.sourceLogicalDBMap {
+		if !p.dbFinished(round, db) {
+			return round
+		}
+	}
+	if round == p.CompareCount {
+		return 0
+	}
+	return round + 1
+}
+
+// dbFinished returns true if the db was verified in the round by the run resumed.
+func (p *FullCheck) dbFinished(round int, db int32) bool {
+	found := false
+	for key, state := range p.resumed {
+		if key.round != round || key.db != db {
+			continue
+		}
+		if !state.done {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+/*
+ * writeCheckpoint records how far each node of the current db is verified, with the slots of
+ * the node for a cluster source. The conflicts of the keys sent after the cursors are deleted
+ * first, so that verifying them again when resumed doesn't record them twice.
+ */
+func (p *FullCheck) writeCheckpoint() {
+	p.forgetKeys(p.checkpoint.unverifiedKeys())
+
+	var topology *clusterTopology
+	if p.times == 1 && p.SourceHost.IsCluster() {
+		var err error
+		if topology, err = p.fetchTopology(); err != nil {
+			common.Logger.Warnf("fetch slots of source cluster failed, the slots aren't recorded: %v", err)
+		}
+	}
+
+	tx, err := p.db[p.CompareCount].Begin()
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	deleteSql := fmt.Sprintf("delete from %s where Round=? and Db=?", CheckpointTable)
+	if _, err := tx.Exec(deleteSql, p.times, p.currentDB); err != nil {
+		panic(common.Logger.Errorf("exec sql %s failed: %v", deleteSql, err))
+	}
+	insertSql := fmt.Sprintf("insert into %s (Round, Db, Node, Slots, Cursor, Done, Keys, Time) "+
+		"values(?,?,?,?,?,?,?,?)", CheckpointTable)
+
+	p.checkpoint.lock.Lock()
+	defer p.checkpoint.lock.Unlock()
+	for name, node := range p.checkpoint.nodes {
+		slots := ""
+		if node.slots != nil {
+			slots = formatSlots(node.slots)
+		} else if topology != nil {
+			slots = slotRanges(topology, name)
+		}
+		if _, err := tx.Exec(insertSql, p.times, p.currentDB, name, slots, node.cursor, node.done, node.keys,
+			time.Now().Unix()); err != nil {
+			panic(common.Logger.Errorf("exec sql %s failed: %v", insertSql, err))
+		}
+		common.Logger.Infof("checkpoint of round %d db %d node %v: cursor[%d] done[%v] %d key(s) verified",
+			p.times, p.currentDB, name, node.cursor, node.done, node.keys)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(common.Logger.Critical(err))
+	}
+}
+
+/*
+ * forgetKeys deletes the conflicts of the keys recorded in the current round and db, including
+ * the final results in the last round.
+ */
+func (p *FullCheck) forgetKeys(keys [][]byte) {
+	if len(keys) == 0 {
+		return
+	}
+	conflictKeyTableName, conflictFieldTableName := p.GetCurrentResultTable()
+	type statement struct {
+		sql string
+		db  interface{}
+	}
+	statements := []statement{
+		{fmt.Sprintf("delete from %s where key_id in (select id from %s where db=? and key=?)",
+			conflictFieldTableName, conflictKeyTableName), p.currentDB},
+		{fmt.Sprintf("delete from %s where db=? and key=?", conflictKeyTableName), p.currentDB},
+	}
+	if p.times == p.CompareCount {
+		statements = append(statements, statement{"delete from FINAL_RESULT where Schema=? and Key=?",
+			strconv.Itoa(int(p.currentDB))})
+	}
+
+	tx, err := p.db[p.times].Begin()
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	for _, statement := range statements {
+		stmt, err := tx.Prepare(statement.sql)
+		if err != nil {
+			panic(common.Logger.Errorf("prepare sql %s failed: %v", statement.sql, err))
+		}
+		for _, key := range keys {
+			if _, err := stmt.Exec(statement.db, string(key)); err != nil {
+				panic(common.Logger.Errorf("exec sql %s failed: %v", statement.sql, err))
+			}
+		}
+		stmt.Close()
+	}
+	if err := tx.Commit(); err != nil {
+		panic(common.Logger.Critical(err))
+	}
+}
+
+/*
+ * writeResultFile writes the result file from the final results once a time-boxed run finishes,
+ * the conflicts deleted when the run stopped would be left in the file if appended as found.
+ */
+func (p *FullCheck) writeResultFile() {
+	rows, err := p.db[p.CompareCount].Query("select InstanceB, Key, Schema, InconsistentType, Extra, TargetKey " +
+		"from FINAL_RESULT order by rowid")
+	if err != nil {
+		panic(common.Logger.Errorf("read final results from %v failed: %v", p.ResultDBFile, err))
+	}
+	defer rows.Close()
+
+	resultfile, err := os.Create(conf.Opts.ResultFile)
+	if err != nil {
+		panic(common.Logger.Errorf("create result file %v failed: %v", conf.Opts.ResultFile, err))
+	}
+	defer resultfile.Close()
+	for rows.Next() {
+		var target, key, db, conflictType, field, targetKey string
+		if err := rows.Scan(&target, &key, &db, &conflictType, &field, &targetKey); err != nil {
+			panic(common.Logger.Errorf("read final results from %v failed: %v", p.ResultDBFile, err))
+		}
+		if _, err := resultfile.WriteString(p.formatResultLine(db, conflictType, key, field, targetKey,
+			target)); err != nil {
+			panic(common.Logger.Errorf("write result file %v failed: %v", conf.Opts.ResultFile, err))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		panic(common.Logger.Errorf("read final results from %v failed: %v", p.ResultDBFile, err))
+	}
+}
+
+// slotRanges formats the slots owned by the node like "0-5460,5462".
+func slotRanges(topology *clusterTopology, node string) string {
+	slots := make([]int, 0)
+	for slot, owner := range topology.owners {
+		if owner == node {
+			slots = append(slots, slot)
+		}
+	}
+	return formatSlots(slots)
+}
+
+// formatSlots formats the slots in order like "0-5460,5462".
+func formatSlots(slots []int) string {
+	ranges := make([]string, 0)
+	for i := 0; i < len(slots); i++ {
+		last := i
+		for last+1 < len(slots) && slots[last+1] == slots[last]+1 {
+			last++
+		}
+		if last == i {
+			ranges = append(ranges, fmt.Sprintf("%d", slots[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", slots[i], slots[last]))
+		}
+		i = last
+	}
+	return strings.Join(ranges, ",")
+}
+
+// parseSlots parses the slots formatted by formatSlots.
+func parseSlots(input string) ([]int, error) {
+	slots := make([]int, 0)
+	if input == "" {
+		return slots, nil
+	}
+	for _, r := range strings.Split(input, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid slots %s", input)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid slots %s", input)
+			}
+		}
+		if first < 0 || last < first || last >= common.ClusterSlots {
+			return nil, fmt.Errorf("invalid slots %s", input)
+		}
+		for slot := first; slot <= last; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+type resumedRescan struct {
+	name  string
+	owner string
+	slots []int
+	state checkpointState
+}
+
+/*
+ * resumedRescans registers the re-scans of the current db recorded by the run resumed, and returns
+ * them. The next re-scans are numbered after them.
+ */
+func (p *FullCheck) resumedRescans() []resumedRescan {
+	if p.checkpoint == nil {
+		return nil
+	}
+	ret := make([]resumedRescan, 0)
+	for key, state := range p.resumed {
+		if key.round != p.times || key.db != p.currentDB || !strings.HasPrefix(key.node, rescanNodePrefix) {
+			continue
+		}
+		fields := strings.SplitN(key.node[len(rescanNodePrefix):], " ", 2)
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) != 2 {
+			panic(common.Logger.Errorf("invalid node %s in checkpoint of %v", key.node, p.ResultDBFile))
+		}
+		slots, err := parseSlots(state.slots)
+		if err != nil {
+			panic(common.Logger.Errorf("node %s in checkpoint of %v: %v", key.node, p.ResultDBFile, err))
+		}
+		node := p.checkpoint.node(key.node, state.cursor, state.done, state.keys)
+		node.slots = slots
+
+		p.checkpoint.lock.Lock()
+		if n >= p.checkpoint.rescans {
+			p.checkpoint.rescans = n + 1
+		}
+		p.checkpoint.lock.Unlock()
+		ret = append(ret, resumedRescan{name: key.node, owner: fields[1], slots: slots, state: state})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+	return ret
+}
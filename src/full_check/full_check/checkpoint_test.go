@@ -0,0 +1,196 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestCheckpoint case %d.\n", nr)
+
+		batch := func(keys ...string) []*common.Key {
+			ret := make([]*common.Key, 0, len(keys))
+			for _, key := range keys {
+				ret = append(ret, &common.Key{Key: []byte(key)})
+			}
+			return ret
+		}
+		c := newCheckpoint()
+		node := c.node("10.0.0.1:6379", 5, false, 10)
+		assert.Equal(t, node, c.node("10.0.0.1:6379", 0, false, 0), "should be equal")
+
+		first, second, third := batch("a", "b"), batch(), batch("c")
+		c.sent(node, first, 17, false)
+		c.sent(node, second, 33, false)
+		c.sent(node, third, 0, true)
+		assert.Equal(t, int64(5), node.cursor, "should be equal")
+
+		// verified out of order and in other batches
+		c.verified(append(third, first[1]))
+		assert.Equal(t, int64(5), node.cursor, "should be equal")
+		assert.False(t, c.finished(), "should be false")
+		// the keys after the cursor are verified again when resumed
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, c.unverifiedKeys(), "should be equal")
+
+		c.verified(first[:1])
+		assert.Equal(t, int64(0), node.cursor, "should be equal")
+		assert.Equal(t, int64(13), node.keys, "should be equal")
+		assert.True(t, node.done, "should be true")
+		assert.True(t, c.finished(), "should be true")
+		assert.Equal(t, 0, len(c.unverifiedKeys()), "should be equal")
+
+		// batches of other sources are ignored
+		c.verified(batch("d"))
+	}
+
+	{
+		nr++
+		fmt.Printf("TestCheckpoint case %d.\n", nr)
+
+		topology := &clusterTopology{}
+		for slot := range topology.owners {
+			topology.owners[slot] = "10.0.0.1:6379"
+		}
+		topology.owners[100] = "10.0.0.2:6379"
+		for slot := 200; slot <= 300; slot++ {
+			topology.owners[slot] = "10.0.0.2:6379"
+		}
+		assert.Equal(t, "100,200-300", slotRanges(topology, "10.0.0.2:6379"), "should be equal")
+		assert.Equal(t, "0-99,101-199,301-16383", slotRanges(topology, "10.0.0.1:6379"), "should be equal")
+		assert.Equal(t, "", slotRanges(topology, "10.0.0.3:6379"), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestCheckpoint case %d.\n", nr)
+
+		slots, err := parseSlots("5,7-9")
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, []int{5, 7, 8, 9}, slots, "should be equal")
+		assert.Equal(t, "5,7-9", formatSlots(slots), "should be equal")
+		_, err = parseSlots("9-7")
+		assert.NotNil(t, err, "should be not nil")
+		_, err = parseSlots("16384")
+		assert.NotNil(t, err, "should be not nil")
+
+		// the re-scans left by the run resumed are registered, the next ones are numbered after them
+		p := &FullCheck{times: 1, currentDB: 0, checkpoint: newCheckpoint()}
+		p.resumed = map[checkpointKey]checkpointState{
+			{1, 0, "10.0.0.1:6379"}:          {done: true},
+			{1, 0, "rescan 0 10.0.0.2:6379"}: {done: true, keys: 3, slots: "1-2"},
+			{1, 0, "rescan 1 10.0.0.3:6379"}: {cursor: 8, keys: 2, slots: "7-9"},
+			{1, 1, "rescan 4 10.0.0.3:6379"}: {slots: "1"},
+		}
+		rescans := p.resumedRescans()
+		assert.Equal(t, 2, len(rescans), "should be equal")
+		assert.Equal(t, "10.0.0.3:6379", rescans[1].owner, "should be equal")
+		assert.Equal(t, []int{7, 8, 9}, rescans[1].slots, "should be equal")
+		assert.False(t, rescans[1].state.done, "should be false")
+
+		node := p.checkpointNode(rescans[1].name)
+		assert.Equal(t, int64(8), node.cursor, "should be equal")
+		assert.Equal(t, []int{7, 8, 9}, node.slots, "should be equal")
+
+		p.checkpoint.rescanNode("10.0.0.1:6379", []int{3})
+		_, ok := p.checkpoint.nodes["rescan 2 10.0.0.1:6379"]
+		assert.True(t, ok, "should be true")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestCheckpoint case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "checkpoint")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		qps, resultFile := conf.Opts.Qps, conf.Opts.ResultFile
+		defer func() {
+			conf.Opts.Qps, conf.Opts.ResultFile = qps, resultFile
+		}()
+		// 2 batches are verified every second, the first run stops before all are
+		conf.Opts.Qps = 2
+		conf.Opts.ResultFile = filepath.Join(dir, "result")
+
+		source, target := store.New(), store.New()
+		for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			source.Set(0, key, store.NewString([]byte(key)))
+			switch key {
+			case "b", "e":
+				target.Set(0, key, store.NewString([]byte("x")))
+			case "g":
+			default:
+				target.Set(0, key, store.NewString([]byte(key)))
+			}
+		}
+		run := func(timeBox checker.TimeBoxParameter) {
+			p := NewFullCheck(checker.FullCheckParameter{
+				SourceHost: client.RedisHost{Addr: []string{"source"}, Store: source, DBType: common.TypeDB,
+					Role: client.RoleSource},
+				TargetHosts: []client.RedisHost{{Addr: []string{"target"}, Store: target, DBType: common.TypeDB,
+					Role: client.RoleTarget}},
+				ResultDBFile: filepath.Join(dir, "result.db"),
+				CompareCount: 1,
+				BatchCount:   2,
+				Parallel:     1,
+				TimeBox:      timeBox,
+				// g is verified before the scan, again when resumed
+				HotKey: checker.HotKeyParameter{Source: HotKeyFilePrefix + "hotkeys", Keys: [][]byte{[]byte("g")},
+					Window: 1},
+			}, FullValue)
+			p.Start()
+		}
+
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "result.db.1"))
+		assert.Nil(t, err, "should be nil")
+		defer db.Close()
+		query := func(query string) []string {
+			ret := make([]string, 0)
+			rows, err := db.Query(query)
+			assert.Nil(t, err, "should be nil")
+			defer rows.Close()
+			for rows.Next() {
+				var row string
+				assert.Nil(t, rows.Scan(&row), "should be nil")
+				ret = append(ret, row)
+			}
+			return ret
+		}
+
+		run(checker.TimeBoxParameter{MaxSeconds: 2})
+		assert.Equal(t, []string{"0"}, query(fmt.Sprintf("select Done from %s", CheckpointTable)),
+			"should be equal")
+		_, err = os.Stat(conf.Opts.ResultFile)
+		assert.True(t, os.IsNotExist(err), "should be true")
+
+		run(checker.TimeBoxParameter{Resume: true})
+		assert.Equal(t, []string{"1"}, query(fmt.Sprintf("select Done from %s", CheckpointTable)),
+			"should be equal")
+		assert.Equal(t, []string{"b", "e", "g"}, query("select key from key order by key"), "should be equal")
+		assert.Equal(t, []string{"b", "e", "g"}, query("select Key from FINAL_RESULT order by Key"),
+			"should be equal")
+		content, err := ioutil.ReadFile(conf.Opts.ResultFile)
+		assert.Nil(t, err, "should be nil")
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		sort.Strings(lines)
+		assert.Equal(t, []string{"0\tlack_target\tg\t", "0\tvalue\tb\t", "0\tvalue\te\t"}, lines,
+			"should be equal")
+	}
+}
@@ -0,0 +1,581 @@
+package full_check
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/metric"
+
+	"github.com/jinzhu/copier"
+)
+
+const (
+	// where the changed keys come from
+	DaemonNotify     = "notify"
+	DaemonFilePrefix = "file:"
+	DaemonUnixPrefix = "unix:"
+	DaemonTcpPrefix  = "tcp:"
+
+	LiveConflictTable = "live_conflict"
+
+	keyspacePattern = "__keyspace@*__:*"
+	keyspacePrefix  = "__keyspace@"
+	settleTick      = 100 * time.Millisecond
+)
+
+type changedKey struct {
+	db  int32
+	key string
+}
+
+type settleState struct {
+	due    time.Time
+	checks int // verifications that found conflicts in a row
+}
+
+// settleQueue delays the verification of a changed key until it hasn't changed for a while.
+type settleQueue struct {
+	delay time.Duration
+	lock  sync.Mutex
+	keys  map[changedKey]*settleState
+}
+
+func newSettleQueue(delay time.Duration) *settleQueue {
+	return &settleQueue{
+		delay: delay,
+		keys:  make(map[changedKey]*settleState),
+	}
+}
+
+// Push (re)starts the settle delay of the changed key.
+func (q *settleQueue) Push(key changedKey) {
+	q.lock.Lock()
+	q.keys[key] = &settleState{due: time.Now().Add(q.delay)}
+	q.lock.Unlock()
+}
+
+// retry verifies the conflicting key again after the settle delay unless it has changed meanwhile.
+func (q *settleQueue) retry(key changedKey, checks int) {
+	q.lock.Lock()
+	if _, ok := q.keys[key]; !ok {
+		q.keys[key] = &settleState{due: time.Now().Add(q.delay), checks: checks}
+	}
+	q.lock.Unlock()
+}
+
+// Pop removes the keys that are due and returns them with their checks.
+func (q *settleQueue) Pop(now time.Time) map[changedKey]int {
+	ret := make(map[changedKey]int)
+	q.lock.Lock()
+	for key, state := range q.keys {
+		if !state.due.After(now) {
+			ret[key] = state.checks
+			delete(q.keys, key)
+		}
+	}
+	q.lock.Unlock()
+	return ret
+}
+
+func (q *settleQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.keys)
+}
+
+// settledBatch is a group of keys of the same db whose settle delay passed.
+type settledBatch struct {
+	db     int32
+	keys   []string
+	checks []int
+}
+
+/*
+ * StartDaemon keeps verifying the keys changed on the source until it's stopped by SIGINT or
+ * SIGTERM. Each changed key is verified after the settle delay, a conflict is verified again until
+ * it's found CompareCount times in a row, and then kept in the live conflict table until a later
+ * verification finds the key consistent. The live conflicts are verified again periodically, and
+ * at once when the daemon starts again with the same result db.
+ */
+func (p *FullCheck) StartDaemon() {
+	// the live conflicts are kept in the final result db across restarts
+	var err error
+	liveDBFile := p.ResultDBFile + "." + strconv.Itoa(p.CompareCount)
+	if p.liveDB, err = sql.Open("sqlite3", liveDBFile); err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	defer p.liveDB.Close()
+	if err := p.createLiveConflictTable(); err != nil {
+		panic(common.Logger.Critical(err))
+	}
+
+	p.startAdaptive()
+	queue := newSettleQueue(time.Duration(p.Daemon.SettleDelayMs) * time.Millisecond)
+	// the live conflicts of the last run are verified again
+	p.requeueLiveConflicts(queue)
+	switch source := p.Daemon.Source; {
+	case source == DaemonNotify:
+		p.subscribeKeyspace(queue)
+	case strings.HasPrefix(source, DaemonFilePrefix):
+		go p.followFile(source[len(DaemonFilePrefix):], queue)
+	case strings.HasPrefix(source, DaemonUnixPrefix):
+		go p.listenChangedKeys("unix", source[len(DaemonUnixPrefix):], queue)
+	case strings.HasPrefix(source, DaemonTcpPrefix):
+		go p.listenChangedKeys("tcp", source[len(DaemonTcpPrefix):], queue)
+	default:
+		panic(common.Logger.Errorf("unknown daemon source[%v]", source))
+	}
+	common.Logger.Infof("daemon started: changed keys from %v, settle delay %dms, live conflicts in %v",
+		p.Daemon.Source, p.Daemon.SettleDelayMs, liveDBFile)
+
+	batches := make(chan *settledBatch, p.Parallel)
+	go p.dispatchSettled(queue, batches)
+	for i := 0; i < p.Parallel; i++ {
+		go p.verifyLive(queue, batches)
+	}
+
+	if p.Daemon.RecheckSecond > 0 {
+		go func() {
+			for range time.NewTicker(time.Duration(p.Daemon.RecheckSecond) * time.Second).C {
+				p.requeueLiveConflicts(queue)
+			}
+		}()
+	}
+
+	go func() {
+		for range time.NewTicker(time.Second * common.StatRollFrequency).C {
+			p.stat.Rotate()
+			p.liveChanged.Rotate()
+			p.PrintLiveStat(queue)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	common.Logger.Infof("daemon stopped by signal[%v], %d key(s) pending", sig, queue.Len())
+	p.PrintLiveStat(queue)
+}
+
+func (p *FullCheck) createLiveConflictTable() error {
+	liveSql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s(
+   db             INTEGER NOT NULL,
+   key            TEXT NOT NULL,
+   target         INTEGER NOT NULL,
+   type           TEXT NOT NULL,
+   conflict_type  TEXT NOT NULL,
+   source_len     INTEGER NOT NULL,
+   target_len     INTEGER NOT NULL,
+   first_seen     INTEGER NOT NULL,
+   last_seen      INTEGER NOT NULL,
+   PRIMARY KEY (db, key, target)
+);
+`, LiveConflictTable)
+	if _, err := p.liveDB.Exec(liveSql); err != nil {
+		return fmt.Errorf("exec sql %s failed: %v", liveSql, err)
+	}
+	return nil
+}
+
+// pushChanged queues the key if it should be compared.
+func (p *FullCheck) pushChanged(queue *settleQueue, db int32, key []byte) {
+	if len(p.SourceHost.DBFilterList) != 0 {
+		if _, ok := p.SourceHost.DBFilterList[int(db)]; !ok {
+			return
+		}
+	}
+	if !p.passKey(key) {
+		return
+	}
+	p.liveChanged.Inc(1)
+	queue.Push(changedKey{db: db, key: string(key)})
+}
+
+// subscribeKeyspace subscribes the keyspace notifications of every source node.
+func (p *FullCheck) subscribeKeyspace(queue *settleQueue) {
+	for _, addr := range p.SourceHost.Addr {
+		var singleHost client.RedisHost
+		copier.Copy(&singleHost, &p.SourceHost)
+		singleHost.Addr = []string{addr}
+		singleHost.DBType = common.TypeDB
+
+		go func() {
+			for {
+				err := p.subscribeNode(singleHost, queue)
+				common.Logger.Warnf("subscribe keyspace notifications of %v failed[%v], retry later", singleHost.Addr, err)
+				time.Sleep(time.Second)
+			}
+		}()
+	}
+}
+
+func (p *FullCheck) subscribeNode(host client.RedisHost, queue *settleQueue) error {
+	nodeClient, err := client.NewRedisClient(host, 0)
+	if err != nil {
+		return err
+	}
+	defer nodeClient.Close()
+
+	if events, err := nodeClient.KeyspaceEvents(); err != nil {
+		common.Logger.Warnf("fetch notify-keyspace-events of %v failed[%v]", host.Addr, err)
+	} else if !client.KeyspaceEventsEnabled(events) {
+		common.Logger.Warnf("notify-keyspace-events of %v is [%v], changed keys can't be received, "+
+			"set it to 'KA' or similar", host.Addr, events)
+	}
+
+	common.Logger.Infof("subscribe keyspace notifications of %v", host.Addr)
+	return nodeClient.PSubscribe(keyspacePattern, func(channel string, data []byte) {
+		// __keyspace@<db>__:<key>
+		if !strings.HasPrefix(channel, keyspacePrefix) {
+			return
+		}
+		items := strings.SplitN(channel[len(keyspacePrefix):], "__:", 2)
+		if len(items) != 2 {
+			return
+		}
+		db, err := strconv.Atoi(items[0])
+		if err != nil {
+			return
+		}
+		p.pushChanged(queue, int32(db), []byte(items[1]))
+	})
+}
+
+// pushChangedLine queues the key of one line: "key" for db 0 or "db<TAB>key".
+func (p *FullCheck) pushChangedLine(queue *settleQueue, line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return
+	}
+
+	db := 0
+	if idx := strings.IndexByte(line, '\t'); idx > 0 {
+		if n, err := strconv.Atoi(line[:idx]); err == nil {
+			db, line = n, line[idx+1:]
+		}
+	}
+	p.pushChanged(queue, int32(db), []byte(line))
+}
+
+// followFile reads the changed keys appended to the file like "tail -f".
+func (p *FullCheck) followFile(path string, queue *settleQueue) {
+	file, err := os.Open(path)
+	if err != nil {
+		panic(common.Logger.Errorf("open changed key file %v failed[%v]", path, err))
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// wait for the rest of the line
+			partial += line
+			time.Sleep(settleTick)
+			continue
+		} else if err != nil {
+			panic(common.Logger.Errorf("read changed key file %v failed[%v]", path, err))
+		}
+		p.pushChangedLine(queue, partial+line)
+		partial = ""
+	}
+}
+
+// listenChangedKeys accepts connections and reads the changed keys sent by each of them.
+func (p *FullCheck) listenChangedKeys(network, address string, queue *settleQueue) {
+	if network == "unix" {
+		os.Remove(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		panic(common.Logger.Errorf("listen %v %v failed[%v]", network, address, err))
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			common.Logger.Warnf("accept on %v %v failed[%v]", network, address, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				p.pushChangedLine(queue, line)
+				if err != nil {
+					if err != io.EOF {
+						common.Logger.Warnf("read changed keys from %v failed[%v]", conn.RemoteAddr(), err)
+					}
+					return
+				}
+			}
+		}()
+	}
+}
+
+// dispatchSettled groups the keys whose settle delay passed by db and sends them to the verifiers.
+func (p *FullCheck) dispatchSettled(queue *settleQueue, batches chan<- *settledBatch) {
+	for now := range time.NewTicker(settleTick).C {
+		groups := make(map[int32]*settledBatch)
+		for key, checks := range queue.Pop(now) {
+			batch, ok := groups[key.db]
+			if !ok {
+				batch = &settledBatch{db: key.db}
+				groups[key.db] = batch
+			}
+			batch.keys = append(batch.keys, key.key)
+			batch.checks = append(batch.checks, checks)
+
+			if len(batch.keys) >= p.BatchCount {
+				batches <- batch
+				delete(groups, key.db)
+			}
+		}
+		for _, batch := range groups {
+			batches <- batch
+		}
+	}
+}
+
+// liveClients holds the connections of one db.
+type liveClients struct {
+	source  client.RedisClient
+	targets []client.RedisClient
+}
+
+func (p *FullCheck) newLiveClients(db int32) *liveClients {
+	var err error
+	clients := &liveClients{targets: make([]client.RedisClient, len(p.TargetHosts))}
+	if clients.source, err = client.NewRedisClient(p.SourceHost, db); err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			p.SourceHost, db, err))
+	}
+	for i, targetHost := range p.TargetHosts {
+		if clients.targets[i], err = client.NewRedisClient(targetHost, p.targetDB(db)); err != nil {
+			panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+				targetHost, p.targetDB(db), err))
+		}
+	}
+	return clients
+}
+
+func (p *FullCheck) verifyLive(queue *settleQueue, batches <-chan *settledBatch) {
+	clients := make(map[int32]*liveClients)
+	qos := common.StartDynamicQoS(p.qpsLimit)
+	defer qos.Close()
+
+	for batch := range batches {
+		<-qos.Bucket
+		dbClients, ok := clients[batch.db]
+		if !ok {
+			dbClients = p.newLiveClients(batch.db)
+			clients[batch.db] = dbClients
+		}
+
+		// conflicts[i][target] of the i-th key
+		conflicts := make([]map[int]*common.Key, len(batch.keys))
+		index := make(map[*common.Key]int, len(batch.keys)*len(dbClients.targets))
+		if len(dbClients.targets) > 1 {
+			dbClients.source.EnableCache()
+		}
+		for target := range dbClients.targets {
+			keyInfo := make([]*common.Key, len(batch.keys))
+			for i, key := range batch.keys {
+				keyInfo[i] = &common.Key{
+					Key:          []byte(key),
+					TargetKey:    p.targetKeyName(batch.db, []byte(key)),
+					Tp:           common.EndKeyType,
+					ConflictType: common.EndConflict,
+					Target:       target,
+				}
+				index[keyInfo[i]] = i
+			}
+
+			conflictKey := make(chan *common.Key, len(keyInfo))
+			done := make(chan struct{})
+			go func() {
+				for key := range conflictKey {
+					i := index[key]
+					if conflicts[i] == nil {
+						conflicts[i] = make(map[int]*common.Key)
+					}
+					conflicts[i][key.Target] = key
+				}
+				close(done)
+			}()
+			p.verifier.VerifyOneGroupKeyInfo(keyInfo, conflictKey, &dbClients.source, &dbClients.targets[target])
+			close(conflictKey)
+			<-done
+		}
+		dbClients.source.DisableCache()
+		p.IncrScanStat(len(batch.keys))
+
+		p.updateLiveConflict(queue, batch, conflicts)
+	}
+}
+
+/*
+ * updateLiveConflict removes the keys found consistent from the live conflict table, verifies the
+ * new conflicts again and records the ones confirmed CompareCount times.
+ */
+func (p *FullCheck) updateLiveConflict(queue *settleQueue, batch *settledBatch, conflicts []map[int]*common.Key) {
+	p.liveLock.Lock()
+	defer p.liveLock.Unlock()
+
+	tx, err := p.liveDB.Begin()
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	statDelete, err := tx.Prepare(fmt.Sprintf("delete from %s where db=? and key=? and target=?", LiveConflictTable))
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	defer statDelete.Close()
+	statUpsert, err := tx.Prepare(fmt.Sprintf(`insert into %s (db, key, target, type, conflict_type, source_len, target_len, first_seen, last_seen)
+values (?,?,?,?,?,?,?,?,?) on conflict(db, key, target) do update set type=excluded.type,
+conflict_type=excluded.conflict_type, source_len=excluded.source_len, target_len=excluded.target_len, last_seen=excluded.last_seen`,
+		LiveConflictTable))
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	defer statUpsert.Close()
+
+	now := time.Now().Unix()
+	for i, key := range batch.keys {
+		confirmed := batch.checks[i]+1 >= p.CompareCount
+		if len(conflicts[i]) != 0 && !confirmed {
+			queue.retry(changedKey{db: batch.db, key: key}, batch.checks[i]+1)
+		}
+		for target := range p.TargetHosts {
+			conflict, ok := conflicts[i][target]
+			if !ok {
+				if _, err := statDelete.Exec(batch.db, key, target); err != nil {
+					panic(common.Logger.Error(err))
+				}
+			} else if confirmed {
+				if _, err := statUpsert.Exec(batch.db, key, target, conflict.Tp.Name, conflict.ConflictType.String(),
+					conflict.SourceAttr.ItemCount, conflict.TargetAttr.ItemCount, now, now); err != nil {
+					panic(common.Logger.Error(err))
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(common.Logger.Error(err))
+	}
+}
+
+/*
+ * requeueLiveConflicts verifies the keys of the live conflict table again, they are removed once the
+ * target catches up even if the source doesn't change any more. A key still conflicting is kept
+ * without being verified CompareCount times again.
+ */
+func (p *FullCheck) requeueLiveConflicts(queue *settleQueue) {
+	p.liveLock.Lock()
+	rows, err := p.liveDB.Query(fmt.Sprintf("select distinct db, key from %s", LiveConflictTable))
+	if err != nil {
+		p.liveLock.Unlock()
+		common.Logger.Warnf("read live conflicts failed[%v]", err)
+		return
+	}
+	keys := make([]changedKey, 0)
+	for rows.Next() {
+		var key changedKey
+		if err := rows.Scan(&key.db, &key.key); err != nil {
+			common.Logger.Warnf("read live conflicts failed[%v]", err)
+			break
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	p.liveLock.Unlock()
+
+	for _, key := range keys {
+		queue.retry(key, p.CompareCount-1)
+	}
+	if len(keys) != 0 {
+		common.Logger.Infof("verify %d live conflict key(s) again", len(keys))
+	}
+}
+
+func (p *FullCheck) liveConflictCount() int64 {
+	p.liveLock.Lock()
+	defer p.liveLock.Unlock()
+
+	var count int64
+	if err := p.liveDB.QueryRow(fmt.Sprintf("select count(*) from %s", LiveConflictTable)).Scan(&count); err != nil {
+		common.Logger.Warnf("count live conflicts failed[%v]", err)
+	}
+	return count
+}
+
+func (p *FullCheck) PrintLiveStat(queue *settleQueue) {
+	liveMetric := &metric.LiveMetric{
+		DateTime:     time.Now().Format("2006-01-02T15:04:05Z"),
+		Timestamp:    time.Now().Unix(),
+		Id:           conf.Opts.Id,
+		JobId:        conf.Opts.JobId,
+		TaskId:       conf.Opts.TaskId,
+		KeyChanged:   p.liveChanged.Json(),
+		KeyVerified:  p.stat.Scan.Json(),
+		Pending:      int64(queue.Len()),
+		LiveConflict: p.liveConflictCount(),
+		KeyMetric:    make(map[string]map[string]*metric.CounterStat),
+		Pools:        client.PoolStats(),
+		Adaptive:     p.adaptiveStat(),
+		Bandwidth:    p.bandwidthStat(),
+		Throttle:     p.throttleStat(),
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "KeyChanged:%v\nKeyVerified:%v\npending:%d, live conflict:%d\n", &p.liveChanged,
+		&p.stat.Scan, liveMetric.Pending, liveMetric.LiveConflict)
+	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
+		liveMetric.KeyMetric[i.String()] = make(map[string]*metric.CounterStat)
+		for j := common.ConflictType(0); j < common.EndConflict; j++ {
+			if p.stat.ConflictKey[i][j].Total() != 0 {
+				liveMetric.KeyMetric[i.String()][j.String()] = p.stat.ConflictKey[i][j].Json()
+				fmt.Fprintf(&buf, "KeyVerifiedLive|%s|%s|%v\n", i, j, &p.stat.ConflictKey[i][j])
+			}
+		}
+	}
+	for _, pool := range liveMetric.Pools {
+		fmt.Fprintf(&buf, "Pool|%s|%v\n", pool.Host, pool)
+	}
+	if liveMetric.Adaptive != nil {
+		fmt.Fprintf(&buf, "Adaptive|%v\n", liveMetric.Adaptive)
+	}
+	for _, bandwidth := range liveMetric.Bandwidth {
+		fmt.Fprintf(&buf, "Bandwidth|%s|%v\n", bandwidth.Role, bandwidth)
+	}
+	for _, throttle := range liveMetric.Throttle {
+		fmt.Fprintf(&buf, "Throttle|%s|%v\n", throttle.Role, throttle)
+	}
+
+	if conf.Opts.MetricPrint {
+		metricstr, _ := json.Marshal(liveMetric)
+		common.Logger.Info(string(metricstr))
+	} else {
+		common.Logger.Infof("live stat:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,240 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitQueue waits until the queue holds the given number of keys.
+func waitQueue(queue *settleQueue, n int) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if queue.Len() >= n {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDaemon(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestDaemon case %d.\n", nr)
+
+		// a key changed again restarts its settle delay
+		delay := 50 * time.Millisecond
+		queue := newSettleQueue(delay)
+		key := changedKey{db: 0, key: "a"}
+		queue.Push(key)
+		due := time.Now().Add(delay)
+		time.Sleep(10 * time.Millisecond)
+		queue.Push(key)
+		assert.Equal(t, 0, len(queue.Pop(due)), "should be equal")
+		assert.Equal(t, 1, queue.Len(), "should be equal")
+		assert.Equal(t, map[changedKey]int{key: 0}, queue.Pop(time.Now().Add(delay)), "should be equal")
+		assert.Equal(t, 0, queue.Len(), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestDaemon case %d.\n", nr)
+
+		// a retry doesn't replace a pending change, a change resets the checks of a retry
+		queue := newSettleQueue(0)
+		changed := changedKey{db: 0, key: "changed"}
+		retried := changedKey{db: 1, key: "retried"}
+		reset := changedKey{db: 0, key: "reset"}
+		queue.Push(changed)
+		queue.retry(changed, 2)
+		queue.retry(retried, 2)
+		queue.retry(reset, 2)
+		queue.Push(reset)
+		assert.Equal(t, map[changedKey]int{changed: 0, retried: 2, reset: 0}, queue.Pop(time.Now()),
+			"should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestDaemon case %d.\n", nr)
+
+		p := &FullCheck{}
+		p.SourceHost.DBFilterList = map[int]struct{}{0: {}, 3: {}}
+		queue := newSettleQueue(0)
+		for _, line := range []string{"a\n", "3\tb\r\n", "x\ty", "", "\n", "\tc", "5\tfiltered"} {
+			p.pushChangedLine(queue, line)
+		}
+		assert.Equal(t, map[changedKey]int{
+			{db: 0, key: "a"}:    0,
+			{db: 3, key: "b"}:    0,
+			{db: 0, key: "x\ty"}: 0,
+			{db: 0, key: "\tc"}:  0,
+		}, queue.Pop(time.Now()), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestDaemon case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "daemon")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		// lines appended later are read, a partial line waits for its end
+		path := filepath.Join(dir, "changed")
+		file, err := os.Create(path)
+		assert.Nil(t, err, "should be nil")
+		defer file.Close()
+		_, err = file.WriteString("a\n1\tb")
+		assert.Nil(t, err, "should be nil")
+
+		p := &FullCheck{}
+		queue := newSettleQueue(time.Hour)
+		go p.followFile(path, queue)
+		assert.True(t, waitQueue(queue, 1), "should be true")
+		time.Sleep(3 * settleTick)
+		assert.Equal(t, 1, queue.Len(), "should be equal")
+
+		_, err = file.WriteString("c\n2\td\n")
+		assert.Nil(t, err, "should be nil")
+		assert.True(t, waitQueue(queue, 3), "should be true")
+		assert.Equal(t, map[changedKey]int{
+			{db: 0, key: "a"}:  0,
+			{db: 1, key: "bc"}: 0,
+			{db: 2, key: "d"}:  0,
+		}, queue.Pop(time.Now().Add(time.Hour)), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestDaemon case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "daemon")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{
+			CompareCount: 2,
+			TargetHosts:  []client.RedisHost{{}, {}},
+		}}
+		p.liveDB, err = sql.Open("sqlite3", filepath.Join(dir, "result.db.1"))
+		assert.Nil(t, err, "should be nil")
+		defer p.liveDB.Close()
+		assert.Nil(t, p.createLiveConflictTable(), "should be nil")
+
+		conflict := func(target int, tp *common.KeyType, conflictType common.ConflictType) *common.Key {
+			return &common.Key{Target: target, Tp: tp, ConflictType: conflictType}
+		}
+		type liveRow struct {
+			key          string
+			target       int
+			tp           string
+			conflictType string
+			firstSeen    int64
+		}
+		rows := func() []liveRow {
+			ret := make([]liveRow, 0)
+			result, err := p.liveDB.Query(fmt.Sprintf("select key, target, type, conflict_type, first_seen "+
+				"from %s order by key, target", LiveConflictTable))
+			assert.Nil(t, err, "should be nil")
+			defer result.Close()
+			for result.Next() {
+				var row liveRow
+				assert.Nil(t, result.Scan(&row.key, &row.target, &row.tp, &row.conflictType, &row.firstSeen),
+					"should be nil")
+				ret = append(ret, row)
+			}
+			return ret
+		}
+
+		// the first conflict of k1 is verified again, the one of k2 is confirmed on target 1
+		queue := newSettleQueue(0)
+		p.updateLiveConflict(queue, &settledBatch{db: 0, keys: []string{"k1", "k2"}, checks: []int{0, 1}},
+			[]map[int]*common.Key{
+				{0: conflict(0, common.StringKeyType, common.ValueConflict)},
+				{1: conflict(1, common.StringKeyType, common.ValueConflict)},
+			})
+		assert.Equal(t, map[changedKey]int{{db: 0, key: "k1"}: 1}, queue.Pop(time.Now()), "should be equal")
+		assert.Equal(t, []liveRow{{"k2", 1, "string", "value", rows()[0].firstSeen}}, rows(), "should be equal")
+
+		// confirmed again with another conflict, the first seen time is kept
+		_, err = p.liveDB.Exec(fmt.Sprintf("update %s set first_seen=1", LiveConflictTable))
+		assert.Nil(t, err, "should be nil")
+		p.updateLiveConflict(queue, &settledBatch{db: 0, keys: []string{"k2"}, checks: []int{1}},
+			[]map[int]*common.Key{{1: conflict(1, common.HashKeyType, common.TypeConflict)}})
+		assert.Equal(t, []liveRow{{"k2", 1, "hash", "type", 1}}, rows(), "should be equal")
+
+		// kept when the daemon starts again, and verified again as confirmed ones
+		assert.Nil(t, p.createLiveConflictTable(), "should be nil")
+		assert.Equal(t, []liveRow{{"k2", 1, "hash", "type", 1}}, rows(), "should be equal")
+		queue = newSettleQueue(0)
+		p.requeueLiveConflicts(queue)
+		assert.Equal(t, map[changedKey]int{{db: 0, key: "k2"}: 1}, queue.Pop(time.Now()), "should be equal")
+
+		// and removed once consistent
+		p.updateLiveConflict(queue, &settledBatch{db: 0, keys: []string{"k2"}, checks: []int{1}},
+			[]map[int]*common.Key{nil})
+		assert.Equal(t, []liveRow{}, rows(), "should be equal")
+		assert.Equal(t, 0, queue.Len(), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestDaemon case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "daemon")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+		qps := conf.Opts.Qps
+		defer func() {
+			conf.Opts.Qps = qps
+		}()
+		conf.Opts.Qps = 100
+
+		// the conflicts confirmed are recorded under their own keys
+		source, target := store.New(), store.New()
+		for _, key := range []string{"a", "b", "c"} {
+			source.Set(0, key, store.NewString([]byte(key)))
+		}
+		target.Set(0, "a", store.NewString([]byte("a")))
+		target.Set(0, "b", store.NewString([]byte("x")))
+		p := NewFullCheck(checker.FullCheckParameter{
+			SourceHost:   client.RedisHost{Addr: []string{"source"}, Store: source, DBType: common.TypeDB},
+			TargetHosts:  []client.RedisHost{{Addr: []string{"target"}, Store: target, DBType: common.TypeDB}},
+			CompareCount: 1,
+			BatchCount:   10,
+			Confirm:      checker.ConfirmParameter{Enable: true},
+		}, FullValue)
+		p.liveDB, err = sql.Open("sqlite3", filepath.Join(dir, "result.db.1"))
+		assert.Nil(t, err, "should be nil")
+		defer p.liveDB.Close()
+		assert.Nil(t, p.createLiveConflictTable(), "should be nil")
+
+		batches := make(chan *settledBatch, 1)
+		batches <- &settledBatch{db: 0, keys: []string{"a", "b", "c"}, checks: []int{0, 0, 0}}
+		close(batches)
+		p.verifyLive(newSettleQueue(0), batches)
+
+		rows, err := p.liveDB.Query(fmt.Sprintf("select key, conflict_type from %s order by key", LiveConflictTable))
+		assert.Nil(t, err, "should be nil")
+		defer rows.Close()
+		conflicts := make([]string, 0)
+		for rows.Next() {
+			var key, conflictType string
+			assert.Nil(t, rows.Scan(&key, &conflictType), "should be nil")
+			conflicts = append(conflicts, key+" "+conflictType)
+		}
+		assert.Equal(t, []string{"b value", "c lack_target"}, conflicts, "should be equal")
+	}
+}
@@ -0,0 +1,164 @@
+package full_check
+
+import (
+	"sync"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/store"
+)
+
+/*
+ * StartExport writes the keys of the source to the export file instead of comparing them. The keys
+ * come from the same scan as the comparison, so the filter and the shard apply. The file can be
+ * compared with a target later by --sourcefile.
+ */
+func (p *FullCheck) StartExport() {
+	if p.SourceReplica.Enable {
+		p.SelectSourceReplica()
+	}
+	p.startAdaptive()
+	sourceClient, err := client.NewRedisClient(p.SourceHost, 0)
+	if err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			p.SourceHost, 0, err))
+	}
+	p.sourceLogicalDBMap, p.sourcePhysicalDBList, err = sourceClient.FetchBaseInfo(p.SourceHost.IsCluster())
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	version, err := sourceClient.FetchVersion()
+	if err != nil {
+		common.Logger.Warnf("fetch source version failed, exported without it: %v", err)
+	}
+	sourceClient.Close()
+
+	writer, err := store.NewExportWriter(p.Export.File, &store.ExportHeader{
+		Source:       p.sourceAddress(),
+		RedisVersion: version,
+		Digest:       p.Export.Digest,
+		Time:         time.Now().Unix(),
+	})
+	if err != nil {
+		panic(common.Logger.Errorf("create export file[%v] failed: %v", p.Export.File, err))
+	}
+	common.Logger.Infof("export the source to %v, digest only: %v", p.Export.File, p.Export.Digest)
+
+	p.times = 1
+	for db := range p.sourceLogicalDBMap {
+		p.currentDB = db
+		p.stat.Reset(false)
+		common.Logger.Infof("start exporting db %d", db)
+
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(time.Second * common.StatRollFrequency)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					common.Logger.Infof("export db %d: %d key(s) scanned, %d key(s) exported in total", db,
+						p.stat.Scan.Total(), writer.Count())
+				}
+			}
+		}()
+
+		keys := make(chan []*common.Key, 1024)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.ScanFromSourceRedis(keys)
+		}()
+		wg.Add(p.Parallel)
+		for i := 0; i < p.Parallel; i++ {
+			go func() {
+				defer wg.Done()
+				p.exportKeys(keys, writer)
+			}()
+		}
+		wg.Wait()
+		close(done)
+		common.Logger.Infof("db %d exported, %d key(s) scanned", db, p.stat.Scan.Total())
+	}
+
+	if err := writer.Close(); err != nil {
+		panic(common.Logger.Errorf("close export file[%v] failed: %v", p.Export.File, err))
+	}
+	common.Logger.Infof("--------------- finished! ----------------\nall finish successfully, %d key(s) exported to %v",
+		writer.Count(), p.Export.File)
+}
+
+func (p *FullCheck) exportKeys(allKeys <-chan []*common.Key, writer *store.ExportWriter) {
+	sourceClient, err := client.NewRedisClient(p.SourceHost, p.currentDB)
+	if err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			p.SourceHost, p.currentDB, err))
+	}
+	defer sourceClient.Close()
+
+	// limit qps
+	qos := common.StartDynamicQoS(p.qpsLimit)
+	defer qos.Close()
+	for keyInfo := range allKeys {
+		<-qos.Bucket
+		if len(keyInfo) == 0 {
+			continue
+		}
+		types, err := sourceClient.PipeTypeCommand(keyInfo)
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		passKeyInfo := make([]*common.Key, 0, len(keyInfo))
+		for i, key := range keyInfo {
+			key.Tp = common.NewKeyType(types[i])
+			if key.Tp != common.NoneKeyType && (p.Filter == nil || p.Filter.PassType(key.Tp)) {
+				passKeyInfo = append(passKeyInfo, key)
+			}
+		}
+		if len(passKeyInfo) == 0 {
+			continue
+		}
+
+		ttls, err := sourceClient.PipePTTLCommand(passKeyInfo)
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		values, err := sourceClient.FetchValues(passKeyInfo)
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		for i, value := range values {
+			// deleted or changed after the type was fetched
+			if value == nil || ttls[i] == -2 {
+				continue
+			}
+			if ttls[i] > 0 {
+				value.ExpireAt = now + ttls[i]
+			}
+			record := store.NewRecord(int(p.currentDB), passKeyInfo[i].Key, value, p.Export.Digest)
+			if err := writer.Write(record); err != nil {
+				panic(common.Logger.Errorf("write export file[%v] failed: %v", p.Export.File, err))
+			}
+		}
+	}
+}
+
+// LoadSourceFile loads the export file and reads the source from it afterwards.
+func (p *FullCheck) LoadSourceFile() {
+	st, header, err := store.LoadExport(p.SourceFile.File)
+	if err != nil {
+		panic(common.Logger.Errorf("load source file[%v] failed: %v", p.SourceFile.File, err))
+	}
+	common.Logger.Infof("source file[%v] loaded: exported from %v(redis %v) at %v, digest only: %v",
+		p.SourceFile.File, header.Source, header.RedisVersion, time.Unix(header.Time, 0).Format(time.RFC3339),
+		header.Digest)
+
+	p.SourceHost.Addr = []string{header.Source}
+	p.SourceHost.Store = st
+	p.SourceHost.DBType = common.TypeDB
+}
@@ -0,0 +1,166 @@
+package full_check
+
+import (
+	"database/sql"
+	"time"
+
+	"full_check/common"
+)
+
+/*
+ * feature is an optional part of the comparison. The tables of the enabled features are created in
+ * the final result db and their setups run in order before the source dbs are fetched. After the
+ * last round their reports are logged before the final message, and their teardowns run in reverse
+ * order when the comparison returns.
+ */
+type feature struct {
+	name     string
+	enabled  func(p *FullCheck) bool
+	table    func(db *sql.DB) error
+	setup    func(p *FullCheck)
+	report   func(p *FullCheck)
+	teardown func(p *FullCheck)
+}
+
+var features = []feature{
+	{
+		name:    "merkle",
+		enabled: func(p *FullCheck) bool { return p.Merkle.Enable },
+		table:   createMerkleTable,
+	},
+	{
+		name:    "sourcereplica",
+		enabled: func(p *FullCheck) bool { return p.SourceReplica.Enable },
+		table:   createSourceReplicaTable,
+		setup:   (*FullCheck).SelectSourceReplica,
+	},
+	{
+		name:    "topologypoll",
+		enabled: func(p *FullCheck) bool { return p.SourceHost.IsCluster() && p.Topology.PollSeconds > 0 },
+		table:   createTopologyTable,
+		report: func(p *FullCheck) {
+			if p.topologyEvents != 0 {
+				common.Logger.Warnf("the topology of source cluster changed %d time(s) during the scan, see table %s",
+					p.topologyEvents, TopologyTable)
+			}
+		},
+	},
+	{
+		name:    "maxduration",
+		enabled: func(p *FullCheck) bool { return p.TimeBox.Enabled() },
+		table:   createCheckpointTable,
+		setup: func(p *FullCheck) {
+			if p.TimeBox.MaxSeconds > 0 {
+				common.Logger.Infof("stop after %ds at %v", p.TimeBox.MaxSeconds, p.deadline.Format(time.RFC3339))
+			}
+		},
+	},
+	{
+		name:     "psync",
+		enabled:  func(p *FullCheck) bool { return p.Psync.Enable },
+		setup:    (*FullCheck).SyncSource,
+		teardown: (*FullCheck).closeReplicas,
+	},
+	{
+		name:    "sourcefile",
+		enabled: func(p *FullCheck) bool { return p.SourceFile.File != "" },
+		setup:   (*FullCheck).LoadSourceFile,
+	},
+	{
+		name:    "fixture",
+		enabled: func(p *FullCheck) bool { return p.Fixture.File != "" },
+		setup:   (*FullCheck).LoadFixture,
+	},
+	{
+		name:    "adaptive",
+		enabled: func(p *FullCheck) bool { return p.Adaptive.Enabled() },
+		setup:   (*FullCheck).startAdaptive,
+	},
+	{
+		name:    "keymap",
+		enabled: func(p *FullCheck) bool { return p.KeyMapper != nil },
+		setup: func(p *FullCheck) {
+			common.Logger.Infof("key map enabled: %v", p.KeyMapper)
+		},
+	},
+	{
+		name:    "filter",
+		enabled: func(p *FullCheck) bool { return p.Filter != nil },
+		setup: func(p *FullCheck) {
+			if pattern := p.Filter.MatchPattern(); pattern != nil {
+				common.Logger.Infof("push filter down to scan with match pattern[%s]", pattern)
+			}
+		},
+	},
+	{
+		name:    "shard",
+		enabled: func(p *FullCheck) bool { return p.Shard != nil },
+		setup: func(p *FullCheck) {
+			common.Logger.Infof("only compare shard %v split by %v", p.Shard, p.Shard.By)
+		},
+	},
+	{
+		name:    "sampling",
+		enabled: func(p *FullCheck) bool { return p.Sample.Enabled() },
+		setup: func(p *FullCheck) {
+			common.Logger.Infof("sample enabled: rate[%v] count[%v] method[%v] seed[%v]", p.Sample.Rate,
+				p.Sample.Count, p.Sample.Method, p.Sample.Seed)
+		},
+		report: (*FullCheck).PrintSampleStat,
+	},
+	{
+		name:    "confirm",
+		enabled: func(p *FullCheck) bool { return p.Confirm.Enable },
+		report: func(p *FullCheck) {
+			common.Logger.Infof("%d conflict key(s) were equal when read again and aren't counted as conflicts",
+				p.stat.TotalFalsePositives)
+		},
+	},
+}
+
+// enabledFeatures returns the enabled features in order.
+func (p *FullCheck) enabledFeatures() []feature {
+	ret := make([]feature, 0, len(features))
+	for _, f := range features {
+		if f.enabled(p) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// setupFeatures creates the tables of the enabled features in the final result db and sets them up.
+func (p *FullCheck) setupFeatures() {
+	enabled := p.enabledFeatures()
+	for _, f := range enabled {
+		if f.table == nil {
+			continue
+		}
+		if err := f.table(p.db[p.CompareCount]); err != nil {
+			panic(common.Logger.Critical(err))
+		}
+	}
+	for _, f := range enabled {
+		if f.setup != nil {
+			common.Logger.Debugf("set up %s", f.name)
+			f.setup(p)
+		}
+	}
+}
+
+func (p *FullCheck) reportFeatures() {
+	for _, f := range p.enabledFeatures() {
+		if f.report != nil {
+			f.report(p)
+		}
+	}
+}
+
+func (p *FullCheck) teardownFeatures() {
+	enabled := p.enabledFeatures()
+	for i := len(enabled) - 1; i >= 0; i-- {
+		if enabled[i].teardown != nil {
+			enabled[i].teardown(p)
+		}
+	}
+}
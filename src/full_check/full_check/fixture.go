@@ -0,0 +1,161 @@
+package full_check
+
+import (
+	"strconv"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/store"
+
+	"github.com/jinzhu/copier"
+)
+
+/*
+ * LoadFixture loads the fixture and reads the source from it afterwards. The scores of a fixture are
+ * formatted like the first target does, so that the version of the target is used as the source's.
+ */
+func (p *FullCheck) LoadFixture() {
+	st, err := store.LoadFixture(p.Fixture.File)
+	if err != nil {
+		panic(common.Logger.Errorf("load fixture failed: %v", err))
+	}
+
+	targetClient, err := client.NewRedisClient(p.TargetHosts[0], 0)
+	if err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			p.TargetHosts[0], 0, err))
+	}
+	if st.Version, err = targetClient.FetchVersion(); err != nil {
+		common.Logger.Warnf("fetch target version failed, scores are formatted like redis before 7.0: %v", err)
+	}
+	targetClient.Close()
+
+	p.SourceHost.Addr = []string{p.Fixture.File}
+	p.SourceHost.Store = st
+	p.SourceHost.DBType = common.TypeDB
+	common.Logger.Infof("fixture[%v] loaded, report extra keys on the target: %v", p.Fixture.File,
+		p.Fixture.ExtraKeys)
+}
+
+// addTargetDBs adds the target dbs that have keys but none in the fixture, so that their extra keys are found.
+func (p *FullCheck) addTargetDBs() {
+	for _, targetHost := range p.TargetHosts {
+		targetClient, err := client.NewRedisClient(targetHost, 0)
+		if err != nil {
+			panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+				targetHost, 0, err))
+		}
+		targetDBMap, _, err := targetClient.FetchBaseInfo(targetHost.IsCluster())
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		targetClient.Close()
+
+		for db := range targetDBMap {
+			if _, ok := p.sourceLogicalDBMap[db]; !ok && p.targetDB(db) == db {
+				p.sourceLogicalDBMap[db] = 0
+			}
+		}
+	}
+}
+
+/*
+ * ScanExtraKeys scans every target db the current db is compared with and reports the keys that
+ * aren't in the fixture as lack_source.
+ */
+func (p *FullCheck) ScanExtraKeys(conflictKey chan<- *common.Key) {
+	sourceClient, err := client.NewRedisClient(p.SourceHost, p.currentDB)
+	if err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			p.SourceHost, p.currentDB, err))
+	}
+	defer sourceClient.Close()
+
+	for i, targetHost := range p.TargetHosts {
+		nodes := []client.RedisHost{targetHost}
+		if targetHost.IsCluster() {
+			nodes = make([]client.RedisHost, len(targetHost.Addr))
+			for j, addr := range targetHost.Addr {
+				copier.Copy(&nodes[j], &targetHost)
+				nodes[j].Addr = []string{addr}
+				nodes[j].DBType = common.TypeDB
+			}
+		}
+		for _, node := range nodes {
+			p.scanExtraKeys(i, node, &sourceClient, conflictKey)
+		}
+	}
+}
+
+func (p *FullCheck) scanExtraKeys(target int, node client.RedisHost, sourceClient *client.RedisClient,
+	conflictKey chan<- *common.Key) {
+	targetClient, err := client.NewRedisClient(node, p.targetDB(p.currentDB))
+	if err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			node, p.targetDB(p.currentDB), err))
+	}
+	defer targetClient.Close()
+
+	scanOptions := p.scanOptions(false)
+	for cursor := 0; ; {
+		reply, err := targetClient.Do("scan", append([]interface{}{cursor, "count", p.BatchCount},
+			scanOptions...)...)
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		replyList, ok := reply.([]interface{})
+		if !ok || len(replyList) != 2 {
+			panic(common.Logger.Criticalf("scan %d count %d failed, result: %+v", cursor, p.BatchCount, reply))
+		}
+		if cursor, err = strconv.Atoi(string(replyList[0].([]byte))); err != nil {
+			panic(common.Logger.Critical(err))
+		}
+
+		keyInfo := make([]*common.Key, 0)
+		for _, value := range replyList[1].([]interface{}) {
+			if key := value.([]byte); p.passKey(key) {
+				keyInfo = append(keyInfo, &common.Key{Key: key, Target: target, Tp: common.EndKeyType})
+			}
+		}
+		if len(keyInfo) != 0 {
+			p.reportExtraKeys(keyInfo, sourceClient, &targetClient, conflictKey)
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+}
+
+func (p *FullCheck) reportExtraKeys(keyInfo []*common.Key, sourceClient, targetClient *client.RedisClient,
+	conflictKey chan<- *common.Key) {
+	exists, err := sourceClient.PipeExistsCommand(keyInfo)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	extraKeys := make([]*common.Key, 0)
+	for i, key := range keyInfo {
+		if exists[i] == 0 {
+			extraKeys = append(extraKeys, key)
+		}
+	}
+	if len(extraKeys) == 0 {
+		return
+	}
+
+	types, err := targetClient.PipeTypeCommand(extraKeys)
+	if err != nil {
+		panic(common.Logger.Critical(err))
+	}
+	for i, key := range extraKeys {
+		// deleted after the scan
+		if key.Tp = common.NewKeyType(types[i]); key.Tp == common.NoneKeyType {
+			continue
+		}
+		if p.Filter != nil && !p.Filter.PassType(key.Tp) {
+			continue
+		}
+		key.ConflictType = common.LackSourceConflict
+		p.stat.ConflictKey[key.Tp.Index][key.ConflictType].Inc(1)
+		conflictKey <- key
+	}
+}
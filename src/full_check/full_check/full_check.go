@@ -10,6 +10,7 @@ import (
 	_ "path"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"full_check/common"
@@ -17,6 +18,7 @@ import (
 	"full_check/checker"
 	"full_check/configure"
 	"full_check/client"
+	"full_check/replica"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -44,42 +46,120 @@ type FullCheck struct {
 	totalKeyConflict   int64
 	totalFieldConflict int64
 
+	// sampling mode
+	sampler        *sampler
+	sampleChecked  [common.EndKeyTypeIndex]int64
+	sampleConflict [common.EndKeyTypeIndex]int64
+
+	// merkle comparison of the current db
+	merkle *merkleState
+
+	// daemon mode
+	liveDB      *sql.DB
+	liveLock    sync.Mutex
+	liveChanged metric.AtomicSpeedCounter
+
+	// psync source
+	replicas []*replica.Replica
+
+	// adaptive qps limit
+	adaptive *adaptiveState
+
+	// the primary of the source read from a replica
+	sourcePrimary []string
+
+	// set once the type filter is pushed down to "scan ... type", the keys of other types are estimated
+	scanTypePushed int32
+	skipEstimate   skipEstimate
+
+	// topology changes of the cluster source during the scans
+	topologyEvents int
+
+	// progress of the current round
+	progress *progressState
+
+	// time-boxed run
+	deadline   time.Time
+	checkpoint *checkpoint // of the current db
+	resumed    map[checkpointKey]checkpointState
+
+	// counted by the verifiers re-checking the suspected conflicts, not printed
+	confirmStat metric.Stat
+
 	verifier checker.IVerifier
 }
 
 func NewFullCheck(f checker.FullCheckParameter, checktype CheckType) *FullCheck {
-	var verifier checker.IVerifier
-
 	fullcheck := &FullCheck{
 		FullCheckParameter: f,
 	}
+	client.SetPoolOptions(f.Pool)
+	fullcheck.limitSides()
+
+	if len(f.TargetHosts) > 1 {
+		fullcheck.stat.Targets = make([]metric.Conflicts, len(f.TargetHosts))
+	}
+	fullcheck.verifier = fullcheck.buildVerifier(&fullcheck.stat, checktype)
+	if f.Fixture.File != "" {
+		fullcheck.verifier = checker.NewFixtureVerifier(&fullcheck.stat, &fullcheck.FullCheckParameter,
+			fullcheck.verifier)
+	}
+	if f.Confirm.Enable {
+		// the same verifiers counting into a scratch stat re-check the suspected conflicts
+		fullcheck.verifier = checker.NewConfirmVerifier(&fullcheck.stat, &fullcheck.FullCheckParameter,
+			fullcheck.verifier, fullcheck.buildVerifier(&fullcheck.confirmStat, checktype))
+	}
+	return fullcheck
+}
+
+// buildVerifier returns the verifier of the compare mode, or dispatching each key to the verifier of its compare mode.
+func (p *FullCheck) buildVerifier(stat *metric.Stat, checktype CheckType) checker.IVerifier {
+	if len(p.TypeCompareMode) == 0 && len(p.PrefixCompareMode) == 0 {
+		return p.newVerifier(stat, checktype)
+	}
+
+	verifiers := map[int]checker.IVerifier{int(checktype): p.newVerifier(stat, checktype)}
+	for _, mode := range p.TypeCompareMode {
+		if _, ok := verifiers[mode]; !ok {
+			verifiers[mode] = p.newVerifier(stat, CheckType(mode))
+		}
+	}
+	for _, rule := range p.PrefixCompareMode {
+		if _, ok := verifiers[rule.Mode]; !ok {
+			verifiers[rule.Mode] = p.newVerifier(stat, CheckType(rule.Mode))
+		}
+	}
+	return checker.NewDispatchVerifier(stat, &p.FullCheckParameter, int(checktype), verifiers)
+}
+
+func (p *FullCheck) newVerifier(stat *metric.Stat, checktype CheckType) checker.IVerifier {
+	// only the digests of the source values are known
+	if p.SourceFile.Digest && (checktype == FullValue || checktype == FullValueWithOutline) {
+		return checker.NewDigestVerifier(stat, &p.FullCheckParameter)
+	}
 
 	switch checktype {
 	case ValueLengthOutline:
-		verifier = checker.NewValueOutlineVerifier(&fullcheck.stat, &fullcheck.FullCheckParameter)
+		return checker.NewValueOutlineVerifier(stat, &p.FullCheckParameter)
 	case KeyOutline:
-		verifier = checker.NewKeyOutlineVerifier(&fullcheck.stat, &fullcheck.FullCheckParameter)
+		return checker.NewKeyOutlineVerifier(stat, &p.FullCheckParameter)
 	case FullValue:
-		verifier = checker.NewFullValueVerifier(&fullcheck.stat, &fullcheck.FullCheckParameter, false)
+		return checker.NewFullValueVerifier(stat, &p.FullCheckParameter, false)
 	case FullValueWithOutline:
-		verifier = checker.NewFullValueVerifier(&fullcheck.stat, &fullcheck.FullCheckParameter, true)
+		return checker.NewFullValueVerifier(stat, &p.FullCheckParameter, true)
 	default:
 		panic(fmt.Sprintf("no such check type : %d", checktype))
 	}
-
-	fullcheck.verifier = verifier
-	return fullcheck
 }
 
 func (p *FullCheck) PrintStat(finished bool) {
 	var buf bytes.Buffer
 
 	var metricStat *metric.Metric
-	var finishPercent int64
-	if p.SourceHost.IsCluster() == false {
-		finishPercent = p.stat.Scan.Total() * 100 * int64(p.times) / (p.sourceLogicalDBMap[p.currentDB] * int64(p.CompareCount))
-	} else {
-		// meaningless for cluster
+	fraction := p.dbFraction(finished)
+	finishPercent := int64(fraction * 100)
+	if p.progress.dbKeys == 0 && !finished {
+		// unknown
 		finishPercent = -1
 	}
 
@@ -88,7 +168,7 @@ func (p *FullCheck) PrintStat(finished bool) {
 			CompareTimes:       p.times,
 			Db:                 p.currentDB,
 			DbKeys:             p.sourceLogicalDBMap[p.currentDB],
-			Process:            finishPercent, // meaningless for cluster
+			Process:            finishPercent, // of the current db
 			OneCompareFinished: finished,
 			AllFinished:        false,
 			Timestamp:          time.Now().Unix(),
@@ -102,7 +182,7 @@ func (p *FullCheck) PrintStat(finished bool) {
 		metricStat = &metric.Metric{
 			CompareTimes:       p.times,
 			Db:                 p.currentDB,
-			Process:            finishPercent, // meaningless for cluster
+			Process:            finishPercent, // of the current db
 			OneCompareFinished: finished,
 			AllFinished:        false,
 			Timestamp:          time.Now().Unix(),
@@ -110,7 +190,8 @@ func (p *FullCheck) PrintStat(finished bool) {
 			Id:                 conf.Opts.Id,
 			JobId:              conf.Opts.JobId,
 			TaskId:             conf.Opts.TaskId}
-		fmt.Fprintf(&buf, "times:%d, db:%d, finished:%v\n", p.times, p.currentDB, finished)
+		fmt.Fprintf(&buf, "times:%d, db:%d, finish:%d%%, finished:%v\n", p.times, p.currentDB, finishPercent,
+			finished)
 	}
 
 	p.totalConflict = int64(0)
@@ -120,88 +201,152 @@ func (p *FullCheck) PrintStat(finished bool) {
 	// fmt.Fprintf(&buf, "--- key scan ---\n")
 	fmt.Fprintf(&buf, "KeyScan:%v\n", p.stat.Scan)
 	metricStat.KeyScan = p.stat.Scan.Json()
-	metricStat.KeyMetric = make(map[string]map[string]*metric.CounterStat)
+	if p.Confirm.Enable {
+		fmt.Fprintf(&buf, "KeyFalsePositive:%v\n", p.stat.FalsePositive)
+		metricStat.FalsePositive = p.stat.FalsePositive.Json()
+	}
+	if atomic.LoadInt32(&p.scanTypePushed) != 0 {
+		// the keys of other types aren't scanned, they are estimated from the pages read without the filter
+		metricStat.SkippedEstimate = make(map[string]int64)
+		for i, count := range p.skipEstimate.estimate() {
+			if count != 0 {
+				metricStat.SkippedEstimate[common.KeyTypeIndex(i).String()] = count
+				fmt.Fprintf(&buf, "KeySkipped|%s|%s|estimated:%d\n", common.KeyTypeIndex(i),
+					common.SkippedConflict, count)
+			}
+		}
+	}
+	if len(p.stat.Targets) == 0 {
+		metricStat.KeyMetric, metricStat.FieldMetric, p.totalKeyConflict, p.totalFieldConflict =
+			p.printConflicts(&buf, "", &p.stat.Conflicts)
+	} else {
+		// every key is compared with each target, their counts are printed apart
+		for i := range p.stat.Targets {
+			target := &metric.TargetMetric{Target: p.TargetHosts[i].Address()}
+			target.KeyMetric, target.FieldMetric, target.TotalKeyConflict, target.TotalFieldConflict =
+				p.printConflicts(&buf, fmt.Sprintf("Target|%s|", target.Target), &p.stat.Targets[i])
+			p.totalKeyConflict += target.TotalKeyConflict
+			p.totalFieldConflict += target.TotalFieldConflict
+			metricStat.Targets = append(metricStat.Targets, target)
+		}
+	}
+
+	metricStat.Progress = p.progressStat(fraction)
+	fmt.Fprintf(&buf, "Progress|%v\n", metricStat.Progress)
 
+	metricStat.Pools = client.PoolStats()
+	for _, pool := range metricStat.Pools {
+		fmt.Fprintf(&buf, "Pool|%s|%v\n", pool.Host, pool)
+	}
+	if metricStat.Adaptive = p.adaptiveStat(); metricStat.Adaptive != nil {
+		fmt.Fprintf(&buf, "Adaptive|%v\n", metricStat.Adaptive)
+	}
+	metricStat.Bandwidth = p.bandwidthStat()
+	for _, bandwidth := range metricStat.Bandwidth {
+		fmt.Fprintf(&buf, "Bandwidth|%s|%v\n", bandwidth.Role, bandwidth)
+	}
+	metricStat.Throttle = p.throttleStat()
+	for _, throttle := range metricStat.Throttle {
+		fmt.Fprintf(&buf, "Throttle|%s|%v\n", throttle.Role, throttle)
+	}
+
+	p.totalConflict = p.totalKeyConflict + p.totalFieldConflict
+	if conf.Opts.MetricPrint {
+		metricstr, _ := json.Marshal(metricStat)
+		common.Logger.Info(string(metricstr))
+		// fmt.Println(string(metricstr))
+
+		if p.times == p.CompareCount && finished {
+			metricStat.AllFinished = true
+			metricStat.Process = int64(100)
+			metricStat.TotalConflict = p.totalConflict
+			metricStat.TotalKeyConflict = p.totalKeyConflict
+			metricStat.TotalFieldConflict = p.totalFieldConflict
+
+			metricstr, _ := json.Marshal(metricStat)
+			common.Logger.Info(string(metricstr))
+			// fmt.Println(string(metricstr))
+		}
+	} else {
+		common.Logger.Infof("stat:\n%s", string(buf.Bytes()))
+	}
+}
+
+/*
+ * printConflicts prints the keys and fields counted by type and conflict, each line after the prefix,
+ * and returns their metrics and the conflicts counted in the last round.
+ */
+func (p *FullCheck) printConflicts(buf *bytes.Buffer, prefix string, conflicts *metric.Conflicts) (keyMetric,
+	fieldMetric map[string]map[string]*metric.CounterStat, keyConflict, fieldConflict int64) {
+	keyMetric = make(map[string]map[string]*metric.CounterStat)
 	// fmt.Fprintf(&buf, "--- key equal ---\n")
 	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
-		metricStat.KeyMetric[i.String()] = make(map[string]*metric.CounterStat)
-		if p.stat.ConflictKey[i][common.NoneConflict].Total() != 0 {
-			metricStat.KeyMetric[i.String()]["equal"] = p.stat.ConflictKey[i][common.NoneConflict].Json()
+		keyMetric[i.String()] = make(map[string]*metric.CounterStat)
+		if conflicts.ConflictKey[i][common.NoneConflict].Total() != 0 {
+			keyMetric[i.String()]["equal"] = conflicts.ConflictKey[i][common.NoneConflict].Json()
 			if p.times == p.CompareCount {
-				fmt.Fprintf(&buf, "KeyEqualAtLast|%s|%s|%v\n", i, common.NoneConflict,
-					p.stat.ConflictKey[i][common.NoneConflict])
+				fmt.Fprintf(buf, prefix+"KeyEqualAtLast|%s|%s|%v\n", i, common.NoneConflict,
+					conflicts.ConflictKey[i][common.NoneConflict])
 			} else {
-				fmt.Fprintf(&buf, "KeyEqualInProcess|%s|%s|%v\n", i, common.NoneConflict,
-					p.stat.ConflictKey[i][common.NoneConflict])
+				fmt.Fprintf(buf, prefix+"KeyEqualInProcess|%s|%s|%v\n", i, common.NoneConflict,
+					conflicts.ConflictKey[i][common.NoneConflict])
 			}
 		}
 	}
+	// keys excluded by the data type rules
+	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
+		if conflicts.ConflictKey[i][common.SkippedConflict].Total() != 0 {
+			keyMetric[i.String()][common.SkippedConflict.String()] = conflicts.ConflictKey[i][common.SkippedConflict].Json()
+			fmt.Fprintf(buf, prefix+"KeySkipped|%s|%s|%v\n", i, common.SkippedConflict,
+				conflicts.ConflictKey[i][common.SkippedConflict])
+		}
+	}
 	// fmt.Fprintf(&buf, "--- key conflict ---\n")
 	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
 		for j := common.ConflictType(0); j < common.NoneConflict; j++ {
-			// fmt.Println(i, j, p.stat.ConflictKey[i][j].Total())
-			if p.stat.ConflictKey[i][j].Total() != 0 {
-				metricStat.KeyMetric[i.String()][j.String()] = p.stat.ConflictKey[i][j].Json()
+			// fmt.Println(i, j, conflicts.ConflictKey[i][j].Total())
+			if conflicts.ConflictKey[i][j].Total() != 0 {
+				keyMetric[i.String()][j.String()] = conflicts.ConflictKey[i][j].Json()
 				if p.times == p.CompareCount {
-					fmt.Fprintf(&buf, "KeyConflictAtLast|%s|%s|%v\n", i, j, p.stat.ConflictKey[i][j])
-					p.totalKeyConflict += p.stat.ConflictKey[i][j].Total()
+					fmt.Fprintf(buf, prefix+"KeyConflictAtLast|%s|%s|%v\n", i, j, conflicts.ConflictKey[i][j])
+					keyConflict += conflicts.ConflictKey[i][j].Total()
 				} else {
-					fmt.Fprintf(&buf, "KeyConflictInProcess|%s|%s|%v\n", i, j, p.stat.ConflictKey[i][j])
+					fmt.Fprintf(buf, prefix+"KeyConflictInProcess|%s|%s|%v\n", i, j, conflicts.ConflictKey[i][j])
 				}
 			}
 		}
 	}
 
-	metricStat.FieldMetric = make(map[string]map[string]*metric.CounterStat)
+	fieldMetric = make(map[string]map[string]*metric.CounterStat)
 	// fmt.Fprintf(&buf, "--- field equal ---\n")
 	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
-		metricStat.FieldMetric[i.String()] = make(map[string]*metric.CounterStat)
-		if p.stat.ConflictField[i][common.NoneConflict].Total() != 0 {
-			metricStat.FieldMetric[i.String()]["equal"] = p.stat.ConflictField[i][common.NoneConflict].Json()
+		fieldMetric[i.String()] = make(map[string]*metric.CounterStat)
+		if conflicts.ConflictField[i][common.NoneConflict].Total() != 0 {
+			fieldMetric[i.String()]["equal"] = conflicts.ConflictField[i][common.NoneConflict].Json()
 			if p.times == p.CompareCount {
-				fmt.Fprintf(&buf, "FieldEqualAtLast|%s|%s|%v\n", i, common.NoneConflict,
-					p.stat.ConflictField[i][common.NoneConflict])
+				fmt.Fprintf(buf, prefix+"FieldEqualAtLast|%s|%s|%v\n", i, common.NoneConflict,
+					conflicts.ConflictField[i][common.NoneConflict])
 			} else {
-				fmt.Fprintf(&buf, "FieldEqualInProcess|%s|%s|%v\n", i, common.NoneConflict,
-					p.stat.ConflictField[i][common.NoneConflict])
+				fmt.Fprintf(buf, prefix+"FieldEqualInProcess|%s|%s|%v\n", i, common.NoneConflict,
+					conflicts.ConflictField[i][common.NoneConflict])
 			}
 		}
 	}
 	// fmt.Fprintf(&buf, "--- field conflict  ---\n")
 	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
 		for j := common.ConflictType(0); j < common.NoneConflict; j++ {
-			if p.stat.ConflictField[i][j].Total() != 0 {
-				metricStat.FieldMetric[i.String()][j.String()] = p.stat.ConflictField[i][j].Json()
+			if conflicts.ConflictField[i][j].Total() != 0 {
+				fieldMetric[i.String()][j.String()] = conflicts.ConflictField[i][j].Json()
 				if p.times == p.CompareCount {
-					fmt.Fprintf(&buf, "FieldConflictAtLast|%s|%s|%v\n", i, j, p.stat.ConflictField[i][j])
-					p.totalFieldConflict += p.stat.ConflictField[i][j].Total()
+					fmt.Fprintf(buf, prefix+"FieldConflictAtLast|%s|%s|%v\n", i, j, conflicts.ConflictField[i][j])
+					fieldConflict += conflicts.ConflictField[i][j].Total()
 				} else {
-					fmt.Fprintf(&buf, "FieldConflictInProcess|%s|%s|%v\n", i, j, p.stat.ConflictField[i][j])
+					fmt.Fprintf(buf, prefix+"FieldConflictInProcess|%s|%s|%v\n", i, j, conflicts.ConflictField[i][j])
 				}
 			}
 		}
 	}
-
-	p.totalConflict = p.totalKeyConflict + p.totalFieldConflict
-	if conf.Opts.MetricPrint {
-		metricstr, _ := json.Marshal(metricStat)
-		common.Logger.Info(string(metricstr))
-		// fmt.Println(string(metricstr))
-
-		if p.times == p.CompareCount && finished {
-			metricStat.AllFinished = true
-			metricStat.Process = int64(100)
-			metricStat.TotalConflict = p.totalConflict
-			metricStat.TotalKeyConflict = p.totalKeyConflict
-			metricStat.TotalFieldConflict = p.totalFieldConflict
-
-			metricstr, _ := json.Marshal(metricStat)
-			common.Logger.Info(string(metricstr))
-			// fmt.Println(string(metricstr))
-		}
-	} else {
-		common.Logger.Infof("stat:\n%s", string(buf.Bytes()))
-	}
+	return
 }
 
 func (p *FullCheck) IncrScanStat(a int) {
@@ -209,25 +354,112 @@ func (p *FullCheck) IncrScanStat(a int) {
 }
 
 func (p *FullCheck) Start() {
-	var err error
+	p.deadline = time.Now().Add(time.Duration(p.TimeBox.MaxSeconds) * time.Second)
+	p.openResultDBs()
+	defer p.closeResultDBs()
+	p.setupFeatures()
+	defer p.teardownFeatures()
+	p.fetchSourceDBs()
+
+	firstRound := 1
+	if p.TimeBox.Resume {
+		if firstRound = p.loadCheckpoint(); firstRound == 0 {
+			common.Logger.Infof("all %d round(s) of %v are finished, nothing to resume", p.CompareCount,
+				p.ResultDBFile)
+			return
+		}
+		common.Logger.Infof("resume from round %d of %v", firstRound, p.ResultDBFile)
+	}
+
+	stoppedRound := 0
+	for p.times = firstRound; p.times <= p.CompareCount && stoppedRound == 0; p.times++ {
+		p.CreateDbTable(p.times)
+		if p.times != firstRound {
+			common.Logger.Infof("wait %d seconds before start", p.Interval)
+			if !p.sleep(time.Second * time.Duration(p.Interval)) {
+				stoppedRound = p.times
+				break
+			}
+			if p.SourceReplica.Enable {
+				p.SelectSourceReplica()
+			}
+		}
+		if p.RoundWait.Source != "" {
+			p.WaitForTarget()
+		}
+		common.Logger.Infof("---------------- start %dth time compare", p.times)
+		p.startRoundProgress()
 
+		for db := range p.sourceLogicalDBMap {
+			if p.TimeBox.Resume && p.dbFinished(p.times, db) {
+				common.Logger.Infof("db %d of round %d is finished, skip it", db, p.times)
+				continue
+			}
+			if p.timeUp() || !p.compareDB(db) {
+				stoppedRound = p.times
+				break
+			}
+		} // for db, keyNum := range dbNums
+
+		p.PrintPsyncStat()
+		// do not reset when run the final time
+		if p.times < p.CompareCount {
+			p.stat.Reset(true)
+		}
+	} // end for
+
+	p.stat.Reset(false)
+	p.reportFeatures()
+	if stoppedRound != 0 {
+		common.Logger.Warnf("--------------- stopped! ----------------\ntime is up after %ds in round %d, "+
+			"the keys verified so far are committed and the coverage is recorded in table %s, run again with "+
+			"--resume to continue", p.TimeBox.MaxSeconds, stoppedRound, CheckpointTable)
+		return
+	}
+	if p.TimeBox.Enabled() && len(conf.Opts.ResultFile) != 0 {
+		p.writeResultFile()
+	}
+	common.Logger.Infof("--------------- finished! ----------------\nall finish successfully, totally %d key(s) and %d field(s) conflict",
+		p.stat.TotalConflictKeys, p.stat.TotalConflictFields)
+	for i := range p.stat.Targets {
+		common.Logger.Infof("target %v: %d key(s) and %d field(s) conflict", p.TargetHosts[i].Address(),
+			p.stat.Targets[i].TotalConflictKeys, p.stat.Targets[i].TotalConflictFields)
+	}
+}
+
+// openResultDBs opens the result db of each round, the ones of a previous run are kept to resume.
+func (p *FullCheck) openResultDBs() {
+	var err error
 	for i := 1; i <= p.CompareCount; i++ {
-		// init sqlite db
-		os.Remove(p.ResultDBFile + "." + strconv.Itoa(i))
+		if !p.TimeBox.Resume {
+			os.Remove(p.ResultDBFile + "." + strconv.Itoa(i))
+		}
 		p.db[i], err = sql.Open("sqlite3", p.ResultDBFile+"."+strconv.Itoa(i))
 		if err != nil {
 			panic(common.Logger.Critical(err))
 		}
-		defer p.db[i].Close()
 	}
 
+	if err := createSummaryTable(p.db[p.CompareCount]); err != nil {
+		panic(common.Logger.Critical(err))
+	}
+}
+
+func (p *FullCheck) closeResultDBs() {
+	for i := 1; i <= p.CompareCount; i++ {
+		p.db[i].Close()
+	}
+}
+
+// fetchSourceDBs fetches the logical dbs of the source and the nodes to scan.
+func (p *FullCheck) fetchSourceDBs() {
 	sourceClient, err := client.NewRedisClient(p.SourceHost, 0)
 	if err != nil {
 		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
 			p.SourceHost, 0, err))
 	}
 
-	p.sourceLogicalDBMap, p.sourcePhysicalDBList, err = sourceClient.FetchBaseInfo(conf.Opts.SourceDBType == 1)
+	p.sourceLogicalDBMap, p.sourcePhysicalDBList, err = sourceClient.FetchBaseInfo(p.SourceHost.IsCluster())
 	if err != nil {
 		panic(common.Logger.Critical(err))
 	}
@@ -236,95 +468,127 @@ func (p *FullCheck) Start() {
 		p.sourcePhysicalDBList)
 
 	sourceClient.Close()
+	if p.Fixture.ExtraKeys {
+		p.addTargetDBs()
+	}
+	p.countSourceKeys()
 	for db, keyNum := range p.sourceLogicalDBMap {
-		if p.SourceHost.IsCluster() == true {
-			common.Logger.Infof("db=%d:keys=%d(inaccurate for type cluster)", db, keyNum)
-		} else {
-			common.Logger.Infof("db=%d:keys=%d", db, keyNum)
-		}
+		common.Logger.Infof("db=%d:keys=%d", db, keyNum)
 	}
+}
 
-	for p.times = 1; p.times <= p.CompareCount; p.times++ {
-		p.CreateDbTable(p.times)
-		if p.times != 1 {
-			common.Logger.Infof("wait %d seconds before start", p.Interval)
-			time.Sleep(time.Second * time.Duration(p.Interval))
-		}
-		common.Logger.Infof("---------------- start %dth time compare", p.times)
-
-		for db := range p.sourceLogicalDBMap {
-			p.currentDB = db
-			p.stat.Reset(false)
-			// init stat timer
-			tickerStat := time.NewTicker(time.Second * common.StatRollFrequency)
-			ctxStat, cancelStat := context.WithCancel(context.Background()) // 主动cancel
-			go func(ctx context.Context) {
-				defer func() {
-					tickerStat.Stop()
-				}()
-
-				for range tickerStat.C {
-					select { // 判断是否结束
-					case <-ctx.Done():
-						return
-					default:
-					}
-					p.stat.Rotate()
-					p.PrintStat(false)
-				}
-			}(ctxStat)
-
-			common.Logger.Infof("start compare db %d", p.currentDB)
-			keys := make(chan []*common.Key, 1024)
-			conflictKey := make(chan *common.Key, 1024)
-			var wg, wg2 sync.WaitGroup
-			// start scan, get all keys
-			if p.times == 1 {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					p.ScanFromSourceRedis(keys)
-				}()
-			} else {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					p.ScanFromDB(keys)
-				}()
+// compareDB compares the keys of the db in the current round, returns false if the time is up before all are verified.
+func (p *FullCheck) compareDB(db int32) bool {
+	p.currentDB = db
+	p.stat.Reset(false)
+	atomic.StoreInt32(&p.scanTypePushed, 0)
+	p.skipEstimate.reset()
+	p.startDBProgress()
+	if p.TimeBox.Enabled() {
+		p.checkpoint = newCheckpoint()
+	}
+	if p.TimeBox.Resume && p.times == 1 && len(p.HotKey.Keys) != 0 {
+		// the listed hot keys are verified again before the scan
+		p.forgetKeys(p.HotKey.Keys)
+	}
+	if p.times == 1 && p.Sample.Enabled() {
+		p.sampler = p.newSampler()
+	}
+	p.merkle = nil
+	if p.times == 1 && p.Merkle.Enable {
+		p.CompareMerkle()
+	}
+	// init stat timer
+	tickerStat := time.NewTicker(time.Second * common.StatRollFrequency)
+	ctxStat, cancelStat := context.WithCancel(context.Background()) // 主动cancel
+	go func(ctx context.Context) {
+		defer func() {
+			tickerStat.Stop()
+		}()
+
+		for range tickerStat.C {
+			select { // 判断是否结束
+			case <-ctx.Done():
+				return
+			default:
 			}
+			p.stat.Rotate()
+			p.PrintStat(false)
+		}
+	}(ctxStat)
 
-			// start check
-			wg.Add(p.Parallel)
-			for i := 0; i < p.Parallel; i++ {
-				go func() {
-					defer wg.Done()
-					p.VerifyAllKeyInfo(keys, conflictKey)
-				}()
-			}
+	common.Logger.Infof("start compare db %d with target db %d", p.currentDB, p.targetDB(p.currentDB))
+	if p.SourceReplica.Enable {
+		p.WriteReplicaLag()
+	}
+	keys := make(chan []*common.Key, 1024)
+	conflictKey := make(chan *common.Key, 1024)
+	var wg, wg2 sync.WaitGroup
+	// the scanned keys are ordered by hotness before being verified
+	scanned := keys
+	if p.HotKey.Source != "" {
+		scanned = make(chan []*common.Key, 1024)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.PrioritizeKeys(scanned, keys)
+		}()
+	}
+	// start scan, get all keys
+	if p.times == 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.ScanFromSourceRedis(scanned)
+		}()
+	} else {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.ScanFromDB(scanned)
+		}()
+	}
 
-			// start write conflictKey
-			wg2.Add(1)
-			go func() {
-				defer wg2.Done()
-				p.WriteConflictKey(conflictKey)
-			}()
-
-			wg.Wait()
-			close(conflictKey)
-			wg2.Wait()
-			cancelStat() // stop stat goroutine
-			p.PrintStat(true)
-		} // for db, keyNum := range dbNums
+	if p.times == 1 && p.Fixture.ExtraKeys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.ScanExtraKeys(conflictKey)
+		}()
+	}
 
-		// do not reset when run the final time
-		if p.times < p.CompareCount {
-			p.stat.Reset(true)
-		}
-	} // end for
+	// start check
+	wg.Add(p.Parallel)
+	for i := 0; i < p.Parallel; i++ {
+		go func() {
+			defer wg.Done()
+			p.VerifyAllKeyInfo(keys, conflictKey)
+		}()
+	}
 
-	p.stat.Reset(false)
-	common.Logger.Infof("--------------- finished! ----------------\nall finish successfully, totally %d key(s) and %d field(s) conflict",
-		p.stat.TotalConflictKeys, p.stat.TotalConflictFields)
+	// start write conflictKey
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		p.WriteConflictKey(conflictKey)
+	}()
+
+	wg.Wait()
+	close(conflictKey)
+	wg2.Wait()
+	cancelStat() // stop stat goroutine
+	finished := true
+	if p.checkpoint != nil {
+		p.writeCheckpoint()
+		finished = p.checkpoint.finished()
+	}
+	p.PrintStat(finished)
+	p.finishDBProgress()
+	p.WriteSummary()
+	if p.Sample.Enabled() {
+		p.collectSampleStat()
+	}
+	return finished
 }
 
 func (p *FullCheck) GetCurrentResultTable() (key string, field string) {
@@ -344,12 +608,14 @@ func (p *FullCheck) CreateDbTable(times int) {
 	conflictKeyTableName, conflictFieldTableName := p.GetCurrentResultTable()
 
 	conflictKeyTableSql := fmt.Sprintf(`
-CREATE TABLE %s(
+CREATE TABLE IF NOT EXISTS %s(
    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
    key            TEXT NOT NULL,
+   target_key     TEXT NOT NULL,
    type           TEXT NOT NULL,
    conflict_type  TEXT NOT NULL,
    db             INTEGER NOT NULL,
+   target         INTEGER NOT NULL,
    source_len     INTEGER NOT NULL,
    target_len     INTEGER NOT NULL
 );
@@ -359,7 +625,7 @@ CREATE TABLE %s(
 		panic(common.Logger.Errorf("exec sql %s failed: %s", conflictKeyTableSql, err))
 	}
 	conflictFieldTableSql := fmt.Sprintf(`
-CREATE TABLE %s(
+CREATE TABLE IF NOT EXISTS %s(
    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
    field          TEXT NOT NULL,
    conflict_type  TEXT NOT NULL,
@@ -378,12 +644,39 @@ CREATE TABLE IF NOT EXISTS %s(
 	Key			TEXT NOT NULL,
 	Schema		TEXT NOT NULL,
 	InconsistentType TEXT NOT NULL,
-	Extra	    TEXT NOT NULL
+	Extra	    TEXT NOT NULL,
+	TargetKey	TEXT NOT NULL
 	);`, "FINAL_RESULT")
 	_, err = p.db[times].Exec(conflictResultSql)
 	if err != nil {
 		panic(common.Logger.Errorf("exec sql %s failed: %s", conflictResultSql, err))
 	}
+
+	// the conflicts of the keys verified again when resumed are looked up by name
+	if p.TimeBox.Enabled() {
+		indexSqls := []string{
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_db_key ON %s(db, key)", conflictKeyTableName,
+				conflictKeyTableName),
+			"CREATE INDEX IF NOT EXISTS FINAL_RESULT_Schema_Key ON FINAL_RESULT(Schema, Key)",
+		}
+		for _, indexSql := range indexSqls {
+			if _, err := p.db[times].Exec(indexSql); err != nil {
+				panic(common.Logger.Errorf("exec sql %s failed: %s", indexSql, err))
+			}
+		}
+	}
+}
+
+// targetDB returns the logical db on the target side of the given source db.
+func (p *FullCheck) targetDB(db int32) int32 {
+	if target, ok := p.DBMapping[db]; ok {
+		return target
+	}
+	if p.TargetDBPrefix != "" {
+		// all dbs are merged into the single db target
+		return 0
+	}
+	return db
 }
 
 func (p *FullCheck) VerifyAllKeyInfo(allKeys <-chan []*common.Key, conflictKey chan<- *common.Key) {
@@ -394,34 +687,75 @@ func (p *FullCheck) VerifyAllKeyInfo(allKeys <-chan []*common.Key, conflictKey c
 	}
 	defer sourceClient.Close()
 
-	targetClient, err := client.NewRedisClient(p.TargetHost, p.currentDB)
-	if err != nil {
-		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
-			p.TargetHost, p.currentDB, err))
+	targetClients := make([]client.RedisClient, len(p.TargetHosts))
+	for i, targetHost := range p.TargetHosts {
+		targetClients[i], err = client.NewRedisClient(targetHost, p.targetDB(p.currentDB))
+		if err != nil {
+			panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+				targetHost, p.targetDB(p.currentDB), err))
+		}
+		defer targetClients[i].Close()
 	}
-	defer targetClient.Close()
 
 	// limit qps
-	qos := common.StartQoS(conf.Opts.Qps)
+	qos := common.StartDynamicQoS(p.qpsLimit)
 	for keyInfo := range allKeys {
+		if p.timeUp() {
+			// drain the keys left unverified, they are verified again when resumed
+			continue
+		}
 		<-qos.Bucket
-		p.verifier.VerifyOneGroupKeyInfo(keyInfo, conflictKey, &sourceClient, &targetClient)
+		if len(targetClients) == 1 {
+			p.verifier.VerifyOneGroupKeyInfo(keyInfo, conflictKey, &sourceClient, &targetClients[0])
+			p.batchVerified(keyInfo)
+			continue
+		}
+
+		// fetch the source value only once and compare it with every target
+		sourceClient.EnableCache()
+		for i := range targetClients {
+			if targetKeyInfo := p.keysOfTarget(keyInfo, i); len(targetKeyInfo) != 0 {
+				p.verifier.VerifyOneGroupKeyInfo(targetKeyInfo, conflictKey, &sourceClient, &targetClients[i])
+			}
+		}
+		sourceClient.DisableCache()
+		p.batchVerified(keyInfo)
 	} // for oneGroupKeys := range allKeys
 
 	qos.Close()
 }
 
+/*
+ * keysOfTarget returns the keys that need to be compared with the given target. In the first round
+ * every key is compared with all targets, so each target gets its own copy. In the following rounds
+ * the keys come from the result db and already belong to one target.
+ */
+func (p *FullCheck) keysOfTarget(keyInfo []*common.Key, target int) []*common.Key {
+	ret := make([]*common.Key, 0, len(keyInfo))
+	for _, key := range keyInfo {
+		if p.times == 1 {
+			copied := *key
+			copied.Target = target
+			ret = append(ret, &copied)
+		} else if key.Target == target {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
 func (p *FullCheck) WriteConflictKey(conflictKey <-chan *common.Key) {
 	conflictKeyTableName, conflictFieldTableName := p.GetCurrentResultTable()
 
+	// the result file of a time-boxed run is written once it finishes
 	var resultfile *os.File
-	if len(conf.Opts.ResultFile) > 0 {
+	if len(conf.Opts.ResultFile) > 0 && !p.TimeBox.Enabled() {
 		resultfile, _ = os.OpenFile(conf.Opts.ResultFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 		defer resultfile.Close()
 	}
 
 	tx, _ := p.db[p.times].Begin()
-	statInsertKey, err := tx.Prepare(fmt.Sprintf("insert into %s (key, type, conflict_type, db, source_len, target_len) values(?,?,?,?,?,?)", conflictKeyTableName))
+	statInsertKey, err := tx.Prepare(fmt.Sprintf("insert into %s (key, target_key, type, conflict_type, db, target, source_len, target_len) values(?,?,?,?,?,?,?,?)", conflictKeyTableName))
 	if err != nil {
 		panic(common.Logger.Error(err))
 	}
@@ -442,7 +776,7 @@ func (p *FullCheck) WriteConflictKey(conflictKey <-chan *common.Key) {
 			}
 
 			tx, _ = p.db[p.times].Begin()
-			statInsertKey, err = tx.Prepare(fmt.Sprintf("insert into %s (key, type, conflict_type, db, source_len, target_len) values(?,?,?,?,?,?)", conflictKeyTableName))
+			statInsertKey, err = tx.Prepare(fmt.Sprintf("insert into %s (key, target_key, type, conflict_type, db, target, source_len, target_len) values(?,?,?,?,?,?,?,?)", conflictKeyTableName))
 			if err != nil {
 				panic(common.Logger.Error(err))
 			}
@@ -453,8 +787,9 @@ func (p *FullCheck) WriteConflictKey(conflictKey <-chan *common.Key) {
 			}
 		}
 		count += 1
+		p.incrConflicts(1)
 
-		result, err := statInsertKey.Exec(string(oneKeyInfo.Key), oneKeyInfo.Tp.Name, oneKeyInfo.ConflictType.String(), p.currentDB, oneKeyInfo.SourceAttr.ItemCount, oneKeyInfo.TargetAttr.ItemCount)
+		result, err := statInsertKey.Exec(string(oneKeyInfo.Key), string(oneKeyInfo.TargetKey), oneKeyInfo.Tp.Name, oneKeyInfo.ConflictType.String(), p.currentDB, oneKeyInfo.Target, oneKeyInfo.SourceAttr.ItemCount, oneKeyInfo.TargetAttr.ItemCount)
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
@@ -467,40 +802,43 @@ func (p *FullCheck) WriteConflictKey(conflictKey <-chan *common.Key) {
 				}
 
 				if p.times == p.CompareCount {
-					finalstat, err := tx.Prepare(fmt.Sprintf("insert into FINAL_RESULT (InstanceA, InstanceB, Key, Schema, InconsistentType, Extra) VALUES(?, ?, ?, ?, ?, ?)"))
+					finalstat, err := tx.Prepare(fmt.Sprintf("insert into FINAL_RESULT (InstanceA, InstanceB, Key, Schema, InconsistentType, Extra, TargetKey) VALUES(?, ?, ?, ?, ?, ?, ?)"))
 					if err != nil {
 						panic(common.Logger.Error(err))
 					}
 					// defer finalstat.Close()
-					_, err = finalstat.Exec("", "", string(oneKeyInfo.Key), strconv.Itoa(int(p.currentDB)),
+					_, err = finalstat.Exec(p.sourceAddress(), p.TargetHosts[oneKeyInfo.Target].Address(),
+						string(oneKeyInfo.Key), strconv.Itoa(int(p.currentDB)),
 						oneKeyInfo.Field[i].ConflictType.String(),
-						string(oneKeyInfo.Field[i].Field))
+						string(oneKeyInfo.Field[i].Field), string(resultTargetKey(oneKeyInfo)))
 					if err != nil {
 						panic(common.Logger.Error(err))
 					}
 
 					finalstat.Close()
 
-					if len(conf.Opts.ResultFile) != 0 {
-						resultfile.WriteString(fmt.Sprintf("%d\t%s\t%s\t%s\n", int(p.currentDB), oneKeyInfo.Field[i].ConflictType.String(), string(oneKeyInfo.Key), string(oneKeyInfo.Field[i].Field)))
+					if resultfile != nil {
+						resultfile.WriteString(p.resultLine(oneKeyInfo, oneKeyInfo.Field[i].ConflictType, oneKeyInfo.Field[i].Field))
 					}
 				}
 			}
 		} else {
 			if p.times == p.CompareCount {
-				finalstat, err := tx.Prepare(fmt.Sprintf("insert into FINAL_RESULT (InstanceA, InstanceB, Key, Schema, InconsistentType, Extra) VALUES(?, ?, ?, ?, ?, ?)"))
+				finalstat, err := tx.Prepare(fmt.Sprintf("insert into FINAL_RESULT (InstanceA, InstanceB, Key, Schema, InconsistentType, Extra, TargetKey) VALUES(?, ?, ?, ?, ?, ?, ?)"))
 				if err != nil {
 					panic(common.Logger.Error(err))
 				}
 				// defer finalstat.Close()
-				_, err = finalstat.Exec("", "", string(oneKeyInfo.Key), strconv.Itoa(int(p.currentDB)), oneKeyInfo.ConflictType.String(), "")
+				_, err = finalstat.Exec(p.sourceAddress(), p.TargetHosts[oneKeyInfo.Target].Address(),
+					string(oneKeyInfo.Key), strconv.Itoa(int(p.currentDB)), oneKeyInfo.ConflictType.String(), "",
+					string(resultTargetKey(oneKeyInfo)))
 				if err != nil {
 					panic(common.Logger.Error(err))
 				}
 				finalstat.Close()
 
-				if len(conf.Opts.ResultFile) != 0 {
-					resultfile.WriteString(fmt.Sprintf("%d\t%s\t%s\t%s\n", int(p.currentDB), oneKeyInfo.ConflictType.String(), string(oneKeyInfo.Key), ""))
+				if resultfile != nil {
+					resultfile.WriteString(p.resultLine(oneKeyInfo, oneKeyInfo.ConflictType, nil))
 				}
 			}
 		}
@@ -509,3 +847,32 @@ func (p *FullCheck) WriteConflictKey(conflictKey <-chan *common.Key) {
 	statInsertField.Close()
 	tx.Commit()
 }
+
+// resultLine formats one line of the result file.
+func (p *FullCheck) resultLine(oneKeyInfo *common.Key, conflictType common.ConflictType, field []byte) string {
+	return p.formatResultLine(strconv.Itoa(int(p.currentDB)), conflictType.String(), string(oneKeyInfo.Key),
+		string(field), string(resultTargetKey(oneKeyInfo)), p.TargetHosts[oneKeyInfo.Target].Address())
+}
+
+/*
+ * formatResultLine formats one line of the result file. The target key is appended when the key
+ * names are mapped, then the target when there are several.
+ */
+func (p *FullCheck) formatResultLine(db, conflictType, key, field, targetKey, target string) string {
+	line := fmt.Sprintf("%s\t%s\t%s\t%s", db, conflictType, key, field)
+	if p.KeyMapper != nil || p.TargetDBPrefix != "" {
+		line += "\t" + targetKey
+	}
+	if len(p.TargetHosts) > 1 {
+		line += "\t" + target
+	}
+	return line + "\n"
+}
+
+// resultTargetKey returns the key name on the target side.
+func resultTargetKey(oneKeyInfo *common.Key) []byte {
+	if oneKeyInfo.TargetKey != nil {
+		return oneKeyInfo.TargetKey
+	}
+	return oneKeyInfo.Key
+}
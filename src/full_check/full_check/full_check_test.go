@@ -3,6 +3,7 @@ package full_check
 import (
 	"database/sql"
 	"fmt"
+	"full_check/common"
 	"github.com/gomodule/redigo/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -246,8 +247,8 @@ func (suite *RedisFullCheckTestSuite) checkKey(statem *sql.Stmt, key string, inc
 
 	count := 0
 	for rows.Next() {
-		var InstanceA, InstanceB, Key, Schema, InconsistentType, Extra string
-		err := rows.Scan(&InstanceA, &InstanceB, &Key, &Schema, &InconsistentType, &Extra)
+		var InstanceA, InstanceB, Key, Schema, InconsistentType, Extra, TargetKey string
+		err := rows.Scan(&InstanceA, &InstanceB, &Key, &Schema, &InconsistentType, &Extra, &TargetKey)
 		if err != nil {
 			panic(err)
 		}
@@ -256,6 +257,7 @@ func (suite *RedisFullCheckTestSuite) checkKey(statem *sql.Stmt, key string, inc
 		assert.Equal(suite.T(), "0", Schema)
 		assert.Equal(suite.T(), inconsistentType, InconsistentType)
 		assert.Equal(suite.T(), field, Extra)
+		assert.Equal(suite.T(), key, TargetKey)
 
 		count += 1
 		break
@@ -269,5 +271,9 @@ func TestFullCheck(t *testing.T) {
 }
 
 func TestMain(m *testing.M) {
+	var err error
+	if common.Logger, err = common.InitLog("", "error,critical"); err != nil {
+		panic(err)
+	}
 	os.Exit(m.Run())
 }
@@ -0,0 +1,230 @@
+package full_check
+
+import (
+	"bytes"
+	"container/heap"
+	"io/ioutil"
+
+	"full_check/client"
+	"full_check/common"
+)
+
+const (
+	// how the hotness of the keys is measured
+	HotKeyFreq       = "freq"
+	HotKeyIdleTime   = "idletime"
+	HotKeyFilePrefix = "file:"
+)
+
+// LoadHotKeys reads the hot keys from the file, one per line, the empty lines and the ones starting with '#' are skipped.
+func LoadHotKeys(file string) ([][]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0)
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+type hotKey struct {
+	key   *common.Key
+	score int64 // the hotter the higher
+	seq   int64 // keys of the same score keep the order they come in
+}
+
+// hotKeyHeap pops the hottest key first.
+type hotKeyHeap []hotKey
+
+func (h hotKeyHeap) Len() int { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].seq < h[j].seq
+}
+func (h hotKeyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hotKeyHeap) Push(x interface{}) { *h = append(*h, x.(hotKey)) }
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// popBatch pops at most count of the hottest keys.
+func (h *hotKeyHeap) popBatch(count int) []*common.Key {
+	batch := make([]*common.Key, 0, count)
+	for h.Len() != 0 && len(batch) < count {
+		batch = append(batch, heap.Pop(h).(hotKey).key)
+	}
+	return batch
+}
+
+/*
+ * PrioritizeKeys passes the keys to the verifiers hottest first. The keys are buffered up to the
+ * window and ordered by "object freq" under an LFU maxmemory-policy, or "object idletime"
+ * otherwise, the other one is tried when the source doesn't track the given one. The keys listed
+ * in the hot key file are verified before the scan in the first round, and first among the
+ * conflict keys in the following ones. Once the time is up the keys are passed as they come.
+ */
+func (p *FullCheck) PrioritizeKeys(in <-chan []*common.Key, out chan<- []*common.Key) {
+	defer close(out)
+
+	var listed map[string]struct{}
+	subcommand := p.HotKey.Source
+	if len(p.HotKey.Keys) != 0 {
+		listed = make(map[string]struct{}, len(p.HotKey.Keys))
+		for _, key := range p.HotKey.Keys {
+			listed[string(key)] = struct{}{}
+		}
+		subcommand = ""
+	}
+
+	sourceClient, err := client.NewRedisClient(p.SourceHost, p.currentDB)
+	if err != nil {
+		panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+			p.SourceHost, p.currentDB, err))
+	}
+	defer sourceClient.Close()
+
+	if p.listedBeforeScan() {
+		p.sendListedKeys(&sourceClient, out)
+	}
+
+	h := make(hotKeyHeap, 0, p.HotKey.Window)
+	seq := int64(0)
+	for batch := range in {
+		if p.timeUp() {
+			out <- batch
+			continue
+		}
+
+		if p.listedBeforeScan() {
+			// verified before the scan
+			kept, dropped := make([]*common.Key, 0, len(batch)), make([]*common.Key, 0)
+			for _, key := range batch {
+				if _, ok := listed[string(key.Key)]; ok {
+					dropped = append(dropped, key)
+				} else {
+					kept = append(kept, key)
+				}
+			}
+			// counted once verified before the scan, only the checkpoint moves on
+			if p.checkpoint != nil {
+				p.checkpoint.verified(dropped)
+			}
+			batch = kept
+		}
+
+		scores := make([]int64, len(batch))
+		switch {
+		case listed != nil:
+			for i, key := range batch {
+				if _, ok := listed[string(key.Key)]; ok {
+					scores[i] = 1
+				}
+			}
+		case subcommand != "" && len(batch) != 0:
+			if scores, subcommand = p.hotness(&sourceClient, batch, subcommand); subcommand == "" {
+				common.Logger.Warnf("the source tracks neither %s nor %s, keys aren't ordered by hotness",
+					HotKeyFreq, HotKeyIdleTime)
+			}
+		}
+		for i, key := range batch {
+			heap.Push(&h, hotKey{key: key, score: scores[i], seq: seq})
+			seq++
+		}
+
+		for h.Len() >= p.HotKey.Window {
+			out <- h.popBatch(p.BatchCount)
+		}
+	}
+	for h.Len() != 0 {
+		out <- h.popBatch(p.BatchCount)
+	}
+}
+
+/*
+ * hotness scores the keys by "object freq", or by "object idletime" that is negated. It tries the
+ * other subcommand when the given one fails, and returns the subcommand that works, empty if none.
+ */
+func (p *FullCheck) hotness(sourceClient *client.RedisClient, batch []*common.Key,
+	subcommand string) ([]int64, string) {
+	for tries := 0; tries < 2; tries++ {
+		values, err := sourceClient.PipeObjectCommand(batch, subcommand)
+		if err == nil {
+			if subcommand == HotKeyIdleTime {
+				for i := range values {
+					values[i] = -values[i]
+				}
+			}
+			return values, subcommand
+		}
+
+		other := HotKeyIdleTime
+		if subcommand == HotKeyIdleTime {
+			other = HotKeyFreq
+		}
+		common.Logger.Warnf("order keys by object %s failed, try %s instead: %v", subcommand, other, err)
+		subcommand = other
+	}
+	return make([]int64, len(batch)), ""
+}
+
+/*
+ * listedBeforeScan returns true if the listed hot keys are verified before the scan in this round.
+ * The keys sampled by count are only known once the scan ends, the listed ones among them are
+ * verified first then.
+ */
+func (p *FullCheck) listedBeforeScan() bool {
+	return len(p.HotKey.Keys) != 0 && p.times == 1 && (p.sampler == nil || p.sampler.sketch == nil)
+}
+
+// sendListedKeys passes the listed keys that exist on the source before the scan starts.
+func (p *FullCheck) sendListedKeys(sourceClient *client.RedisClient, out chan<- []*common.Key) {
+	sent := 0
+	for start := 0; start < len(p.HotKey.Keys) && !p.timeUp(); start += p.BatchCount {
+		end := start + p.BatchCount
+		if end > len(p.HotKey.Keys) {
+			end = len(p.HotKey.Keys)
+		}
+
+		candidates := make([]*common.Key, 0, end-start)
+		for _, key := range p.HotKey.Keys[start:end] {
+			if !p.passKey(key) || (p.sampler != nil && !p.sampler.pick(p.Sample.Seed, key)) {
+				continue
+			}
+			candidates = append(candidates, &common.Key{
+				Key:          key,
+				TargetKey:    p.targetKeyName(p.currentDB, key),
+				Tp:           common.EndKeyType,
+				ConflictType: common.EndConflict,
+			})
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		exists, err := sourceClient.PipeExistsCommand(candidates)
+		if err != nil {
+			panic(common.Logger.Errorf("check hot keys on source failed: %v", err))
+		}
+		batch := make([]*common.Key, 0, len(candidates))
+		for i, key := range candidates {
+			if exists[i] != 0 {
+				batch = append(batch, key)
+			}
+		}
+		// counted as scanned when the scan comes across them
+		sent += len(batch)
+		out <- batch
+	}
+	common.Logger.Infof("%d of %d hot key(s) exist in db %d and are verified first", sent, len(p.HotKey.Keys),
+		p.currentDB)
+}
@@ -0,0 +1,99 @@
+package full_check
+
+import (
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+	"full_check/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKey(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestHotKey case %d.\n", nr)
+
+		file, err := ioutil.TempFile("", "hotkeys")
+		assert.Nil(t, err, "should be nil")
+		defer os.Remove(file.Name())
+		file.WriteString("# top keys\nuser:1\r\n\nsession:{a}\n")
+		file.Close()
+
+		keys, err := LoadHotKeys(file.Name())
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, [][]byte{[]byte("user:1"), []byte("session:{a}")}, keys, "should be equal")
+
+		_, err = LoadHotKeys(file.Name() + ".missing")
+		assert.NotNil(t, err, "should be not nil")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestHotKey case %d.\n", nr)
+
+		h := make(hotKeyHeap, 0)
+		for i, score := range []int64{3, -10, 7, 3, 0} {
+			heap.Push(&h, hotKey{key: &common.Key{Key: []byte(fmt.Sprintf("k%d", i))}, score: score, seq: int64(i)})
+		}
+		names := func(batch []*common.Key) []string {
+			ret := make([]string, 0, len(batch))
+			for _, key := range batch {
+				ret = append(ret, string(key.Key))
+			}
+			return ret
+		}
+		assert.Equal(t, []string{"k2", "k0", "k3"}, names(h.popBatch(3)), "should be equal")
+		assert.Equal(t, []string{"k4", "k1"}, names(h.popBatch(3)), "should be equal")
+		assert.Equal(t, 0, len(h.popBatch(3)), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestHotKey case %d.\n", nr)
+
+		source := store.New()
+		for _, key := range []string{"a", "b", "c"} {
+			source.Set(0, key, store.NewString([]byte(key)))
+		}
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{
+			SourceHost: client.RedisHost{Addr: []string{"source"}, Store: source, DBType: common.TypeDB},
+			BatchCount: 2,
+			HotKey:     checker.HotKeyParameter{Source: HotKeyFilePrefix + "hotkeys", Keys: [][]byte{[]byte("b")}, Window: 1},
+		}}
+		p.times = 1
+		p.progress = &progressState{}
+		p.checkpoint = newCheckpoint()
+		node := p.checkpoint.node("source", 0, false, 0)
+
+		// the scan counts and registers its batch, the listed key is verified before it
+		scanned := []*common.Key{{Key: []byte("a")}, {Key: []byte("b")}, {Key: []byte("c")}}
+		p.checkpoint.sent(node, scanned, 0, true)
+		p.IncrScanStat(len(scanned))
+		in, out := make(chan []*common.Key, 1), make(chan []*common.Key, 10)
+		in <- scanned
+		close(in)
+		p.PrioritizeKeys(in, out)
+		names := make([]string, 0)
+		for batch := range out {
+			for _, key := range batch {
+				names = append(names, string(key.Key))
+			}
+			p.batchVerified(batch)
+		}
+		assert.Equal(t, []string{"b", "a", "c"}, names, "should be equal")
+
+		// the listed key is counted once
+		assert.Equal(t, int64(3), p.stat.Scan.Total(), "should be equal")
+		assert.Equal(t, int64(3), p.progress.verified, "should be equal")
+		assert.True(t, p.checkpoint.finished(), "should be true")
+		assert.Equal(t, int64(3), node.keys, "should be equal")
+	}
+}
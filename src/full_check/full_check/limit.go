@@ -0,0 +1,68 @@
+package full_check
+
+import (
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+)
+
+// limitSides shares one byte limiter and one throttle by all clients of each limited side.
+func (p *FullCheck) limitSides() {
+	if p.Bandwidth.Source > 0 {
+		p.SourceHost.Bandwidth = common.NewRateLimiter(p.Bandwidth.Source)
+	}
+	p.SourceHost.Throttle = common.NewThrottle(p.Throttle.SourceQps, p.Throttle.SourceConcurrency)
+
+	var bandwidth *common.RateLimiter
+	if p.Bandwidth.Target > 0 {
+		bandwidth = common.NewRateLimiter(p.Bandwidth.Target)
+	}
+	throttle := common.NewThrottle(p.Throttle.TargetQps, p.Throttle.TargetConcurrency)
+	for i := range p.TargetHosts {
+		p.TargetHosts[i].Bandwidth = bandwidth
+		p.TargetHosts[i].Throttle = throttle
+	}
+}
+
+func (p *FullCheck) bandwidthStat() []*metric.BandwidthStat {
+	ret := make([]*metric.BandwidthStat, 0, 2)
+	if limiter := p.SourceHost.Bandwidth; limiter != nil {
+		ret = append(ret, newBandwidthStat(client.RoleSource, limiter))
+	}
+	// no target is given in the export
+	if len(p.TargetHosts) != 0 && p.TargetHosts[0].Bandwidth != nil {
+		limiter := p.TargetHosts[0].Bandwidth
+		ret = append(ret, newBandwidthStat(client.RoleTarget, limiter))
+	}
+	return ret
+}
+
+func newBandwidthStat(role string, limiter *common.RateLimiter) *metric.BandwidthStat {
+	return &metric.BandwidthStat{
+		Role:   role,
+		Limit:  limiter.Rate(),
+		Bytes:  limiter.Total(),
+		WaitMs: limiter.Waited().Milliseconds(),
+	}
+}
+
+func (p *FullCheck) throttleStat() []*metric.ThrottleStat {
+	ret := make([]*metric.ThrottleStat, 0, 2)
+	if throttle := p.SourceHost.Throttle; throttle != nil {
+		ret = append(ret, newThrottleStat(client.RoleSource, throttle))
+	}
+	if len(p.TargetHosts) != 0 && p.TargetHosts[0].Throttle != nil {
+		ret = append(ret, newThrottleStat(client.RoleTarget, p.TargetHosts[0].Throttle))
+	}
+	return ret
+}
+
+func newThrottleStat(role string, throttle *common.Throttle) *metric.ThrottleStat {
+	return &metric.ThrottleStat{
+		Role:        role,
+		Qps:         throttle.Qps(),
+		Concurrency: throttle.Concurrency(),
+		Requests:    throttle.Requests(),
+		WaitMs:      throttle.Waited().Milliseconds(),
+	}
+}
@@ -0,0 +1,296 @@
+package full_check
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+
+	"full_check/common"
+)
+
+const (
+	MergeCommand = "merge"
+
+	SummaryTable = "SUMMARY"
+
+	// category of the summary rows
+	summaryScan  = "scan"
+	summaryKey   = "key"
+	summaryField = "field"
+)
+
+func createSummaryTable(db *sql.DB) error {
+	summarySql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s(
+	Shard        TEXT NOT NULL,
+	Db           INTEGER NOT NULL,
+	Round        INTEGER NOT NULL,
+	Category     TEXT NOT NULL,
+	Type         TEXT NOT NULL,
+	ConflictType TEXT NOT NULL,
+	Count        INTEGER NOT NULL
+	);`, SummaryTable)
+	if _, err := db.Exec(summarySql); err != nil {
+		return fmt.Errorf("exec sql %s failed: %v", summarySql, err)
+	}
+	return nil
+}
+
+func (p *FullCheck) shardName() string {
+	if p.Shard == nil {
+		return ""
+	}
+	return p.Shard.String()
+}
+
+/*
+ * WriteSummary stores the stat of the current db and round into the final result db, so that the
+ * totals of several shards can be added up by "merge".
+ */
+func (p *FullCheck) WriteSummary() {
+	tx, err := p.db[p.CompareCount].Begin()
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	statInsert, err := tx.Prepare(fmt.Sprintf("insert into %s (Shard, Db, Round, Category, Type, ConflictType, Count) values(?,?,?,?,?,?,?)", SummaryTable))
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	defer statInsert.Close()
+
+	insert := func(category, tp, conflictType string, count int64) {
+		if _, err := statInsert.Exec(p.shardName(), p.currentDB, p.times, category, tp, conflictType, count); err != nil {
+			panic(common.Logger.Error(err))
+		}
+	}
+	insert(summaryScan, "", "", p.stat.Scan.Total())
+	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
+		for j := common.ConflictType(0); j < common.EndConflict; j++ {
+			if count := p.stat.ConflictKey[i][j].Total(); count != 0 {
+				insert(summaryKey, i.String(), j.String(), count)
+			}
+			if count := p.stat.ConflictField[i][j].Total(); count != 0 {
+				insert(summaryField, i.String(), j.String(), count)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(common.Logger.Error(err))
+	}
+}
+
+/*
+ * Merge combines the final result dbs of several shards into output: the conflict keys and fields,
+ * FINAL_RESULT and SUMMARY are copied, then the totals are printed. Scanned keys are counted in the
+ * first round and conflicts in the last round of each shard.
+ */
+func Merge(output string, inputs []string) error {
+	os.Remove(output)
+	db, err := sql.Open("sqlite3", output)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// "attach" only applies to one connection
+	db.SetMaxOpenConns(1)
+
+	if err := createMergeTables(db); err != nil {
+		return err
+	}
+	for _, input := range inputs {
+		if _, err := os.Stat(input); err != nil {
+			return err
+		}
+		if err := mergeOne(db, input); err != nil {
+			return fmt.Errorf("merge %v failed: %v", input, err)
+		}
+		common.Logger.Infof("merge %v into %v", input, output)
+	}
+
+	return printMergeSummary(db)
+}
+
+func createMergeTables(db *sql.DB) error {
+	for _, createSql := range []string{`
+CREATE TABLE key(
+   id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+   key            TEXT NOT NULL,
+   target_key     TEXT NOT NULL,
+   type           TEXT NOT NULL,
+   conflict_type  TEXT NOT NULL,
+   db             INTEGER NOT NULL,
+   target         INTEGER NOT NULL,
+   source_len     INTEGER NOT NULL,
+   target_len     INTEGER NOT NULL
+);`, `
+CREATE TABLE field(
+   id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+   field          TEXT NOT NULL,
+   conflict_type  TEXT NOT NULL,
+   key_id         INTEGER NOT NULL
+);`, `
+CREATE TABLE FINAL_RESULT(
+	InstanceA	TEXT NOT NULL,
+	InstanceB	TEXT NOT NULL,
+	Key			TEXT NOT NULL,
+	Schema		TEXT NOT NULL,
+	InconsistentType TEXT NOT NULL,
+	Extra	    TEXT NOT NULL,
+	TargetKey	TEXT NOT NULL
+	);`} {
+		if _, err := db.Exec(createSql); err != nil {
+			return fmt.Errorf("exec sql %s failed: %v", createSql, err)
+		}
+	}
+	return createSummaryTable(db)
+}
+
+func mergeOne(db *sql.DB, input string) error {
+	if _, err := db.Exec("attach database ? as src", input); err != nil {
+		return err
+	}
+	defer db.Exec("detach database src")
+
+	// keep the key ids of the fields valid by shifting them behind the merged ones
+	var offset int64
+	if err := db.QueryRow("select ifnull(max(id), 0) from key").Scan(&offset); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, mergeSql := range []string{
+		"insert into key (id, key, target_key, type, conflict_type, db, target, source_len, target_len) " +
+			"select id+?, key, target_key, type, conflict_type, db, target, source_len, target_len from src.key",
+		"insert into field (field, conflict_type, key_id) select field, conflict_type, key_id+? from src.field",
+	} {
+		if _, err := tx.Exec(mergeSql, offset); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, mergeSql := range []string{
+		"insert into FINAL_RESULT (InstanceA, InstanceB, Key, Schema, InconsistentType, Extra, TargetKey) " +
+			"select InstanceA, InstanceB, Key, Schema, InconsistentType, Extra, TargetKey from src.FINAL_RESULT",
+		fmt.Sprintf("insert into %s select * from src.%s", SummaryTable, SummaryTable),
+	} {
+		if _, err := tx.Exec(mergeSql); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func printMergeSummary(db *sql.DB) error {
+	// shards found in the inputs, check that they are complete and not duplicated
+	rows, err := db.Query(fmt.Sprintf(`select Shard, max(Times) from
+(select Shard, count(*) as Times from %s where Category=? group by Shard, Db, Round) group by Shard`,
+		SummaryTable), summaryScan)
+	if err != nil {
+		return err
+	}
+	shards := make([]string, 0)
+	for rows.Next() {
+		var shard string
+		var times int
+		if err := rows.Scan(&shard, &times); err != nil {
+			rows.Close()
+			return err
+		}
+		if times > 1 {
+			common.Logger.Warnf("shard[%v] is merged %d times, totals count its keys repeatedly", shard, times)
+		}
+		shards = append(shards, shard)
+	}
+	rows.Close()
+	for _, warning := range checkShards(shards) {
+		common.Logger.Warn(warning)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "shards:%v\n", shards)
+
+	var scanned int64
+	if err := db.QueryRow(fmt.Sprintf("select ifnull(sum(Count), 0) from %s where Category=? and Round=1",
+		SummaryTable), summaryScan).Scan(&scanned); err != nil {
+		return err
+	}
+	fmt.Fprintf(&buf, "KeyScan:%d\n", scanned)
+
+	// conflicts of the last round of every shard
+	lastRound := fmt.Sprintf(`select s.Category, s.Type, s.ConflictType, sum(s.Count) from %s s
+join (select Shard, max(Round) as Round from %s group by Shard) l on s.Shard=l.Shard and s.Round=l.Round
+where s.Category!=? group by s.Category, s.Type, s.ConflictType order by s.Category desc, s.Type, s.ConflictType`,
+		SummaryTable, SummaryTable)
+	rows, err = db.Query(lastRound, summaryScan)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var totalKeyConflict, totalFieldConflict int64
+	for rows.Next() {
+		var category, tp, conflictType string
+		var count int64
+		if err := rows.Scan(&category, &tp, &conflictType, &count); err != nil {
+			return err
+		}
+
+		conType := common.NewConflictType(conflictType)
+		prefix := "Equal"
+		if conType == common.SkippedConflict {
+			prefix = "Skipped"
+		} else if conType < common.NoneConflict {
+			prefix = "Conflict"
+			if category == summaryKey {
+				totalKeyConflict += count
+			} else {
+				totalFieldConflict += count
+			}
+		}
+		if category == summaryKey {
+			fmt.Fprintf(&buf, "Key%sAtLast|%s|%s|%d\n", prefix, tp, conflictType, count)
+		} else {
+			fmt.Fprintf(&buf, "Field%sAtLast|%s|%s|%d\n", prefix, tp, conflictType, count)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	common.Logger.Infof("merged stat:\n%s", buf.String())
+	common.Logger.Infof("--------------- merged! ----------------\ntotally %d key(s) and %d field(s) conflict",
+		totalKeyConflict, totalFieldConflict)
+	return nil
+}
+
+// checkShards returns the warnings when the merged shards don't cover the whole keyspace exactly once.
+func checkShards(shards []string) []string {
+	sort.Strings(shards)
+	var count int
+	seen := make(map[int]bool)
+	for _, name := range shards {
+		var index, n int
+		if _, err := fmt.Sscanf(name, "%d/%d", &index, &n); err != nil {
+			return []string{"result db of an unsharded run is merged, totals may count keys twice"}
+		}
+		if count != 0 && n != count {
+			return []string{fmt.Sprintf("shards %v are split into different numbers, totals may be wrong", shards)}
+		}
+		count = n
+		seen[index] = true
+	}
+	warnings := make([]string, 0)
+	for i := 0; i < count; i++ {
+		if !seen[i] {
+			warnings = append(warnings, fmt.Sprintf("shard %d/%d isn't merged, totals only cover part of the "+
+				"keyspace", i, count))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,84 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestMerge case %d.\n", nr)
+
+		assert.Equal(t, 0, len(checkShards([]string{"1/2", "0/2"})), "should be equal")
+		assert.Equal(t, []string{"shard 1/3 isn't merged, totals only cover part of the keyspace"},
+			checkShards([]string{"0/3", "2/3"}), "should be equal")
+		assert.Equal(t, 1, len(checkShards([]string{"0/2", "1/3"})), "should be equal")
+		assert.Equal(t, 1, len(checkShards([]string{"0/2", ""})), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestMerge case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "merge")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		// each shard has one conflict key with one field
+		inputs := make([]string, 0)
+		for i, name := range []string{"0/2", "1/2"} {
+			input := filepath.Join(dir, fmt.Sprintf("result.db.%d", i))
+			db, err := sql.Open("sqlite3", input)
+			assert.Nil(t, err, "should be nil")
+			assert.Nil(t, createMergeTables(db), "should be nil")
+			for _, insertSql := range []string{
+				"insert into key (key, target_key, type, conflict_type, db, target, source_len, target_len) " +
+					"values('k" + name + "', '', 'hash', 'value', 0, 0, 2, 1)",
+				"insert into field (field, conflict_type, key_id) values('f', 'lack_target', 1)",
+				"insert into FINAL_RESULT values('s', 't', 'k" + name + "', '0', 'lack_target', 'f', 'k" + name + "')",
+				"insert into SUMMARY values('" + name + "', 0, 1, 'scan', '', '', 10)",
+				"insert into SUMMARY values('" + name + "', 0, 1, 'key', 'hash', 'value', 1)",
+			} {
+				_, err := db.Exec(insertSql)
+				assert.Nil(t, err, "should be nil: %v", insertSql)
+			}
+			db.Close()
+			inputs = append(inputs, input)
+		}
+
+		output := filepath.Join(dir, "merged.db")
+		assert.Nil(t, Merge(output, inputs), "should be nil")
+		db, err := sql.Open("sqlite3", output)
+		assert.Nil(t, err, "should be nil")
+		defer db.Close()
+
+		var count int
+		assert.Nil(t, db.QueryRow("select count(*) from FINAL_RESULT").Scan(&count), "should be nil")
+		assert.Equal(t, 2, count, "should be equal")
+		assert.Nil(t, db.QueryRow("select sum(Count) from SUMMARY where Category='scan'").Scan(&count),
+			"should be nil")
+		assert.Equal(t, 20, count, "should be equal")
+
+		// the fields still point to their keys
+		rows, err := db.Query("select k.key from field f join key k on f.key_id=k.id order by k.key")
+		assert.Nil(t, err, "should be nil")
+		keys := make([]string, 0)
+		for rows.Next() {
+			var key string
+			assert.Nil(t, rows.Scan(&key), "should be nil")
+			keys = append(keys, key)
+		}
+		rows.Close()
+		assert.Equal(t, []string{"k0/2", "k1/2"}, keys, "should be equal")
+
+		// a missing input fails the merge
+		assert.NotNil(t, Merge(output, []string{filepath.Join(dir, "missing")}), "should be not nil")
+	}
+}
@@ -0,0 +1,248 @@
+package full_check
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"full_check/client"
+	"full_check/common"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jinzhu/copier"
+)
+
+const MerkleTable = "MERKLE"
+
+// merkleState is the result of the merkle comparison of the current db.
+type merkleState struct {
+	source *common.MerkleTree
+	diff   map[uint32]struct{} // leaves that differ with any target
+}
+
+// pass returns true if the key is in a differing bucket and needs the key-level comparison.
+func (m *merkleState) pass(key []byte) bool {
+	_, ok := m.diff[m.source.Bucket(key)]
+	return ok
+}
+
+/*
+ * CompareMerkle builds the merkle trees of the source and every target over the keys and value
+ * digests of the current db, and keeps the buckets that differ. Only the keys in these buckets
+ * are compared by the verifiers afterwards. The digests of every level are stored in the final
+ * result db, and compared with the ones of a previous run if given.
+ */
+func (p *FullCheck) CompareMerkle() {
+	source := p.buildMerkleTree(p.SourceHost, p.currentDB)
+	targets := make([]*common.MerkleTree, len(p.TargetHosts))
+	for i, targetHost := range p.TargetHosts {
+		targets[i] = p.buildMerkleTree(targetHost, p.targetDB(p.currentDB))
+	}
+
+	p.merkle = p.diffMerkle(source, targets)
+	p.WriteMerkle(source, targets)
+	if len(p.Merkle.BaseFile) != 0 {
+		p.compareMerkleBase(source, targets)
+	}
+}
+
+// diffMerkle keeps the leaves of the source that differ with any target.
+func (p *FullCheck) diffMerkle(source *common.MerkleTree, targets []*common.MerkleTree) *merkleState {
+	diff := make(map[uint32]struct{})
+	for i, target := range targets {
+		for bucket := range source.Diff(target) {
+			diff[bucket] = struct{}{}
+		}
+		common.Logger.Infof("merkle db[%d] target[%v]: %v", p.currentDB, p.TargetHosts[i].Address(),
+			p.merkleDiffString(source, target))
+	}
+
+	var diffKeys int64
+	leaves := source.Level(common.MerkleLeafLevel)
+	for bucket := range diff {
+		if node, ok := leaves[bucket]; ok {
+			diffKeys += node.Keys
+		}
+	}
+	common.Logger.Infof("merkle db[%d]: %d bucket(s) with %d source key(s) differ, compare them by key",
+		p.currentDB, len(diff), diffKeys)
+	return &merkleState{
+		source: source,
+		diff:   diff,
+	}
+}
+
+// merkleDiffString describes how many nodes differ at each level.
+func (p *FullCheck) merkleDiffString(a, b *common.MerkleTree) string {
+	return fmt.Sprintf("differ root:%d, slot:%d, bucket:%d",
+		len(common.DiffLevel(a.Level(common.MerkleRootLevel), b.Level(common.MerkleRootLevel))),
+		len(common.DiffLevel(a.Level(common.MerkleSlotLevel), b.Level(common.MerkleSlotLevel))),
+		len(common.DiffLevel(a.Level(common.MerkleLeafLevel), b.Level(common.MerkleLeafLevel))))
+}
+
+// buildMerkleTree scans every node of the host concurrently and adds the digests of its keys.
+func (p *FullCheck) buildMerkleTree(host client.RedisHost, db int32) *common.MerkleTree {
+	tree := common.NewMerkleTree(p.Merkle.SubBuckets)
+
+	var wg sync.WaitGroup
+	wg.Add(len(host.Addr))
+	for _, addr := range host.Addr {
+		go func(addr string) {
+			defer wg.Done()
+
+			var singleHost client.RedisHost
+			copier.Copy(&singleHost, &host)
+			singleHost.Addr = []string{addr}
+			singleHost.DBType = common.TypeDB
+			nodeClient, err := client.NewRedisClient(singleHost, db)
+			if err != nil {
+				panic(common.Logger.Errorf("create redis client with host[%v] db[%v] error[%v]",
+					singleHost, db, err))
+			}
+			defer nodeClient.Close()
+
+			scanOptions := p.scanOptions(false)
+			for cursor := 0; ; {
+				reply, err := redis.Values(nodeClient.Do("scan", append([]interface{}{cursor, "count", p.BatchCount},
+					scanOptions...)...))
+				if err == nil && len(reply) != 2 {
+					err = fmt.Errorf("invalid reply length[%v]", len(reply))
+				}
+				if err != nil {
+					panic(common.Logger.Errorf("scan %d of %v failed[%v]", cursor, nodeClient, err))
+				}
+				if cursor, err = redis.Int(reply[0], nil); err != nil {
+					panic(common.Logger.Errorf("scan %d of %v failed[%v]", cursor, nodeClient, err))
+				}
+				keys, err := redis.ByteSlices(reply[1], nil)
+				if err != nil {
+					panic(common.Logger.Errorf("scan %d of %v failed[%v]", cursor, nodeClient, err))
+				}
+
+				keyInfo := make([]*common.Key, 0, len(keys))
+				for _, key := range keys {
+					if p.passKey(key) {
+						keyInfo = append(keyInfo, &common.Key{Key: key})
+					}
+				}
+				if len(keyInfo) != 0 {
+					digests, err := nodeClient.PipeDigestCommand(keyInfo, p.Merkle.Digest)
+					if err != nil {
+						panic(common.Logger.Critical(err))
+					}
+					for i, digest := range digests {
+						tree.Add(keyInfo[i].Key, digest)
+					}
+				}
+
+				if cursor == 0 {
+					break
+				}
+			}
+		}(addr)
+	}
+	wg.Wait()
+	return tree
+}
+
+func createMerkleTable(db *sql.DB) error {
+	merkleSql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s(
+	Side   TEXT NOT NULL,
+	Db     INTEGER NOT NULL,
+	Level  INTEGER NOT NULL,
+	Node   INTEGER NOT NULL,
+	Digest TEXT NOT NULL,
+	Keys   INTEGER NOT NULL
+	);`, MerkleTable)
+	if _, err := db.Exec(merkleSql); err != nil {
+		return fmt.Errorf("exec sql %s failed: %v", merkleSql, err)
+	}
+	return nil
+}
+
+// merkleSide names the side of a tree in the merkle table: "source", "target" or "target1", "target2"...
+func (p *FullCheck) merkleSide(target int) string {
+	if target < 0 {
+		return client.RoleSource
+	}
+	if len(p.TargetHosts) == 1 {
+		return client.RoleTarget
+	}
+	return fmt.Sprintf("%s%d", client.RoleTarget, target)
+}
+
+// WriteMerkle stores the nodes of every level into the final result db.
+func (p *FullCheck) WriteMerkle(source *common.MerkleTree, targets []*common.MerkleTree) {
+	tx, err := p.db[p.CompareCount].Begin()
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	statInsert, err := tx.Prepare(fmt.Sprintf("insert into %s (Side, Db, Level, Node, Digest, Keys) values(?,?,?,?,?,?)", MerkleTable))
+	if err != nil {
+		panic(common.Logger.Error(err))
+	}
+	defer statInsert.Close()
+
+	for i, tree := range append([]*common.MerkleTree{source}, targets...) {
+		for level := common.MerkleRootLevel; level <= common.MerkleLeafLevel; level++ {
+			for index, node := range tree.Level(level) {
+				if _, err := statInsert.Exec(p.merkleSide(i-1), p.currentDB, level, index,
+					hex.EncodeToString(node.Digest[:]), node.Keys); err != nil {
+					panic(common.Logger.Error(err))
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(common.Logger.Error(err))
+	}
+}
+
+/*
+ * compareMerkleBase logs how many nodes of each side changed since the run stored in the base file,
+ * and returns them per side(the source first) and per level. It returns nil if the base can't be read.
+ */
+func (p *FullCheck) compareMerkleBase(source *common.MerkleTree, targets []*common.MerkleTree) [][]int {
+	base, err := sql.Open("sqlite3", p.Merkle.BaseFile)
+	if err != nil {
+		panic(common.Logger.Errorf("open merkle base %v failed[%v]", p.Merkle.BaseFile, err))
+	}
+	defer base.Close()
+
+	query := fmt.Sprintf("select Node, Digest, Keys from %s where Side=? and Db=? and Level=?", MerkleTable)
+	ret := make([][]int, 0, len(targets)+1)
+	for i, tree := range append([]*common.MerkleTree{source}, targets...) {
+		side := p.merkleSide(i - 1)
+		changed := make([]int, 0, common.MerkleLeafLevel+1)
+		for level := common.MerkleRootLevel; level <= common.MerkleLeafLevel; level++ {
+			rows, err := base.Query(query, side, p.currentDB, level)
+			if err != nil {
+				common.Logger.Warnf("read merkle base %v failed[%v]", p.Merkle.BaseFile, err)
+				return nil
+			}
+			nodes := make(map[uint32]*common.MerkleNode)
+			for rows.Next() {
+				var index uint32
+				var digest string
+				node := new(common.MerkleNode)
+				if err := rows.Scan(&index, &digest, &node.Keys); err != nil {
+					rows.Close()
+					panic(common.Logger.Errorf("read merkle base %v failed[%v]", p.Merkle.BaseFile, err))
+				}
+				if raw, err := hex.DecodeString(digest); err == nil {
+					copy(node.Digest[:], raw)
+				}
+				nodes[index] = node
+			}
+			rows.Close()
+			changed = append(changed, len(common.DiffLevel(tree.Level(level), nodes)))
+		}
+		common.Logger.Infof("merkle db[%d] %s changed since %v: root:%d, slot:%d, bucket:%d", p.currentDB, side,
+			p.Merkle.BaseFile, changed[common.MerkleRootLevel], changed[common.MerkleSlotLevel],
+			changed[common.MerkleLeafLevel])
+		ret = append(ret, changed)
+	}
+	return ret
+}
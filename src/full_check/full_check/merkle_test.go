@@ -0,0 +1,111 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestMerkleTree builds a tree over the keys, the value is used as the digest.
+func newTestMerkleTree(keys map[string]string) *common.MerkleTree {
+	tree := common.NewMerkleTree(16)
+	for key, value := range keys {
+		tree.Add([]byte(key), []byte(value))
+	}
+	return tree
+}
+
+func TestMerkle(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestMerkle case %d.\n", nr)
+
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{
+			TargetHosts: []client.RedisHost{{Addr: []string{"t1"}}, {Addr: []string{"t2"}}},
+		}}
+		source := newTestMerkleTree(map[string]string{"same": "v", "changed": "v", "lack": "v"})
+		targets := []*common.MerkleTree{
+			newTestMerkleTree(map[string]string{"same": "v", "changed": "w", "lack": "v"}),
+			newTestMerkleTree(map[string]string{"same": "v", "changed": "v", "extra": "v"}),
+		}
+		state := p.diffMerkle(source, targets)
+
+		// the keys of the buckets differing with any target are compared
+		assert.True(t, state.pass([]byte("changed")), "should be true")
+		assert.True(t, state.pass([]byte("lack")), "should be true")
+		assert.True(t, state.pass([]byte("extra")), "should be true")
+		if source.Bucket([]byte("same")) != source.Bucket([]byte("changed")) &&
+			source.Bucket([]byte("same")) != source.Bucket([]byte("lack")) &&
+			source.Bucket([]byte("same")) != source.Bucket([]byte("extra")) {
+			assert.False(t, state.pass([]byte("same")), "should be false")
+		}
+	}
+
+	{
+		nr++
+		fmt.Printf("TestMerkle case %d.\n", nr)
+
+		// identical trees have no differing bucket
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{
+			TargetHosts: []client.RedisHost{{Addr: []string{"t"}}},
+		}}
+		keys := map[string]string{"a": "1", "b": "2"}
+		state := p.diffMerkle(newTestMerkleTree(keys), []*common.MerkleTree{newTestMerkleTree(keys)})
+		assert.Equal(t, 0, len(state.diff), "should be equal")
+		assert.False(t, state.pass([]byte("a")), "should be false")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestMerkle case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "merkle")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		// the trees of a previous run are stored in its final result db
+		base := filepath.Join(dir, "result.db.1")
+		db, err := sql.Open("sqlite3", base)
+		assert.Nil(t, err, "should be nil")
+		assert.Nil(t, createMerkleTable(db), "should be nil")
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{
+			TargetHosts: []client.RedisHost{{Addr: []string{"t"}}},
+		}}
+		p.db[p.CompareCount] = db
+		keys := map[string]string{"a": "1", "b": "2"}
+		p.WriteMerkle(newTestMerkleTree(keys), []*common.MerkleTree{newTestMerkleTree(keys)})
+		db.Close()
+
+		// only the target changed since then, one key in one slot
+		p.Merkle.BaseFile = base
+		changed := p.compareMerkleBase(newTestMerkleTree(keys),
+			[]*common.MerkleTree{newTestMerkleTree(map[string]string{"a": "1", "b": "3"})})
+		assert.Equal(t, [][]int{{0, 0, 0}, {1, 1, 1}}, changed, "should be equal")
+
+		// another db isn't in the base, all of its nodes are new
+		p.currentDB = 1
+		changed = p.compareMerkleBase(newTestMerkleTree(map[string]string{"a": "1"}),
+			[]*common.MerkleTree{newTestMerkleTree(nil)})
+		assert.Equal(t, [][]int{{1, 1, 1}, {0, 0, 0}}, changed, "should be equal")
+
+		// a base without the merkle table can't be compared
+		other := filepath.Join(dir, "other.db")
+		db, err = sql.Open("sqlite3", other)
+		assert.Nil(t, err, "should be nil")
+		_, err = db.Exec("create table t (a int)")
+		assert.Nil(t, err, "should be nil")
+		db.Close()
+		p.Merkle.BaseFile = other
+		assert.Nil(t, p.compareMerkleBase(newTestMerkleTree(keys), []*common.MerkleTree{newTestMerkleTree(keys)}),
+			"should be nil")
+	}
+}
@@ -0,0 +1,148 @@
+package full_check
+
+import (
+	"fmt"
+	"strings"
+
+	"full_check/checker"
+	"full_check/common"
+)
+
+var liveDBTypes = []int{common.TypeDB, common.TypeCluster}
+
+/*
+ * modeRule restricts a mode: the modes it should be used with, the modes it can't be used with and
+ * the db types of the source and the targets it supports, nil means any.
+ */
+type modeRule struct {
+	mode        string
+	requires    []string
+	excludes    []string
+	sourceTypes []int
+	targetTypes []int
+}
+
+var modeRules = []modeRule{
+	{mode: "samplemethod " + SampleRandomKey, sourceTypes: liveDBTypes},
+	{
+		mode:        "merkle",
+		excludes:    []string{"keymap", "targetdbprefix", "sampling"},
+		sourceTypes: liveDBTypes,
+		targetTypes: liveDBTypes,
+	},
+	{mode: "daemon", excludes: []string{"merkle", "sampling"}},
+	{mode: "daemonsource " + DaemonNotify, sourceTypes: liveDBTypes},
+	{mode: "psync", excludes: []string{"merkle", "daemon"}, sourceTypes: liveDBTypes},
+	{mode: "psyncfollow", requires: []string{"psync"}},
+	{mode: "export", excludes: []string{"sourcefile", "merkle", "daemon", "psync", "sampling"}},
+	{mode: "exportdigest", requires: []string{"export"}},
+	{mode: "sourcefile", excludes: []string{"merkle", "daemon", "psync", "sampling"}},
+	{mode: "fixture", excludes: []string{"merkle", "daemon", "psync", "sampling", "export", "sourcefile"}},
+	{mode: "fixtureextrakeys", requires: []string{"fixture"}, excludes: []string{"keymap", "targetdbprefix"}},
+	{mode: "adaptive", excludes: []string{"psync", "sourcefile", "fixture"}},
+	{mode: "sourcebyterate", excludes: []string{"psync", "sourcefile", "fixture"}},
+	{
+		mode:     "sourcereplica",
+		excludes: []string{"daemon", "psync", "sourcefile", "fixture"},
+		// a proxy doesn't report the replicas of the nodes behind it
+		sourceTypes: []int{common.TypeDB},
+	},
+	{mode: "roundwait", excludes: []string{"daemon", "export"}},
+	{
+		mode:     "roundwait " + RoundWaitReplica,
+		excludes: []string{"psync", "sourcefile", "fixture"},
+		// a proxy doesn't report the replication offset of the nodes behind it
+		sourceTypes: []int{common.TypeDB},
+		targetTypes: []int{common.TypeDB},
+	},
+	{
+		mode: "maxduration",
+		excludes: []string{"daemon", "export", "psync", "sourcefile", "fixture", "samplecount",
+			"samplemethod " + SampleRandomKey},
+	},
+	{
+		mode: "resume",
+		excludes: []string{"daemon", "export", "psync", "sourcefile", "fixture", "samplecount",
+			"samplemethod " + SampleRandomKey},
+	},
+	{mode: "hotkeys", excludes: []string{"daemon", "export", "psync", "sourcefile", "fixture"}},
+	{mode: "confirm", excludes: []string{"export", "sourcefile", "fixture"}},
+}
+
+// enabledModes returns the modes of the comparison that are enabled.
+func enabledModes(p *checker.FullCheckParameter) map[string]bool {
+	return map[string]bool{
+		"keymap":                          p.KeyMapper != nil,
+		"targetdbprefix":                  p.TargetDBPrefix != "",
+		"sampling":                        p.Sample.Enabled(),
+		"samplecount":                     p.Sample.Count > 0,
+		"samplemethod " + SampleRandomKey: p.Sample.Enabled() && p.Sample.Method == SampleRandomKey,
+		"merkle":                          p.Merkle.Enable,
+		"daemon":                          p.Daemon.Enable,
+		"daemonsource " + DaemonNotify:    p.Daemon.Enable && p.Daemon.Source == DaemonNotify,
+		"psync":                           p.Psync.Enable,
+		"psyncfollow":                     p.Psync.Follow,
+		"export":                          p.Export.File != "",
+		"exportdigest":                    p.Export.Digest,
+		"sourcefile":                      p.SourceFile.File != "",
+		"fixture":                         p.Fixture.File != "",
+		"fixtureextrakeys":                p.Fixture.ExtraKeys,
+		"adaptive":                        p.Adaptive.Enabled(),
+		"sourcebyterate":                  p.Bandwidth.Source > 0,
+		"sourcereplica":                   p.SourceReplica.Enable,
+		"roundwait":                       p.RoundWait.Source != "",
+		"roundwait " + RoundWaitReplica:   p.RoundWait.Source == RoundWaitReplica,
+		"maxduration":                     p.TimeBox.MaxSeconds > 0,
+		"resume":                          p.TimeBox.Resume,
+		"hotkeys":                         p.HotKey.Source != "",
+		"confirm":                         p.Confirm.Enable,
+	}
+}
+
+// CheckModes returns an error describing the first rule broken by the enabled modes.
+func CheckModes(p *checker.FullCheckParameter) error {
+	enabled := enabledModes(p)
+	for _, rule := range modeRules {
+		if !enabled[rule.mode] {
+			continue
+		}
+		for _, mode := range rule.requires {
+			if !enabled[mode] {
+				return fmt.Errorf("%s should be used with %s", rule.mode, mode)
+			}
+		}
+		for _, mode := range rule.excludes {
+			if enabled[mode] {
+				return fmt.Errorf("%s can't be used with %s", rule.mode, mode)
+			}
+		}
+		if rule.sourceTypes != nil && !supportsDBType(rule.sourceTypes, p.SourceHost.DBType) {
+			return fmt.Errorf("%s doesn't support source db type %d, expect %s", rule.mode,
+				p.SourceHost.DBType, dbTypesString(rule.sourceTypes))
+		}
+		for _, host := range p.TargetHosts {
+			if rule.targetTypes != nil && !supportsDBType(rule.targetTypes, host.DBType) {
+				return fmt.Errorf("%s doesn't support target db type %d, expect %s", rule.mode, host.DBType,
+					dbTypesString(rule.targetTypes))
+			}
+		}
+	}
+	return nil
+}
+
+func supportsDBType(types []int, dbType int) bool {
+	for _, tp := range types {
+		if tp == dbType {
+			return true
+		}
+	}
+	return false
+}
+
+func dbTypesString(types []int) string {
+	ret := make([]string, len(types))
+	for i, tp := range types {
+		ret[i] = fmt.Sprint(tp)
+	}
+	return strings.Join(ret, "/")
+}
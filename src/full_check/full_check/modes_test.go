@@ -0,0 +1,104 @@
+package full_check
+
+import (
+	"fmt"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckModes(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestCheckModes case %d.\n", nr)
+
+		// every mode of the rules is known
+		known := enabledModes(&checker.FullCheckParameter{})
+		for _, rule := range modeRules {
+			for _, mode := range append(append([]string{rule.mode}, rule.requires...), rule.excludes...) {
+				_, ok := known[mode]
+				assert.True(t, ok, "unknown mode %s", mode)
+			}
+		}
+	}
+
+	{
+		nr++
+		fmt.Printf("TestCheckModes case %d.\n", nr)
+
+		param := func(f func(p *checker.FullCheckParameter)) *checker.FullCheckParameter {
+			p := &checker.FullCheckParameter{
+				SourceHost:  client.RedisHost{DBType: common.TypeDB},
+				TargetHosts: []client.RedisHost{{DBType: common.TypeDB}},
+			}
+			f(p)
+			return p
+		}
+		tests := []struct {
+			param *checker.FullCheckParameter
+			err   string
+		}{
+			{param(func(p *checker.FullCheckParameter) {}), ""},
+			{param(func(p *checker.FullCheckParameter) { p.Psync.Follow = true }), "psyncfollow should be used with psync"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.Export.File = "a"
+				p.SourceFile.File = "b"
+			}), "export can't be used with sourcefile"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.Merkle.Enable = true
+				p.SourceHost.DBType = common.TypeCluster
+				p.TargetHosts[0].DBType = common.TypeCluster
+			}), ""},
+			{param(func(p *checker.FullCheckParameter) {
+				p.Merkle.Enable = true
+				p.TargetHosts = append(p.TargetHosts, client.RedisHost{DBType: common.TypeAliyunProxy})
+			}), "merkle doesn't support target db type 2, expect 0/1"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.Merkle.Enable = true
+				p.TargetDBPrefix = "db{db}:"
+			}), "merkle can't be used with targetdbprefix"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.SourceReplica.Enable = true
+				p.SourceHost.DBType = common.TypeCluster
+			}), "sourcereplica doesn't support source db type 1, expect 0"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.SourceReplica.Enable = true
+				p.SourceHost.DBType = common.TypeAliyunProxy
+			}), "sourcereplica doesn't support source db type 2, expect 0"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.TimeBox.MaxSeconds = 10
+				p.Sample.Rate = 0.1
+				p.Sample.Method = SampleHash
+			}), ""},
+			{param(func(p *checker.FullCheckParameter) {
+				p.TimeBox.Resume = true
+				p.Sample.Count = 10
+			}), "resume can't be used with samplecount"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.RoundWait.Source = RoundWaitReplica
+				p.TargetHosts[0].DBType = common.TypeCluster
+			}), "roundwait replica doesn't support target db type 1, expect 0"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.RoundWait.Source = RoundWaitReplica
+				p.SourceHost.DBType = common.TypeTencentProxy
+			}), "roundwait replica doesn't support source db type 3, expect 0"},
+			{param(func(p *checker.FullCheckParameter) {
+				p.Confirm.Enable = true
+				p.Fixture.File = "a"
+			}), "confirm can't be used with fixture"},
+		}
+		for i, test := range tests {
+			err := CheckModes(test.param)
+			if test.err == "" {
+				assert.Nil(t, err, "should be nil: %d", i)
+			} else if assert.NotNil(t, err, "should not be nil: %d", i) {
+				assert.Equal(t, test.err, err.Error(), "should be equal: %d", i)
+			}
+		}
+	}
+}
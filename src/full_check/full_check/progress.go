@@ -0,0 +1,287 @@
+package full_check
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/metric"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// the speed used by the eta is measured over this long
+	progressWindow = time.Minute
+)
+
+/*
+ * progressState is the progress of the current round. The work of a round is counted in source
+ * keys: the keys of the source in the first round, the conflict keys of the last round in the
+ * following ones. The keys scanned from the source count once they are verified, so the keys
+ * dropped by the filter, the shard or the sample count as well.
+ */
+type progressState struct {
+	roundKeys int64 // keys of all dbs in the round, 0 means unknown
+	dbKeys    int64 // keys of the current db
+	doneKeys  int64 // keys of the dbs finished in the round
+
+	// of the current db, updated by the scan, the verifiers and the conflict writer
+	scanned   int64 // keys read from the source before the filters
+	verified  int64 // keys passed to the verifiers and verified
+	conflicts int64 // conflict keys written
+
+	// of the dbs finished in the round
+	doneVerified  int64
+	doneConflicts int64
+
+	lock    sync.Mutex
+	samples []progressSample
+}
+
+type progressSample struct {
+	at       time.Time
+	done     int64 // source keys covered
+	verified int64
+}
+
+/*
+ * countSourceKeys fills the keys of the source dbs that "info keyspace" can't tell: "dbsize" of
+ * every scanned node of a cluster, or the keyspace of every node behind a proxy.
+ */
+func (p *FullCheck) countSourceKeys() {
+	switch p.SourceHost.DBType {
+	case common.TypeCluster:
+		total := int64(0)
+		for _, addr := range p.sourcePhysicalDBList {
+			nodeClient, err := client.NewRedisClient(p.sourceNodeHost(addr), 0)
+			if err != nil {
+				common.Logger.Warnf("count keys of source node %v failed, progress is unknown: %v", addr, err)
+				return
+			}
+			keys, err := redis.Int64(nodeClient.Do("dbsize"))
+			nodeClient.Close()
+			if err != nil {
+				common.Logger.Warnf("count keys of source node %v failed, progress is unknown: %v", addr, err)
+				return
+			}
+			total += keys
+		}
+		if _, ok := p.sourceLogicalDBMap[0]; ok {
+			p.sourceLogicalDBMap[0] = total
+		}
+	case common.TypeAliyunProxy, common.TypeTencentProxy:
+		for _, keys := range p.sourceLogicalDBMap {
+			if keys != 0 {
+				// the proxy adds up the nodes
+				return
+			}
+		}
+		sourceClient, err := client.NewRedisClient(p.SourceHost, 0)
+		if err != nil {
+			common.Logger.Warnf("count keys of source failed, progress is unknown: %v", err)
+			return
+		}
+		defer sourceClient.Close()
+
+		totals := make(map[int32]int64)
+		for index, node := range p.sourcePhysicalDBList {
+			var reply interface{}
+			if p.SourceHost.DBType == common.TypeAliyunProxy {
+				reply, err = sourceClient.Do("iinfo", index, "keyspace")
+			} else {
+				reply, err = sourceClient.Do("info", "keyspace", node)
+			}
+			content, err := redis.Bytes(reply, err)
+			if err != nil {
+				common.Logger.Warnf("count keys of source node %v failed, progress is unknown: %v", node, err)
+				return
+			}
+			keyspace, err := common.ParseKeyspace(content)
+			if err != nil {
+				common.Logger.Warnf("count keys of source node %v failed, progress is unknown: %v", node, err)
+				return
+			}
+			for db, keys := range keyspace {
+				totals[db] += keys
+			}
+		}
+		for db := range p.sourceLogicalDBMap {
+			p.sourceLogicalDBMap[db] = totals[db]
+		}
+	}
+}
+
+// startRoundProgress counts the keys of the round, the conflict keys of the last round after the first one.
+func (p *FullCheck) startRoundProgress() {
+	p.progress = &progressState{}
+	if p.times == 1 {
+		for _, keys := range p.sourceLogicalDBMap {
+			p.progress.roundKeys += keys
+		}
+		return
+	}
+
+	conflictKeyTableName, _ := p.GetLastResultTable()
+	query := fmt.Sprintf("select count(*) from %s", conflictKeyTableName)
+	if err := p.db[p.times-1].QueryRow(query).Scan(&p.progress.roundKeys); err != nil {
+		panic(common.Logger.Errorf("exec sql %s failed: %v", query, err))
+	}
+}
+
+// startDBProgress counts the keys of the current db.
+func (p *FullCheck) startDBProgress() {
+	progress := p.progress
+	atomic.StoreInt64(&progress.scanned, 0)
+	atomic.StoreInt64(&progress.verified, 0)
+	atomic.StoreInt64(&progress.conflicts, 0)
+	if p.times == 1 {
+		progress.dbKeys = p.sourceLogicalDBMap[p.currentDB]
+		return
+	}
+
+	conflictKeyTableName, _ := p.GetLastResultTable()
+	query := fmt.Sprintf("select count(*) from %s where db=?", conflictKeyTableName)
+	if err := p.db[p.times-1].QueryRow(query, p.currentDB).Scan(&progress.dbKeys); err != nil {
+		panic(common.Logger.Errorf("exec sql %s failed: %v", query, err))
+	}
+}
+
+// finishDBProgress adds the current db to the finished part of the round.
+func (p *FullCheck) finishDBProgress() {
+	progress := p.progress
+	progress.doneKeys += progress.dbKeys
+	progress.doneVerified += atomic.LoadInt64(&progress.verified)
+	progress.doneConflicts += atomic.LoadInt64(&progress.conflicts)
+}
+
+func (p *FullCheck) incrScanned(n int) {
+	if p.progress != nil {
+		atomic.AddInt64(&p.progress.scanned, int64(n))
+	}
+}
+
+// batchVerified counts the keys verified and moves the checkpoint on.
+func (p *FullCheck) batchVerified(batch []*common.Key) {
+	if p.progress != nil {
+		atomic.AddInt64(&p.progress.verified, int64(len(batch)))
+	}
+	if p.checkpoint != nil {
+		p.checkpoint.verified(batch)
+	}
+}
+
+func (p *FullCheck) incrConflicts(n int) {
+	if p.progress != nil {
+		atomic.AddInt64(&p.progress.conflicts, int64(n))
+	}
+}
+
+/*
+ * dbFraction returns the part of the current db done. The scanned part is discounted by the keys
+ * still waiting for the verifiers. A sample of a fixed count, or drawn by "randomkey", is done
+ * once that many keys are verified.
+ */
+func (p *FullCheck) dbFraction(finished bool) float64 {
+	progress := p.progress
+	if finished {
+		return 1
+	}
+	verified := float64(atomic.LoadInt64(&progress.verified))
+	dbKeys := float64(progress.dbKeys)
+	if dbKeys == 0 {
+		return 0
+	}
+	if p.times != 1 {
+		return math.Min(1, verified/dbKeys)
+	}
+
+	fraction := 0.0
+	if p.sampler != nil {
+		target := float64(p.Sample.Count)
+		if target == 0 {
+			target = p.Sample.Rate * dbKeys
+		}
+		if target = math.Min(target, dbKeys); target > 0 {
+			fraction = verified / target
+		}
+	}
+	if p.sampler == nil || p.Sample.Method != SampleRandomKey {
+		scanned := math.Min(1, float64(atomic.LoadInt64(&progress.scanned))/dbKeys)
+		if emitted := float64(p.stat.Scan.Total()); emitted > 0 {
+			scanned *= math.Min(1, verified/emitted)
+		}
+		fraction = math.Max(fraction, scanned)
+	}
+	return math.Min(1, fraction)
+}
+
+/*
+ * progressStat returns the progress of the round and the eta. The speed is measured over the
+ * progress window. The following rounds are expected to verify as many keys as the conflicts of
+ * this round extrapolated to its end, at the speed of verifying keys, after the interval.
+ */
+func (p *FullCheck) progressStat(fraction float64) *metric.ProgressStat {
+	progress := p.progress
+	now := time.Now()
+	done := progress.doneKeys + int64(fraction*float64(progress.dbKeys))
+	verified := progress.doneVerified + atomic.LoadInt64(&progress.verified)
+
+	progress.lock.Lock()
+	progress.samples = append(progress.samples, progressSample{at: now, done: done, verified: verified})
+	for len(progress.samples) > 2 && now.Sub(progress.samples[1].at) >= progressWindow {
+		progress.samples = progress.samples[1:]
+	}
+	speed, verifySpeed := sampleSpeed(progress.samples)
+	progress.lock.Unlock()
+
+	stat := &metric.ProgressStat{
+		Done:            done,
+		Total:           progress.roundKeys,
+		Speed:           int64(speed),
+		EtaSeconds:      -1,
+		TotalEtaSeconds: -1,
+	}
+	if progress.roundKeys == 0 || speed <= 0 {
+		return stat
+	}
+	stat.EtaSeconds = EstimateSeconds(progress.roundKeys-done, speed)
+
+	rounds := p.CompareCount - p.times
+	if rounds == 0 {
+		stat.TotalEtaSeconds = stat.EtaSeconds
+		return stat
+	}
+	if verifySpeed <= 0 || done == 0 {
+		return stat
+	}
+	conflicts := progress.doneConflicts + atomic.LoadInt64(&progress.conflicts)
+	nextKeys := int64(float64(conflicts) * float64(progress.roundKeys) / float64(done))
+	stat.TotalEtaSeconds = stat.EtaSeconds + int64(rounds)*(int64(p.Interval)+EstimateSeconds(nextKeys, verifySpeed))
+	return stat
+}
+
+// sampleSpeed returns the keys covered and verified per second between the first and the last sample.
+func sampleSpeed(samples []progressSample) (float64, float64) {
+	if len(samples) < 2 {
+		return 0, 0
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	seconds := last.at.Sub(first.at).Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	return float64(last.done-first.done) / seconds, float64(last.verified-first.verified) / seconds
+}
+
+// EstimateSeconds returns the seconds to finish the keys at the speed, rounded up.
+func EstimateSeconds(keys int64, speed float64) int64 {
+	if keys <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(keys) / speed))
+}
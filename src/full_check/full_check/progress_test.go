@@ -0,0 +1,39 @@
+package full_check
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressSpeed(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestProgressSpeed case %d.\n", nr)
+
+		now := time.Now()
+		speed, verifySpeed := sampleSpeed([]progressSample{{at: now, done: 100, verified: 10}})
+		assert.Equal(t, 0.0, speed, "should be equal")
+		assert.Equal(t, 0.0, verifySpeed, "should be equal")
+
+		speed, verifySpeed = sampleSpeed([]progressSample{
+			{at: now, done: 100, verified: 10},
+			{at: now.Add(2 * time.Second), done: 300, verified: 20},
+			{at: now.Add(4 * time.Second), done: 500, verified: 50},
+		})
+		assert.Equal(t, 100.0, speed, "should be equal")
+		assert.Equal(t, 10.0, verifySpeed, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestProgressSpeed case %d.\n", nr)
+
+		assert.Equal(t, int64(0), EstimateSeconds(0, 100), "should be equal")
+		assert.Equal(t, int64(10), EstimateSeconds(1000, 100), "should be equal")
+		assert.Equal(t, int64(11), EstimateSeconds(1001, 100), "should be equal")
+	}
+}
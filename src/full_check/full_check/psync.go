@@ -0,0 +1,105 @@
+package full_check
+
+import (
+	"sync"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/replica"
+	"full_check/store"
+)
+
+/*
+ * SyncSource loads the snapshot of every source node by psync into one store and reads the source
+ * from the store afterwards. The keys of a cluster are spread over the nodes without overlap, so the
+ * store is read like a single db. The flushes of the command stream of a node only remove the keys
+ * of the slots it serves when the snapshot is taken.
+ */
+func (p *FullCheck) SyncSource() {
+	st := store.New()
+	p.replicas = make([]*replica.Replica, len(p.SourceHost.Addr))
+	var owners []func(key []byte) bool
+	if p.Psync.Follow && p.SourceHost.IsCluster() {
+		owners = p.nodeOwners()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(p.SourceHost.Addr))
+	for i, addr := range p.SourceHost.Addr {
+		p.replicas[i] = replica.NewReplica(addr, p.SourceHost.Password, p.SourceHost.Authtype, st, p.Psync.Follow)
+		if owners != nil {
+			p.replicas[i].Own(owners[i])
+		}
+		go func(r *replica.Replica) {
+			defer wg.Done()
+			keys, err := r.Sync()
+			if err != nil {
+				panic(common.Logger.Errorf("%v: psync failed: %v", r, err))
+			}
+			common.Logger.Infof("%v: snapshot loaded with %d keys", r, keys)
+		}(p.replicas[i])
+	}
+	wg.Wait()
+
+	p.SourceHost.Store = st
+	p.SourceHost.DBType = common.TypeDB
+	if p.Psync.Follow {
+		common.Logger.Infof("psync: follow the command stream of %d source node(s)", len(p.replicas))
+	}
+}
+
+// nodeOwners returns for each source node whether a key is in the slots it serves.
+func (p *FullCheck) nodeOwners() []func(key []byte) bool {
+	nodeClient, err := client.NewRedisClient(p.sourceNodeHost(p.SourceHost.Addr[0]), 0)
+	if err != nil {
+		panic(common.Logger.Errorf("fetch slots of source cluster failed: %v", err))
+	}
+	defer nodeClient.Close()
+	_, ranges, err := nodeClient.FetchClusterSlots()
+	if err != nil {
+		panic(common.Logger.Errorf("fetch slots of source cluster failed: %v", err))
+	}
+	return slotOwners(p.SourceHost.Addr, ranges)
+}
+
+// slotOwners returns for each node whether a key is in the slot ranges it serves.
+func slotOwners(nodes []string, ranges []common.SlotRange) []func(key []byte) bool {
+	owners := make([]func(key []byte) bool, len(nodes))
+	for i, node := range nodes {
+		var slots [common.ClusterSlots]bool
+		for _, r := range ranges {
+			for _, rangeNode := range r.Nodes {
+				if rangeNode != node {
+					continue
+				}
+				for slot := r.First; slot <= r.Last; slot++ {
+					slots[slot] = true
+				}
+			}
+		}
+		owners[i] = func(key []byte) bool {
+			return slots[common.KeySlot(key)]
+		}
+	}
+	return owners
+}
+
+// PrintPsyncStat logs how many commands were applied and how many keys aren't tracked any more.
+func (p *FullCheck) PrintPsyncStat() {
+	if !p.Psync.Follow {
+		return
+	}
+	var applied, failed int64
+	for _, r := range p.replicas {
+		a, f := r.Applied()
+		applied, failed = applied+a, failed+f
+	}
+	common.Logger.Infof("psync: %d command(s) applied, %d unknown or malformed, %d key(s) untracked",
+		applied, failed, p.SourceHost.Store.Dirty())
+}
+
+func (p *FullCheck) closeReplicas() {
+	for _, r := range p.replicas {
+		r.Close()
+	}
+}
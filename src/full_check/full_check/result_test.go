@@ -0,0 +1,109 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultLine(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestResultLine case %d.\n", nr)
+
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{TargetHosts: []client.RedisHost{{}}}}
+		key := &common.Key{Key: []byte("k")}
+		assert.Equal(t, "0\tvalue\tk\tf\n", p.resultLine(key, common.ValueConflict, []byte("f")), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestResultLine case %d.\n", nr)
+
+		// the target key follows when the key names are mapped
+		p := &FullCheck{FullCheckParameter: checker.FullCheckParameter{
+			TargetHosts:    []client.RedisHost{{}},
+			TargetDBPrefix: "db{db}:",
+		}}
+		p.currentDB = 1
+		key := &common.Key{Key: []byte("k"), TargetKey: p.targetKeyName(1, []byte("k"))}
+		assert.Equal(t, "1\tlack_target\tk\t\tdb1:k\n", p.resultLine(key, common.LackTargetConflict, nil),
+			"should be equal")
+		assert.Equal(t, []byte("db1:k"), resultTargetKey(key), "should be equal")
+		assert.Equal(t, []byte("k"), resultTargetKey(&common.Key{Key: []byte("k")}), "should be equal")
+	}
+}
+
+func TestTargetStat(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestTargetStat case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "targets")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		qps := conf.Opts.Qps
+		defer func() {
+			conf.Opts.Qps = qps
+		}()
+		conf.Opts.Qps = 100
+
+		source, first, second := store.New(), store.New(), store.New()
+		for _, key := range []string{"a", "b", "c"} {
+			source.Set(0, key, store.NewString([]byte(key)))
+		}
+		first.Set(0, "a", store.NewString([]byte("a")))
+		first.Set(0, "b", store.NewString([]byte("x")))
+		second.Set(0, "a", store.NewString([]byte("x")))
+		second.Set(0, "b", store.NewString([]byte("x")))
+
+		p := NewFullCheck(checker.FullCheckParameter{
+			SourceHost: client.RedisHost{Addr: []string{"source"}, Store: source, DBType: common.TypeDB,
+				Role: client.RoleSource},
+			TargetHosts: []client.RedisHost{
+				{Addr: []string{"first"}, Store: first, DBType: common.TypeDB, Role: client.RoleTarget},
+				{Addr: []string{"second"}, Store: second, DBType: common.TypeDB, Role: client.RoleTarget},
+			},
+			ResultDBFile: filepath.Join(dir, "result.db"),
+			CompareCount: 2,
+			BatchCount:   10,
+			Parallel:     1,
+		}, FullValue)
+		p.Start()
+
+		// the conflicts are counted by target, 2 with the first and 3 with the second in each round
+		assert.Equal(t, p.stat.TotalConflictKeys, p.stat.Targets[0].TotalConflictKeys+
+			p.stat.Targets[1].TotalConflictKeys, "should be equal")
+		assert.Equal(t, p.stat.Targets[0].TotalConflictKeys*3, p.stat.Targets[1].TotalConflictKeys*2,
+			"should be equal")
+
+		// b conflicts with both targets and is scanned once in each round
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "result.db.2"))
+		assert.Nil(t, err, "should be nil")
+		defer db.Close()
+		scanned := make([]int64, 0)
+		rows, err := db.Query(fmt.Sprintf("select Count from %s where Category=? order by Round", SummaryTable),
+			summaryScan)
+		assert.Nil(t, err, "should be nil")
+		defer rows.Close()
+		for rows.Next() {
+			var count int64
+			assert.Nil(t, rows.Scan(&count), "should be nil")
+			scanned = append(scanned, count)
+		}
+		assert.Equal(t, []int64{3, 3}, scanned, "should be equal")
+	}
+}
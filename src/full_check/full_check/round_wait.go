@@ -0,0 +1,166 @@
+package full_check
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+)
+
+const (
+	// where the lag of the sync comes from before each round
+	RoundWaitReplica    = "replica"
+	RoundWaitFilePrefix = "file:"
+
+	roundWaitPoll = time.Second
+)
+
+/*
+ * WaitForTarget waits until the target reflects the source at the moment the round starts, or the
+ * timeout passes. For a target that is a direct replica, the replication offset of the source is
+ * taken when the round starts and every target should apply it. Otherwise the sync tool reports
+ * its lag in milliseconds by an http endpoint or a file, and the target has caught up once the lag
+ * is shorter than the time passed since the round started.
+ */
+func (p *FullCheck) WaitForTarget() {
+	start := time.Now()
+	deadline := start.Add(time.Duration(p.RoundWait.TimeoutSeconds) * time.Second)
+
+	var caughtUp func() (bool, string, error)
+	if p.RoundWait.Source == RoundWaitReplica {
+		offset, err := p.sourceReplicationOffset()
+		if err != nil {
+			common.Logger.Warnf("fetch replication offset of source failed, don't wait for the target: %v", err)
+			return
+		}
+		caughtUp = func() (bool, string, error) {
+			return p.targetsReachOffset(offset)
+		}
+	} else {
+		caughtUp = func() (bool, string, error) {
+			lag, err := p.fetchSyncLag()
+			if err != nil {
+				return false, "", err
+			}
+			return lag <= time.Since(start), fmt.Sprintf("sync lag %v", lag), nil
+		}
+	}
+
+	for {
+		ok, progress, err := caughtUp()
+		if err != nil {
+			common.Logger.Warnf("check whether the target caught up failed: %v", err)
+		} else if ok {
+			common.Logger.Infof("target caught up with the source after %v(%s)", time.Since(start), progress)
+			return
+		}
+		if !time.Now().Before(deadline) {
+			common.Logger.Warnf("target didn't catch up with the source in %ds(%s), start round %d anyway",
+				p.RoundWait.TimeoutSeconds, progress, p.times)
+			return
+		}
+		common.Logger.Debugf("wait for the target to catch up: %s", progress)
+		time.Sleep(roundWaitPoll)
+	}
+}
+
+// sourceReplicationOffset returns the master_repl_offset of the source, the primary when reading a replica.
+func (p *FullCheck) sourceReplicationOffset() (int64, error) {
+	host := p.SourceHost
+	if p.sourcePrimary != nil {
+		host = p.primaryHost()
+	}
+	sourceClient, err := client.NewRedisClient(host, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer sourceClient.Close()
+
+	_, offset, err := sourceClient.FetchReplicationOffset()
+	return offset, err
+}
+
+// targetsReachOffset checks whether every target applied the given offset of the source.
+func (p *FullCheck) targetsReachOffset(offset int64) (bool, string, error) {
+	progress := make([]string, 0, len(p.TargetHosts))
+	reached := true
+	for _, targetHost := range p.TargetHosts {
+		targetClient, err := client.NewRedisClient(targetHost, 0)
+		if err != nil {
+			return false, "", err
+		}
+		role, targetOffset, err := targetClient.FetchReplicationOffset()
+		targetClient.Close()
+		if err != nil {
+			return false, "", err
+		}
+		if role != "slave" {
+			return false, "", fmt.Errorf("target %v is a %v rather than a replica", targetHost.Address(), role)
+		}
+		if targetOffset < offset {
+			reached = false
+		}
+		progress = append(progress, fmt.Sprintf("target %v behind %d bytes", targetHost.Address(),
+			offset-targetOffset))
+	}
+	return reached, strings.Join(progress, ", "), nil
+}
+
+/*
+ * fetchSyncLag reads the lag of the sync tool in milliseconds from the http endpoint or the file.
+ * The content is either a number or a json object with the "lag" field.
+ */
+func (p *FullCheck) fetchSyncLag() (time.Duration, error) {
+	var content []byte
+	var err error
+	if strings.HasPrefix(p.RoundWait.Source, RoundWaitFilePrefix) {
+		content, err = ioutil.ReadFile(p.RoundWait.Source[len(RoundWaitFilePrefix):])
+	} else {
+		content, err = httpGet(p.RoundWait.Source)
+	}
+	if err != nil {
+		return 0, err
+	}
+	lag, err := ParseSyncLag(content)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lag from %v: %v", p.RoundWait.Source, err)
+	}
+	return time.Duration(lag * float64(time.Millisecond)), nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	httpClient := http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %v failed: %v", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// ParseSyncLag parses the lag in milliseconds like "1500" or `{"lag": 1500}`.
+func ParseSyncLag(content []byte) (float64, error) {
+	text := strings.TrimSpace(string(content))
+	if lag, err := strconv.ParseFloat(text, 64); err == nil {
+		return lag, nil
+	}
+	var body struct {
+		Lag *float64 `json:"lag"`
+	}
+	if err := json.Unmarshal([]byte(text), &body); err != nil {
+		return 0, err
+	}
+	if body.Lag == nil {
+		return 0, fmt.Errorf("no lag field in %v", text)
+	}
+	return *body.Lag, nil
+}
@@ -0,0 +1,33 @@
+package full_check
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyncLag(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestParseSyncLag case %d.\n", nr)
+
+		for input, expect := range map[string]float64{"1500": 1500, " 12.5\n": 12.5, `{"lag": 300, "qps": 10}`: 300,
+			`{"lag": 0}`: 0} {
+			lag, err := ParseSyncLag([]byte(input))
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, expect, lag, "should be equal: %v", input)
+		}
+	}
+
+	{
+		nr++
+		fmt.Printf("TestParseSyncLag case %d.\n", nr)
+
+		for _, input := range []string{"", "slow", `{"delay": 300}`, `{"lag": "300"}`} {
+			_, err := ParseSyncLag([]byte(input))
+			assert.NotNil(t, err, "should be not nil: %v", input)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package full_check
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/metric"
+)
+
+const (
+	SampleHash      = "hash"
+	SampleRandomKey = "randomkey"
+
+	sampleHashBase = 1 << 32
+	// "randomkey" returns duplicated keys, give up a node after drawing this many times its quota
+	sampleMaxDrawFactor = 3
+)
+
+/*
+ * sampler picks the keys compared in the first round of the current db. With "hash" and a count the
+ * keys of the lowest hashes are kept in a sketch and passed once the scan ends.
+ */
+type sampler struct {
+	rate     float64 // "hash": the probability a key is picked
+	limit    int64   // "randomkey": max picked keys, 0 means no limit
+	selected int64
+	sketch   *sampleSketch
+}
+
+func (p *FullCheck) newSampler() *sampler {
+	s := &sampler{
+		rate:  p.Sample.Rate,
+		limit: p.Sample.Count,
+	}
+	if p.Sample.Method == SampleHash && p.Sample.Count > 0 {
+		s.rate, s.limit = 1, 0
+		s.sketch = newSampleSketch(int(p.Sample.Count))
+	}
+	return s
+}
+
+func sampleHash(seed string, key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	h.Write(key)
+	return h.Sum64()
+}
+
+/*
+ * pick returns true if the key is in the sample. The choice only depends on the seed and the key
+ * name, so repeated runs with the same seed pick the same subset. The keys offered to the sketch
+ * aren't picked yet, see sampled.
+ */
+func (s *sampler) pick(seed string, key []byte) bool {
+	if s.sketch != nil {
+		s.sketch.offer(sampleHash(seed, key), key)
+		return false
+	}
+	if s.rate < 1 && float64(sampleHash(seed, key)%sampleHashBase) >= s.rate*sampleHashBase {
+		return false
+	}
+	return s.take(1) == 1
+}
+
+// take reserves at most n keys under the limit and returns the reserved number.
+func (s *sampler) take(n int64) int64 {
+	if s.limit <= 0 {
+		atomic.AddInt64(&s.selected, n)
+		return n
+	}
+	selected := atomic.AddInt64(&s.selected, n)
+	if over := selected - s.limit; over >= n {
+		return 0
+	} else if over > 0 {
+		return n - over
+	}
+	return n
+}
+
+func (s *sampler) full() bool {
+	return s.limit > 0 && atomic.LoadInt64(&s.selected) >= s.limit
+}
+
+// sampled returns the keys kept by the sketch ordered by name, nil without a sketch.
+func (s *sampler) sampled() [][]byte {
+	if s.sketch == nil {
+		return nil
+	}
+	return s.sketch.keys()
+}
+
+type sampleEntry struct {
+	hash uint64
+	key  []byte
+}
+
+// lower orders the entries by hash, then by name.
+func (e sampleEntry) lower(other sampleEntry) bool {
+	if e.hash != other.hash {
+		return e.hash < other.hash
+	}
+	return bytes.Compare(e.key, other.key) < 0
+}
+
+// sampleHeap pops the key of the highest hash first.
+type sampleHeap []sampleEntry
+
+func (h sampleHeap) Len() int            { return len(h) }
+func (h sampleHeap) Less(i, j int) bool  { return h[j].lower(h[i]) }
+func (h sampleHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x interface{}) { *h = append(*h, x.(sampleEntry)) }
+func (h *sampleHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+/*
+ * sampleSketch keeps the keys of the lowest hashes offered by the scans of all nodes, a uniform
+ * sample of the given size whatever the scan order.
+ */
+type sampleSketch struct {
+	lock  sync.Mutex
+	size  int
+	heap  sampleHeap
+	names map[string]struct{} // the keys in the heap, the scan may return a key twice
+}
+
+func newSampleSketch(size int) *sampleSketch {
+	return &sampleSketch{size: size, heap: make(sampleHeap, 0, size), names: make(map[string]struct{}, size)}
+}
+
+func (s *sampleSketch) offer(hash uint64, key []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.names[string(key)]; ok {
+		return
+	}
+	entry := sampleEntry{hash: hash, key: key}
+	if len(s.heap) == s.size {
+		if !entry.lower(s.heap[0]) {
+			return
+		}
+		delete(s.names, string(heap.Pop(&s.heap).(sampleEntry).key))
+	}
+	heap.Push(&s.heap, entry)
+	s.names[string(key)] = struct{}{}
+}
+
+func (s *sampleSketch) keys() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := make([][]byte, 0, len(s.heap))
+	for _, entry := range s.heap {
+		ret = append(ret, entry.key)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i], ret[j]) < 0
+	})
+	return ret
+}
+
+// sendSampled passes the keys kept by the sketch once all nodes are scanned.
+func (p *FullCheck) sendSampled(allKeys chan<- []*common.Key) {
+	keys := p.sampler.sampled()
+	for start := 0; start < len(keys); start += p.BatchCount {
+		end := start + p.BatchCount
+		if end > len(keys) {
+			end = len(keys)
+		}
+		keysInfo := make([]*common.Key, 0, end-start)
+		for _, key := range keys[start:end] {
+			keysInfo = append(keysInfo, &common.Key{
+				Key:          key,
+				TargetKey:    p.targetKeyName(p.currentDB, key),
+				Tp:           common.EndKeyType,
+				ConflictType: common.EndConflict,
+			})
+		}
+		p.IncrScanStat(len(keysInfo))
+		allKeys <- keysInfo
+	}
+}
+
+// nodeQuota returns how many keys "randomkey" should pick on the node the client connects to.
+func (p *FullCheck) nodeQuota(sourceClient *client.RedisClient) (int64, error) {
+	if p.Sample.Count > 0 {
+		nodes := int64(len(p.sourcePhysicalDBList))
+		return (p.Sample.Count + nodes - 1) / nodes, nil
+	}
+
+	reply, err := sourceClient.Do("dbsize")
+	if err != nil {
+		return 0, err
+	}
+	dbsize, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("dbsize returns invalid result[%v]", reply)
+	}
+	return int64(math.Ceil(p.Sample.Rate * float64(dbsize))), nil
+}
+
+// sampleRandomKeys picks keys of one node by "randomkey" instead of "scan".
+func (p *FullCheck) sampleRandomKeys(sourceClient *client.RedisClient, allKeys chan<- []*common.Key) {
+	quota, err := p.nodeQuota(sourceClient)
+	if err != nil {
+		panic(common.Logger.Errorf("fetch sample quota of %v failed[%v]", sourceClient, err))
+	}
+	common.Logger.Infof("sample %d keys of %v by randomkey", quota, sourceClient)
+
+	seen := make(map[string]struct{}, quota)
+	for draw := int64(0); int64(len(seen)) < quota && draw < quota*sampleMaxDrawFactor; {
+		if p.sampler.full() {
+			return
+		}
+
+		count := quota - int64(len(seen))
+		if count > int64(p.BatchCount) {
+			count = int64(p.BatchCount)
+		}
+		draw += count
+
+		keys, err := sourceClient.PipeRandomKeyCommand(int(count))
+		if err != nil {
+			panic(common.Logger.Critical(err))
+		}
+		keysInfo := make([]*common.Key, 0, len(keys))
+		for _, key := range keys {
+			if key == nil {
+				// db is empty
+				draw = quota * sampleMaxDrawFactor
+				break
+			}
+			if _, ok := seen[string(key)]; ok || p.passKey(key) == false {
+				continue
+			}
+			seen[string(key)] = struct{}{}
+
+			keysInfo = append(keysInfo, &common.Key{
+				Key:          key,
+				TargetKey:    p.targetKeyName(p.currentDB, key),
+				Tp:           common.EndKeyType,
+				ConflictType: common.EndConflict,
+			})
+		}
+		keysInfo = keysInfo[:p.sampler.take(int64(len(keysInfo)))]
+		if len(keysInfo) == 0 {
+			continue
+		}
+		p.IncrScanStat(len(keysInfo))
+		allKeys <- keysInfo
+	}
+	if int64(len(seen)) < quota {
+		common.Logger.Warnf("only %d of %d keys sampled on %v, the node has too few keys or most keys "+
+			"are filtered out", len(seen), quota, sourceClient)
+	}
+}
+
+// collectSampleStat accumulates the checked keys of the first round and the conflicts of the last one.
+func (p *FullCheck) collectSampleStat() {
+	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
+		if p.times == 1 {
+			for j := common.ConflictType(0); j <= common.NoneConflict; j++ {
+				p.sampleChecked[i] += p.stat.ConflictKey[i][j].Total()
+			}
+		}
+		if p.times == p.CompareCount {
+			for j := common.ConflictType(0); j < common.NoneConflict; j++ {
+				p.sampleConflict[i] += p.stat.ConflictKey[i][j].Total()
+			}
+		}
+	}
+}
+
+// PrintSampleStat prints the estimated inconsistency rate of every data type and of all keys.
+func (p *FullCheck) PrintSampleStat() {
+	var buf bytes.Buffer
+	var checked, conflict int64
+	stats := make([]*metric.SampleStat, 0, common.EndKeyTypeIndex+1)
+	for i := common.KeyTypeIndex(0); i < common.EndKeyTypeIndex; i++ {
+		if p.sampleChecked[i] == 0 {
+			continue
+		}
+		checked += p.sampleChecked[i]
+		conflict += p.sampleConflict[i]
+		stats = append(stats, metric.NewSampleStat(i.String(), p.sampleChecked[i], p.sampleConflict[i],
+			p.Sample.Confidence))
+	}
+	stats = append(stats, metric.NewSampleStat("all", checked, conflict, p.Sample.Confidence))
+
+	if conf.Opts.MetricPrint {
+		metricstr, _ := json.Marshal(stats)
+		common.Logger.Info(string(metricstr))
+		return
+	}
+
+	fmt.Fprintf(&buf, "sampled by %s, inconsistency rate at %.2f%% confidence:\n", p.Sample.Method,
+		p.Sample.Confidence*100)
+	for _, stat := range stats {
+		fmt.Fprintf(&buf, "SampleEstimate|%s|checked:%d|conflict:%d|rate:%.4f%%|interval:[%.4f%%, %.4f%%]\n",
+			stat.Type, stat.Checked, stat.Conflict, stat.Rate*100, stat.Low*100, stat.High*100)
+	}
+	common.Logger.Infof("sample stat:\n%s", buf.String())
+}
@@ -0,0 +1,111 @@
+package full_check
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleSketch(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestSampleSketch case %d.\n", nr)
+
+		keys := make([][]byte, 0, 100)
+		for i := 0; i < 100; i++ {
+			keys = append(keys, []byte(fmt.Sprintf("key%d", i)))
+		}
+		// the keys of the lowest hashes are expected
+		lowest := append([][]byte{}, keys...)
+		sort.Slice(lowest, func(i, j int) bool {
+			return sampleHash("seed", lowest[i]) < sampleHash("seed", lowest[j])
+		})
+		expected := make([]string, 0, 10)
+		for _, key := range lowest[:10] {
+			expected = append(expected, string(key))
+		}
+		sort.Strings(expected)
+
+		sample := func(keys [][]byte) []string {
+			s := &sampler{sketch: newSampleSketch(10)}
+			for _, key := range keys {
+				assert.False(t, s.pick("seed", key), "should be false")
+			}
+			ret := make([]string, 0)
+			for _, key := range s.sampled() {
+				ret = append(ret, string(key))
+			}
+			return ret
+		}
+		assert.Equal(t, expected, sample(keys), "should be equal")
+
+		// whatever the scan order, a key returned twice is kept once
+		reversed := make([][]byte, 0, len(keys)+1)
+		for i := len(keys) - 1; i >= 0; i-- {
+			reversed = append(reversed, keys[i])
+		}
+		reversed = append(reversed, lowest[0])
+		assert.Equal(t, expected, sample(reversed), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestSampleSketch case %d.\n", nr)
+
+		// fewer keys than the count are all kept
+		s := &sampler{sketch: newSampleSketch(10)}
+		s.pick("seed", []byte("b"))
+		s.pick("seed", []byte("a"))
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, s.sampled(), "should be equal")
+		assert.False(t, s.full(), "should be false")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestSampleSketch case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "sample")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		qps := conf.Opts.Qps
+		defer func() {
+			conf.Opts.Qps = qps
+		}()
+		conf.Opts.Qps = 100
+
+		// exactly the count of keys are verified once the scan ends
+		source, target := store.New(), store.New()
+		for i := 0; i < 20; i++ {
+			source.Set(0, fmt.Sprintf("key%d", i), store.NewString([]byte("v")))
+		}
+		p := NewFullCheck(checker.FullCheckParameter{
+			SourceHost: client.RedisHost{Addr: []string{"source"}, Store: source, DBType: common.TypeDB,
+				Role: client.RoleSource},
+			TargetHosts: []client.RedisHost{{Addr: []string{"target"}, Store: target, DBType: common.TypeDB,
+				Role: client.RoleTarget}},
+			ResultDBFile: filepath.Join(dir, "result.db"),
+			CompareCount: 1,
+			BatchCount:   3,
+			Parallel:     1,
+			Sample:       checker.SampleParameter{Count: 5, Method: SampleHash, Seed: "seed", Confidence: 0.95},
+		}, FullValue)
+		p.Start()
+		var checked int64
+		for _, count := range p.sampleChecked {
+			checked += count
+		}
+		assert.Equal(t, int64(5), checked, "should be equal")
+	}
+}
@@ -2,16 +2,45 @@ package full_check
 
 import (
 	"strconv"
+	"strings"
 	"fmt"
+	"math"
 
 	"full_check/common"
 	"full_check/client"
 
 	"github.com/jinzhu/copier"
+	"github.com/gomodule/redigo/redis"
 	"sync"
+	"sync/atomic"
 )
 
+// skipSampleCount is the count of the page read without the type filter to estimate the skipped keys.
+const skipSampleCount = 1000
+
 func (p *FullCheck) ScanFromSourceRedis(allKeys chan<- []*common.Key) {
+	if p.merkle != nil && len(p.merkle.diff) == 0 {
+		// all buckets are the same, no need to scan again
+		if node := p.checkpointNode(""); node != nil {
+			p.checkpoint.sent(node, nil, 0, true)
+		}
+		close(allKeys)
+		return
+	}
+
+	var watch *topologyWatch
+	if p.sampler == nil || p.Sample.Method != SampleRandomKey {
+		watch = p.watchTopology()
+	}
+	// the slots the run resumed was re-scanning are re-scanned after the scan
+	rescans := p.resumedRescans()
+	if watch != nil {
+		watch.skip(rescans)
+	}
+
+	// only the nodes and the slots of the shard are read
+	shardSlots, partial := p.scanShardSlots()
+
 	var wg sync.WaitGroup
 
 	wg.Add(len(p.sourcePhysicalDBList))
@@ -20,6 +49,21 @@ func (p *FullCheck) ScanFromSourceRedis(allKeys chan<- []*common.Key) {
 		go func(index int) {
 			defer wg.Done()
 			cursor := 0
+			node := p.checkpointNode(p.sourcePhysicalDBList[index])
+			if node != nil {
+				if node.done {
+					return
+				}
+				cursor = int(node.cursor)
+			}
+			if _, ok := shardSlots[p.sourcePhysicalDBList[index]]; shardSlots != nil && !ok {
+				common.Logger.Infof("node %v serves no slot of shard %v, skip it", p.sourcePhysicalDBList[index],
+					p.Shard)
+				if node != nil {
+					p.checkpoint.sent(node, nil, 0, true)
+				}
+				return
+			}
 			var sourceClient client.RedisClient
 			var err error
 
@@ -45,21 +89,51 @@ func (p *FullCheck) ScanFromSourceRedis(allKeys chan<- []*common.Key) {
 
 			common.Logger.Infof("build connection[%v]", sourceClient.String())
 
+			if p.sampler != nil && p.Sample.Method == SampleRandomKey {
+				p.sampleRandomKeys(&sourceClient, allKeys)
+				return
+			}
+
+			if partial[p.sourcePhysicalDBList[index]] {
+				err := p.scanSlots(&sourceClient, p.sourcePhysicalDBList[index],
+					shardSlots[p.sourcePhysicalDBList[index]], cursor, node, watch, allKeys)
+				if err != nil {
+					if watch != nil {
+						p.nodeFailed(watch, p.sourcePhysicalDBList[index], err)
+						return
+					}
+					panic(common.Logger.Critical(err))
+				}
+				return
+			}
+
+			pushed := p.supportScanType(&sourceClient) && p.sampleSkipped(&sourceClient, cursor)
+			scanOptions := p.scanOptions(pushed)
 			for {
 				var reply interface{}
 				var err error
 
+				if p.timeUp() {
+					common.Logger.Infof("time is up, stop scanning %v at cursor %d", sourceClient.String(), cursor)
+					return
+				}
+
 				switch p.SourceHost.DBType {
 				case common.TypeDB:
 					fallthrough
 				case common.TypeCluster:
-					reply, err = sourceClient.Do("scan", cursor, "count", p.BatchCount)
+					reply, err = sourceClient.Do("scan", append([]interface{}{cursor, "count", p.BatchCount},
+						scanOptions...)...)
 				case common.TypeAliyunProxy:
 					reply, err = sourceClient.Do("iscan", index, cursor, "count", p.BatchCount)
 				case common.TypeTencentProxy:
 					reply, err = sourceClient.Do("scan", cursor, "count", p.BatchCount, p.sourcePhysicalDBList[index])
 				}
 				if err != nil {
+					if watch != nil {
+						p.nodeFailed(watch, p.sourcePhysicalDBList[index], err)
+						return
+					}
 					panic(common.Logger.Critical(err))
 				}
 
@@ -82,6 +156,7 @@ func (p *FullCheck) ScanFromSourceRedis(allKeys chan<- []*common.Key) {
 				if ok == false {
 					panic(common.Logger.Criticalf("scan failed, result: %+v", reply))
 				}
+				p.incrScanned(len(keylist))
 				keysInfo := make([]*common.Key, 0, len(keylist))
 				for _, value := range keylist {
 					bytes, ok = value.([]byte)
@@ -89,22 +164,35 @@ func (p *FullCheck) ScanFromSourceRedis(allKeys chan<- []*common.Key) {
 						panic(common.Logger.Criticalf("scan failed, result: %+v", reply))
 					}
 
-					// check filter list
-					if common.CheckFilter(p.FilterTree, bytes) == false {
+					// check filter list and shard
+					if p.passKey(bytes) == false {
+						continue
+					}
+					if pushed {
+						p.skipEstimate.scanned(1)
+					}
+					if watch != nil && !watch.owns(p.sourcePhysicalDBList[index], bytes) {
+						continue
+					}
+					if p.sampler != nil && !p.sampler.pick(p.Sample.Seed, bytes) {
 						continue
 					}
 
 					keysInfo = append(keysInfo, &common.Key{
 						Key:          bytes,
+						TargetKey:    p.targetKeyName(p.currentDB, bytes),
 						Tp:           common.EndKeyType,
 						ConflictType: common.EndConflict,
 					})
 					// common.Logger.Debugf("read key: %v", string(bytes))
 				}
+				if node != nil {
+					p.checkpoint.sent(node, keysInfo, int64(cursor), cursor == 0)
+				}
 				p.IncrScanStat(len(keysInfo))
 				allKeys <- keysInfo
 
-				if cursor == 0 {
+				if cursor == 0 || (p.sampler != nil && p.sampler.full()) {
 					break
 				}
 			} // end for{}
@@ -112,13 +200,182 @@ func (p *FullCheck) ScanFromSourceRedis(allKeys chan<- []*common.Key) {
 	} // end fo for idx := 0; idx < p.sourcePhysicalDBList; idx++
 
 	wg.Wait()
+	p.resumeRescans(rescans, allKeys)
+	if watch != nil {
+		p.rescanTopology(watch, allKeys)
+		p.writeTopologyEvents(watch)
+	}
+	if p.sampler != nil {
+		p.sendSampled(allKeys)
+	}
 	close(allKeys)
 }
 
+/*
+ * passKey returns true if the key passes the filter, belongs to the shard of this process and is
+ * in a bucket that differs in the merkle comparison.
+ */
+func (p *FullCheck) passKey(key []byte) bool {
+	return common.CheckFilter(p.Filter, key) && (p.Shard == nil || p.Shard.Pass(key)) &&
+		(p.merkle == nil || p.merkle.pass(key))
+}
+
+// targetKeyName returns the key name on the target side, nil means the same as the source.
+func (p *FullCheck) targetKeyName(db int32, key []byte) []byte {
+	if p.KeyMapper == nil && p.TargetDBPrefix == "" {
+		return nil
+	}
+
+	if p.KeyMapper != nil {
+		key = p.KeyMapper.Map(key)
+	}
+	if p.TargetDBPrefix != "" {
+		prefix := strings.Replace(p.TargetDBPrefix, common.DBPlaceholder, strconv.Itoa(int(db)), -1)
+		key = append([]byte(prefix), key...)
+	}
+	return key
+}
+
+// scanOptions returns the extra "scan" arguments used to push the filter down to the source.
+func (p *FullCheck) scanOptions(withType bool) []interface{} {
+	if p.Filter == nil {
+		return nil
+	}
+
+	var options []interface{}
+	if pattern := p.Filter.MatchPattern(); pattern != nil {
+		options = append(options, "match", pattern)
+	}
+	if withType {
+		options = append(options, "type", p.Filter.ScanType())
+	}
+	return options
+}
+
+// supportScanType returns true if the type filter can be pushed down to "scan ... type".
+func (p *FullCheck) supportScanType(sourceClient *client.RedisClient) bool {
+	if p.Filter == nil || p.Filter.ScanType() == "" ||
+		(p.SourceHost.DBType != common.TypeDB && p.SourceHost.DBType != common.TypeCluster) {
+		return false
+	}
+
+	version, err := sourceClient.FetchVersion()
+	if err != nil {
+		common.Logger.Warnf("fetch version of %v failed[%v], filter data type after scan", sourceClient, err)
+		return false
+	}
+	if !common.VersionAtLeast(version, 6, 0) {
+		return false
+	}
+	return true
+}
+
+/*
+ * sampleSkipped reads a page of the node at the cursor without the type filter, the keys of the
+ * other types are estimated from the types of its keys. It returns false if none of them are of
+ * the included type, the keys are filtered after their type is fetched then.
+ */
+func (p *FullCheck) sampleSkipped(sourceClient *client.RedisClient, cursor int) bool {
+	reply, err := sourceClient.Do("scan", append([]interface{}{cursor, "count", skipSampleCount},
+		p.scanOptions(false)...)...)
+	if err != nil {
+		common.Logger.Warnf("scan %v without type filter failed[%v], filter data type after scan", sourceClient, err)
+		return false
+	}
+	values, err := redis.Values(reply, nil)
+	if err == nil && len(values) != 2 {
+		err = fmt.Errorf("invalid result %+v", reply)
+	}
+	var names [][]byte
+	if err == nil {
+		names, err = redis.ByteSlices(values[1], nil)
+	}
+	if err != nil {
+		common.Logger.Warnf("scan %v without type filter failed[%v], filter data type after scan", sourceClient, err)
+		return false
+	}
+
+	keys := make([]*common.Key, 0, len(names))
+	for _, name := range names {
+		if p.passKey(name) {
+			keys = append(keys, &common.Key{Key: name})
+		}
+	}
+	var types []string
+	if len(keys) != 0 {
+		if types, err = sourceClient.PipeTypeCommand(keys); err != nil {
+			common.Logger.Warnf("fetch types of %v failed[%v], filter data type after scan", sourceClient, err)
+			return false
+		}
+	}
+	var included int64
+	var excluded [common.EndKeyTypeIndex]int64
+	for _, name := range types {
+		tp := common.NewKeyType(name)
+		if p.Filter.PassType(tp) {
+			included++
+		} else if tp.Index < common.EndKeyTypeIndex {
+			excluded[tp.Index]++
+		}
+	}
+	if included == 0 {
+		return false
+	}
+
+	p.skipEstimate.add(included, excluded)
+	common.Logger.Infof("push type filter down to scan of %v, keys of other types are estimated from %d "+
+		"key(s) read without it", sourceClient, len(keys))
+	atomic.StoreInt32(&p.scanTypePushed, 1)
+	return true
+}
+
+// skipEstimate estimates the keys of each excluded type from the pages read without the type filter.
+type skipEstimate struct {
+	lock     sync.Mutex
+	included int64 // keys of the included type in the pages
+	excluded [common.EndKeyTypeIndex]int64
+	pushed   int64 // keys scanned with the type filter
+}
+
+func (e *skipEstimate) reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.included, e.excluded, e.pushed = 0, [common.EndKeyTypeIndex]int64{}, 0
+}
+
+func (e *skipEstimate) add(included int64, excluded [common.EndKeyTypeIndex]int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.included += included
+	for i := range excluded {
+		e.excluded[i] += excluded[i]
+	}
+}
+
+func (e *skipEstimate) scanned(n int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.pushed += n
+}
+
+// estimate returns the keys of each type skipped in proportion to the keys scanned with the filter.
+func (e *skipEstimate) estimate() [common.EndKeyTypeIndex]int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var ret [common.EndKeyTypeIndex]int64
+	if e.included == 0 {
+		return ret
+	}
+	for i := range e.excluded {
+		ret[i] = int64(math.Round(float64(e.excluded[i]) * float64(e.pushed) / float64(e.included)))
+	}
+	return ret
+}
+
 func (p *FullCheck) ScanFromDB(allKeys chan<- []*common.Key) {
 	conflictKeyTableName, conflictFieldTableName := p.GetLastResultTable()
 
-	keyQuery := fmt.Sprintf("select id,key,type,conflict_type,source_len,target_len from %s where id>? and db=%d limit %d",
+	keyQuery := fmt.Sprintf("select id,key,target_key,type,conflict_type,target,source_len,target_len from %s where id>? and db=%d limit %d",
 		conflictKeyTableName, p.currentDB, p.BatchCount)
 	keyStatm, err := p.db[p.times-1].Prepare(keyQuery)
 	if err != nil {
@@ -133,17 +390,36 @@ func (p *FullCheck) ScanFromDB(allKeys chan<- []*common.Key) {
 	}
 	defer fieldStatm.Close()
 
+	// the key conflicting with several targets has a row for each, it's counted as scanned once
+	var scanned map[string]struct{}
+	if len(p.TargetHosts) > 1 {
+		scanned = make(map[string]struct{})
+	}
+
 	var startId int64 = 0
+	node := p.checkpointNode(conflictKeyTableName)
+	if node != nil {
+		if node.done {
+			close(allKeys)
+			return
+		}
+		startId = node.cursor
+	}
 	for {
+		if p.timeUp() {
+			common.Logger.Infof("time is up, stop reading %s at id %d", conflictKeyTableName, startId)
+			close(allKeys)
+			break
+		}
 		rows, err := keyStatm.Query(startId)
 		if err != nil {
 			panic(common.Logger.Error(err))
 		}
 		keyInfo := make([]*common.Key, 0, p.BatchCount)
 		for rows.Next() {
-			var key, keytype, conflictType string
-			var id, source_len, target_len int64
-			err = rows.Scan(&id, &key, &keytype, &conflictType, &source_len, &target_len)
+			var key, targetKey, keytype, conflictType string
+			var id, target, source_len, target_len int64
+			err = rows.Scan(&id, &key, &targetKey, &keytype, &conflictType, &target, &source_len, &target_len)
 			if err != nil {
 				panic(common.Logger.Error(err))
 			}
@@ -151,12 +427,19 @@ func (p *FullCheck) ScanFromDB(allKeys chan<- []*common.Key) {
 				Key:          []byte(key),
 				Tp:           common.NewKeyType(keytype),
 				ConflictType: common.NewConflictType(conflictType),
+				Target:       int(target),
 				SourceAttr:   common.Attribute{ItemCount: source_len},
 				TargetAttr:   common.Attribute{ItemCount: target_len},
 			}
+			if targetKey != "" {
+				oneKeyInfo.TargetKey = []byte(targetKey)
+			}
 			if oneKeyInfo.Tp == common.EndKeyType {
 				panic(common.Logger.Errorf("invalid type from table %s: key=%s type=%s ", conflictKeyTableName, key, keytype))
 			}
+			if oneKeyInfo.Target < 0 || oneKeyInfo.Target >= len(p.TargetHosts) {
+				panic(common.Logger.Errorf("invalid target from table %s: key=%s target=%d ", conflictKeyTableName, key, target))
+			}
 			if oneKeyInfo.ConflictType == common.EndConflict {
 				panic(common.Logger.Errorf("invalid conflict_type from table %s: key=%s conflict_type=%s ", conflictKeyTableName, key, conflictType))
 			}
@@ -196,12 +479,25 @@ func (p *FullCheck) ScanFromDB(allKeys chan<- []*common.Key) {
 			panic(common.Logger.Error(err))
 		}
 		rows.Close()
+		if node != nil {
+			p.checkpoint.sent(node, keyInfo, startId, len(keyInfo) == 0)
+		}
 		// 结束
 		if len(keyInfo) == 0 {
 			close(allKeys)
 			break
 		}
-		p.IncrScanStat(len(keyInfo))
+		scannedKeys := len(keyInfo)
+		if scanned != nil {
+			scannedKeys = 0
+			for _, key := range keyInfo {
+				if _, ok := scanned[string(key.Key)]; !ok {
+					scanned[string(key.Key)] = struct{}{}
+					scannedKeys++
+				}
+			}
+		}
+		p.IncrScanStat(scannedKeys)
 		allKeys <- keyInfo
 	} // for{}
 }
\ No newline at end of file
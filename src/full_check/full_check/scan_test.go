@@ -0,0 +1,78 @@
+package full_check
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"full_check/checker"
+	"full_check/client"
+	"full_check/common"
+	"full_check/configure"
+	"full_check/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipEstimate(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestSkipEstimate case %d.\n", nr)
+
+		// 3 strings for each hash in the pages, 10 hashes scanned with the type filter
+		var e skipEstimate
+		var excluded [common.EndKeyTypeIndex]int64
+		excluded[common.StringTypeIndex] = 6
+		e.add(2, excluded)
+		e.scanned(10)
+		assert.Equal(t, int64(30), e.estimate()[common.StringTypeIndex], "should be equal")
+		assert.Equal(t, int64(0), e.estimate()[common.ListTypeIndex], "should be equal")
+
+		e.reset()
+		assert.Equal(t, int64(0), e.estimate()[common.StringTypeIndex], "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestSkipEstimate case %d.\n", nr)
+
+		dir, err := os.MkdirTemp("", "scan")
+		assert.Nil(t, err, "should be nil")
+		defer os.RemoveAll(dir)
+
+		qps := conf.Opts.Qps
+		defer func() {
+			conf.Opts.Qps = qps
+		}()
+		conf.Opts.Qps = 100
+
+		source := store.New()
+		source.Version = "6.2.0"
+		for i := 0; i < 12; i++ {
+			source.Set(0, fmt.Sprintf("string%d", i), store.NewString([]byte("v")))
+		}
+		for i := 0; i < 4; i++ {
+			source.Set(0, fmt.Sprintf("hash%d", i), store.NewHash())
+		}
+		filter := common.NewKeyFilter()
+		assert.Nil(t, filter.AddIncludeType("hash"), "should be nil")
+
+		// the type filter is pushed down and the strings are estimated from the page read without it
+		p := NewFullCheck(checker.FullCheckParameter{
+			SourceHost: client.RedisHost{Addr: []string{"source"}, Store: source, DBType: common.TypeDB,
+				Role: client.RoleSource},
+			TargetHosts: []client.RedisHost{{Addr: []string{"target"}, Store: store.New(), DBType: common.TypeDB,
+				Role: client.RoleTarget}},
+			ResultDBFile: filepath.Join(dir, "result.db"),
+			CompareCount: 1,
+			BatchCount:   3,
+			Parallel:     1,
+			Filter:       filter,
+		}, FullValue)
+		p.Start()
+		assert.Equal(t, int32(1), p.scanTypePushed, "should be equal")
+		assert.Equal(t, int64(12), p.skipEstimate.estimate()[common.StringTypeIndex], "should be equal")
+	}
+}
@@ -0,0 +1,185 @@
+package full_check
+
+import (
+	"fmt"
+	"strconv"
+
+	"full_check/client"
+	"full_check/common"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// a slot holding more batches of keys is read by "scan" instead of "cluster getkeysinslot"
+	slotFetchBatches = 16
+)
+
+/*
+ * shardNodeSlots returns the slots of the shard served by each node when the keyspace is split by
+ * slot, and whether the node serves other slots as well. A node serving none of the slots of the
+ * shard isn't in the result and isn't scanned.
+ */
+func shardNodeSlots(owners *[common.ClusterSlots]string, shard *common.Shard) (map[string][]int, map[string]bool) {
+	first, last := shard.SlotRange()
+	slots := make(map[string][]int)
+	partial := make(map[string]bool)
+	for slot, owner := range owners {
+		if owner == "" {
+			continue
+		}
+		if slot < first || slot > last {
+			partial[owner] = true
+			continue
+		}
+		slots[owner] = append(slots[owner], slot)
+	}
+	for owner := range partial {
+		if _, ok := slots[owner]; !ok {
+			delete(partial, owner)
+		}
+	}
+	return slots, partial
+}
+
+/*
+ * scanShardSlots plans the scan of a cluster source split by slot, so that each shard only reads
+ * the nodes and the slots it owns. It returns nil when every node should be scanned as usual.
+ */
+func (p *FullCheck) scanShardSlots() (map[string][]int, map[string]bool) {
+	if p.Shard == nil || p.Shard.By != common.ShardBySlot || !p.SourceHost.IsCluster() {
+		return nil, nil
+	}
+	topology, err := p.fetchTopology()
+	if err != nil {
+		common.Logger.Warnf("fetch slots of source cluster failed, all nodes are scanned for shard %v: %v",
+			p.Shard, err)
+		return nil, nil
+	}
+	slots, partial := shardNodeSlots(&topology.owners, p.Shard)
+	first, last := p.Shard.SlotRange()
+	common.Logger.Infof("shard %v owns slot %d-%d served by %d of %d scanned node(s), %d of them serve other "+
+		"slots as well and only the slots of the shard are read", p.Shard, first, last, len(slots),
+		len(p.sourcePhysicalDBList), len(partial))
+	return slots, partial
+}
+
+/*
+ * readSlots reads the keys of the slots from the node in order, starting from slot from. The keys
+ * of a slot are read by "cluster getkeysinslot", which has no cursor, so the slots holding more
+ * than slotFetchBatches batches are read together by one "scan" of the node after the others,
+ * keeping only the keys of those slots. The keys are sent in batches with the slot to continue
+ * from after them, common.ClusterSlots while the large slots are scanned, and whether it's the
+ * last batch, which may be empty.
+ */
+func (p *FullCheck) readSlots(nodeClient *client.RedisClient, slots []int, from int,
+	send func(keys [][]byte, cursor int, last bool)) error {
+	large := make(map[uint16]struct{})
+	for i, slot := range slots {
+		if p.timeUp() {
+			return nil
+		}
+		count, err := redis.Int(nodeClient.Do("cluster", "countkeysinslot", slot))
+		if err != nil {
+			return err
+		}
+		if count > p.BatchCount*slotFetchBatches {
+			large[uint16(slot)] = struct{}{}
+			continue
+		}
+		if slot < from || count == 0 {
+			continue
+		}
+		keys, err := redis.ByteSlices(nodeClient.Do("cluster", "getkeysinslot", slot, count))
+		if err != nil {
+			return err
+		}
+		next := common.ClusterSlots
+		if i+1 < len(slots) {
+			next = slots[i+1]
+		}
+		for start := 0; start < len(keys); start += p.BatchCount {
+			end := start + p.BatchCount
+			if end >= len(keys) {
+				// the slot is read again when it's not finished
+				send(keys[start:], next, false)
+				break
+			}
+			send(keys[start:end], slot, false)
+		}
+	}
+	if len(large) == 0 {
+		send(nil, common.ClusterSlots, true)
+		return nil
+	}
+
+	common.Logger.Infof("scan %d large slot(s) of %v", len(large), nodeClient.String())
+	cursor := 0
+	for {
+		if p.timeUp() {
+			return nil
+		}
+		reply, err := redis.Values(nodeClient.Do("scan", cursor, "count", p.BatchCount))
+		if err != nil {
+			return err
+		}
+		if len(reply) != 2 {
+			return fmt.Errorf("scan %d count %d failed, result: %+v", cursor, p.BatchCount, reply)
+		}
+		next, err := redis.Bytes(reply[0], nil)
+		if err != nil {
+			return err
+		}
+		if cursor, err = strconv.Atoi(string(next)); err != nil {
+			return err
+		}
+		keys, err := redis.ByteSlices(reply[1], nil)
+		if err != nil {
+			return err
+		}
+		kept := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			if _, ok := large[common.KeySlot(key)]; ok {
+				kept = append(kept, key)
+			}
+		}
+		send(kept, common.ClusterSlots, cursor == 0)
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// scanSlots passes the keys of the slots read from the node like the scan of the node does.
+func (p *FullCheck) scanSlots(nodeClient *client.RedisClient, name string, slots []int, from int,
+	node *checkpointNode, watch *topologyWatch, allKeys chan<- []*common.Key) error {
+	return p.readSlots(nodeClient, slots, from, func(keys [][]byte, cursor int, last bool) {
+		p.incrScanned(len(keys))
+		keysInfo := p.slotKeys(keys, name, watch)
+		if node != nil {
+			p.checkpoint.sent(node, keysInfo, int64(cursor), last)
+		}
+		if len(keysInfo) != 0 {
+			p.IncrScanStat(len(keysInfo))
+			allKeys <- keysInfo
+		}
+	})
+}
+
+// slotKeys returns the keys read from the node that should be compared.
+func (p *FullCheck) slotKeys(keys [][]byte, name string, watch *topologyWatch) []*common.Key {
+	keysInfo := make([]*common.Key, 0, len(keys))
+	for _, key := range keys {
+		if !p.passKey(key) || (watch != nil && !watch.owns(name, key)) ||
+			(p.sampler != nil && !p.sampler.pick(p.Sample.Seed, key)) {
+			continue
+		}
+		keysInfo = append(keysInfo, &common.Key{
+			Key:          key,
+			TargetKey:    p.targetKeyName(p.currentDB, key),
+			Tp:           common.EndKeyType,
+			ConflictType: common.EndConflict,
+		})
+	}
+	return keysInfo
+}
@@ -0,0 +1,78 @@
+package full_check
+
+import (
+	"fmt"
+	"testing"
+
+	"full_check/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardNodeSlots(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestShardNodeSlots case %d.\n", nr)
+
+		// three nodes, the middle one serves slot 5461-10922
+		var owners [common.ClusterSlots]string
+		for slot := range owners {
+			switch {
+			case slot <= 5460:
+				owners[slot] = "a"
+			case slot <= 10922:
+				owners[slot] = "b"
+			default:
+				owners[slot] = "c"
+			}
+		}
+
+		// shard 0/2 owns slot 0-8191
+		slots, partial := shardNodeSlots(&owners, &common.Shard{Index: 0, Count: 2, By: common.ShardBySlot})
+		assert.Equal(t, 2, len(slots), "should be equal")
+		assert.Equal(t, 5461, len(slots["a"]), "should be equal")
+		assert.Equal(t, 8192-5461, len(slots["b"]), "should be equal")
+		assert.Equal(t, 5461, slots["b"][0], "should be equal")
+		assert.Equal(t, 8191, slots["b"][len(slots["b"])-1], "should be equal")
+		assert.Equal(t, map[string]bool{"b": true}, partial, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestShardNodeSlots case %d.\n", nr)
+
+		// one node per shard, no node is read partly
+		var owners [common.ClusterSlots]string
+		for slot := range owners {
+			owners[slot] = fmt.Sprintf("node%d", slot*4/common.ClusterSlots)
+		}
+		owners[0] = "" // not served
+		slots, partial := shardNodeSlots(&owners, &common.Shard{Index: 1, Count: 4, By: common.ShardBySlot})
+		assert.Equal(t, 1, len(slots), "should be equal")
+		assert.Equal(t, common.ClusterSlots/4, len(slots["node1"]), "should be equal")
+		assert.Equal(t, 0, len(partial), "should be equal")
+	}
+}
+
+func TestSlotOwners(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestSlotOwners case %d.\n", nr)
+
+		// the flushes of a psync node only remove the keys of its slots
+		ranges := []common.SlotRange{
+			{First: 0, Last: 8191, Nodes: []string{"10.0.0.1:6379", "10.0.0.3:6379"}},
+			{First: 8192, Last: common.ClusterSlots - 1, Nodes: []string{"10.0.0.2:6379"}},
+		}
+		owners := slotOwners([]string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"}, ranges)
+		low, high := []byte("b"), []byte("a")
+		assert.True(t, common.KeySlot(low) < 8192 && common.KeySlot(high) >= 8192, "should be true")
+		assert.True(t, owners[0](low), "should be true")
+		assert.False(t, owners[0](high), "should be false")
+		assert.True(t, owners[1](high), "should be true")
+		// a replica serves the slots of its master
+		assert.True(t, owners[2](low), "should be true")
+	}
+}
@@ -0,0 +1,148 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+)
+
+const SourceReplicaTable = "SOURCE_REPLICA"
+
+func createSourceReplicaTable(db *sql.DB) error {
+	replicaSql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s(
+	Db         INTEGER NOT NULL,
+	Round      INTEGER NOT NULL,
+	Source     TEXT NOT NULL,
+	Replica    TEXT NOT NULL,
+	LagSeconds INTEGER NOT NULL,
+	LagBytes   INTEGER NOT NULL,
+	Time       INTEGER NOT NULL
+	);`, SourceReplicaTable)
+	if _, err := db.Exec(replicaSql); err != nil {
+		return fmt.Errorf("exec sql %s failed: %v", replicaSql, err)
+	}
+	return nil
+}
+
+// primaryHost returns the source host that reads the primary.
+func (p *FullCheck) primaryHost() client.RedisHost {
+	host := p.SourceHost
+	host.Addr, host.Fallback = p.sourcePrimary, nil
+	return host
+}
+
+// sourceAddress names the source in the results, the primary rather than the replica read.
+func (p *FullCheck) sourceAddress() string {
+	if p.sourcePrimary != nil {
+		return p.primaryHost().Address()
+	}
+	return p.SourceHost.Address()
+}
+
+// fetchReplicas returns the master_repl_offset of the primary and its replicas.
+func (p *FullCheck) fetchReplicas() (int64, []client.ReplicaState, error) {
+	primaryClient, err := client.NewRedisClient(p.primaryHost(), 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer primaryClient.Close()
+
+	role, offset, replicas, err := primaryClient.FetchReplicas()
+	if err != nil {
+		return 0, nil, err
+	}
+	if role != "" && role != "master" {
+		return 0, nil, fmt.Errorf("the source is a %v rather than the primary", role)
+	}
+	return offset, replicas, nil
+}
+
+/*
+ * SelectSourceReplica points the source to the online replica with the lowest lag that answers,
+ * the primary is connected instead once the replica fails. The source is read from the primary
+ * when no replica lags behind less than the max lag.
+ */
+func (p *FullCheck) SelectSourceReplica() {
+	if p.sourcePrimary == nil {
+		p.sourcePrimary = p.SourceHost.Addr
+	}
+
+	offset, replicas, err := p.fetchReplicas()
+	if err != nil {
+		common.Logger.Warnf("fetch replicas of source %v failed, read the primary: %v", p.sourcePrimary, err)
+		p.SourceHost.Addr, p.SourceHost.Fallback = p.sourcePrimary, nil
+		return
+	}
+
+	candidates := make([]client.ReplicaState, 0, len(replicas))
+	for _, replica := range replicas {
+		if replica.State == "online" && replica.Lag <= int64(p.SourceReplica.MaxLagSeconds) {
+			candidates = append(candidates, replica)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Lag != candidates[j].Lag {
+			return candidates[i].Lag < candidates[j].Lag
+		}
+		return candidates[i].Offset > candidates[j].Offset
+	})
+
+	for _, replica := range candidates {
+		replicaHost := p.SourceHost
+		replicaHost.Addr, replicaHost.Fallback = []string{replica.Addr}, nil
+		replicaClient, err := client.NewRedisClient(replicaHost, 0)
+		if err != nil {
+			common.Logger.Warnf("replica %v of source is unavailable: %v", replica.Addr, err)
+			continue
+		}
+		replicaClient.Close()
+
+		p.SourceHost.Addr, p.SourceHost.Fallback = replicaHost.Addr, p.sourcePrimary
+		common.Logger.Infof("read source from replica %v of %v, lag %ds and %d bytes", replica.Addr,
+			p.sourcePrimary, replica.Lag, offset-replica.Offset)
+		return
+	}
+
+	common.Logger.Warnf("no replica of source %v is online with lag <= %ds among %d, read the primary",
+		p.sourcePrimary, p.SourceReplica.MaxLagSeconds, len(replicas))
+	p.SourceHost.Addr, p.SourceHost.Fallback = p.sourcePrimary, nil
+}
+
+// WriteReplicaLag records the lag of the replica read when the current db starts to be compared.
+func (p *FullCheck) WriteReplicaLag() {
+	replica := p.SourceHost.Address()
+	lagSeconds, lagBytes := int64(0), int64(0)
+	if p.SourceHost.Fallback != nil {
+		offset, replicas, err := p.fetchReplicas()
+		if err != nil {
+			common.Logger.Warnf("fetch replicas of source %v failed, lag isn't recorded: %v", p.sourcePrimary, err)
+			return
+		}
+		found := false
+		for _, state := range replicas {
+			if state.Addr == replica {
+				lagSeconds, lagBytes, found = state.Lag, offset-state.Offset, true
+				break
+			}
+		}
+		if !found {
+			common.Logger.Warnf("replica %v isn't connected to source %v any more", replica, p.sourcePrimary)
+			lagSeconds, lagBytes = -1, -1
+		}
+	}
+
+	insertSql := fmt.Sprintf("insert into %s (Db, Round, Source, Replica, LagSeconds, LagBytes, Time) "+
+		"values(?,?,?,?,?,?,?)", SourceReplicaTable)
+	if _, err := p.db[p.CompareCount].Exec(insertSql, p.currentDB, p.times,
+		p.sourceAddress(), replica, lagSeconds, lagBytes,
+		time.Now().Unix()); err != nil {
+		panic(common.Logger.Errorf("exec sql %s failed: %v", insertSql, err))
+	}
+	common.Logger.Infof("db %d round %d reads source %v, lag %ds and %d bytes", p.currentDB, p.times, replica,
+		lagSeconds, lagBytes)
+}
@@ -0,0 +1,433 @@
+package full_check
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"full_check/client"
+	"full_check/common"
+
+	"github.com/jinzhu/copier"
+)
+
+const (
+	TopologyTable = "TOPOLOGY"
+
+	// the MOVED redirections are checked this often, a new one polls the slots at once
+	topologyMovedCheck = time.Second
+	// the slots changed again during a re-scan are re-scanned at most this many times
+	topologyMaxRescans = 3
+)
+
+func createTopologyTable(db *sql.DB) error {
+	topologySql := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s(
+	Db     INTEGER NOT NULL,
+	Round  INTEGER NOT NULL,
+	Epoch  INTEGER NOT NULL,
+	Event  TEXT NOT NULL,
+	Time   INTEGER NOT NULL
+	);`, TopologyTable)
+	if _, err := db.Exec(topologySql); err != nil {
+		return fmt.Errorf("exec sql %s failed: %v", topologySql, err)
+	}
+	return nil
+}
+
+// clusterTopology is the slot map of the source cluster.
+type clusterTopology struct {
+	epoch  int64
+	owners [common.ClusterSlots]string // the node scanned for each slot
+}
+
+// SlotMove is a range of slots whose owner changed.
+type SlotMove struct {
+	First int
+	Last  int
+	From  string // empty when the slots weren't served
+	To    string // empty when the slots aren't served any more
+}
+
+func (m SlotMove) String() string {
+	return fmt.Sprintf("slots %d-%d moved from [%s] to [%s]", m.First, m.Last, m.From, m.To)
+}
+
+type topologyEvent struct {
+	epoch int64
+	event string
+	time  int64
+}
+
+/*
+ * topologyWatch polls the slots of the source cluster during the scan. Each node only passes the
+ * keys of the slots it served when the scan started, and the slots that moved, or whose node
+ * failed, are skipped by all nodes and re-scanned slot by slot from their new owners after the
+ * scan. The keys of a moved slot scanned before the move is noticed are compared twice.
+ */
+type topologyWatch struct {
+	start *clusterTopology
+
+	lock     sync.RWMutex
+	current  *clusterTopology
+	affected map[int]struct{} // slots to re-scan from their current owners
+	skipped  map[int]struct{} // slots re-scanned by the run resumed
+	events   []topologyEvent
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// sourceNodeHost returns the source host of one node of the cluster.
+func (p *FullCheck) sourceNodeHost(addr string) client.RedisHost {
+	var singleHost client.RedisHost
+	copier.Copy(&singleHost, &p.SourceHost)
+	singleHost.Addr = []string{addr}
+	singleHost.DBType = common.TypeDB
+	return singleHost
+}
+
+/*
+ * fetchTopology asks the scanned nodes in turn for the slots. A slot is owned by its master, or by
+ * one of its replicas when the replicas are scanned instead.
+ */
+func (p *FullCheck) fetchTopology() (*clusterTopology, error) {
+	scanned := make(map[string]bool, len(p.sourcePhysicalDBList))
+	for _, addr := range p.sourcePhysicalDBList {
+		scanned[addr] = true
+	}
+
+	var lastErr error
+	for _, addr := range p.sourcePhysicalDBList {
+		nodeClient, err := client.NewRedisClient(p.sourceNodeHost(addr), 0)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		epoch, ranges, err := nodeClient.FetchClusterSlots()
+		nodeClient.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("node %v: %v", addr, err)
+			continue
+		}
+
+		topology := &clusterTopology{epoch: epoch}
+		for _, r := range ranges {
+			owner := r.Nodes[0]
+			for _, node := range r.Nodes {
+				if scanned[node] {
+					owner = node
+					break
+				}
+			}
+			for slot := r.First; slot <= r.Last; slot++ {
+				topology.owners[slot] = owner
+			}
+		}
+		return topology, nil
+	}
+	return nil, lastErr
+}
+
+// DiffSlots returns the ranges of the slots whose owner differs, ordered by slot.
+func DiffSlots(old, new *[common.ClusterSlots]string) []SlotMove {
+	moves := make([]SlotMove, 0)
+	for slot := 0; slot < common.ClusterSlots; slot++ {
+		if old[slot] == new[slot] {
+			continue
+		}
+		if n := len(moves); n != 0 && moves[n-1].Last == slot-1 && moves[n-1].From == old[slot] &&
+			moves[n-1].To == new[slot] {
+			moves[n-1].Last = slot
+			continue
+		}
+		moves = append(moves, SlotMove{First: slot, Last: slot, From: old[slot], To: new[slot]})
+	}
+	return moves
+}
+
+func newTopologyWatch(start *clusterTopology) *topologyWatch {
+	return &topologyWatch{
+		start:    start,
+		current:  start,
+		affected: make(map[int]struct{}),
+		skipped:  make(map[int]struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+/*
+ * watchTopology starts polling the slots of the source cluster every poll interval, and at once
+ * when any connection is redirected by MOVED. It returns nil when the source isn't a cluster or the
+ * slots can't be fetched.
+ */
+func (p *FullCheck) watchTopology() *topologyWatch {
+	if !p.SourceHost.IsCluster() || p.Topology.PollSeconds <= 0 {
+		return nil
+	}
+	start, err := p.fetchTopology()
+	if err != nil {
+		common.Logger.Warnf("fetch slots of source cluster failed, topology changes aren't detected: %v", err)
+		return nil
+	}
+	common.Logger.Infof("watch topology of source cluster from epoch %d every %ds", start.epoch,
+		p.Topology.PollSeconds)
+
+	w := newTopologyWatch(start)
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(topologyMovedCheck)
+		defer ticker.Stop()
+		moved := common.MovedRedirections()
+		polledAt := time.Now()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+			if current := common.MovedRedirections(); current != moved ||
+				time.Since(polledAt) >= time.Duration(p.Topology.PollSeconds)*time.Second {
+				moved, polledAt = current, time.Now()
+				p.pollTopology(w)
+			}
+		}
+	}()
+	return w
+}
+
+// pollTopology fetches the slots and marks the ones that moved since the last poll.
+func (p *FullCheck) pollTopology(w *topologyWatch) {
+	topology, err := p.fetchTopology()
+	if err != nil {
+		common.Logger.Warnf("poll slots of source cluster failed: %v", err)
+		return
+	}
+	w.update(topology)
+}
+
+// update marks the slots that moved in the topology fetched.
+func (w *topologyWatch) update(topology *clusterTopology) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	moves := DiffSlots(&w.current.owners, &topology.owners)
+	if len(moves) == 0 && topology.epoch == w.current.epoch {
+		return
+	}
+	for _, move := range moves {
+		for slot := move.First; slot <= move.Last; slot++ {
+			w.affected[slot] = struct{}{}
+		}
+	}
+	descriptions := make([]string, 0, len(moves))
+	for _, move := range moves {
+		descriptions = append(descriptions, move.String())
+	}
+	if len(descriptions) == 0 {
+		descriptions = append(descriptions, "no slot moved")
+	}
+	w.record(topology.epoch, fmt.Sprintf("epoch %d -> %d: %s", w.current.epoch, topology.epoch,
+		strings.Join(descriptions, ", ")))
+	w.current = topology
+}
+
+// record logs the event and keeps it for the final result db, the lock should be held.
+func (w *topologyWatch) record(epoch int64, event string) {
+	common.Logger.Warnf("topology of source cluster: %s", event)
+	w.events = append(w.events, topologyEvent{epoch: epoch, event: event, time: time.Now().Unix()})
+}
+
+// owns returns true if the key should be passed by the scan of the node.
+func (w *topologyWatch) owns(node string, key []byte) bool {
+	slot := int(common.KeySlot(key))
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if _, ok := w.affected[slot]; ok {
+		return false
+	}
+	if _, ok := w.skipped[slot]; ok {
+		return false
+	}
+	return w.start.owners[slot] == node
+}
+
+// nodeFailed gives up the scan of the node, its slots are re-scanned from their current owners.
+func (p *FullCheck) nodeFailed(w *topologyWatch, node string, err error) {
+	p.pollTopology(w)
+	w.nodeFailed(node, err)
+}
+
+func (w *topologyWatch) nodeFailed(node string, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	count := 0
+	for slot, owner := range w.start.owners {
+		if owner == node {
+			w.affected[slot] = struct{}{}
+			count++
+		}
+	}
+	w.record(w.current.epoch, fmt.Sprintf("scan of node %v failed[%v], its %d slot(s) are re-scanned", node, err,
+		count))
+}
+
+/*
+ * rescanTopology stops the watch and re-scans the affected slots from their current owners. The
+ * slots moved again during the re-scan are re-scanned once more, at most topologyMaxRescans times.
+ * The slots left when the time is up are recorded in the checkpoint and re-scanned when resumed.
+ */
+func (p *FullCheck) rescanTopology(w *topologyWatch, allKeys chan<- []*common.Key) {
+	close(w.stop)
+	<-w.done
+	p.pollTopology(w)
+
+	for i := 0; ; i++ {
+		timeUp := p.timeUp()
+		slotsOf, epoch := w.nextRescan(i, timeUp)
+		if len(slotsOf) == 0 {
+			break
+		}
+		for owner, slots := range slotsOf {
+			var node *checkpointNode
+			if p.checkpoint != nil {
+				node = p.checkpoint.rescanNode(owner, slots)
+			}
+			if !timeUp {
+				p.rescan(w, epoch, owner, slots, node, allKeys)
+			}
+		}
+		if timeUp {
+			break
+		}
+		p.pollTopology(w)
+	}
+}
+
+/*
+ * nextRescan takes the affected slots grouped by their current owner, ordered by slot, for the
+ * given re-scan, and the epoch of the owners. When the time is up they are taken to be re-scanned
+ * when resumed. Nothing is returned after topologyMaxRescans re-scans, the slots left are recorded
+ * as missed like the slots no node serves.
+ */
+func (w *topologyWatch) nextRescan(rescans int, timeUp bool) (map[string][]int, int64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.affected) == 0 {
+		return nil, w.current.epoch
+	}
+	if timeUp {
+		w.record(w.current.epoch, fmt.Sprintf("time is up, %d slot(s) are re-scanned when resumed",
+			len(w.affected)))
+	} else if rescans == topologyMaxRescans {
+		w.record(w.current.epoch, fmt.Sprintf("%d slot(s) still moving after %d re-scans, their keys may be "+
+			"missed", len(w.affected), topologyMaxRescans))
+		return nil, w.current.epoch
+	}
+
+	slotsOf := make(map[string][]int)
+	for slot := range w.affected {
+		slotsOf[w.current.owners[slot]] = append(slotsOf[w.current.owners[slot]], slot)
+	}
+	w.affected = make(map[int]struct{})
+	for _, slots := range slotsOf {
+		sort.Ints(slots)
+	}
+	if slots, ok := slotsOf[""]; ok {
+		w.record(w.current.epoch, fmt.Sprintf("%d slot(s) aren't served by any node, their keys are missed",
+			len(slots)))
+		delete(slotsOf, "")
+	}
+	return slotsOf, w.current.epoch
+}
+
+// skip makes the scan skip the slots of the re-scans of the run resumed.
+func (w *topologyWatch) skip(rescans []resumedRescan) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, rescan := range rescans {
+		for _, slot := range rescan.slots {
+			w.skipped[slot] = struct{}{}
+		}
+	}
+}
+
+/*
+ * rescan re-scans the slots from the owner, they are given up when it fails. The watch is nil when
+ * the re-scan is resumed.
+ */
+func (p *FullCheck) rescan(w *topologyWatch, epoch int64, owner string, slots []int, node *checkpointNode,
+	allKeys chan<- []*common.Key) {
+	if err := p.rescanSlots(owner, slots, node, allKeys); err != nil {
+		event := fmt.Sprintf("re-scan of %d slot(s) from node %v failed[%v], their keys may be missed",
+			len(slots), owner, err)
+		if w != nil {
+			w.lock.Lock()
+			w.record(epoch, event)
+			w.lock.Unlock()
+		} else {
+			common.Logger.Warnf("topology of source cluster: %s", event)
+		}
+		if node != nil {
+			p.checkpoint.sent(node, nil, 0, true)
+		}
+		return
+	}
+	if !p.timeUp() {
+		common.Logger.Infof("re-scanned %d slot(s) from node %v", len(slots), owner)
+	}
+}
+
+// resumeRescans re-scans the slots of the re-scans the run resumed didn't finish.
+func (p *FullCheck) resumeRescans(rescans []resumedRescan, allKeys chan<- []*common.Key) {
+	for _, rescan := range rescans {
+		if !rescan.state.done {
+			p.rescan(nil, 0, rescan.owner, rescan.slots, p.checkpointNode(rescan.name), allKeys)
+		}
+	}
+}
+
+/*
+ * rescanSlots reads the keys of the slots from the node like the scan of the slots of a shard does,
+ * from the cursor of the checkpoint node if any.
+ */
+func (p *FullCheck) rescanSlots(owner string, slots []int, node *checkpointNode, allKeys chan<- []*common.Key) error {
+	nodeClient, err := client.NewRedisClient(p.sourceNodeHost(owner), 0)
+	if err != nil {
+		return err
+	}
+	defer nodeClient.Close()
+
+	from := 0
+	if node != nil {
+		from = int(node.cursor)
+	}
+	return p.scanSlots(&nodeClient, owner, slots, from, node, nil, allKeys)
+}
+
+// writeTopologyEvents stores the events of the scan into the final result db if there is one.
+func (p *FullCheck) writeTopologyEvents(w *topologyWatch) {
+	w.lock.Lock()
+	events := w.events
+	w.lock.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	p.topologyEvents += len(events)
+	common.Logger.Warnf("%d topology change(s) of source cluster during the scan of db %d", len(events),
+		p.currentDB)
+
+	db := p.db[p.CompareCount]
+	if db == nil {
+		return
+	}
+	insertSql := fmt.Sprintf("insert into %s (Db, Round, Epoch, Event, Time) values(?,?,?,?,?)", TopologyTable)
+	for _, event := range events {
+		if _, err := db.Exec(insertSql, p.currentDB, p.times, event.epoch, event.event, event.time); err != nil {
+			panic(common.Logger.Errorf("exec sql %s failed: %v", insertSql, err))
+		}
+	}
+}
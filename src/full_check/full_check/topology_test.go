@@ -0,0 +1,143 @@
+package full_check
+
+import (
+	"fmt"
+	"testing"
+
+	"full_check/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSlots(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestDiffSlots case %d.\n", nr)
+
+		var old [common.ClusterSlots]string
+		for slot := range old {
+			if slot < 8192 {
+				old[slot] = "10.0.0.1:6379"
+			} else {
+				old[slot] = "10.0.0.2:6379"
+			}
+		}
+		new := old
+		assert.Equal(t, 0, len(DiffSlots(&old, &new)), "should be equal")
+
+		// slot migration and a failover
+		for slot := 100; slot <= 199; slot++ {
+			new[slot] = "10.0.0.2:6379"
+		}
+		for slot := 8192; slot < common.ClusterSlots; slot++ {
+			new[slot] = "10.0.0.3:6379"
+		}
+		new[300] = ""
+		assert.Equal(t, []SlotMove{
+			{First: 100, Last: 199, From: "10.0.0.1:6379", To: "10.0.0.2:6379"},
+			{First: 300, Last: 300, From: "10.0.0.1:6379", To: ""},
+			{First: 8192, Last: 16383, From: "10.0.0.2:6379", To: "10.0.0.3:6379"},
+		}, DiffSlots(&old, &new), "should be equal")
+	}
+}
+
+// newTestTopology serves the first half of the slots by n1 and the rest by n2.
+func newTestTopology(epoch int64) *clusterTopology {
+	topology := &clusterTopology{epoch: epoch}
+	for slot := range topology.owners {
+		if slot < common.ClusterSlots/2 {
+			topology.owners[slot] = "n1"
+		} else {
+			topology.owners[slot] = "n2"
+		}
+	}
+	return topology
+}
+
+func TestTopologyWatch(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestTopologyWatch case %d.\n", nr)
+
+		w := newTopologyWatch(newTestTopology(1))
+		key := []byte("a")
+		slot := int(common.KeySlot(key))
+		owner, other := "n1", "n2"
+		if slot >= common.ClusterSlots/2 {
+			owner, other = other, owner
+		}
+		assert.True(t, w.owns(owner, key), "should be true")
+		assert.False(t, w.owns(other, key), "should be false")
+
+		// the same topology isn't an event, a new epoch is
+		w.update(newTestTopology(1))
+		assert.Equal(t, 0, len(w.events), "should be equal")
+		w.update(newTestTopology(2))
+		assert.Equal(t, 1, len(w.events), "should be equal")
+		assert.Equal(t, "epoch 1 -> 2: no slot moved", w.events[0].event, "should be equal")
+		assert.Equal(t, 0, len(w.affected), "should be equal")
+
+		// the moved slot is passed by no node and re-scanned from its new owner
+		moved := newTestTopology(3)
+		moved.owners[slot] = other
+		w.update(moved)
+		assert.Equal(t, map[int]struct{}{slot: {}}, w.affected, "should be equal")
+		assert.False(t, w.owns(owner, key), "should be false")
+		assert.False(t, w.owns(other, key), "should be false")
+		slotsOf, epoch := w.nextRescan(0, false)
+		assert.Equal(t, map[string][]int{other: {slot}}, slotsOf, "should be equal")
+		assert.Equal(t, int64(3), epoch, "should be equal")
+		assert.Equal(t, 0, len(w.affected), "should be equal")
+		slotsOf, _ = w.nextRescan(1, false)
+		assert.Equal(t, 0, len(slotsOf), "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestTopologyWatch case %d.\n", nr)
+
+		// the slots of a failed node are re-scanned from their current owners
+		w := newTopologyWatch(newTestTopology(1))
+		failover := newTestTopology(2)
+		for slot := 0; slot < common.ClusterSlots/2; slot++ {
+			failover.owners[slot] = "n3"
+		}
+		failover.owners[0] = ""
+		w.update(failover)
+		w.nodeFailed("n1", fmt.Errorf("EOF"))
+		assert.Equal(t, common.ClusterSlots/2, len(w.affected), "should be equal")
+		assert.Equal(t, "scan of node n1 failed[EOF], its 8192 slot(s) are re-scanned", w.events[1].event,
+			"should be equal")
+
+		// the slot served by no node is recorded as missed
+		slotsOf, _ := w.nextRescan(0, false)
+		assert.Equal(t, 1, len(slotsOf), "should be equal")
+		assert.Equal(t, common.ClusterSlots/2-1, len(slotsOf["n3"]), "should be equal")
+		assert.Equal(t, 1, slotsOf["n3"][0], "should be equal")
+		assert.Equal(t, "1 slot(s) aren't served by any node, their keys are missed", w.events[2].event,
+			"should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestTopologyWatch case %d.\n", nr)
+
+		// the slots are left to the resumed run when the time is up, given up after the last re-scan
+		w := newTopologyWatch(newTestTopology(1))
+		w.nodeFailed("n2", fmt.Errorf("EOF"))
+		slotsOf, _ := w.nextRescan(0, true)
+		assert.Equal(t, 1, len(slotsOf), "should be equal")
+		assert.Equal(t, common.ClusterSlots/2, len(slotsOf["n2"]), "should be equal")
+		assert.Equal(t, 0, len(w.affected), "should be equal")
+		assert.Equal(t, "time is up, 8192 slot(s) are re-scanned when resumed",
+			w.events[len(w.events)-1].event, "should be equal")
+
+		w.nodeFailed("n2", fmt.Errorf("EOF"))
+		slotsOf, _ = w.nextRescan(topologyMaxRescans, false)
+		assert.Equal(t, 0, len(slotsOf), "should be equal")
+		assert.Equal(t, "8192 slot(s) still moving after 3 re-scans, their keys may be missed",
+			w.events[len(w.events)-1].event, "should be equal")
+	}
+}
@@ -12,11 +12,11 @@ require (
 	github.com/najoast/redis-go-cluster v1.0.0
 	github.com/stretchr/testify v1.8.1
 	github.com/vinllen/redis-go-cluster v1.0.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20210320140829-1e4c9ba3b0c4 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
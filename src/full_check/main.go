@@ -5,12 +5,14 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"full_check/configure"
 	"full_check/full_check"
 	"full_check/checker"
 	"full_check/client"
 	"full_check/common"
+	"full_check/store"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/gugemichael/nimo4go"
@@ -37,14 +39,25 @@ func main() {
 		}
 	}
 
-	if conf.Opts.SourceAddr == "" || conf.Opts.TargetAddr == "" {
-		fmt.Fprintf(os.Stderr, "-s, --source or -t, --target not specified\n")
-		os.Exit(1)
-	}
+	// "merge result.db.3 ..." combines the result dbs of several shards into the one given by --db
+	merge := len(args) != 0 && args[0] == full_check.MergeCommand
+	if merge {
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "usage: %s [--db=OUTPUT] result.db.N [result.db.N ...]\n", full_check.MergeCommand)
+			os.Exit(1)
+		}
+	} else {
+		// the export has no target and the source file replaces the source
+		if conf.Opts.SourceAddr == "" && conf.Opts.SourceFile == "" && conf.Opts.Fixture == "" ||
+			len(conf.Opts.TargetAddr) == 0 && conf.Opts.Export == "" {
+			fmt.Fprintf(os.Stderr, "-s, --source or -t, --target not specified\n")
+			os.Exit(1)
+		}
 
-	if len(args) != 0 {
-		fmt.Fprintf(os.Stderr, "unexpected args %+v", args)
-		os.Exit(1)
+		if len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "unexpected args %+v", args)
+			os.Exit(1)
+		}
 	}
 
 	// init log
@@ -64,6 +77,13 @@ func main() {
 	common.Logger.Info("init log success")
 	defer common.Logger.Flush()
 
+	if merge {
+		if err := full_check.Merge(conf.Opts.ResultDBFile, args[1:]); err != nil {
+			panic(common.Logger.Errorf("merge result dbs failed: %v", err))
+		}
+		return
+	}
+
 	compareCount, err := strconv.Atoi(conf.Opts.CompareTimes)
 	if err != nil || compareCount < 1 {
 		panic(common.Logger.Errorf("invalid option cmpcount %s, expect int >=1", conf.Opts.CompareTimes))
@@ -79,6 +99,16 @@ func main() {
 	if parallel < 1 || parallel > 100 {
 		panic(common.Logger.Errorf("invalid option parallel %d, expect 1<=parallel<=100", conf.Opts.Parallel))
 	}
+	if conf.Opts.PoolSize < 0 || conf.Opts.PoolSize != 0 && conf.Opts.PoolSize <= parallel {
+		panic(common.Logger.Errorf("invalid option poolsize %d, expect 0 or poolsize>parallel", conf.Opts.PoolSize))
+	}
+	if conf.Opts.PoolIdleTimeout < 0 || conf.Opts.PoolHealthCheck < 0 {
+		panic(common.Logger.Errorf("invalid option poolidletimeout %d or poolhealthcheck %d, expect int >=0",
+			conf.Opts.PoolIdleTimeout, conf.Opts.PoolHealthCheck))
+	}
+	// keep the connections of all goroutines and the scan when unlimited
+	pool := client.NewPoolOptions(conf.Opts.PoolSize, parallel+2,
+		time.Duration(conf.Opts.PoolIdleTimeout)*time.Second, time.Duration(conf.Opts.PoolHealthCheck)*time.Second)
 	qps := conf.Opts.Qps
 	if qps < 1 || qps > 5000000 {
 		panic(common.Logger.Errorf("invalid option qps %d, expect 1<=qps<=5000000", conf.Opts.Qps))
@@ -86,12 +116,43 @@ func main() {
 	if conf.Opts.SourceAuthType != "auth" && conf.Opts.SourceAuthType != "adminauth" {
 		panic(common.Logger.Errorf("invalid sourceauthtype %s, expect auth/adminauth", conf.Opts.SourceAuthType))
 	}
-	if conf.Opts.TargetAuthType != "auth" && conf.Opts.TargetAuthType != "adminauth" {
-		panic(common.Logger.Errorf("invalid targetauthtype %s, expect auth/adminauth", conf.Opts.TargetAuthType))
+	for _, authType := range conf.Opts.TargetAuthType {
+		if authType != "auth" && authType != "adminauth" {
+			panic(common.Logger.Errorf("invalid targetauthtype %s, expect auth/adminauth", authType))
+		}
+	}
+	targetCount := len(conf.Opts.TargetAddr)
+	for name, count := range map[string]int{
+		"targetpassword": len(conf.Opts.TargetPassword),
+		"targetauthtype": len(conf.Opts.TargetAuthType),
+		"targetdbtype":   len(conf.Opts.TargetDBType),
+	} {
+		if count > 1 && count != targetCount {
+			panic(common.Logger.Errorf("%s is given %d times for %d targets, expect once for all targets or once "+
+				"per target", name, count, targetCount))
+		}
 	}
 	if conf.Opts.CompareMode < full_check.FullValue || conf.Opts.CompareMode > full_check.FullValueWithOutline {
 		panic(common.Logger.Errorf("invalid compare mode %d", conf.Opts.CompareMode))
 	}
+	typeCompareMode, err := checker.ParseTypeCompareMode(conf.Opts.CompareModeType)
+	if err != nil {
+		panic(common.Logger.Errorf("invalid compare mode type: %v", err))
+	}
+	for _, mode := range typeCompareMode {
+		if mode < full_check.FullValue || mode > full_check.FullValueWithOutline {
+			panic(common.Logger.Errorf("invalid compare mode %d in compare mode type", mode))
+		}
+	}
+	prefixCompareMode, err := checker.ParsePrefixCompareMode(conf.Opts.CompareModePrefix)
+	if err != nil {
+		panic(common.Logger.Errorf("invalid compare mode prefix: %v", err))
+	}
+	for _, rule := range prefixCompareMode {
+		if rule.Mode < full_check.FullValue || rule.Mode > full_check.FullValueWithOutline {
+			panic(common.Logger.Errorf("invalid compare mode %d in compare mode prefix", rule.Mode))
+		}
+	}
 	if conf.Opts.BigKeyThreshold < 0 {
 		panic(common.Logger.Errorf("invalid big key threshold: %d", conf.Opts.BigKeyThreshold))
 	} else if conf.Opts.BigKeyThreshold == 0 {
@@ -100,41 +161,348 @@ func main() {
 		common.BigKeyThreshold = conf.Opts.BigKeyThreshold
 	}
 
-	sourceAddressList, err := client.HandleAddress(conf.Opts.SourceAddr, conf.Opts.SourcePassword, conf.Opts.SourceAuthType)
-	if err != nil {
-		panic(common.Logger.Errorf("source address[%v] illegal[%v]", conf.Opts.SourceAddr, err))
-	} else if len(sourceAddressList) > 1 && conf.Opts.SourceDBType != 1 {
-		panic(common.Logger.Errorf("looks like the source is cluster? please set sourcedbtype"))
-	} else if len(sourceAddressList) == 0 {
-		panic(common.Logger.Errorf("input source address is empty"))
+	// the source address of a source file is read from the file, a fixture has no source
+	var sourceAddressList []string
+	if conf.Opts.SourceFile == "" && conf.Opts.Fixture == "" {
+		sourceAddressList, err = client.HandleAddress(conf.Opts.SourceAddr, conf.Opts.SourcePassword, conf.Opts.SourceAuthType)
+		if err != nil {
+			panic(common.Logger.Errorf("source address[%v] illegal[%v]", conf.Opts.SourceAddr, err))
+		} else if len(sourceAddressList) > 1 && conf.Opts.SourceDBType != 1 {
+			panic(common.Logger.Errorf("looks like the source is cluster? please set sourcedbtype"))
+		} else if len(sourceAddressList) == 0 {
+			panic(common.Logger.Errorf("input source address is empty"))
+		}
 	}
 
-	targetAddressList, err := client.HandleAddress(conf.Opts.TargetAddr, conf.Opts.TargetPassword, conf.Opts.TargetAuthType)
-	if err != nil {
-		panic(common.Logger.Errorf("target address[%v] illegal[%v]", conf.Opts.TargetAddr, err))
-	} else if len(targetAddressList) > 1 && conf.Opts.TargetDBType != 1 {
-		panic(common.Logger.Errorf("looks like the target is cluster? please set targetdbtype"))
-	} else if len(targetAddressList) == 0 {
-		panic(common.Logger.Errorf("input target address is empty"))
+	targetHosts := make([]client.RedisHost, targetCount)
+	for i, targetAddr := range conf.Opts.TargetAddr {
+		targetHosts[i] = client.RedisHost{
+			Password:     targetOption(conf.Opts.TargetPassword, i, ""),
+			TimeoutMs:    0,
+			Role:         client.RoleTarget,
+			Authtype:     targetOption(conf.Opts.TargetAuthType, i, "auth"),
+			DBType:       targetIntOption(conf.Opts.TargetDBType, i, common.TypeDB),
+			DBFilterList: common.FilterDBList(conf.Opts.TargetDBFilterList),
+		}
+
+		targetAddressList, err := client.HandleAddress(targetAddr, targetHosts[i].Password, targetHosts[i].Authtype)
+		if err != nil {
+			panic(common.Logger.Errorf("target address[%v] illegal[%v]", targetAddr, err))
+		} else if len(targetAddressList) > 1 && targetHosts[i].DBType != 1 {
+			panic(common.Logger.Errorf("looks like the target[%v] is cluster? please set targetdbtype", targetAddr))
+		} else if len(targetAddressList) == 0 {
+			panic(common.Logger.Errorf("input target address is empty"))
+		}
+		targetHosts[i].Addr = targetAddressList
 	}
 
 	// filter list
-	var filterTree *common.Trie
-	if len(conf.Opts.FilterList) != 0 {
-		filterTree = common.NewTrie()
-		filterList := strings.Split(conf.Opts.FilterList, "|")
-		for _, filter := range filterList {
-			if filter == "" {
-				panic(common.Logger.Errorf("invalid input filter list: %v", filterList))
+	var filter *common.KeyFilter
+	if len(conf.Opts.FilterList) != 0 || len(conf.Opts.Include) != 0 || len(conf.Opts.Exclude) != 0 ||
+		len(conf.Opts.IncludeType) != 0 || len(conf.Opts.ExcludeType) != 0 {
+		filter = common.NewKeyFilter()
+		if len(conf.Opts.FilterList) != 0 {
+			filterList := strings.Split(conf.Opts.FilterList, "|")
+			for _, element := range filterList {
+				if element == "" {
+					panic(common.Logger.Errorf("invalid input filter list: %v", filterList))
+				}
+				filter.AddPrefix(element)
+			}
+		}
+		for _, rule := range conf.Opts.Include {
+			if err := filter.AddInclude(rule); err != nil {
+				panic(common.Logger.Errorf("invalid include rule[%v]: %v", rule, err))
+			}
+		}
+		for _, rule := range conf.Opts.Exclude {
+			if err := filter.AddExclude(rule); err != nil {
+				panic(common.Logger.Errorf("invalid exclude rule[%v]: %v", rule, err))
+			}
+		}
+		if len(conf.Opts.IncludeType) != 0 {
+			for _, tp := range strings.Split(conf.Opts.IncludeType, common.Splitter) {
+				if err := filter.AddIncludeType(tp); err != nil {
+					panic(common.Logger.Errorf("invalid include type list[%v]: %v", conf.Opts.IncludeType, err))
+				}
+			}
+		}
+		if len(conf.Opts.ExcludeType) != 0 {
+			for _, tp := range strings.Split(conf.Opts.ExcludeType, common.Splitter) {
+				if err := filter.AddExcludeType(tp); err != nil {
+					panic(common.Logger.Errorf("invalid exclude type list[%v]: %v", conf.Opts.ExcludeType, err))
+				}
 			}
-			filterTree.Insert([]byte(filter))
 		}
-		common.Logger.Infof("filter list enabled: %v", filterList)
+		common.Logger.Infof("filter enabled: %v", filter)
 	}
 
-	// remove result file if has
-	if len(conf.Opts.ResultFile) > 0 {
-		os.Remove(conf.Opts.ResultFile)
+	// db mapping
+	dbMapping, err := common.ParseDBMapping(conf.Opts.DBMapping)
+	if err != nil {
+		panic(common.Logger.Errorf("invalid db mapping: %v", err))
+	}
+	for _, targetHost := range targetHosts {
+		if targetHost.IsCluster() {
+			for source, target := range dbMapping {
+				if target != 0 {
+					panic(common.Logger.Errorf("target is cluster, source db %d can't be mapped to db %d", source, target))
+				}
+			}
+		}
+	}
+	if len(conf.Opts.TargetDBPrefix) != 0 && !strings.Contains(conf.Opts.TargetDBPrefix, common.DBPlaceholder) {
+		panic(common.Logger.Errorf("invalid target db prefix[%v], should contain %v", conf.Opts.TargetDBPrefix,
+			common.DBPlaceholder))
+	}
+
+	// key map
+	var keyMapper *common.KeyMapper
+	if len(conf.Opts.KeyMap) != 0 {
+		keyMapper = common.NewKeyMapper()
+		for _, rule := range conf.Opts.KeyMap {
+			if err := keyMapper.AddRule(rule); err != nil {
+				panic(common.Logger.Errorf("invalid key map rule: %v", err))
+			}
+		}
+	}
+
+	// sample
+	sample := checker.SampleParameter{
+		Rate:       conf.Opts.SampleRate,
+		Count:      conf.Opts.SampleCount,
+		Method:     conf.Opts.SampleMethod,
+		Seed:       conf.Opts.SampleSeed,
+		Confidence: conf.Opts.SampleConfidence,
+	}
+	if sample.Rate < 0 || sample.Rate > 1 {
+		panic(common.Logger.Errorf("invalid option samplerate %v, expect 0<samplerate<=1", sample.Rate))
+	}
+	if sample.Count < 0 {
+		panic(common.Logger.Errorf("invalid option samplecount %d, expect int >=0", sample.Count))
+	}
+	if sample.Rate > 0 && sample.Count > 0 {
+		panic(common.Logger.Errorf("samplerate and samplecount can't be given at the same time"))
+	}
+	if sample.Method != full_check.SampleHash && sample.Method != full_check.SampleRandomKey {
+		panic(common.Logger.Errorf("invalid option samplemethod %s, expect %s/%s", sample.Method,
+			full_check.SampleHash, full_check.SampleRandomKey))
+	}
+	if sample.Confidence <= 0 || sample.Confidence >= 1 {
+		panic(common.Logger.Errorf("invalid option sampleconfidence %v, expect 0<sampleconfidence<1",
+			sample.Confidence))
+	}
+
+	// shard
+	var shard *common.Shard
+	if len(conf.Opts.Shard) != 0 {
+		if shard, err = common.ParseShard(conf.Opts.Shard, conf.Opts.ShardBy); err != nil {
+			panic(common.Logger.Errorf("invalid shard: %v", err))
+		}
+	}
+
+	// merkle
+	merkle := checker.MerkleParameter{
+		Enable:     conf.Opts.Merkle,
+		SubBuckets: conf.Opts.MerkleBuckets,
+		Digest:     conf.Opts.MerkleDigest,
+		BaseFile:   conf.Opts.MerkleBase,
+	}
+	if merkle.Enable {
+		if merkle.SubBuckets < 1 || merkle.SubBuckets > 1024 {
+			panic(common.Logger.Errorf("invalid option merklebuckets %d, expect 1<=merklebuckets<=1024", merkle.SubBuckets))
+		}
+		if merkle.Digest != common.DigestDump && merkle.Digest != common.DigestDebug {
+			panic(common.Logger.Errorf("invalid option merkledigest %s, expect %s/%s", merkle.Digest,
+				common.DigestDump, common.DigestDebug))
+		}
+		if len(merkle.BaseFile) != 0 {
+			if _, err := os.Stat(merkle.BaseFile); err != nil {
+				panic(common.Logger.Errorf("invalid option merklebase: %v", err))
+			}
+			// result dbs are removed before the run
+			if strings.HasPrefix(merkle.BaseFile, conf.Opts.ResultDBFile+".") {
+				panic(common.Logger.Errorf("merklebase %v is overwritten by this run, copy it first", merkle.BaseFile))
+			}
+		}
+	}
+
+	// daemon
+	daemon := checker.DaemonParameter{
+		Enable:        conf.Opts.Daemon,
+		Source:        conf.Opts.DaemonSource,
+		SettleDelayMs: conf.Opts.SettleDelay,
+		RecheckSecond: conf.Opts.LiveRecheck,
+	}
+	if daemon.Enable {
+		switch {
+		case daemon.Source == full_check.DaemonNotify,
+			strings.HasPrefix(daemon.Source, full_check.DaemonFilePrefix),
+			strings.HasPrefix(daemon.Source, full_check.DaemonUnixPrefix),
+			strings.HasPrefix(daemon.Source, full_check.DaemonTcpPrefix):
+		default:
+			panic(common.Logger.Errorf("invalid option daemonsource %s, expect %s, %sPATH, %sPATH or %sADDR",
+				daemon.Source, full_check.DaemonNotify, full_check.DaemonFilePrefix, full_check.DaemonUnixPrefix,
+				full_check.DaemonTcpPrefix))
+		}
+		if daemon.SettleDelayMs < 0 {
+			panic(common.Logger.Errorf("invalid option settledelay %d, expect int >=0", daemon.SettleDelayMs))
+		}
+		if daemon.RecheckSecond < 0 {
+			panic(common.Logger.Errorf("invalid option liverecheck %d, expect int >=0", daemon.RecheckSecond))
+		}
+	}
+
+	// psync
+	psync := checker.PsyncParameter{
+		Enable: conf.Opts.Psync,
+		Follow: conf.Opts.PsyncFollow,
+	}
+
+	// export and source file
+	export := checker.ExportParameter{
+		File:   conf.Opts.Export,
+		Digest: conf.Opts.ExportDigest,
+	}
+	sourceFile := checker.SourceFileParameter{
+		File: conf.Opts.SourceFile,
+	}
+	if sourceFile.File != "" {
+		header, err := store.ReadExportHeader(sourceFile.File)
+		if err != nil {
+			panic(common.Logger.Errorf("invalid sourcefile: %v", err))
+		}
+		sourceFile.Digest = header.Digest
+	}
+
+	// fixture
+	fixture := checker.FixtureParameter{
+		File:      conf.Opts.Fixture,
+		ExtraKeys: conf.Opts.FixtureExtraKeys,
+	}
+
+	// global qps and concurrency of each side
+	throttle := checker.ThrottleParameter{
+		SourceQps:         conf.Opts.SourceQps,
+		TargetQps:         conf.Opts.TargetQps,
+		SourceConcurrency: conf.Opts.SourceConcurrency,
+		TargetConcurrency: conf.Opts.TargetConcurrency,
+	}
+	if throttle.SourceQps < 0 || throttle.TargetQps < 0 || throttle.SourceConcurrency < 0 ||
+		throttle.TargetConcurrency < 0 {
+		panic(common.Logger.Errorf("invalid option sourceqps %d, targetqps %d, sourceconcurrency %d or "+
+			"targetconcurrency %d, expect int >=0", throttle.SourceQps, throttle.TargetQps,
+			throttle.SourceConcurrency, throttle.TargetConcurrency))
+	}
+
+	// adaptive qps limit
+	adaptive := checker.AdaptiveParameter{
+		MaxLatencyMs: conf.Opts.AdaptiveLatency,
+		MaxOps:       conf.Opts.AdaptiveOps,
+		MaxCpu:       conf.Opts.AdaptiveCpu,
+		MinQps:       conf.Opts.AdaptiveMinQps,
+	}
+	if adaptive.MaxLatencyMs < 0 || adaptive.MaxOps < 0 || adaptive.MaxCpu < 0 {
+		panic(common.Logger.Errorf("invalid option adaptivelatency %d, adaptiveops %d or adaptivecpu %v, expect >=0",
+			adaptive.MaxLatencyMs, adaptive.MaxOps, adaptive.MaxCpu))
+	}
+	// the global source qps is adjusted when it's given
+	maxQps := qps
+	if throttle.SourceQps > 0 {
+		maxQps = throttle.SourceQps
+	}
+	if adaptive.MinQps < 1 || adaptive.MinQps > maxQps {
+		panic(common.Logger.Errorf("invalid option adaptiveminqps %d, expect 1<=adaptiveminqps<=%d", adaptive.MinQps,
+			maxQps))
+	}
+
+	// byte rates
+	var bandwidth checker.BandwidthParameter
+	if bandwidth.Source, err = common.ParseBytes(conf.Opts.SourceByteRate); err != nil {
+		panic(common.Logger.Errorf("invalid option sourcebyterate: %v", err))
+	}
+	if bandwidth.Target, err = common.ParseBytes(conf.Opts.TargetByteRate); err != nil {
+		panic(common.Logger.Errorf("invalid option targetbyterate: %v", err))
+	}
+
+	// replica read of the source
+	sourceReplica := checker.SourceReplicaParameter{
+		Enable:        conf.Opts.SourceReplica,
+		MaxLagSeconds: conf.Opts.MaxReplicaLag,
+	}
+	if sourceReplica.Enable {
+		if sourceReplica.MaxLagSeconds < 0 {
+			panic(common.Logger.Errorf("invalid option maxreplicalag %d, expect int >=0", sourceReplica.MaxLagSeconds))
+		}
+	}
+
+	// wait for the target before each round
+	roundWait := checker.RoundWaitParameter{
+		Source:         conf.Opts.RoundWait,
+		TimeoutSeconds: conf.Opts.RoundWaitTimeout,
+	}
+	if roundWait.Source != "" {
+		if roundWait.Source != full_check.RoundWaitReplica &&
+			!strings.HasPrefix(roundWait.Source, full_check.RoundWaitFilePrefix) &&
+			!strings.HasPrefix(roundWait.Source, "http://") && !strings.HasPrefix(roundWait.Source, "https://") {
+			panic(common.Logger.Errorf("invalid option roundwait %s, expect %s, %sPATH or an http(s) url",
+				roundWait.Source, full_check.RoundWaitReplica, full_check.RoundWaitFilePrefix))
+		}
+		if roundWait.TimeoutSeconds < 0 {
+			panic(common.Logger.Errorf("invalid option roundwaittimeout %d, expect int >=0", roundWait.TimeoutSeconds))
+		}
+	}
+
+	// topology changes of the cluster source
+	topology := checker.TopologyParameter{
+		PollSeconds: conf.Opts.TopologyPoll,
+	}
+	if topology.PollSeconds < 0 {
+		panic(common.Logger.Errorf("invalid option topologypoll %d, expect int >=0", topology.PollSeconds))
+	}
+
+	// time-boxed run
+	timeBox := checker.TimeBoxParameter{
+		MaxSeconds: conf.Opts.MaxDuration,
+		Resume:     conf.Opts.Resume,
+	}
+	if timeBox.MaxSeconds < 0 {
+		panic(common.Logger.Errorf("invalid option maxduration %d, expect int >=0", timeBox.MaxSeconds))
+	}
+	if timeBox.Resume {
+		if _, err := os.Stat(conf.Opts.ResultDBFile + "." + strconv.Itoa(compareCount)); err != nil {
+			panic(common.Logger.Errorf("no result db to resume: %v", err))
+		}
+	}
+
+	// hot keys first
+	hotKey := checker.HotKeyParameter{
+		Source: conf.Opts.HotKeys,
+		Window: conf.Opts.HotKeyWindow,
+	}
+	if hotKey.Source != "" {
+		if strings.HasPrefix(hotKey.Source, full_check.HotKeyFilePrefix) {
+			if hotKey.Keys, err = full_check.LoadHotKeys(hotKey.Source[len(full_check.HotKeyFilePrefix):]); err != nil {
+				panic(common.Logger.Errorf("load hot keys failed: %v", err))
+			}
+		} else if hotKey.Source != full_check.HotKeyFreq && hotKey.Source != full_check.HotKeyIdleTime {
+			panic(common.Logger.Errorf("invalid option hotkeys %s, expect %s, %s or %sPATH", hotKey.Source,
+				full_check.HotKeyFreq, full_check.HotKeyIdleTime, full_check.HotKeyFilePrefix))
+		}
+		if hotKey.Window < 1 {
+			panic(common.Logger.Errorf("invalid option hotkeywindow %d, expect int >=1", hotKey.Window))
+		}
+	}
+
+	// re-check the conflicts
+	confirm := checker.ConfirmParameter{
+		Enable:  conf.Opts.Confirm,
+		DelayMs: conf.Opts.ConfirmDelay,
+	}
+	if confirm.Enable {
+		if confirm.DelayMs < 0 {
+			panic(common.Logger.Errorf("invalid option confirmdelay %d, expect int >=0", confirm.DelayMs))
+		}
 	}
 
 	fullCheckParameter := checker.FullCheckParameter{
@@ -142,31 +510,88 @@ func main() {
 			Addr:         sourceAddressList,
 			Password:     conf.Opts.SourcePassword,
 			TimeoutMs:    0,
-			Role:         "source",
+			Role:         client.RoleSource,
 			Authtype:     conf.Opts.SourceAuthType,
 			DBType:       conf.Opts.SourceDBType,
 			DBFilterList: common.FilterDBList(conf.Opts.SourceDBFilterList),
 		},
-		TargetHost: client.RedisHost{
-			Addr:         targetAddressList,
-			Password:     conf.Opts.TargetPassword,
-			TimeoutMs:    0,
-			Role:         "target",
-			Authtype:     conf.Opts.TargetAuthType,
-			DBType:       conf.Opts.TargetDBType,
-			DBFilterList: common.FilterDBList(conf.Opts.TargetDBFilterList),
-		},
+		TargetHosts:  targetHosts,
 		ResultDBFile: conf.Opts.ResultDBFile,
 		CompareCount: compareCount,
 		Interval:     conf.Opts.Interval,
 		BatchCount:   batchCount,
 		Parallel:     parallel,
-		FilterTree:   filterTree,
+		Filter:       filter,
+		KeyMapper:    keyMapper,
+		DBMapping:    dbMapping,
+
+		TargetDBPrefix: conf.Opts.TargetDBPrefix,
+
+		TypeCompareMode:   typeCompareMode,
+		PrefixCompareMode: prefixCompareMode,
+
+		Sample: sample,
+		Shard:  shard,
+		Merkle: merkle,
+		Daemon: daemon,
+		Psync:  psync,
+
+		Export:     export,
+		SourceFile: sourceFile,
+		Fixture:    fixture,
+
+		Pool:      pool,
+		Adaptive:  adaptive,
+		Bandwidth: bandwidth,
+		Throttle:  throttle,
+
+		SourceReplica: sourceReplica,
+		RoundWait:     roundWait,
+		Topology:      topology,
+		TimeBox:       timeBox,
+		HotKey:        hotKey,
+		Confirm:       confirm,
+	}
+
+	if err := full_check.CheckModes(&fullCheckParameter); err != nil {
+		panic(common.Logger.Errorf("invalid options: %v", err))
+	}
+
+	// remove result file if has
+	if len(conf.Opts.ResultFile) > 0 && !timeBox.Resume {
+		os.Remove(conf.Opts.ResultFile)
 	}
 
 	common.Logger.Info("configuration: ", conf.Opts)
 	common.Logger.Info("---------")
 
 	fullCheck := full_check.NewFullCheck(fullCheckParameter, full_check.CheckType(conf.Opts.CompareMode))
-	fullCheck.Start()
+	if daemon.Enable {
+		fullCheck.StartDaemon()
+	} else if export.File != "" {
+		fullCheck.StartExport()
+	} else {
+		fullCheck.Start()
+	}
+}
+
+// targetOption returns the option of the i-th target, given once for all targets, once per target or not at all.
+func targetOption(values []string, i int, defaultValue string) string {
+	switch len(values) {
+	case 0:
+		return defaultValue
+	case 1:
+		return values[0]
+	}
+	return values[i]
+}
+
+func targetIntOption(values []int, i int, defaultValue int) int {
+	switch len(values) {
+	case 0:
+		return defaultValue
+	case 1:
+		return values[0]
+	}
+	return values[i]
 }
@@ -1,5 +1,10 @@
 package metric
 
+import (
+	"fmt"
+	"time"
+)
+
 type Metric struct {
 	DateTime           string                             `json:"datetime"`
 	Timestamp          int64                              `json:"timestamp"`
@@ -13,15 +18,126 @@ type Metric struct {
 	OneCompareFinished bool                               `json:"has_finished"`
 	AllFinished        bool                               `json:"all_finished"`
 	KeyScan            *CounterStat                       `json:"key_scan"`
+	FalsePositive      *CounterStat                       `json:"false_positive,omitempty"`
 	TotalConflict      int64                              `json:"total_conflict"`
 	TotalKeyConflict   int64                              `json:"total_key_conflict"`
 	TotalFieldConflict int64                              `json:"total_field_conflict"`
 	KeyMetric          map[string]map[string]*CounterStat `json:"key_stat"`
 	FieldMetric        map[string]map[string]*CounterStat `json:"field_stat"`
+	Pools              []*PoolStat                        `json:"pools"`
+	Adaptive           *AdaptiveStat                      `json:"adaptive,omitempty"`
+	Bandwidth          []*BandwidthStat                   `json:"bandwidth,omitempty"`
+	Throttle           []*ThrottleStat                    `json:"throttle,omitempty"`
+	Progress           *ProgressStat                      `json:"progress,omitempty"`
+	Targets            []*TargetMetric                    `json:"targets,omitempty"`
+	SkippedEstimate    map[string]int64                   `json:"skipped_estimate,omitempty"`
+}
+
+// TargetMetric holds the key and field stat of one of several targets, instead of key_stat and field_stat.
+type TargetMetric struct {
+	Target             string                             `json:"target"`
+	TotalKeyConflict   int64                              `json:"total_key_conflict"`
+	TotalFieldConflict int64                              `json:"total_field_conflict"`
+	KeyMetric          map[string]map[string]*CounterStat `json:"key_stat"`
+	FieldMetric        map[string]map[string]*CounterStat `json:"field_stat"`
 }
 
 type MetricItem struct {
 	Type     string       `json:"type"`
 	Conflict string       `json:"conflict"`
 	Stat     *CounterStat `json:"stat"`
-}
\ No newline at end of file
+}
+
+// LiveMetric is printed periodically in the daemon mode.
+type LiveMetric struct {
+	DateTime     string                             `json:"datetime"`
+	Timestamp    int64                              `json:"timestamp"`
+	Id           string                             `json:"id"`
+	JobId        string                             `json:"jobid"`
+	TaskId       string                             `json:"taskid"`
+	KeyChanged   *CounterStat                       `json:"key_changed"`
+	KeyVerified  *CounterStat                       `json:"key_verified"`
+	Pending      int64                              `json:"pending"`
+	LiveConflict int64                              `json:"live_conflict"`
+	KeyMetric    map[string]map[string]*CounterStat `json:"key_stat"`
+	Pools        []*PoolStat                        `json:"pools"`
+	Adaptive     *AdaptiveStat                      `json:"adaptive,omitempty"`
+	Bandwidth    []*BandwidthStat                   `json:"bandwidth,omitempty"`
+	Throttle     []*ThrottleStat                    `json:"throttle,omitempty"`
+}
+
+// PoolStat is the statistics of the connection pool of one host.
+type PoolStat struct {
+	Host      string `json:"host"`
+	Active    int    `json:"active"` // idle and in use
+	Idle      int    `json:"idle"`
+	WaitCount int64  `json:"wait_count"`
+	WaitMs    int64  `json:"wait_ms"`
+	Dials     int64  `json:"dials"`
+	Unhealthy int64  `json:"unhealthy"` // dropped by the health check
+}
+
+func (p *PoolStat) String() string {
+	return fmt.Sprintf("active:%d,idle:%d,wait:%d(%dms),dials:%d,unhealthy:%d", p.Active, p.Idle, p.WaitCount,
+		p.WaitMs, p.Dials, p.Unhealthy)
+}
+
+// AdaptiveStat is the latest load sample of the source and the qps limit adjusted by it.
+type AdaptiveStat struct {
+	Limit       int     `json:"limit"`
+	P99Ms       int64   `json:"p99_ms"`
+	Ops         int64   `json:"ops"`
+	Cpu         float64 `json:"cpu"` // percent
+	Adjustments int64   `json:"adjustments"`
+}
+
+func (p *AdaptiveStat) String() string {
+	return fmt.Sprintf("limit:%d,p99:%dms,ops:%d,cpu:%.1f%%,adjustments:%d", p.Limit, p.P99Ms, p.Ops, p.Cpu,
+		p.Adjustments)
+}
+
+// BandwidthStat is the bytes read from one side and the time throttled by its byte rate.
+type BandwidthStat struct {
+	Role   string `json:"role"`
+	Limit  int64  `json:"limit"` // bytes per second
+	Bytes  int64  `json:"bytes"`
+	WaitMs int64  `json:"wait_ms"`
+}
+
+func (p *BandwidthStat) String() string {
+	return fmt.Sprintf("limit:%dB/s,bytes:%d,wait:%dms", p.Limit, p.Bytes, p.WaitMs)
+}
+
+// ThrottleStat is the requests sent to one side and the time waited for its qps and concurrency limits.
+type ThrottleStat struct {
+	Role        string `json:"role"`
+	Qps         int    `json:"qps"`         // 0 means unlimited
+	Concurrency int    `json:"concurrency"` // 0 means unlimited
+	Requests    int64  `json:"requests"`
+	WaitMs      int64  `json:"wait_ms"`
+}
+
+func (p *ThrottleStat) String() string {
+	return fmt.Sprintf("qps:%d,concurrency:%d,requests:%d,wait:%dms", p.Qps, p.Concurrency, p.Requests, p.WaitMs)
+}
+
+// ProgressStat is the progress of the current round in source keys and the estimated time left.
+type ProgressStat struct {
+	Done            int64 `json:"done"`
+	Total           int64 `json:"total"`             // 0 means unknown
+	Speed           int64 `json:"speed"`             // per second over the last minute
+	EtaSeconds      int64 `json:"eta_seconds"`       // of the current round, -1 means unknown
+	TotalEtaSeconds int64 `json:"total_eta_seconds"` // of all rounds, -1 means unknown
+}
+
+func (p *ProgressStat) String() string {
+	return fmt.Sprintf("done:%d/%d,speed:%d,eta:%s,total_eta:%s", p.Done, p.Total, p.Speed, eta(p.EtaSeconds),
+		eta(p.TotalEtaSeconds))
+}
+
+func eta(seconds int64) string {
+	if seconds < 0 {
+		return "unknown"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
@@ -0,0 +1,47 @@
+package metric
+
+import (
+	"math"
+)
+
+// SampleStat is the estimated inconsistency rate of one data type in the sampling mode.
+type SampleStat struct {
+	Type     string  `json:"type"`
+	Checked  int64   `json:"checked"`
+	Conflict int64   `json:"conflict"`
+	Rate     float64 `json:"rate"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+}
+
+func NewSampleStat(tp string, checked, conflict int64, confidence float64) *SampleStat {
+	stat := &SampleStat{
+		Type:     tp,
+		Checked:  checked,
+		Conflict: conflict,
+	}
+	if checked > 0 {
+		stat.Rate = float64(conflict) / float64(checked)
+	}
+	stat.Low, stat.High = WilsonInterval(conflict, checked, confidence)
+	return stat
+}
+
+/*
+ * WilsonInterval returns the Wilson score interval of the proportion success/total at the given
+ * confidence level, e.g., 0.95. Unlike the normal approximation it behaves well when the proportion
+ * is close to 0, which is the common case for inconsistency rates.
+ */
+func WilsonInterval(success, total int64, confidence float64) (float64, float64) {
+	if total <= 0 {
+		return 0, 1
+	}
+
+	z := math.Sqrt2 * math.Erfinv(confidence)
+	n := float64(total)
+	phat := float64(success) / n
+	denominator := 1 + z*z/n
+	center := (phat + z*z/(2*n)) / denominator
+	margin := z * math.Sqrt(phat*(1-phat)/n+z*z/(4*n*n)) / denominator
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
@@ -0,0 +1,44 @@
+package metric
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWilsonInterval(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestWilsonInterval case %d.\n", nr)
+
+		low, high := WilsonInterval(0, 0, 0.95)
+		assert.Equal(t, float64(0), low, "should be equal")
+		assert.Equal(t, float64(1), high, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestWilsonInterval case %d.\n", nr)
+
+		// 10 conflicts out of 100 keys: [0.0552, 0.1744] at 95%
+		low, high := WilsonInterval(10, 100, 0.95)
+		assert.InDelta(t, 0.0552, low, 0.0001, "should be equal")
+		assert.InDelta(t, 0.1744, high, 0.0001, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestWilsonInterval case %d.\n", nr)
+
+		// no conflict still gives an upper bound
+		low, high := WilsonInterval(0, 1000, 0.95)
+		assert.Equal(t, float64(0), low, "should be equal")
+		assert.InDelta(t, 0.0038, high, 0.0001, "should be equal")
+
+		stat := NewSampleStat("hash", 1000, 0, 0.95)
+		assert.Equal(t, float64(0), stat.Rate, "should be equal")
+		assert.Equal(t, high, stat.High, "should be equal")
+	}
+}
@@ -4,17 +4,62 @@ import (
 	"full_check/common"
 )
 
-type Stat struct {
-	Scan          AtomicSpeedCounter
+// Conflicts counts the keys and fields by type and conflict.
+type Conflicts struct {
 	ConflictField [common.EndKeyTypeIndex][common.EndConflict]AtomicSpeedCounter
 	ConflictKey   [common.EndKeyTypeIndex][common.EndConflict]AtomicSpeedCounter
 
 	TotalConflictFields int64
-	TotalConflictKeys int64
+	TotalConflictKeys   int64
+}
+
+type Stat struct {
+	Scan          AtomicSpeedCounter
+	FalsePositive AtomicSpeedCounter // conflict keys found equal when read again
+	Conflicts
+
+	TotalFalsePositives int64
+
+	// the keys and fields counted again by target when compared with several targets
+	Targets []Conflicts
+}
+
+// IncrKey counts the key compared with the target.
+func (p *Stat) IncrKey(keyType common.KeyTypeIndex, conType common.ConflictType, target int, n int) {
+	p.ConflictKey[keyType][conType].Inc(n)
+	if target < len(p.Targets) {
+		p.Targets[target].ConflictKey[keyType][conType].Inc(n)
+	}
+}
+
+// IncrField counts the field compared with the target.
+func (p *Stat) IncrField(keyType common.KeyTypeIndex, conType common.ConflictType, target int, n int) {
+	p.ConflictField[keyType][conType].Inc(n)
+	if target < len(p.Targets) {
+		p.Targets[target].ConflictField[keyType][conType].Inc(n)
+	}
 }
 
 func (p *Stat) Rotate() {
 	p.Scan.Rotate()
+	p.FalsePositive.Rotate()
+	p.Conflicts.Rotate()
+	for i := range p.Targets {
+		p.Targets[i].Rotate()
+	}
+}
+
+func (p *Stat) Reset(clear bool) {
+	p.Scan.Reset()
+	p.TotalFalsePositives += p.FalsePositive.Total()
+	p.FalsePositive.Reset()
+	p.Conflicts.Reset(clear)
+	for i := range p.Targets {
+		p.Targets[i].Reset(clear)
+	}
+}
+
+func (p *Conflicts) Rotate() {
 	for keyType := common.KeyTypeIndex(0); keyType < common.EndKeyTypeIndex; keyType++ {
 		for conType := common.ConflictType(0); conType < common.EndConflict; conType++ {
 			p.ConflictField[keyType][conType].Rotate()
@@ -23,8 +68,7 @@ func (p *Stat) Rotate() {
 	}
 }
 
-func (p *Stat) Reset(clear bool) {
-	p.Scan.Reset()
+func (p *Conflicts) Reset(clear bool) {
 	if clear {
 		p.TotalConflictFields = 0
 		p.TotalConflictKeys = 0
@@ -49,4 +93,4 @@ func (p *Stat) Reset(clear bool) {
 			p.ConflictKey[keyType][conType].Reset()
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,307 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+var errTruncated = fmt.Errorf("truncated encoded value")
+
+func lzfDecompress(input []byte, length int) ([]byte, error) {
+	output := make([]byte, 0, length)
+	for i := 0; i < len(input); {
+		ctrl := int(input[i])
+		i++
+		if ctrl < 32 {
+			// literal run
+			ctrl++
+			if i+ctrl > len(input) {
+				return nil, errTruncated
+			}
+			output = append(output, input[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+
+		// back reference
+		size := ctrl >> 5
+		if size == 7 {
+			if i >= len(input) {
+				return nil, errTruncated
+			}
+			size += int(input[i])
+			i++
+		}
+		if i >= len(input) {
+			return nil, errTruncated
+		}
+		ref := len(output) - (ctrl&0x1F)<<8 - int(input[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("invalid lzf back reference")
+		}
+		for j := 0; j < size+2; j++ {
+			output = append(output, output[ref+j])
+		}
+	}
+	if len(output) != length {
+		return nil, fmt.Errorf("lzf length %d != %d", len(output), length)
+	}
+	return output, nil
+}
+
+func parseZiplist(data []byte) ([][]byte, error) {
+	if len(data) < 11 {
+		return nil, errTruncated
+	}
+	count := int(binary.LittleEndian.Uint16(data[8:10]))
+	ret := make([][]byte, 0, count)
+	pos := 10
+	for {
+		if pos >= len(data) {
+			return nil, errTruncated
+		}
+		if data[pos] == 0xFF {
+			return ret, nil
+		}
+
+		// previous entry length
+		if data[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, errTruncated
+		}
+
+		encoding := data[pos]
+		var element []byte
+		switch encoding >> 6 {
+		case 0, 1, 2:
+			var length int
+			switch encoding >> 6 {
+			case 0:
+				length = int(encoding & 0x3F)
+				pos++
+			case 1:
+				if pos+2 > len(data) {
+					return nil, errTruncated
+				}
+				length = int(encoding&0x3F)<<8 | int(data[pos+1])
+				pos += 2
+			default:
+				if pos+5 > len(data) {
+					return nil, errTruncated
+				}
+				length = int(binary.BigEndian.Uint32(data[pos+1 : pos+5]))
+				pos += 5
+			}
+			if pos+length > len(data) {
+				return nil, errTruncated
+			}
+			element = data[pos : pos+length]
+			pos += length
+		default:
+			pos++
+			var value int64
+			var size int
+			switch encoding {
+			case 0xC0:
+				size = 2
+			case 0xD0:
+				size = 4
+			case 0xE0:
+				size = 8
+			case 0xF0:
+				size = 3
+			case 0xFE:
+				size = 1
+			default:
+				if encoding < 0xF1 || encoding > 0xFD {
+					return nil, fmt.Errorf("unknown ziplist encoding[%#x]", encoding)
+				}
+				value = int64(encoding&0x0F) - 1
+			}
+			if pos+size > len(data) {
+				return nil, errTruncated
+			}
+			if size != 0 {
+				value = littleEndianInt(data[pos : pos+size])
+			}
+			pos += size
+			element = []byte(strconv.FormatInt(value, 10))
+		}
+		ret = append(ret, element)
+	}
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(data []byte) int64 {
+	var value uint64
+	for i := len(data) - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+	shift := uint(64 - 8*len(data))
+	return int64(value<<shift) >> shift
+}
+
+func listpackBacklen(length int) int {
+	switch {
+	case length <= 127:
+		return 1
+	case length < 16383:
+		return 2
+	case length < 2097151:
+		return 3
+	case length < 268435455:
+		return 4
+	}
+	return 5
+}
+
+func parseListpack(data []byte) ([][]byte, error) {
+	if len(data) < 7 {
+		return nil, errTruncated
+	}
+	ret := make([][]byte, 0, binary.LittleEndian.Uint16(data[4:6]))
+	pos := 6
+	for {
+		if pos >= len(data) {
+			return nil, errTruncated
+		}
+		encoding := data[pos]
+		if encoding == 0xFF {
+			return ret, nil
+		}
+
+		var element []byte
+		var value int64
+		isInt := true
+		header, length := 1, 0
+		switch {
+		case encoding&0x80 == 0:
+			value = int64(encoding & 0x7F)
+		case encoding&0xC0 == 0x80:
+			isInt = false
+			length = int(encoding & 0x3F)
+		case encoding&0xE0 == 0xC0:
+			if pos+2 > len(data) {
+				return nil, errTruncated
+			}
+			value = int64(encoding&0x1F)<<8 | int64(data[pos+1])
+			if value >= 1<<12 {
+				value -= 1 << 13
+			}
+			header = 2
+		case encoding&0xF0 == 0xE0:
+			if pos+2 > len(data) {
+				return nil, errTruncated
+			}
+			isInt = false
+			length = int(encoding&0x0F)<<8 | int(data[pos+1])
+			header = 2
+		case encoding == 0xF0:
+			if pos+5 > len(data) {
+				return nil, errTruncated
+			}
+			isInt = false
+			length = int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+			header = 5
+		case encoding >= 0xF1 && encoding <= 0xF4:
+			size := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[encoding]
+			if pos+1+size > len(data) {
+				return nil, errTruncated
+			}
+			value = littleEndianInt(data[pos+1 : pos+1+size])
+			header = 1 + size
+		default:
+			return nil, fmt.Errorf("unknown listpack encoding[%#x]", encoding)
+		}
+
+		if pos+header+length > len(data) {
+			return nil, errTruncated
+		}
+		if isInt {
+			element = []byte(strconv.FormatInt(value, 10))
+		} else {
+			element = data[pos+header : pos+header+length]
+		}
+		ret = append(ret, element)
+		pos += header + length + listpackBacklen(header+length)
+	}
+}
+
+func parseIntset(data []byte) ([][]byte, error) {
+	if len(data) < 8 {
+		return nil, errTruncated
+	}
+	size := int(binary.LittleEndian.Uint32(data[0:4]))
+	count := int(binary.LittleEndian.Uint32(data[4:8]))
+	if size != 2 && size != 4 && size != 8 {
+		return nil, fmt.Errorf("invalid intset encoding[%d]", size)
+	}
+	if 8+size*count > len(data) {
+		return nil, errTruncated
+	}
+	ret := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		value := littleEndianInt(data[8+i*size : 8+(i+1)*size])
+		ret = append(ret, []byte(strconv.FormatInt(value, 10)))
+	}
+	return ret, nil
+}
+
+// parseZipmap parses the hash encoding used before redis 2.6, returns field, value, field, value...
+func parseZipmap(data []byte) ([][]byte, error) {
+	ret := make([][]byte, 0)
+	pos := 1
+	readLength := func() (int, bool, error) {
+		if pos >= len(data) {
+			return 0, false, errTruncated
+		}
+		switch first := data[pos]; first {
+		case 0xFF:
+			return 0, true, nil
+		case 0xFE:
+			if pos+5 > len(data) {
+				return 0, false, errTruncated
+			}
+			length := int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+			pos += 5
+			return length, false, nil
+		default:
+			pos++
+			return int(first), false, nil
+		}
+	}
+
+	for {
+		length, end, err := readLength()
+		if err != nil {
+			return nil, err
+		} else if end {
+			return ret, nil
+		}
+		if pos+length > len(data) {
+			return nil, errTruncated
+		}
+		field := data[pos : pos+length]
+		pos += length
+
+		if length, _, err = readLength(); err != nil {
+			return nil, err
+		}
+		if pos >= len(data) {
+			return nil, errTruncated
+		}
+		free := int(data[pos])
+		pos++
+		if pos+length+free > len(data) {
+			return nil, errTruncated
+		}
+		ret = append(ret, field, data[pos:pos+length])
+		pos += length + free
+	}
+}
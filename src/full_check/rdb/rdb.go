@@ -0,0 +1,494 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"full_check/common"
+	"full_check/store"
+)
+
+const (
+	opSlotInfo     = 0xF4
+	opFunction2    = 0xF5
+	opFunctionPre  = 0xF6
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+
+	typeString           = 0
+	typeList             = 1
+	typeSet              = 2
+	typeZset             = 3
+	typeHash             = 4
+	typeZset2            = 5
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZsetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZsetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+
+	quicklistNodePlain = 1
+
+	// the version written by redis 7.2, later versions are loaded if they don't use new types
+	maxKnownVersion = 11
+)
+
+/*
+ * Loader reads an rdb file(e.g., the snapshot received by psync) into the store. Keys of module
+ * types and hashes with field expiry(redis >= 7.4) aren't supported and abort the load.
+ */
+type Loader struct {
+	reader  *bufio.Reader
+	store   *store.Store
+	version int
+	db      int
+	keys    int64
+}
+
+func NewLoader(reader io.Reader, st *store.Store) *Loader {
+	return &Loader{
+		reader: bufio.NewReaderSize(reader, 1024*1024),
+		store:  st,
+	}
+}
+
+// Load reads the whole rdb and returns the number of keys loaded.
+func (l *Loader) Load() (int64, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(l.reader, header); err != nil {
+		return 0, err
+	}
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return 0, fmt.Errorf("invalid rdb header[%q]", header)
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rdb version[%q]", header[5:])
+	}
+	if version > maxKnownVersion {
+		common.Logger.Warnf("rdb version[%v] is newer than %v, keys of unknown types fail the load",
+			version, maxKnownVersion)
+	}
+	l.version = version
+
+	expireAt := int64(0)
+	for {
+		op, err := l.reader.ReadByte()
+		if err != nil {
+			return l.keys, err
+		}
+
+		switch op {
+		case opEOF:
+			if l.version >= 5 {
+				// crc64 checksum, not verified
+				if _, err := io.ReadFull(l.reader, make([]byte, 8)); err != nil {
+					return l.keys, err
+				}
+			}
+			return l.keys, nil
+		case opSelectDB:
+			db, err := l.readLength()
+			if err != nil {
+				return l.keys, err
+			}
+			l.db = int(db)
+		case opResizeDB:
+			if _, err := l.readLength(); err != nil {
+				return l.keys, err
+			}
+			if _, err := l.readLength(); err != nil {
+				return l.keys, err
+			}
+		case opSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := l.readLength(); err != nil {
+					return l.keys, err
+				}
+			}
+		case opAux:
+			key, err := l.readString()
+			if err != nil {
+				return l.keys, err
+			}
+			value, err := l.readString()
+			if err != nil {
+				return l.keys, err
+			}
+			if string(key) == "redis-ver" {
+				l.store.Version = string(value)
+			}
+		case opFunction2, opFunctionPre:
+			if _, err := l.readString(); err != nil {
+				return l.keys, err
+			}
+		case opModuleAux:
+			return l.keys, fmt.Errorf("module aux data isn't supported")
+		case opIdle:
+			if _, err := l.readLength(); err != nil {
+				return l.keys, err
+			}
+		case opFreq:
+			if _, err := l.reader.ReadByte(); err != nil {
+				return l.keys, err
+			}
+		case opExpireTime:
+			buf, err := l.readBytes(4)
+			if err != nil {
+				return l.keys, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(buf)) * 1000
+		case opExpireTimeMs:
+			buf, err := l.readBytes(8)
+			if err != nil {
+				return l.keys, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(buf))
+		default:
+			key, err := l.readString()
+			if err != nil {
+				return l.keys, err
+			}
+			value, err := l.readValue(op)
+			if err != nil {
+				return l.keys, fmt.Errorf("load key[%s] of type %d failed: %v", key, op, err)
+			}
+			value.ExpireAt = expireAt
+			expireAt = 0
+			l.store.Set(l.db, string(key), value)
+			l.keys++
+		}
+	}
+}
+
+func (l *Loader) readBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(l.reader, buf)
+	return buf, err
+}
+
+// readLengthWithEncoding returns the length, or the string encoding type with encoded set.
+func (l *Loader) readLengthWithEncoding() (uint64, bool, error) {
+	first, err := l.reader.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3F), false, nil
+	case 1:
+		next, err := l.reader.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch first {
+		case 0x80:
+			buf, err := l.readBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := l.readBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown length encoding[%#x]", first)
+	default:
+		return uint64(first & 0x3F), true, nil
+	}
+}
+
+func (l *Loader) readLength() (uint64, error) {
+	length, encoded, err := l.readLengthWithEncoding()
+	if err == nil && encoded {
+		err = fmt.Errorf("unexpected encoded length")
+	}
+	return length, err
+}
+
+func (l *Loader) readString() ([]byte, error) {
+	length, encoded, err := l.readLengthWithEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return l.readBytes(int(length))
+	}
+
+	switch length {
+	case encInt8:
+		b, err := l.reader.ReadByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case encInt16:
+		buf, err := l.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case encInt32:
+		buf, err := l.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf))))), nil
+	case encLZF:
+		compressed, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		data, err := l.readBytes(int(compressed))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(data, int(raw))
+	}
+	return nil, fmt.Errorf("unknown string encoding[%d]", length)
+}
+
+// readDouble reads the string encoded score of the legacy zset type.
+func (l *Loader) readDouble() (float64, error) {
+	length, err := l.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := l.readBytes(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (l *Loader) readBinaryDouble() (float64, error) {
+	buf, err := l.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// readStrings reads a length followed by that many strings.
+func (l *Loader) readStrings(multiple uint64) ([][]byte, error) {
+	length, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([][]byte, 0, length*multiple)
+	for i := uint64(0); i < length*multiple; i++ {
+		element, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, element)
+	}
+	return ret, nil
+}
+
+func (l *Loader) readValue(tp byte) (*store.Value, error) {
+	switch tp {
+	case typeString:
+		value, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+		return store.NewString(value), nil
+	case typeList:
+		elements, err := l.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		value := store.NewList()
+		value.List = elements
+		return value, nil
+	case typeSet:
+		elements, err := l.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return newSet(elements), nil
+	case typeHash:
+		elements, err := l.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(elements), nil
+	case typeZset, typeZset2:
+		length, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		value := store.NewZset()
+		for i := uint64(0); i < length; i++ {
+			member, err := l.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if tp == typeZset {
+				score, err = l.readDouble()
+			} else {
+				score, err = l.readBinaryDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			value.Zset[string(member)] = score
+		}
+		return value, nil
+	case typeHashZipmap:
+		return l.readEncoded(func(data []byte) (*store.Value, error) {
+			elements, err := parseZipmap(data)
+			if err != nil {
+				return nil, err
+			}
+			return newHash(elements), nil
+		})
+	case typeListZiplist, typeSetIntset, typeZsetZiplist, typeHashZiplist, typeHashListpack,
+		typeZsetListpack, typeSetListpack:
+		return l.readEncoded(func(data []byte) (*store.Value, error) {
+			var elements [][]byte
+			var err error
+			switch tp {
+			case typeListZiplist, typeZsetZiplist, typeHashZiplist:
+				elements, err = parseZiplist(data)
+			case typeSetIntset:
+				elements, err = parseIntset(data)
+			default:
+				elements, err = parseListpack(data)
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch tp {
+			case typeListZiplist:
+				value := store.NewList()
+				value.List = elements
+				return value, nil
+			case typeSetIntset, typeSetListpack:
+				return newSet(elements), nil
+			case typeHashZiplist, typeHashListpack:
+				return newHash(elements), nil
+			default:
+				return newZset(elements)
+			}
+		})
+	case typeListQuicklist, typeListQuicklist2:
+		return l.readQuicklist(tp)
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return l.readStream(tp)
+	}
+	return nil, fmt.Errorf("unsupported type[%d]", tp)
+}
+
+// readEncoded reads a string holding a ziplist, listpack, intset or zipmap and parses it.
+func (l *Loader) readEncoded(parse func([]byte) (*store.Value, error)) (*store.Value, error) {
+	data, err := l.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(data)
+}
+
+func (l *Loader) readQuicklist(tp byte) (*store.Value, error) {
+	nodes, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+	value := store.NewList()
+	for i := uint64(0); i < nodes; i++ {
+		container := uint64(2)
+		if tp == typeListQuicklist2 {
+			if container, err = l.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		data, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var elements [][]byte
+		switch {
+		case container == quicklistNodePlain:
+			elements = [][]byte{data}
+		case tp == typeListQuicklist:
+			elements, err = parseZiplist(data)
+		default:
+			elements, err = parseListpack(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		value.List = append(value.List, elements...)
+	}
+	return value, nil
+}
+
+func newSet(elements [][]byte) *store.Value {
+	value := store.NewSet()
+	for _, element := range elements {
+		value.Set[string(element)] = struct{}{}
+	}
+	return value
+}
+
+func newHash(elements [][]byte) *store.Value {
+	value := store.NewHash()
+	for i := 0; i+1 < len(elements); i += 2 {
+		value.Hash[string(elements[i])] = elements[i+1]
+	}
+	return value
+}
+
+func newZset(elements [][]byte) (*store.Value, error) {
+	value := store.NewZset()
+	for i := 0; i+1 < len(elements); i += 2 {
+		score, err := strconv.ParseFloat(string(elements[i+1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score[%s]", elements[i+1])
+		}
+		value.Zset[string(elements[i])] = score
+	}
+	return value, nil
+}
@@ -0,0 +1,209 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"full_check/store"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// the helpers below only encode short strings and small numbers
+
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func listpack(elements ...interface{}) []byte {
+	var body []byte
+	for _, element := range elements {
+		switch v := element.(type) {
+		case int:
+			body = append(body, byte(v), 1)
+		case string:
+			body = append(body, byte(0x80|len(v)))
+			body = append(body, v...)
+			body = append(body, byte(1+len(v)))
+		}
+	}
+	header := make([]byte, 6)
+	binary.LittleEndian.PutUint32(header, uint32(6+len(body)+1))
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(elements)))
+	return append(append(header, body...), 0xFF)
+}
+
+func ziplist(elements ...interface{}) []byte {
+	var body []byte
+	for _, element := range elements {
+		body = append(body, 0) // previous length, not used by the parser
+		switch v := element.(type) {
+		case int:
+			body = append(body, byte(0xF1+v))
+		case string:
+			body = append(body, byte(len(v)))
+			body = append(body, v...)
+		}
+	}
+	header := make([]byte, 10)
+	binary.LittleEndian.PutUint32(header, uint32(10+len(body)+1))
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(elements)))
+	return append(append(header, body...), 0xFF)
+}
+
+func intset(values ...int16) []byte {
+	buf := make([]byte, 8+2*len(values))
+	binary.LittleEndian.PutUint32(buf, 2)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(values)))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(buf[8+2*i:], uint16(v))
+	}
+	return buf
+}
+
+func buildRdb() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0011")
+	buf.Write([]byte{opAux})
+	buf.Write(rdbString("redis-ver"))
+	buf.Write(rdbString("7.2.0"))
+	buf.Write([]byte{opSelectDB, 0, opResizeDB, 7, 1})
+
+	// string with expiry far in the future
+	expire := make([]byte, 8)
+	binary.LittleEndian.PutUint64(expire, 4102444800000)
+	buf.Write([]byte{opExpireTimeMs})
+	buf.Write(expire)
+	buf.Write([]byte{typeString})
+	buf.Write(rdbString("str"))
+	buf.Write(rdbString("value"))
+
+	// integer encoded string
+	buf.Write([]byte{typeString})
+	buf.Write(rdbString("int"))
+	buf.Write([]byte{0xC0, 0x85}) // int8 -123
+
+	// lzf compressed string "abcabcabc"
+	buf.Write([]byte{typeString})
+	buf.Write(rdbString("lzf"))
+	buf.Write([]byte{0xC3, 6, 9, 0x02, 'a', 'b', 'c', 0x80, 0x02})
+
+	buf.Write([]byte{typeSetIntset})
+	buf.Write(rdbString("intset"))
+	buf.Write(rdbString(string(intset(1, -2))))
+
+	buf.Write([]byte{typeHashListpack})
+	buf.Write(rdbString("hash"))
+	buf.Write(rdbString(string(listpack("f1", "v1", "f2", 5))))
+
+	buf.Write([]byte{typeListQuicklist2})
+	buf.Write(rdbString("list"))
+	buf.Write([]byte{2, 2}) // 2 nodes, the first packed
+	buf.Write(rdbString(string(listpack("a", 7))))
+	buf.Write([]byte{quicklistNodePlain})
+	buf.Write(rdbString("plain"))
+
+	buf.Write([]byte{typeZsetZiplist})
+	buf.Write(rdbString("zset"))
+	buf.Write(rdbString(string(ziplist("m1", "1.5", "m2", 2))))
+
+	buf.Write([]byte{opSelectDB, 3})
+	buf.Write([]byte{typeString})
+	buf.Write(rdbString("db3"))
+	buf.Write(rdbString("x"))
+
+	buf.Write([]byte{opEOF})
+	buf.Write(make([]byte, 8))
+	return buf.Bytes()
+}
+
+func TestLoad(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestLoad case %d.\n", nr)
+
+		st := store.New()
+		keys, err := NewLoader(bytes.NewReader(buildRdb()), st).Load()
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, int64(8), keys, "should be equal")
+		assert.Equal(t, "7.2.0", st.Version, "should be equal")
+
+		conn := store.NewConn(st, 0)
+		str, err := redis.String(conn.Do("get", "str"))
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "value", str, "should be equal")
+		ttl, err := redis.Int64(conn.Do("ttl", "str"))
+		assert.Nil(t, err, "should be nil")
+		assert.True(t, ttl > 0, "should be true")
+
+		str, _ = redis.String(conn.Do("get", "int"))
+		assert.Equal(t, "-123", str, "should be equal")
+		str, _ = redis.String(conn.Do("get", "lzf"))
+		assert.Equal(t, "abcabcabc", str, "should be equal")
+
+		members, _ := redis.Strings(conn.Do("smembers", "intset"))
+		assert.ElementsMatch(t, []string{"1", "-2"}, members, "should be equal")
+
+		hash, _ := redis.StringMap(conn.Do("hgetall", "hash"))
+		assert.Equal(t, map[string]string{"f1": "v1", "f2": "5"}, hash, "should be equal")
+
+		list, _ := redis.Strings(conn.Do("lrange", "list", 0, -1))
+		assert.Equal(t, []string{"a", "7", "plain"}, list, "should be equal")
+
+		zset, _ := redis.Strings(conn.Do("zrange", "zset", 0, -1, "withscores"))
+		assert.Equal(t, []string{"m1", "1.5", "m2", "2"}, zset, "should be equal")
+
+		tp, _ := redis.String(conn.Do("type", "hash"))
+		assert.Equal(t, "hash", tp, "should be equal")
+		_, err = conn.Do("llen", "hash")
+		assert.Equal(t, "WRONGTYPE", err.Error()[:9], "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestLoad case %d.\n", nr)
+
+		// dbs, keyspace and scan
+		st := store.New()
+		_, err := NewLoader(bytes.NewReader(buildRdb()), st).Load()
+		assert.Nil(t, err, "should be nil")
+
+		conn := store.NewConn(st, 0)
+		info, _ := redis.String(conn.Do("info", "keyspace"))
+		assert.Equal(t, "# Keyspace\r\ndb0:keys=7,expires=1,avg_ttl=0\r\ndb3:keys=1,expires=0,avg_ttl=0\r\n",
+			info, "should be equal")
+
+		scanned := make([]string, 0)
+		for cursor := 0; ; {
+			reply, err := redis.Values(conn.Do("scan", cursor, "count", 3))
+			assert.Nil(t, err, "should be nil")
+			cursor, _ = redis.Int(reply[0], nil)
+			keys, _ := redis.Strings(reply[1], nil)
+			scanned = append(scanned, keys...)
+			if cursor == 0 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"hash", "int", "intset", "list", "lzf", "str", "zset"}, scanned,
+			"should be equal")
+
+		conn.Do("select", 3)
+		reply, _ := redis.Values(conn.Do("scan", 0, "count", 10, "match", "db*", "type", "string"))
+		keys, _ := redis.Strings(reply[1], nil)
+		assert.Equal(t, []string{"db3"}, keys, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestLoad case %d.\n", nr)
+
+		// truncated rdb
+		data := buildRdb()
+		_, err := NewLoader(bytes.NewReader(data[:len(data)/2]), store.New()).Load()
+		assert.NotNil(t, err, "should be not nil")
+	}
+}
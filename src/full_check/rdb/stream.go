@@ -0,0 +1,284 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"full_check/store"
+)
+
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+func parseStreamID(data []byte) (store.StreamID, error) {
+	if len(data) != 16 {
+		return store.StreamID{}, fmt.Errorf("invalid stream id length[%d]", len(data))
+	}
+	return store.StreamID{
+		Ms:  binary.BigEndian.Uint64(data[:8]),
+		Seq: binary.BigEndian.Uint64(data[8:]),
+	}, nil
+}
+
+func (l *Loader) readStreamID() (store.StreamID, error) {
+	ms, err := l.readLength()
+	if err != nil {
+		return store.StreamID{}, err
+	}
+	seq, err := l.readLength()
+	return store.StreamID{Ms: ms, Seq: seq}, err
+}
+
+func (l *Loader) readMillisecondTime() (int64, error) {
+	buf, err := l.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// listpackInt converts a listpack element of the stream encoding to an integer.
+func listpackInt(element []byte) (int64, error) {
+	return strconv.ParseInt(string(element), 10, 64)
+}
+
+/*
+ * parseStreamListpack parses one node of the stream radix tree. The first entry holds the master
+ * fields, each following entry is flags, ms-diff, seq-diff, then either the values of the master
+ * fields or its own fields, and ends with the number of its elements.
+ */
+func parseStreamListpack(master store.StreamID, data []byte) ([]*store.StreamEntry, error) {
+	elements, err := parseListpack(data)
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	next := func() (int64, error) {
+		if pos >= len(elements) {
+			return 0, errTruncated
+		}
+		pos++
+		return listpackInt(elements[pos-1])
+	}
+
+	// master entry: count, deleted, master field count, master fields, 0
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := next()
+	if err != nil {
+		return nil, err
+	}
+	fieldCount, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if pos+int(fieldCount)+1 > len(elements) {
+		return nil, errTruncated
+	}
+	masterFields := elements[pos : pos+int(fieldCount)]
+	pos += int(fieldCount) + 1
+
+	entries := make([]*store.StreamEntry, 0, count)
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		entry := &store.StreamEntry{
+			ID: store.StreamID{Ms: master.Ms + uint64(msDiff), Seq: master.Seq + uint64(seqDiff)},
+		}
+
+		if flags&streamItemSameFields != 0 {
+			if pos+len(masterFields) > len(elements) {
+				return nil, errTruncated
+			}
+			for j, field := range masterFields {
+				entry.Fields = append(entry.Fields, field, elements[pos+j])
+			}
+			pos += len(masterFields)
+		} else {
+			fields, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if pos+2*int(fields) > len(elements) {
+				return nil, errTruncated
+			}
+			entry.Fields = append(entry.Fields, elements[pos:pos+2*int(fields)]...)
+			pos += 2 * int(fields)
+		}
+		// lp-count
+		pos++
+
+		if flags&streamItemDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (l *Loader) readStream(tp byte) (*store.Value, error) {
+	value := store.NewStream()
+	stream := value.Stream
+
+	nodes, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+		master, err := parseStreamID(key)
+		if err != nil {
+			return nil, err
+		}
+		data, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseStreamListpack(master, data)
+		if err != nil {
+			return nil, err
+		}
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	// length, it's the same as the number of entries
+	if _, err := l.readLength(); err != nil {
+		return nil, err
+	}
+	if stream.LastID, err = l.readStreamID(); err != nil {
+		return nil, err
+	}
+	if tp >= typeStreamListpacks2 {
+		// first id
+		if _, err := l.readStreamID(); err != nil {
+			return nil, err
+		}
+		if stream.MaxDeletedID, err = l.readStreamID(); err != nil {
+			return nil, err
+		}
+		added, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		stream.EntriesAdded = int64(added)
+	} else {
+		stream.EntriesAdded = int64(len(stream.Entries))
+	}
+
+	groups, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		group, err := l.readStreamGroup(tp)
+		if err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return value, nil
+}
+
+func (l *Loader) readStreamGroup(tp byte) (*store.StreamGroup, error) {
+	name, err := l.readString()
+	if err != nil {
+		return nil, err
+	}
+	group := &store.StreamGroup{Name: string(name), EntriesRead: -1}
+	if group.LastID, err = l.readStreamID(); err != nil {
+		return nil, err
+	}
+	if tp >= typeStreamListpacks2 {
+		read, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		// SCG_INVALID_ENTRIES_READ is -1 saved as unsigned
+		if int64(read) >= 0 {
+			group.EntriesRead = int64(read)
+		}
+	}
+
+	// the global pending entries list of the group
+	pendingCount, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+	pendings := make(map[store.StreamID]*store.StreamPending, pendingCount)
+	for i := uint64(0); i < pendingCount; i++ {
+		raw, err := l.readBytes(16)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := parseStreamID(raw)
+		pending := &store.StreamPending{ID: id}
+		if pending.DeliveryTime, err = l.readMillisecondTime(); err != nil {
+			return nil, err
+		}
+		count, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		pending.DeliveryCount = int64(count)
+		pendings[id] = pending
+		group.Pending = append(group.Pending, pending)
+	}
+	sort.Slice(group.Pending, func(i, j int) bool {
+		return group.Pending[i].ID.Less(group.Pending[j].ID)
+	})
+
+	// consumers, each with the ids of its own pending entries
+	consumers, err := l.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		consumer, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+		group.Consumers = append(group.Consumers, string(consumer))
+		// seen time, and active time since rdb type 21
+		if _, err := l.readMillisecondTime(); err != nil {
+			return nil, err
+		}
+		if tp >= typeStreamListpacks3 {
+			if _, err := l.readMillisecondTime(); err != nil {
+				return nil, err
+			}
+		}
+		count, err := l.readLength()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < count; j++ {
+			raw, err := l.readBytes(16)
+			if err != nil {
+				return nil, err
+			}
+			id, _ := parseStreamID(raw)
+			if pending, ok := pendings[id]; ok {
+				pending.Consumer = string(consumer)
+			}
+		}
+	}
+	return group, nil
+}
@@ -0,0 +1,334 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"full_check/common"
+	"full_check/rdb"
+	"full_check/store"
+)
+
+const (
+	eofMarkPrefix = "EOF:"
+	eofMarkLen    = 40
+
+	readerSize  = 1024 * 1024
+	ackInterval = time.Second
+)
+
+/*
+ * Replica connects to the source as a replica: it sends "replconf" and "psync ? -1", loads the
+ * rdb snapshot of the full resynchronization into the store and, if follow is set, keeps applying
+ * the command stream so that the store tracks the source. The source only pays for one replica
+ * sync instead of the scan and value commands of the comparison.
+ */
+type Replica struct {
+	addr     string
+	password string
+	authType string
+	store    *store.Store
+	follow   bool
+	owns     func(key []byte) bool // the keys of the source among several sharing the store, nil means all
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex // acks are sent by both the ack loop and the command stream
+	replID    string
+	offset    int64 // replication offset of the last applied command, read by the ack loop
+	db        int
+
+	applied int64 // number of applied commands
+	failed  int64 // number of commands the store doesn't support
+	closed  int32
+}
+
+func NewReplica(addr, password, authType string, st *store.Store, follow bool) *Replica {
+	return &Replica{
+		addr:     addr,
+		password: password,
+		authType: authType,
+		store:    st,
+		follow:   follow,
+	}
+}
+
+// Own restricts the flushes of the command stream to the keys owned by the source, e.g., its slots.
+func (r *Replica) Own(owns func(key []byte) bool) {
+	r.owns = owns
+}
+
+func (r *Replica) String() string {
+	return fmt.Sprintf("replica of %v", r.addr)
+}
+
+/*
+ * Sync loads the snapshot and returns the number of keys in it. The connection is closed unless
+ * follow is set, then the command stream is applied in the background until Close is called.
+ */
+func (r *Replica) Sync() (int64, error) {
+	var err error
+	if r.conn, err = net.Dial("tcp", r.addr); err != nil {
+		return 0, err
+	}
+	r.reader = bufio.NewReaderSize(r.conn, readerSize)
+
+	if err := r.handshake(); err != nil {
+		r.conn.Close()
+		return 0, err
+	}
+
+	keys, err := r.loadSnapshot()
+	if err != nil {
+		r.conn.Close()
+		return keys, err
+	}
+
+	if !r.follow {
+		r.conn.Close()
+		return keys, nil
+	}
+	go r.ack()
+	go r.apply()
+	return keys, nil
+}
+
+func (r *Replica) Close() {
+	if atomic.CompareAndSwapInt32(&r.closed, 0, 1) && r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+// Applied returns the number of applied commands and the number of unsupported ones.
+func (r *Replica) Applied() (int64, int64) {
+	return atomic.LoadInt64(&r.applied), atomic.LoadInt64(&r.failed)
+}
+
+func (r *Replica) send(args ...string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	r.writeLock.Lock()
+	_, err := r.conn.Write(buf.Bytes())
+	r.writeLock.Unlock()
+	return err
+}
+
+func (r *Replica) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command sends the command and expects a status reply.
+func (r *Replica) command(args ...string) (string, error) {
+	if err := r.send(args...); err != nil {
+		return "", err
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "-") {
+		return "", fmt.Errorf("%v failed: %v", args[0], line[1:])
+	}
+	return line, nil
+}
+
+func (r *Replica) handshake() error {
+	if len(r.password) != 0 {
+		args := append([]string{r.authType}, strings.Split(r.password, ":")...)
+		if _, err := r.command(args...); err != nil {
+			return err
+		}
+	}
+	if _, err := r.command("ping"); err != nil {
+		return err
+	}
+
+	port := strconv.Itoa(r.conn.LocalAddr().(*net.TCPAddr).Port)
+	if _, err := r.command("replconf", "listening-port", port); err != nil {
+		return err
+	}
+	// accept the diskless payload ended by a mark, ignored by old versions
+	if _, err := r.command("replconf", "capa", "eof", "capa", "psync2"); err != nil {
+		common.Logger.Warnf("%v: replconf capa failed: %v", r, err)
+	}
+
+	reply, err := r.command("psync", "?", "-1")
+	if err != nil {
+		return err
+	}
+	// +FULLRESYNC <replid> <offset>
+	items := strings.Fields(reply)
+	if len(items) != 3 || items[0] != "+FULLRESYNC" {
+		return fmt.Errorf("unexpected psync reply[%v]", reply)
+	}
+	r.replID = items[1]
+	if r.offset, err = strconv.ParseInt(items[2], 10, 64); err != nil {
+		return fmt.Errorf("unexpected psync reply[%v]", reply)
+	}
+	common.Logger.Infof("%v: full resync with replid[%v] offset[%v]", r, r.replID, r.offset)
+	return nil
+}
+
+func (r *Replica) loadSnapshot() (int64, error) {
+	// the source sends newlines as keepalive while the rdb is generated
+	var line string
+	var err error
+	for {
+		if line, err = r.readLine(); err != nil {
+			return 0, err
+		}
+		if len(line) != 0 {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "$") {
+		return 0, fmt.Errorf("unexpected rdb payload header[%v]", line)
+	}
+
+	start := time.Now()
+	if strings.HasPrefix(line[1:], eofMarkPrefix) {
+		// diskless: the rdb is followed by the mark
+		mark := line[1+len(eofMarkPrefix):]
+		if len(mark) != eofMarkLen {
+			return 0, fmt.Errorf("invalid rdb eof mark[%v]", mark)
+		}
+		common.Logger.Infof("%v: receive diskless rdb", r)
+		keys, err := rdb.NewLoader(r.reader, r.store).Load()
+		if err != nil {
+			return keys, err
+		}
+		tail := make([]byte, eofMarkLen)
+		if _, err := io.ReadFull(r.reader, tail); err != nil {
+			return keys, err
+		}
+		if string(tail) != mark {
+			return keys, fmt.Errorf("rdb eof mark mismatch")
+		}
+		common.Logger.Infof("%v: load %d keys in %v", r, keys, time.Since(start))
+		return keys, nil
+	}
+
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected rdb payload header[%v]", line)
+	}
+	common.Logger.Infof("%v: receive rdb of %d bytes", r, size)
+	payload := io.LimitReader(r.reader, size)
+	keys, err := rdb.NewLoader(payload, r.store).Load()
+	if err != nil {
+		return keys, err
+	}
+	// skip anything after the rdb eof, e.g., padding
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return keys, err
+	}
+	common.Logger.Infof("%v: load %d keys in %v", r, keys, time.Since(start))
+	return keys, nil
+}
+
+// ack reports the processed offset every second, so that the source doesn't drop the replica.
+func (r *Replica) ack() {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadInt32(&r.closed) == 1 {
+			return
+		}
+		offset := strconv.FormatInt(atomic.LoadInt64(&r.offset), 10)
+		if err := r.send("replconf", "ack", offset); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one command of the stream and returns its arguments and size in bytes.
+func (r *Replica) readCommand() ([][]byte, int64, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(len(line))
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		// inline command, e.g., a newline
+		return bytes.Fields([]byte(line)), size, nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, size, fmt.Errorf("invalid multibulk length[%v]", line)
+	}
+	args := make([][]byte, count)
+	for i := range args {
+		header, err := r.reader.ReadString('\n')
+		if err != nil {
+			return nil, size, err
+		}
+		size += int64(len(header))
+		if !strings.HasPrefix(header, "$") {
+			return nil, size, fmt.Errorf("invalid bulk header[%v]", header)
+		}
+		length, err := strconv.Atoi(strings.TrimRight(header[1:], "\r\n"))
+		if err != nil {
+			return nil, size, fmt.Errorf("invalid bulk header[%v]", header)
+		}
+		args[i] = make([]byte, length+2)
+		if _, err := io.ReadFull(r.reader, args[i]); err != nil {
+			return nil, size, err
+		}
+		size += int64(length + 2)
+		args[i] = args[i][:length]
+	}
+	return args, size, nil
+}
+
+func (r *Replica) apply() {
+	for {
+		args, size, err := r.readCommand()
+		if err != nil {
+			if atomic.LoadInt32(&r.closed) == 0 {
+				common.Logger.Errorf("%v: read command stream failed, stop following: %v", r, err)
+			}
+			return
+		}
+
+		if len(args) != 0 {
+			switch strings.ToLower(string(args[0])) {
+			case "select":
+				if len(args) == 2 {
+					if db, err := strconv.Atoi(string(args[1])); err == nil {
+						r.db = db
+					}
+				}
+			case "replconf":
+				// "replconf getack *" asks for the offset right away, not counted
+				if len(args) >= 2 && strings.ToLower(string(args[1])) == "getack" {
+					offset := strconv.FormatInt(atomic.LoadInt64(&r.offset), 10)
+					r.send("replconf", "ack", offset)
+				}
+			default:
+				if err := r.store.ApplyOwned(r.db, args, r.owns); err != nil {
+					atomic.AddInt64(&r.failed, 1)
+					common.Logger.Debugf("%v: apply %q failed: %v", r, args[0], err)
+				}
+			}
+			atomic.AddInt64(&r.applied, 1)
+		}
+		atomic.AddInt64(&r.offset, size)
+	}
+}
@@ -0,0 +1,108 @@
+package replica
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"full_check/common"
+	"full_check/store"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// needs a local redis-server, e.g., "redis-server --port 6000"
+const testAddr = "127.0.0.1:6000"
+
+func TestReplica(t *testing.T) {
+	conn, err := redis.Dial("tcp", testAddr)
+	if err != nil {
+		t.Skipf("redis on %v isn't available: %v", testAddr, err)
+	}
+	defer conn.Close()
+	if common.Logger, err = common.InitLog("", "error,critical"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Do("flushall")
+	conn.Do("set", "str", "value")
+	conn.Do("set", "int", 12345)
+	conn.Do("rpush", "list", "a", "b", 3)
+	conn.Do("sadd", "intset", 1, 2, 3)
+	conn.Do("sadd", "set", "a", "b")
+	conn.Do("hset", "hash", "f1", "v1", "f2", 2)
+	conn.Do("zadd", "zset", 1.5, "m1", 2, "m2")
+	conn.Do("xadd", "stream", "1-1", "f", "v")
+	conn.Do("xgroup", "create", "stream", "g1", "0")
+	conn.Do("xreadgroup", "group", "g1", "c1", "count", "1", "streams", "stream", ">")
+	conn.Do("select", 2)
+	conn.Do("set", "db2", "x")
+	conn.Do("select", 0)
+
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestReplica case %d.\n", nr)
+
+		st := store.New()
+		r := NewReplica(testAddr, "", "auth", st, true)
+		keys, err := r.Sync()
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, int64(9), keys, "should be equal")
+		defer r.Close()
+
+		// the snapshot replies the same as the source
+		local := store.NewConn(st, 0)
+		commands := [][]interface{}{
+			{"get", "str"},
+			{"get", "int"},
+			{"lrange", "list", 0, -1},
+			{"zrange", "zset", 0, -1, "withscores"},
+			{"xrange", "stream", "-", "+"},
+			{"xinfo", "groups", "stream"},
+			{"type", "hash"},
+			{"hlen", "hash"},
+			{"scard", "intset"},
+			{"hmget", "hash", "f1", "f2", "f3"},
+		}
+		for _, command := range commands {
+			expected, err := conn.Do(command[0].(string), command[1:]...)
+			assert.Nil(t, err, "should be nil")
+			actual, err := local.Do(command[0].(string), command[1:]...)
+			assert.Nil(t, err, "should be nil")
+			assert.Equal(t, expected, actual, "should be equal: %v", command)
+		}
+
+		// the command stream is applied
+		conn.Do("set", "str", "new")
+		conn.Do("hdel", "hash", "f1")
+		conn.Do("del", "list")
+		conn.Do("select", 2)
+		conn.Do("rpush", "db2list", "x")
+		conn.Do("select", 0)
+		conn.Do("xadd", "stream", "2-1", "f", "v")
+		conn.Do("set", "last", "1")
+
+		for i := 0; i < 50; i++ {
+			if exists, _ := redis.Int64(local.Do("exists", "last")); exists == 1 {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		str, _ := redis.String(local.Do("get", "str"))
+		assert.Equal(t, "new", str, "should be equal")
+		hash, _ := redis.StringMap(local.Do("hgetall", "hash"))
+		assert.Equal(t, map[string]string{"f2": "2"}, hash, "should be equal")
+		exists, _ := redis.Int64(local.Do("exists", "list", "last"))
+		assert.Equal(t, int64(1), exists, "should be equal")
+		// stream commands aren't applied, the key isn't tracked any more
+		tp, _ := redis.String(local.Do("type", "stream"))
+		assert.Equal(t, "none", tp, "should be equal")
+		assert.Equal(t, 1, st.Dirty(), "should be equal")
+
+		local.Do("select", 2)
+		list, _ := redis.Strings(local.Do("lrange", "db2list", 0, -1))
+		assert.Equal(t, []string{"x"}, list, "should be equal")
+	}
+}
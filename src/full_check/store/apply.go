@@ -0,0 +1,510 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"full_check/common"
+)
+
+/*
+ * Apply applies one write command of the replication stream to the db. Commands that only touch
+ * one key but can't be applied(e.g., "linsert" or stream commands) make the key dirty, the key is
+ * removed and no longer compared. The returned error means the command is unknown or malformed,
+ * its first argument is made dirty as well.
+ */
+func (s *Store) Apply(db int, args [][]byte) error {
+	return s.ApplyOwned(db, args, nil)
+}
+
+/*
+ * ApplyOwned is Apply for one of the sources sharing the store, e.g., a node of a cluster. The
+ * flushes only remove the keys the source owns and swapping dbs isn't supported. A nil owns means
+ * the source owns all keys.
+ */
+func (s *Store) ApplyOwned(db int, args [][]byte, owns func(key []byte) bool) error {
+	if len(args) == 0 {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "ping", "multi", "exec", "publish":
+		return nil
+	case "flushdb":
+		if owns != nil {
+			s.flushOwned(db, owns)
+			return nil
+		}
+		s.dbs[db] = make(map[string]*Value)
+		delete(s.dirty, db)
+		return nil
+	case "flushall":
+		if owns != nil {
+			for db := range s.dbs {
+				s.flushOwned(db, owns)
+			}
+			for db := range s.dirty {
+				s.flushOwned(db, owns)
+			}
+			return nil
+		}
+		s.dbs = make(map[int]map[string]*Value)
+		s.dirty = make(map[int]map[string]struct{})
+		return nil
+	case "swapdb":
+		if owns != nil || len(args) != 3 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		a, err1 := strconv.Atoi(string(args[1]))
+		b, err2 := strconv.Atoi(string(args[2]))
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		s.dbs[a], s.dbs[b] = s.dbs[b], s.dbs[a]
+		s.dirty[a], s.dirty[b] = s.dirty[b], s.dirty[a]
+		return nil
+	case "del", "unlink":
+		for _, key := range args[1:] {
+			s.del(db, string(key))
+			delete(s.dirty[db], string(key))
+		}
+		return nil
+	case "mset":
+		for i := 1; i+1 < len(args); i += 2 {
+			s.set(db, string(args[i]), NewString(bulk(args[i+1])))
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("invalid command %v", name)
+	}
+	key := string(args[1])
+	err := s.applyKey(db, name, key, args[2:])
+	if err != nil {
+		s.markDirty(db, key)
+		if err == errDirty {
+			return nil
+		}
+		return err
+	}
+	if value, ok := s.dbs[db][key]; ok && value.empty() {
+		s.del(db, key)
+	}
+	return nil
+}
+
+// flushOwned removes the keys of the db the source owns, the dirty ones included.
+func (s *Store) flushOwned(db int, owns func(key []byte) bool) {
+	for key := range s.dbs[db] {
+		if owns([]byte(key)) {
+			delete(s.dbs[db], key)
+		}
+	}
+	for key := range s.dirty[db] {
+		if owns([]byte(key)) {
+			delete(s.dirty[db], key)
+		}
+	}
+}
+
+// errDirty marks the key dirty without reporting an error, the command is known but not applied.
+var errDirty = fmt.Errorf("dirty")
+
+func (s *Store) applyKey(db int, name, key string, args [][]byte) error {
+	if _, ok := s.dirty[db][key]; ok {
+		// only commands replacing the whole value make a dirty key tracked again
+		switch name {
+		case "set", "setex", "psetex", "getset":
+		case "rename", "renamenx":
+			if len(args) == 1 {
+				s.markDirty(db, string(args[0]))
+			}
+			return errDirty
+		default:
+			return errDirty
+		}
+	}
+
+	old := s.dbs[db][key]
+	if old != nil && old.expired(nowMs()) {
+		s.del(db, key)
+		old = nil
+	}
+
+	switch name {
+	case "set", "setex", "psetex", "setnx", "getset", "getdel", "append", "incr", "decr", "incrby",
+		"decrby":
+		return s.applyString(db, name, key, old, args)
+	case "expire", "pexpire", "expireat", "pexpireat":
+		if old == nil || len(args) < 1 {
+			return nil
+		}
+		t, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "expire":
+			t = nowMs() + t*1000
+		case "pexpire":
+			t = nowMs() + t
+		case "expireat":
+			t *= 1000
+		}
+		old.ExpireAt = t
+		if old.expired(nowMs()) {
+			s.del(db, key)
+		}
+		return nil
+	case "persist":
+		if old != nil {
+			old.ExpireAt = 0
+		}
+		return nil
+	case "rename", "renamenx":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		if old == nil {
+			return nil
+		}
+		s.del(db, key)
+		s.set(db, string(args[0]), old)
+		return nil
+	}
+
+	// the remaining commands modify aggregate types, create the value if missing
+	var value *Value
+	switch name {
+	case "hset", "hmset", "hsetnx", "hdel", "hincrby":
+		value = s.valueOf(db, key, old, NewHash)
+	case "lpush", "rpush", "lpushx", "rpushx", "lpop", "rpop", "lset", "ltrim":
+		value = s.valueOf(db, key, old, NewList)
+	case "sadd", "srem":
+		value = s.valueOf(db, key, old, NewSet)
+	case "zadd", "zrem", "zincrby":
+		value = s.valueOf(db, key, old, NewZset)
+	default:
+		return errDirtyOrUnknown(name)
+	}
+	if value == nil {
+		return fmt.Errorf("command %v against key of type %v", name, old.Type.Name)
+	}
+
+	switch name {
+	case "hset", "hmset":
+		for i := 0; i+1 < len(args); i += 2 {
+			value.Hash[string(args[i])] = bulk(args[i+1])
+		}
+	case "hsetnx":
+		if _, ok := value.Hash[string(args[0])]; !ok && len(args) == 2 {
+			value.Hash[string(args[0])] = bulk(args[1])
+		}
+	case "hdel":
+		for _, field := range args {
+			delete(value.Hash, string(field))
+		}
+	case "hincrby":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		ret, err := incrBy(value.Hash[string(args[0])], args[1])
+		if err != nil {
+			return err
+		}
+		value.Hash[string(args[0])] = ret
+	case "lpush", "lpushx":
+		if name == "lpushx" && len(value.List) == 0 {
+			break
+		}
+		for _, element := range args {
+			value.List = append([][]byte{bulk(element)}, value.List...)
+		}
+	case "rpush", "rpushx":
+		if name == "rpushx" && len(value.List) == 0 {
+			break
+		}
+		for _, element := range args {
+			value.List = append(value.List, bulk(element))
+		}
+	case "lpop", "rpop":
+		count := 1
+		if len(args) == 1 {
+			var err error
+			if count, err = strconv.Atoi(string(args[0])); err != nil {
+				return err
+			}
+		}
+		if count > len(value.List) {
+			count = len(value.List)
+		}
+		if name == "lpop" {
+			value.List = value.List[count:]
+		} else {
+			value.List = value.List[:len(value.List)-count]
+		}
+	case "lset":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		index, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return err
+		}
+		if index < 0 {
+			index += len(value.List)
+		}
+		if index < 0 || index >= len(value.List) {
+			return fmt.Errorf("lset index out of range")
+		}
+		value.List[index] = bulk(args[1])
+	case "ltrim":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		from, to, err := rangeIndex(string(args[0]), string(args[1]), len(value.List))
+		if err != nil {
+			return err
+		}
+		value.List = value.List[from:to]
+	case "sadd":
+		for _, member := range args {
+			value.Set[string(member)] = struct{}{}
+		}
+	case "srem":
+		for _, member := range args {
+			delete(value.Set, string(member))
+		}
+	case "zadd":
+		return applyZadd(value, args)
+	case "zrem":
+		for _, member := range args {
+			delete(value.Zset, string(member))
+		}
+	case "zincrby":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		delta, err := parseScore(args[0])
+		if err != nil {
+			return err
+		}
+		value.Zset[string(args[1])] += delta
+	}
+	return nil
+}
+
+func errDirtyOrUnknown(name string) error {
+	switch name {
+	case "linsert", "lrem", "lmove", "rpoplpush", "smove", "hincrbyfloat", "incrbyfloat", "setrange",
+		"zremrangebyscore", "zremrangebyrank", "zremrangebylex", "zpopmin", "zpopmax", "restore",
+		"xadd", "xdel", "xtrim", "xgroup", "xack", "xclaim", "xautoclaim", "xsetid", "pfadd", "pfmerge",
+		"setbit", "bitop", "bitfield", "copy", "move":
+		return errDirty
+	}
+	return fmt.Errorf("unknown command %v", name)
+}
+
+// valueOf returns the existing value of the type or a new one, nil if the key holds another type.
+func (s *Store) valueOf(db int, key string, old *Value, create func() *Value) *Value {
+	value := create()
+	if old == nil {
+		s.set(db, key, value)
+		return value
+	} else if old.Type != value.Type {
+		return nil
+	}
+	return old
+}
+
+func (s *Store) applyString(db int, name, key string, old *Value, args [][]byte) error {
+	if old != nil && old.Type != common.StringKeyType && name != "set" && name != "setex" &&
+		name != "psetex" && name != "getdel" {
+		return fmt.Errorf("command %v against key of type %v", name, old.Type.Name)
+	}
+
+	switch name {
+	case "getdel":
+		s.del(db, key)
+		return nil
+	case "setex", "psetex":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		t, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		if name == "setex" {
+			t *= 1000
+		}
+		value := NewString(bulk(args[1]))
+		value.ExpireAt = nowMs() + t
+		s.set(db, key, value)
+		return nil
+	case "setnx":
+		// only executed commands are propagated, so the key didn't exist on the source
+		if len(args) == 1 {
+			s.set(db, key, NewString(bulk(args[0])))
+		}
+		return nil
+	case "getset":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		s.set(db, key, NewString(bulk(args[0])))
+		return nil
+	case "append":
+		if len(args) != 1 {
+			return fmt.Errorf("invalid command %v", name)
+		}
+		if old == nil {
+			s.set(db, key, NewString(bulk(args[0])))
+		} else {
+			old.String = append(bulk(old.String), args[0]...)
+		}
+		return nil
+	case "incr", "decr", "incrby", "decrby":
+		delta := []byte("1")
+		if len(args) == 1 {
+			delta = args[0]
+		}
+		if name == "decr" || name == "decrby" {
+			delta = append([]byte("-"), delta...)
+			if delta[1] == '-' {
+				delta = delta[2:]
+			}
+		}
+		var current []byte
+		if old != nil {
+			current = old.String
+		}
+		ret, err := incrBy(current, delta)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			s.set(db, key, NewString(ret))
+		} else {
+			old.String = ret
+		}
+		return nil
+	}
+
+	// set key value [NX|XX] [GET] [EX|PX|EXAT|PXAT time|KEEPTTL]
+	if len(args) < 1 {
+		return fmt.Errorf("invalid command %v", name)
+	}
+	value := NewString(bulk(args[0]))
+	keepTTL := false
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch option {
+		case "nx", "xx", "get":
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) {
+				return fmt.Errorf("invalid command %v", name)
+			}
+			i++
+			t, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return err
+			}
+			switch option {
+			case "ex":
+				t = nowMs() + t*1000
+			case "px":
+				t = nowMs() + t
+			case "exat":
+				t *= 1000
+			}
+			value.ExpireAt = t
+		default:
+			return fmt.Errorf("invalid option %v of command %v", option, name)
+		}
+	}
+	if keepTTL && old != nil {
+		value.ExpireAt = old.ExpireAt
+	}
+	s.set(db, key, value)
+	return nil
+}
+
+func incrBy(current, delta []byte) ([]byte, error) {
+	n := int64(0)
+	if current != nil {
+		var err error
+		if n, err = strconv.ParseInt(string(current), 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	d, err := strconv.ParseInt(string(delta), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.FormatInt(n+d, 10)), nil
+}
+
+func parseScore(input []byte) (float64, error) {
+	switch strings.ToLower(string(input)) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(string(input), 64)
+}
+
+// applyZadd applies "zadd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]".
+func applyZadd(value *Value, args [][]byte) error {
+	var nx, xx, gt, lt, incr bool
+	i := 0
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "incr":
+			incr = true
+		case "ch":
+		default:
+			goto members
+		}
+	}
+members:
+	if (len(args)-i)%2 != 0 {
+		return fmt.Errorf("invalid command zadd")
+	}
+	for ; i < len(args); i += 2 {
+		score, err := parseScore(args[i])
+		if err != nil {
+			return err
+		}
+		member := string(args[i+1])
+		current, ok := value.Zset[member]
+		if nx && ok || xx && !ok {
+			continue
+		}
+		if incr && ok {
+			score += current
+		}
+		if ok && (gt && score <= current || lt && score >= current) {
+			continue
+		}
+		value.Zset[member] = score
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestApply case %d.\n", nr)
+
+		// two sources share the store, each one only flushes its own keys
+		st := New()
+		for _, key := range []string{"a1", "a2", "b1"} {
+			st.Set(0, key, NewString([]byte(key)))
+		}
+		st.Set(1, "a3", NewString([]byte("a3")))
+		ownsA := func(key []byte) bool {
+			return strings.HasPrefix(string(key), "a")
+		}
+		keys := func(db int) []string {
+			ret := st.Keys(db)
+			sort.Strings(ret)
+			return ret
+		}
+
+		assert.Nil(t, st.ApplyOwned(0, [][]byte{[]byte("flushdb")}, ownsA), "should be nil")
+		assert.Equal(t, []string{"b1"}, keys(0), "should be equal")
+		assert.Equal(t, []string{"a3"}, keys(1), "should be equal")
+
+		assert.Nil(t, st.ApplyOwned(0, [][]byte{[]byte("flushall")}, ownsA), "should be nil")
+		assert.Equal(t, []string{"b1"}, keys(0), "should be equal")
+		assert.Equal(t, 0, len(keys(1)), "should be equal")
+
+		assert.NotNil(t, st.ApplyOwned(0, [][]byte{[]byte("swapdb"), []byte("0"), []byte("1")}, ownsA),
+			"should be not nil")
+		assert.Equal(t, []string{"b1"}, keys(0), "should be equal")
+
+		// a single source flushes all
+		assert.Nil(t, st.Apply(0, [][]byte{[]byte("flushall")}), "should be nil")
+		assert.Equal(t, 0, len(keys(0)), "should be equal")
+	}
+}
@@ -0,0 +1,600 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"full_check/common"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	wrongTypeError = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	syntaxError    = redis.Error("ERR syntax error")
+	notIntError    = redis.Error("ERR value is not an integer or out of range")
+)
+
+type connReply struct {
+	reply interface{}
+	err   error
+}
+
+/*
+ * Conn answers the read-only commands issued by the scanner and the verifiers from the store, with
+ * the same reply types as redigo returns for a live redis. Errors replied by redis(e.g., WRONGTYPE)
+ * are returned as redis.Error just like redigo does.
+ */
+type Conn struct {
+	store   *Store
+	db      int
+	pending []connReply
+
+	scanKeys []string // key snapshot taken when "scan 0" starts
+}
+
+func NewConn(store *Store, db int) *Conn {
+	return &Conn{store: store, db: db}
+}
+
+func (c *Conn) Close() error {
+	c.pending = nil
+	return nil
+}
+
+func (c *Conn) Err() error {
+	return nil
+}
+
+func (c *Conn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" {
+		// flush and receive all pending replies like redigo, the last one is returned
+		var reply interface{}
+		var err error
+		for len(c.pending) != 0 {
+			reply, err = c.Receive()
+		}
+		return reply, err
+	}
+	return c.exec(commandName, args)
+}
+
+func (c *Conn) Send(commandName string, args ...interface{}) error {
+	reply, err := c.exec(commandName, args)
+	c.pending = append(c.pending, connReply{reply: reply, err: err})
+	return nil
+}
+
+func (c *Conn) Flush() error {
+	return nil
+}
+
+func (c *Conn) Receive() (interface{}, error) {
+	if len(c.pending) == 0 {
+		return nil, fmt.Errorf("store: no pending reply")
+	}
+	ret := c.pending[0]
+	c.pending = c.pending[1:]
+	return ret.reply, ret.err
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func bulk(input []byte) []byte {
+	return append([]byte{}, input...)
+}
+
+func (c *Conn) exec(commandName string, input []interface{}) (interface{}, error) {
+	args := make([]string, len(input))
+	for i, arg := range input {
+		args[i] = argString(arg)
+	}
+	name := strings.ToLower(commandName)
+
+	switch name {
+	case "ping":
+		return "PONG", nil
+	case "auth", "adminauth":
+		return "OK", nil
+	case "select":
+		if len(args) != 1 {
+			return nil, arityError(name)
+		}
+		db, err := strconv.Atoi(args[0])
+		if err != nil || db < 0 {
+			return nil, redis.Error("ERR DB index is out of range")
+		}
+		c.db = db
+		return "OK", nil
+	case "info":
+		return c.info(args), nil
+	case "scan":
+		return c.scan(args)
+	}
+
+	c.store.lock.RLock()
+	defer c.store.lock.RUnlock()
+
+	switch name {
+	case "dbsize":
+		keys, _ := c.store.dbSize()
+		return keys[c.db], nil
+	case "randomkey":
+		return c.randomKey(), nil
+	case "xinfo":
+		return c.xinfoGroups(args)
+	}
+
+	if len(args) == 0 {
+		return nil, arityError(name)
+	}
+	key := args[0]
+	value := c.store.get(c.db, key)
+
+	switch name {
+	case "type":
+		if value == nil {
+			return common.NoneKeyType.Name, nil
+		}
+		return value.Type.Name, nil
+	case "exists":
+		var count int64
+		for _, key := range args {
+			if c.store.get(c.db, key) != nil {
+				count++
+			}
+		}
+		return count, nil
+	case "ttl", "pttl":
+		if value == nil {
+			return int64(-2), nil
+		} else if value.ExpireAt == 0 {
+			return int64(-1), nil
+		}
+		left := value.ExpireAt - nowMs()
+		if name == "ttl" {
+			return (left + 500) / 1000, nil
+		}
+		return left, nil
+	case "strlen", "llen", "scard", "hlen", "zcard", "xlen":
+		if value == nil {
+			return int64(0), nil
+		} else if value.Type.FetchLenCommand != name {
+			return nil, wrongTypeError
+		}
+		return value.Len(), nil
+	}
+
+	// the remaining commands read the value of a given type
+	tp, ok := commandTypes[name]
+	if !ok {
+		return nil, redis.Error(fmt.Sprintf("ERR unknown command '%s'", commandName))
+	}
+	if value != nil && value.Type != tp {
+		return nil, wrongTypeError
+	}
+	if value != nil && value.Digest != nil {
+		return nil, redis.Error("ERR only the digest of the value is known")
+	}
+
+	switch name {
+	case "get":
+		if value == nil {
+			return nil, nil
+		}
+		return bulk(value.String), nil
+	case "hgetall":
+		ret := make([]interface{}, 0)
+		if value != nil {
+			for field, v := range value.Hash {
+				ret = append(ret, []byte(field), bulk(v))
+			}
+		}
+		return ret, nil
+	case "hmget":
+		ret := make([]interface{}, 0, len(args)-1)
+		for _, field := range args[1:] {
+			if v, ok := value.hashField(field); ok {
+				ret = append(ret, bulk(v))
+			} else {
+				ret = append(ret, nil)
+			}
+		}
+		return ret, nil
+	case "smembers":
+		ret := make([]interface{}, 0)
+		if value != nil {
+			for member := range value.Set {
+				ret = append(ret, []byte(member))
+			}
+		}
+		return ret, nil
+	case "sismember":
+		if len(args) != 2 {
+			return nil, arityError(name)
+		}
+		if value != nil {
+			if _, ok := value.Set[args[1]]; ok {
+				return int64(1), nil
+			}
+		}
+		return int64(0), nil
+	case "zscore":
+		if len(args) != 2 || value == nil {
+			return nil, nil
+		}
+		if score, ok := value.Zset[args[1]]; ok {
+			return []byte(c.store.FormatScore(score)), nil
+		}
+		return nil, nil
+	case "lrange":
+		return c.lrange(value, args)
+	case "zrange":
+		return c.zrange(value, args)
+	case "hscan", "sscan", "zscan":
+		return c.scanValue(value), nil
+	case "xrange":
+		return c.xrange(value, args)
+	case "xpending":
+		return c.xpending(value, args)
+	}
+	return nil, redis.Error(fmt.Sprintf("ERR unknown command '%s'", commandName))
+}
+
+var commandTypes = map[string]*common.KeyType{
+	"get":       common.StringKeyType,
+	"hgetall":   common.HashKeyType,
+	"hmget":     common.HashKeyType,
+	"hscan":     common.HashKeyType,
+	"smembers":  common.SetKeyType,
+	"sismember": common.SetKeyType,
+	"sscan":     common.SetKeyType,
+	"lrange":    common.ListKeyType,
+	"zrange":    common.ZsetKeyType,
+	"zscore":    common.ZsetKeyType,
+	"zscan":     common.ZsetKeyType,
+	"xrange":    common.StreamKeyType,
+	"xpending":  common.StreamKeyType,
+}
+
+func arityError(name string) error {
+	return redis.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+func (v *Value) hashField(field string) ([]byte, bool) {
+	if v == nil {
+		return nil, false
+	}
+	ret, ok := v.Hash[field]
+	return ret, ok
+}
+
+func (c *Conn) info(args []string) []byte {
+	var buf strings.Builder
+	section := ""
+	if len(args) != 0 {
+		section = strings.ToLower(args[0])
+	}
+	if section == "" || section == "server" || section == "all" {
+		buf.WriteString("# Server\r\n")
+		fmt.Fprintf(&buf, "redis_version:%s\r\n", c.store.Version)
+		buf.WriteString("\r\n")
+	}
+	if section == "" || section == "keyspace" || section == "all" {
+		buf.WriteString("# Keyspace\r\n")
+		c.store.lock.RLock()
+		keys, expires := c.store.dbSize()
+		c.store.lock.RUnlock()
+		dbs := make([]int, 0, len(keys))
+		for db := range keys {
+			dbs = append(dbs, db)
+		}
+		sort.Ints(dbs)
+		for _, db := range dbs {
+			fmt.Fprintf(&buf, "db%d:keys=%d,expires=%d,avg_ttl=0\r\n", db, keys[db], expires[db])
+		}
+	}
+	return []byte(buf.String())
+}
+
+/*
+ * scan walks a snapshot of the sorted key names taken when cursor 0 is given, the cursor is the
+ * position in the snapshot. Keys deleted after the snapshot are still returned, the verifiers see
+ * them as deleted on the source like with a live redis.
+ */
+func (c *Conn) scan(args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, arityError("scan")
+	}
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		return nil, redis.Error("ERR invalid cursor")
+	}
+	count := 10
+	var match []byte
+	var tp string
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, syntaxError
+		}
+		switch strings.ToLower(args[i]) {
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				return nil, syntaxError
+			}
+		case "match":
+			match = []byte(args[i+1])
+		case "type":
+			tp = strings.ToLower(args[i+1])
+		default:
+			return nil, syntaxError
+		}
+	}
+
+	if cursor == 0 || c.scanKeys == nil {
+		c.scanKeys = c.store.Keys(c.db)
+	}
+	if cursor > len(c.scanKeys) {
+		cursor = len(c.scanKeys)
+	}
+	end := cursor + count
+	if end > len(c.scanKeys) {
+		end = len(c.scanKeys)
+	}
+
+	c.store.lock.RLock()
+	keys := make([]interface{}, 0, end-cursor)
+	for _, key := range c.scanKeys[cursor:end] {
+		if match != nil && !common.GlobMatch(match, []byte(key)) {
+			continue
+		}
+		if tp != "" {
+			if value := c.store.get(c.db, key); value == nil || value.Type.Name != tp {
+				continue
+			}
+		}
+		keys = append(keys, []byte(key))
+	}
+	c.store.lock.RUnlock()
+
+	next := end
+	if end == len(c.scanKeys) {
+		next = 0
+		c.scanKeys = nil
+	}
+	return []interface{}{[]byte(strconv.Itoa(next)), keys}, nil
+}
+
+func (c *Conn) randomKey() interface{} {
+	keys := c.store.dbs[c.db]
+	if len(keys) == 0 {
+		return nil
+	}
+	// map iteration isn't random enough, skip a random number of keys
+	skip := rand.Intn(len(keys))
+	now := nowMs()
+	for key, value := range keys {
+		if skip--; skip < 0 && !value.expired(now) {
+			return []byte(key)
+		}
+	}
+	for key, value := range keys {
+		if !value.expired(now) {
+			return []byte(key)
+		}
+	}
+	return nil
+}
+
+// rangeIndex converts the redis style [start, stop] with negative indexes to [from, to).
+func rangeIndex(startArg, stopArg string, length int) (int, int, error) {
+	start, err1 := strconv.Atoi(startArg)
+	stop, err2 := strconv.Atoi(stopArg)
+	if err1 != nil || err2 != nil {
+		return 0, 0, notIntError
+	}
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return 0, 0, nil
+	}
+	return start, stop + 1, nil
+}
+
+func (c *Conn) lrange(value *Value, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, arityError("lrange")
+	}
+	var list [][]byte
+	if value != nil {
+		list = value.List
+	}
+	from, to, err := rangeIndex(args[1], args[2], len(list))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, to-from)
+	for _, element := range list[from:to] {
+		ret = append(ret, bulk(element))
+	}
+	return ret, nil
+}
+
+func (c *Conn) zrange(value *Value, args []string) (interface{}, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, arityError("zrange")
+	}
+	withScores := len(args) == 4
+	if withScores && strings.ToLower(args[3]) != "withscores" {
+		return nil, syntaxError
+	}
+	var members []ZsetMember
+	if value != nil {
+		members = value.SortedZset()
+	}
+	from, to, err := rangeIndex(args[1], args[2], len(members))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 0, 2*(to-from))
+	for _, member := range members[from:to] {
+		ret = append(ret, []byte(member.Member))
+		if withScores {
+			ret = append(ret, []byte(c.store.FormatScore(member.Score)))
+		}
+	}
+	return ret, nil
+}
+
+// scanValue returns all elements in one batch with the cursor 0.
+func (c *Conn) scanValue(value *Value) interface{} {
+	elements := make([]interface{}, 0)
+	if value != nil {
+		switch value.Type {
+		case common.HashKeyType:
+			for field, v := range value.Hash {
+				elements = append(elements, []byte(field), bulk(v))
+			}
+		case common.SetKeyType:
+			for member := range value.Set {
+				elements = append(elements, []byte(member))
+			}
+		case common.ZsetKeyType:
+			for member, score := range value.Zset {
+				elements = append(elements, []byte(member), []byte(c.store.FormatScore(score)))
+			}
+		}
+	}
+	return []interface{}{[]byte("0"), elements}
+}
+
+func (c *Conn) xrange(value *Value, args []string) (interface{}, error) {
+	if len(args) != 3 && len(args) != 5 {
+		return nil, arityError("xrange")
+	}
+	start, err := ParseStreamID(args[1], false)
+	if err != nil {
+		return nil, redis.Error("ERR Invalid stream ID specified as stream command argument")
+	}
+	end, err := ParseStreamID(args[2], true)
+	if err != nil {
+		return nil, redis.Error("ERR Invalid stream ID specified as stream command argument")
+	}
+	count := 0
+	if len(args) == 5 {
+		if strings.ToLower(args[3]) != "count" {
+			return nil, syntaxError
+		}
+		if count, err = strconv.Atoi(args[4]); err != nil {
+			return nil, notIntError
+		}
+	}
+
+	ret := make([]interface{}, 0)
+	if value == nil {
+		return ret, nil
+	}
+	for _, entry := range value.Stream.Range(start, end, count) {
+		fields := make([]interface{}, 0, len(entry.Fields))
+		for _, field := range entry.Fields {
+			fields = append(fields, bulk(field))
+		}
+		ret = append(ret, []interface{}{[]byte(entry.ID.String()), fields})
+	}
+	return ret, nil
+}
+
+func (c *Conn) xinfoGroups(args []string) (interface{}, error) {
+	if len(args) != 2 || strings.ToLower(args[0]) != "groups" {
+		return nil, redis.Error("ERR only 'xinfo groups' is supported")
+	}
+	value := c.store.get(c.db, args[1])
+	if value == nil {
+		return nil, redis.Error("ERR no such key")
+	} else if value.Type != common.StreamKeyType {
+		return nil, wrongTypeError
+	}
+
+	stream := value.Stream
+	ret := make([]interface{}, 0, len(stream.Groups))
+	for _, group := range stream.Groups {
+		line := []interface{}{
+			[]byte("name"), []byte(group.Name),
+			[]byte("consumers"), int64(len(group.Consumers)),
+			[]byte("pending"), int64(len(group.Pending)),
+			[]byte("last-delivered-id"), []byte(group.LastID.String()),
+		}
+		if common.VersionAtLeast(c.store.Version, 7, 0) {
+			var entriesRead, lag interface{}
+			if group.EntriesRead >= 0 {
+				entriesRead = group.EntriesRead
+			}
+			if l := stream.Lag(group); l >= 0 {
+				lag = l
+			}
+			line = append(line, []byte("entries-read"), entriesRead, []byte("lag"), lag)
+		}
+		ret = append(ret, line)
+	}
+	return ret, nil
+}
+
+// xpending supports the extended form "xpending key group start end count".
+func (c *Conn) xpending(value *Value, args []string) (interface{}, error) {
+	if len(args) != 5 {
+		return nil, redis.Error("ERR only 'xpending key group start end count' is supported")
+	}
+	if value == nil {
+		return nil, redis.Error(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", args[0], args[1]))
+	}
+	group := value.Stream.Group(args[1])
+	if group == nil {
+		return nil, redis.Error(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", args[0], args[1]))
+	}
+	start, err1 := ParseStreamID(args[2], false)
+	end, err2 := ParseStreamID(args[3], true)
+	count, err3 := strconv.Atoi(args[4])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, syntaxError
+	}
+
+	now := nowMs()
+	ret := make([]interface{}, 0)
+	for _, pending := range group.Pending {
+		if len(ret) >= count {
+			break
+		}
+		if pending.ID.Less(start) || end.Less(pending.ID) {
+			continue
+		}
+		ret = append(ret, []interface{}{
+			[]byte(pending.ID.String()),
+			[]byte(pending.Consumer),
+			now - pending.DeliveryTime,
+			pending.DeliveryCount,
+		})
+	}
+	return ret, nil
+}
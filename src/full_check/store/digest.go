@@ -0,0 +1,100 @@
+package store
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash"
+	"sort"
+	"strconv"
+
+	"full_check/common"
+)
+
+func writeElement(h hash.Hash, element []byte) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(element)))
+	h.Write(length[:])
+	h.Write(element)
+}
+
+func sortedKeys(input map[string]struct{}) []string {
+	ret := make([]string, 0, len(input))
+	for key := range input {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+ * ValueDigest returns the md5 of the value serialized in a canonical way: the elements of sets and
+ * hashes are sorted and scores are written in the shortest form. Unlike "dump", the digest doesn't
+ * depend on the internal encoding or the redis version, so values read from different redis can be
+ * compared by their digests. The expiry isn't included.
+ */
+func (v *Value) ValueDigest() []byte {
+	if v.Digest != nil {
+		return v.Digest
+	}
+
+	h := md5.New()
+	writeElement(h, []byte(v.Type.Name))
+	switch v.Type {
+	case common.StringKeyType:
+		writeElement(h, v.String)
+	case common.ListKeyType:
+		for _, element := range v.List {
+			writeElement(h, element)
+		}
+	case common.SetKeyType:
+		for _, member := range sortedKeys(v.Set) {
+			writeElement(h, []byte(member))
+		}
+	case common.HashKeyType:
+		fields := make(map[string]struct{}, len(v.Hash))
+		for field := range v.Hash {
+			fields[field] = struct{}{}
+		}
+		for _, field := range sortedKeys(fields) {
+			writeElement(h, []byte(field))
+			writeElement(h, v.Hash[field])
+		}
+	case common.ZsetKeyType:
+		for _, member := range v.SortedZset() {
+			writeElement(h, []byte(member.Member))
+			writeElement(h, []byte(strconv.FormatFloat(member.Score, 'g', -1, 64)))
+		}
+	case common.StreamKeyType:
+		for _, entry := range v.Stream.Entries {
+			writeElement(h, []byte(entry.ID.String()))
+			for _, field := range entry.Fields {
+				writeElement(h, field)
+			}
+		}
+		groups := append([]*StreamGroup{}, v.Stream.Groups...)
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i].Name < groups[j].Name
+		})
+		for _, group := range groups {
+			writeElement(h, []byte(group.Name))
+			writeElement(h, []byte(group.LastID.String()))
+			for _, pending := range group.Pending {
+				writeElement(h, []byte(pending.ID.String()))
+				writeElement(h, []byte(pending.Consumer))
+			}
+		}
+	}
+	return h.Sum(nil)
+}
+
+// ValueDigest returns the digest of the value of the key, nil if the key doesn't exist.
+func (s *Store) ValueDigest(db int, key string) []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	value := s.get(db, key)
+	if value == nil {
+		return nil
+	}
+	return value.ValueDigest()
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"full_check/common"
+)
+
+const (
+	ExportFormat  = "redis-full-check-export"
+	ExportVersion = 1
+)
+
+/*
+ * An export file is gzip compressed json lines: the header, then one record per key. Key names and
+ * values are binary safe, they are base64 encoded by json.
+ */
+type ExportHeader struct {
+	Format       string `json:"format"`
+	Version      int    `json:"version"`
+	Source       string `json:"source"`
+	RedisVersion string `json:"redis_version"`
+	Digest       bool   `json:"digest"` // records only hold the length and the digest of values
+	Time         int64  `json:"time"`   // unix time in seconds when the export started
+}
+
+type Record struct {
+	Db       int      `json:"db"`
+	Key      []byte   `json:"key"`
+	Type     string   `json:"type"`
+	ExpireAt int64    `json:"expire_at,omitempty"` // unix time in milliseconds
+	String   []byte   `json:"string,omitempty"`
+	Elements [][]byte `json:"elements,omitempty"` // list, set, field and value of hash, member and score of zset
+	Stream   *Stream  `json:"stream,omitempty"`
+	Length   int64    `json:"length,omitempty"` // digest export only
+	Digest   []byte   `json:"digest,omitempty"` // digest export only
+}
+
+// NewRecord converts the value of the key, only its length and digest are kept if digest is set.
+func NewRecord(db int, key []byte, value *Value, digest bool) *Record {
+	record := &Record{
+		Db:       db,
+		Key:      key,
+		Type:     value.Type.Name,
+		ExpireAt: value.ExpireAt,
+	}
+	if digest {
+		record.Length = value.Len()
+		record.Digest = value.ValueDigest()
+		return record
+	}
+
+	switch value.Type {
+	case common.StringKeyType:
+		record.String = value.String
+	case common.ListKeyType:
+		record.Elements = value.List
+	case common.SetKeyType:
+		for _, member := range sortedKeys(value.Set) {
+			record.Elements = append(record.Elements, []byte(member))
+		}
+	case common.HashKeyType:
+		for field, v := range value.Hash {
+			record.Elements = append(record.Elements, []byte(field), v)
+		}
+	case common.ZsetKeyType:
+		for _, member := range value.SortedZset() {
+			record.Elements = append(record.Elements, []byte(member.Member),
+				[]byte(strconv.FormatFloat(member.Score, 'g', -1, 64)))
+		}
+	case common.StreamKeyType:
+		record.Stream = value.Stream
+	}
+	return record
+}
+
+// Value converts the record back.
+func (r *Record) Value() (*Value, error) {
+	var value *Value
+	switch common.NewKeyType(r.Type) {
+	case common.StringKeyType:
+		value = NewString(r.String)
+	case common.ListKeyType:
+		value = NewList()
+		value.List = r.Elements
+	case common.SetKeyType:
+		value = NewSet()
+		for _, member := range r.Elements {
+			value.Set[string(member)] = struct{}{}
+		}
+	case common.HashKeyType:
+		value = NewHash()
+		for i := 0; i+1 < len(r.Elements); i += 2 {
+			value.Hash[string(r.Elements[i])] = r.Elements[i+1]
+		}
+	case common.ZsetKeyType:
+		value = NewZset()
+		for i := 0; i+1 < len(r.Elements); i += 2 {
+			score, err := parseScore(r.Elements[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid score[%s] of key[%s]", r.Elements[i+1], r.Key)
+			}
+			value.Zset[string(r.Elements[i])] = score
+		}
+	case common.StreamKeyType:
+		value = NewStream()
+		if r.Stream != nil {
+			value.Stream = r.Stream
+		}
+	default:
+		return nil, fmt.Errorf("unknown type[%v] of key[%s]", r.Type, r.Key)
+	}
+
+	value.ExpireAt = r.ExpireAt
+	if r.Digest != nil {
+		value.Digest, value.Length = r.Digest, r.Length
+	}
+	return value, nil
+}
+
+// ExportWriter writes the export file, Write can be called concurrently.
+type ExportWriter struct {
+	lock    sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+	count   int64
+}
+
+func NewExportWriter(path string, header *ExportHeader) (*ExportWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &ExportWriter{file: file, gzip: gzip.NewWriter(file)}
+	w.encoder = json.NewEncoder(w.gzip)
+
+	header.Format, header.Version = ExportFormat, ExportVersion
+	if err := w.encoder.Encode(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *ExportWriter) Write(record *Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.count++
+	return w.encoder.Encode(record)
+}
+
+// Count returns the number of written records.
+func (w *ExportWriter) Count() int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.count
+}
+
+func (w *ExportWriter) Close() error {
+	if err := w.gzip.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func openExport(path string) (*os.File, *json.Decoder, *ExportHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("open export file[%v] failed: %v", path, err)
+	}
+	decoder := json.NewDecoder(reader)
+
+	header := new(ExportHeader)
+	if err := decoder.Decode(header); err != nil || header.Format != ExportFormat {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("%v isn't an export file", path)
+	}
+	if header.Version > ExportVersion {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("export file version %d is newer than %d", header.Version, ExportVersion)
+	}
+	return file, decoder, header, nil
+}
+
+// ReadExportHeader returns the header of the export file.
+func ReadExportHeader(path string) (*ExportHeader, error) {
+	file, _, header, err := openExport(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return header, nil
+}
+
+// LoadExport loads all records of the export file into a new store.
+func LoadExport(path string) (*Store, *ExportHeader, error) {
+	file, decoder, header, err := openExport(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	st := New()
+	st.Version = header.RedisVersion
+	for {
+		record := new(Record)
+		if err := decoder.Decode(record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("read export file[%v] failed: %v", path, err)
+		}
+		value, err := record.Value()
+		if err != nil {
+			return nil, nil, err
+		}
+		st.Set(record.Db, string(record.Key), value)
+	}
+	return st, header, nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportValues() map[string]*Value {
+	hash := NewHash()
+	hash.Hash["f1"] = []byte("v1")
+	hash.Hash["f2"] = []byte("v2")
+	set := NewSet()
+	set.Set["a"] = struct{}{}
+	set.Set["b"] = struct{}{}
+	zset := NewZset()
+	zset.Zset["m1"] = 1.5
+	zset.Zset["m2"] = 0.1
+	list := NewList()
+	list.List = [][]byte{[]byte("x"), []byte("y")}
+	stream := NewStream()
+	stream.Stream.Entries = []*StreamEntry{{ID: StreamID{1, 1}, Fields: [][]byte{[]byte("f"), []byte("v")}}}
+	stream.Stream.LastID = StreamID{1, 1}
+	stream.Stream.Groups = []*StreamGroup{{Name: "g1", LastID: StreamID{1, 1}, EntriesRead: -1,
+		Pending: []*StreamPending{{ID: StreamID{1, 1}, Consumer: "c1", DeliveryCount: 1}}, Consumers: []string{"c1"}}}
+	str := NewString([]byte("value\x00binary"))
+	str.ExpireAt = nowMs() + 100000
+
+	return map[string]*Value{"str": str, "hash": hash, "set": set, "zset": zset, "list": list, "stream": stream}
+}
+
+func writeExport(t *testing.T, path string, digest bool) {
+	writer, err := NewExportWriter(path, &ExportHeader{Source: "127.0.0.1:6379", RedisVersion: "7.2.0",
+		Digest: digest})
+	assert.Nil(t, err, "should be nil")
+	for key, value := range exportValues() {
+		assert.Nil(t, writer.Write(NewRecord(1, []byte(key), value, digest)), "should be nil")
+	}
+	assert.Nil(t, writer.Close(), "should be nil")
+}
+
+func TestExport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "export")
+	assert.Nil(t, err, "should be nil")
+	defer os.RemoveAll(dir)
+
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestExport case %d.\n", nr)
+
+		// full values are loaded back as they were
+		path := filepath.Join(dir, "full.gz")
+		writeExport(t, path, false)
+		st, header, err := LoadExport(path)
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, "127.0.0.1:6379", header.Source, "should be equal")
+		assert.Equal(t, false, header.Digest, "should be equal")
+		assert.Equal(t, "7.2.0", st.Version, "should be equal")
+
+		for key, value := range exportValues() {
+			assert.Equal(t, value.ValueDigest(), st.ValueDigest(1, key), "should be equal: %v", key)
+		}
+		conn := NewConn(st, 1)
+		str, _ := redis.String(conn.Do("get", "str"))
+		assert.Equal(t, "value\x00binary", str, "should be equal")
+		ttl, _ := redis.Int64(conn.Do("ttl", "str"))
+		assert.True(t, ttl > 0, "should be true")
+		zset, _ := redis.Strings(conn.Do("zrange", "zset", 0, -1, "withscores"))
+		assert.Equal(t, []string{"m2", "0.1", "m1", "1.5"}, zset, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestExport case %d.\n", nr)
+
+		// only the lengths and digests are kept
+		path := filepath.Join(dir, "digest.gz")
+		writeExport(t, path, true)
+		header, err := ReadExportHeader(path)
+		assert.Nil(t, err, "should be nil")
+		assert.Equal(t, true, header.Digest, "should be equal")
+
+		st, _, err := LoadExport(path)
+		assert.Nil(t, err, "should be nil")
+		for key, value := range exportValues() {
+			assert.Equal(t, value.ValueDigest(), st.ValueDigest(1, key), "should be equal: %v", key)
+		}
+		conn := NewConn(st, 1)
+		length, _ := redis.Int64(conn.Do("hlen", "hash"))
+		assert.Equal(t, int64(2), length, "should be equal")
+		tp, _ := redis.String(conn.Do("type", "stream"))
+		assert.Equal(t, "stream", tp, "should be equal")
+		_, err = conn.Do("hgetall", "hash")
+		assert.NotNil(t, err, "should be not nil")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestExport case %d.\n", nr)
+
+		// the digest doesn't depend on the order of the elements
+		a, b := NewHash(), NewHash()
+		a.Hash["f1"], a.Hash["f2"] = []byte("1"), []byte("2")
+		b.Hash["f2"], b.Hash["f1"] = []byte("2"), []byte("1")
+		assert.Equal(t, a.ValueDigest(), b.ValueDigest(), "should be equal")
+		b.Hash["f1"] = []byte("3")
+		assert.NotEqual(t, a.ValueDigest(), b.ValueDigest(), "should be not equal")
+
+		// not an export file
+		path := filepath.Join(dir, "plain")
+		os.WriteFile(path, []byte("plain"), 0644)
+		_, err := ReadExportHeader(path)
+		assert.NotNil(t, err, "should be not nil")
+	}
+}
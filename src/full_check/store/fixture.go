@@ -0,0 +1,191 @@
+package store
+
+import (
+	"fmt"
+	"os"
+
+	"full_check/common"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * A fixture describes the data expected on the target in YAML or JSON, e.g.,
+ *
+ *   keys:
+ *     - {key: greeting, type: string, value: hello, ttl: 3600}
+ *     - {key: tags, db: 1, type: set, value: [a, b]}
+ *     - {key: "user:1", type: hash, value: {name: alice, age: 30}}
+ *     - {key: scores, type: zset, value: {alice: 1.5, bob: 2}}
+ *     - {key: queue, type: list, value: [a, b, c]}
+ *     - {key: events, type: stream, value: [{id: 1-1, fields: [f, v]}]}
+ *
+ * db defaults to 0, ttl in seconds is optional. Scalars are taken as written, so 1.0 is the string
+ * "1.0" rather than "1".
+ */
+type fixtureFile struct {
+	Keys []fixtureKey `yaml:"keys"`
+}
+
+type fixtureKey struct {
+	Db    int       `yaml:"db"`
+	Key   string    `yaml:"key"`
+	Type  string    `yaml:"type"`
+	Value yaml.Node `yaml:"value"`
+	TTL   int64     `yaml:"ttl"`
+}
+
+type fixtureEntry struct {
+	ID     string   `yaml:"id"`
+	Fields []string `yaml:"fields"`
+}
+
+// LoadFixture loads the keys of the fixture into a new store, the ttl counts from now.
+func LoadFixture(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture fixtureFile
+	if err := yaml.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("parse fixture[%v] failed: %v", path, err)
+	}
+
+	st := New()
+	now := nowMs()
+	for i, key := range fixture.Keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("key %d of fixture[%v] has no name", i, path)
+		}
+		if key.Db < 0 || key.TTL < 0 {
+			return nil, fmt.Errorf("invalid db or ttl of key[%v] in fixture[%v]", key.Key, path)
+		}
+		if _, ok := st.dbs[key.Db][key.Key]; ok {
+			return nil, fmt.Errorf("key[%v] of db %d is given twice in fixture[%v]", key.Key, key.Db, path)
+		}
+		value, err := fixtureValue(&key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of key[%v] in fixture[%v]: %v", key.Key, path, err)
+		} else if value.empty() {
+			return nil, fmt.Errorf("value of key[%v] in fixture[%v] is empty", key.Key, path)
+		}
+		if key.TTL > 0 {
+			value.ExpireAt = now + key.TTL*1000
+		}
+		st.set(key.Db, key.Key, value)
+	}
+	return st, nil
+}
+
+func fixtureValue(key *fixtureKey) (*Value, error) {
+	node := &key.Value
+	switch common.NewKeyType(key.Type) {
+	case common.StringKeyType:
+		if node.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("string should be a scalar")
+		}
+		return NewString([]byte(node.Value)), nil
+	case common.ListKeyType:
+		elements, err := scalars(node)
+		if err != nil {
+			return nil, err
+		}
+		value := NewList()
+		for _, element := range elements {
+			value.List = append(value.List, []byte(element))
+		}
+		return value, nil
+	case common.SetKeyType:
+		elements, err := scalars(node)
+		if err != nil {
+			return nil, err
+		}
+		value := NewSet()
+		for _, element := range elements {
+			value.Set[element] = struct{}{}
+		}
+		return value, nil
+	case common.HashKeyType:
+		pairs, err := scalarPairs(node)
+		if err != nil {
+			return nil, err
+		}
+		value := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			value.Hash[pairs[i]] = []byte(pairs[i+1])
+		}
+		return value, nil
+	case common.ZsetKeyType:
+		pairs, err := scalarPairs(node)
+		if err != nil {
+			return nil, err
+		}
+		value := NewZset()
+		for i := 0; i < len(pairs); i += 2 {
+			score, err := parseScore([]byte(pairs[i+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid score[%v] of member[%v]", pairs[i+1], pairs[i])
+			}
+			value.Zset[pairs[i]] = score
+		}
+		return value, nil
+	case common.StreamKeyType:
+		var entries []fixtureEntry
+		if err := node.Decode(&entries); err != nil {
+			return nil, err
+		}
+		value := NewStream()
+		for _, entry := range entries {
+			id, err := ParseStreamID(entry.ID, false)
+			if err != nil {
+				return nil, err
+			}
+			if !value.Stream.LastID.Less(id) {
+				return nil, fmt.Errorf("stream id[%v] isn't greater than the previous one", entry.ID)
+			}
+			if len(entry.Fields) == 0 || len(entry.Fields)%2 != 0 {
+				return nil, fmt.Errorf("fields of stream id[%v] should be field, value pairs", entry.ID)
+			}
+			fields := make([][]byte, len(entry.Fields))
+			for i, field := range entry.Fields {
+				fields[i] = []byte(field)
+			}
+			value.Stream.Entries = append(value.Stream.Entries, &StreamEntry{ID: id, Fields: fields})
+			value.Stream.LastID = id
+		}
+		value.Stream.EntriesAdded = int64(len(entries))
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unknown type[%v]", key.Type)
+	}
+}
+
+// scalars returns the elements of a sequence of scalars.
+func scalars(node *yaml.Node) ([]string, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("should be a sequence")
+	}
+	ret := make([]string, 0, len(node.Content))
+	for _, element := range node.Content {
+		if element.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("element at line %d should be a scalar", element.Line)
+		}
+		ret = append(ret, element.Value)
+	}
+	return ret, nil
+}
+
+// scalarPairs returns the keys and values of a mapping of scalars in order.
+func scalarPairs(node *yaml.Node) ([]string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("should be a mapping")
+	}
+	ret := make([]string, 0, len(node.Content))
+	for _, element := range node.Content {
+		if element.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("element at line %d should be a scalar", element.Line)
+		}
+		ret = append(ret, element.Value)
+	}
+	return ret, nil
+}
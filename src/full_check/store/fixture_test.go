@@ -0,0 +1,92 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFixture(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fixture")
+	assert.Nil(t, err, "should be nil")
+	defer os.RemoveAll(dir)
+
+	var nr int
+	{
+		nr++
+		fmt.Printf("TestLoadFixture case %d.\n", nr)
+
+		path := filepath.Join(dir, "fixture.yaml")
+		os.WriteFile(path, []byte(`
+keys:
+  - {key: greeting, type: string, value: 1.0, ttl: 60}
+  - {key: tags, db: 1, type: set, value: [a, b]}
+  - key: "user:1"
+    type: hash
+    value: {name: alice, age: 30}
+  - {key: scores, type: zset, value: {alice: 1.5, bob: inf}}
+  - {key: queue, type: list, value: [c, a, b]}
+  - {key: events, type: stream, value: [{id: 1-1, fields: [f, v]}, {id: 2-0, fields: [f, 2]}]}
+`), 0644)
+		st, err := LoadFixture(path)
+		assert.Nil(t, err, "should be nil")
+
+		conn := NewConn(st, 0)
+		str, _ := redis.String(conn.Do("get", "greeting"))
+		assert.Equal(t, "1.0", str, "should be equal")
+		ttl, _ := redis.Int64(conn.Do("ttl", "greeting"))
+		assert.True(t, ttl > 0 && ttl <= 60, "should be true")
+		hash, _ := redis.StringMap(conn.Do("hgetall", "user:1"))
+		assert.Equal(t, map[string]string{"name": "alice", "age": "30"}, hash, "should be equal")
+		zset, _ := redis.Strings(conn.Do("zrange", "scores", 0, -1, "withscores"))
+		assert.Equal(t, []string{"alice", "1.5", "bob", "inf"}, zset, "should be equal")
+		list, _ := redis.Strings(conn.Do("lrange", "queue", 0, -1))
+		assert.Equal(t, []string{"c", "a", "b"}, list, "should be equal")
+		length, _ := redis.Int64(conn.Do("xlen", "events"))
+		assert.Equal(t, int64(2), length, "should be equal")
+		exists, _ := redis.Int64(conn.Do("exists", "tags"))
+		assert.Equal(t, int64(0), exists, "should be equal")
+
+		conn.Do("select", 1)
+		members, _ := redis.Strings(conn.Do("smembers", "tags"))
+		assert.ElementsMatch(t, []string{"a", "b"}, members, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestLoadFixture case %d.\n", nr)
+
+		// json is accepted as well
+		path := filepath.Join(dir, "fixture.json")
+		os.WriteFile(path, []byte(`{"keys": [{"key": "k", "type": "list", "value": ["x", "y"]}]}`), 0644)
+		st, err := LoadFixture(path)
+		assert.Nil(t, err, "should be nil")
+		list, _ := redis.Strings(NewConn(st, 0).Do("lrange", "k", 0, -1))
+		assert.Equal(t, []string{"x", "y"}, list, "should be equal")
+	}
+
+	{
+		nr++
+		fmt.Printf("TestLoadFixture case %d.\n", nr)
+
+		// invalid fixtures
+		inputs := []string{
+			`keys: [{key: k, type: hash, value: [a, b]}]`,
+			`keys: [{key: k, type: unknown, value: a}]`,
+			`keys: [{key: k, type: set, value: []}]`,
+			`keys: [{type: string, value: a}]`,
+			`keys: [{key: k, type: string, value: a}, {key: k, type: string, value: b}]`,
+			`keys: [{key: k, type: stream, value: [{id: 2-0, fields: [f, v]}, {id: 1-0, fields: [f, v]}]}]`,
+		}
+		for i, input := range inputs {
+			path := filepath.Join(dir, fmt.Sprintf("invalid%d.yaml", i))
+			os.WriteFile(path, []byte(input), 0644)
+			_, err := LoadFixture(path)
+			assert.NotNil(t, err, "should be not nil: %v", input)
+		}
+	}
+}
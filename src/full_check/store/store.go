@@ -0,0 +1,257 @@
+package store
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"full_check/common"
+)
+
+/*
+ * Value is one key kept in memory. Only the field of its type is set. The store answers the read
+ * commands issued by the verifiers from these values, so that a snapshot(e.g., an rdb file received
+ * by psync) can be compared with a live redis.
+ */
+type Value struct {
+	Type     *common.KeyType
+	String   []byte
+	List     [][]byte
+	Set      map[string]struct{}
+	Hash     map[string][]byte
+	Zset     map[string]float64
+	Stream   *Stream
+	ExpireAt int64 // unix time in milliseconds, 0 means no expiry
+
+	// set if only the digest of the value is known, e.g., loaded from a digest export
+	Digest []byte
+	Length int64
+}
+
+func NewString(value []byte) *Value {
+	return &Value{Type: common.StringKeyType, String: value}
+}
+
+func NewList() *Value {
+	return &Value{Type: common.ListKeyType}
+}
+
+func NewSet() *Value {
+	return &Value{Type: common.SetKeyType, Set: make(map[string]struct{})}
+}
+
+func NewHash() *Value {
+	return &Value{Type: common.HashKeyType, Hash: make(map[string][]byte)}
+}
+
+func NewZset() *Value {
+	return &Value{Type: common.ZsetKeyType, Zset: make(map[string]float64)}
+}
+
+func NewStream() *Value {
+	return &Value{Type: common.StreamKeyType, Stream: &Stream{}}
+}
+
+// Len returns the length reported by strlen/llen/scard/hlen/zcard/xlen.
+func (v *Value) Len() int64 {
+	if v.Digest != nil {
+		return v.Length
+	}
+	switch v.Type {
+	case common.StringKeyType:
+		return int64(len(v.String))
+	case common.ListKeyType:
+		return int64(len(v.List))
+	case common.SetKeyType:
+		return int64(len(v.Set))
+	case common.HashKeyType:
+		return int64(len(v.Hash))
+	case common.ZsetKeyType:
+		return int64(len(v.Zset))
+	case common.StreamKeyType:
+		return int64(len(v.Stream.Entries))
+	}
+	return 0
+}
+
+// empty returns true if the value of an aggregate type has no element left and should be removed.
+func (v *Value) empty() bool {
+	return v.Type != common.StringKeyType && v.Type != common.StreamKeyType && v.Len() == 0
+}
+
+func (v *Value) expired(now int64) bool {
+	return v.ExpireAt > 0 && v.ExpireAt <= now
+}
+
+type ZsetMember struct {
+	Member string
+	Score  float64
+}
+
+// SortedZset returns the members ordered like "zrange": by score, then by member.
+func (v *Value) SortedZset() []ZsetMember {
+	members := make([]ZsetMember, 0, len(v.Zset))
+	for member, score := range v.Zset {
+		members = append(members, ZsetMember{Member: member, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+	return members
+}
+
+type Store struct {
+	// redis version the data comes from, the score format and "xinfo" replies depend on it
+	Version string
+
+	lock  sync.RWMutex
+	dbs   map[int]map[string]*Value
+	dirty map[int]map[string]struct{}
+}
+
+func New() *Store {
+	return &Store{
+		dbs:   make(map[int]map[string]*Value),
+		dirty: make(map[int]map[string]struct{}),
+	}
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Set stores the value of the key, replacing the old one.
+func (s *Store) Set(db int, key string, value *Value) {
+	s.lock.Lock()
+	s.set(db, key, value)
+	s.lock.Unlock()
+}
+
+func (s *Store) set(db int, key string, value *Value) {
+	keys, ok := s.dbs[db]
+	if !ok {
+		keys = make(map[string]*Value)
+		s.dbs[db] = keys
+	}
+	keys[key] = value
+	if dirty, ok := s.dirty[db]; ok {
+		delete(dirty, key)
+	}
+}
+
+// get returns the value of the key, nil if it doesn't exist or is logically expired.
+func (s *Store) get(db int, key string) *Value {
+	value, ok := s.dbs[db][key]
+	if !ok || value.expired(nowMs()) {
+		return nil
+	}
+	return value
+}
+
+func (s *Store) del(db int, key string) bool {
+	keys, ok := s.dbs[db]
+	if !ok {
+		return false
+	}
+	_, ok = keys[key]
+	delete(keys, key)
+	return ok
+}
+
+/*
+ * markDirty removes the key changed by a command the store can't apply, so that a stale value is
+ * never compared. Dirty keys are counted to tell the user how many keys aren't tracked.
+ */
+func (s *Store) markDirty(db int, key string) {
+	s.del(db, key)
+	dirty, ok := s.dirty[db]
+	if !ok {
+		dirty = make(map[string]struct{})
+		s.dirty[db] = dirty
+	}
+	dirty[key] = struct{}{}
+}
+
+// Dirty returns the number of keys that aren't tracked because of unsupported commands.
+func (s *Store) Dirty() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	count := 0
+	for _, keys := range s.dirty {
+		count += len(keys)
+	}
+	return count
+}
+
+// Keys returns the sorted names of the existing keys in the db.
+func (s *Store) Keys(db int) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := nowMs()
+	keys := make([]string, 0, len(s.dbs[db]))
+	for key, value := range s.dbs[db] {
+		if !value.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DBSize returns the number of keys and the number of keys with expiry of every non-empty db.
+func (s *Store) DBSize() (map[int]int64, map[int]int64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.dbSize()
+}
+
+func (s *Store) dbSize() (map[int]int64, map[int]int64) {
+	now := nowMs()
+	keys, expires := make(map[int]int64), make(map[int]int64)
+	for db, values := range s.dbs {
+		for _, value := range values {
+			if value.expired(now) {
+				continue
+			}
+			keys[db]++
+			if value.ExpireAt > 0 {
+				expires[db]++
+			}
+		}
+	}
+	return keys, expires
+}
+
+// Flush removes all keys of the db, db < 0 means all dbs.
+func (s *Store) Flush(db int) {
+	s.lock.Lock()
+	if db < 0 {
+		s.dbs = make(map[int]map[string]*Value)
+		s.dirty = make(map[int]map[string]struct{})
+	} else {
+		delete(s.dbs, db)
+		delete(s.dirty, db)
+	}
+	s.lock.Unlock()
+}
+
+// FormatScore formats a zset score the same way as the redis version of the store.
+func (s *Store) FormatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	} else if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	if common.VersionAtLeast(s.Version, 7, 0) {
+		// the shortest representation since 7.0
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'g', 17, 64)
+}
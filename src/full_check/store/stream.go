@@ -0,0 +1,118 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type StreamID struct {
+	Ms  uint64 `json:"ms"`
+	Seq uint64 `json:"seq"`
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+/*
+ * ParseStreamID parses "ms-seq" or "ms". "-" and "+" are the smallest and the largest id. A missing
+ * sequence is 0, or the max sequence if maxSeq is set, which is how an end id is completed.
+ */
+func ParseStreamID(input string, maxSeq bool) (StreamID, error) {
+	switch input {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return StreamID{Ms: ^uint64(0), Seq: ^uint64(0)}, nil
+	}
+
+	items := strings.SplitN(input, "-", 2)
+	ms, err := strconv.ParseUint(items[0], 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream id[%v]", input)
+	}
+	id := StreamID{Ms: ms}
+	if len(items) == 2 {
+		if id.Seq, err = strconv.ParseUint(items[1], 10, 64); err != nil {
+			return StreamID{}, fmt.Errorf("invalid stream id[%v]", input)
+		}
+	} else if maxSeq {
+		id.Seq = ^uint64(0)
+	}
+	return id, nil
+}
+
+type StreamEntry struct {
+	ID     StreamID `json:"id"`
+	Fields [][]byte `json:"fields"` // field, value, field, value...
+}
+
+type StreamPending struct {
+	ID            StreamID `json:"id"`
+	Consumer      string   `json:"consumer"`
+	DeliveryTime  int64    `json:"delivery_time"` // unix time in milliseconds
+	DeliveryCount int64    `json:"delivery_count"`
+}
+
+type StreamGroup struct {
+	Name        string           `json:"name"`
+	LastID      StreamID         `json:"last_id"`
+	EntriesRead int64            `json:"entries_read"` // -1 means invalid
+	Pending     []*StreamPending `json:"pending"`
+	Consumers   []string         `json:"consumers"`
+}
+
+type Stream struct {
+	Entries      []*StreamEntry `json:"entries"` // ordered by id
+	LastID       StreamID       `json:"last_id"`
+	EntriesAdded int64          `json:"entries_added"`
+	MaxDeletedID StreamID       `json:"max_deleted_id"`
+	Groups       []*StreamGroup `json:"groups"`
+}
+
+// Range returns at most count entries whose ids are in [start, end], count <= 0 means all.
+func (s *Stream) Range(start, end StreamID, count int) []*StreamEntry {
+	from := sort.Search(len(s.Entries), func(i int) bool {
+		return !s.Entries[i].ID.Less(start)
+	})
+	ret := make([]*StreamEntry, 0)
+	for i := from; i < len(s.Entries) && !end.Less(s.Entries[i].ID); i++ {
+		if count > 0 && len(ret) >= count {
+			break
+		}
+		ret = append(ret, s.Entries[i])
+	}
+	return ret
+}
+
+func (s *Stream) Group(name string) *StreamGroup {
+	for _, group := range s.Groups {
+		if group.Name == name {
+			return group
+		}
+	}
+	return nil
+}
+
+// Lag returns the lag of the group reported by "xinfo groups" since 7.0, -1 means unknown.
+func (s *Stream) Lag(group *StreamGroup) int64 {
+	if s.EntriesAdded == 0 {
+		return 0
+	}
+	// the lag can't be computed when entries after the last delivered one were deleted
+	tombstones := len(s.Entries) != 0 && !s.MaxDeletedID.IsZero() && !s.MaxDeletedID.Less(group.LastID)
+	if group.EntriesRead < 0 || tombstones {
+		return -1
+	}
+	return s.EntriesAdded - group.EntriesRead
+}